@@ -0,0 +1,84 @@
+package cmd
+
+// 管理消息
+// 消息ID以ADMIN_开头，仅允许服务器内部已校验的连接发送
+// 运维工具可通过路由C2S_Route转发至指定服务或网关，或通过DialAdmin直连
+//   c, err := cmd.DialAdmin("127.0.0.1:9003")
+//   c.Send("ADMIN_ListServers", struct{}{})
+//   data, err := c.Expect("S2C_ListServers", 3*time.Second)
+
+import (
+	"github.com/guogeer/husky/log"
+	"net"
+	"time"
+)
+
+func BindAdmin(name string, h Handler, args interface{}, opts ...BindOption) {
+	h2 := func(ctx *Context, i interface{}) {
+		if !isAdminContext(ctx) {
+			log.Warnf("reject admin message %s from %s", name, ctx.Out.RemoteAddr())
+			return
+		}
+		h(ctx, i)
+	}
+	BindWithName(name, h2, args, opts...)
+}
+
+func isAdminContext(ctx *Context) bool {
+	return ctx.isGateway == false
+}
+
+// 运维工具直连路由或服务的连接，仅在单个协程中使用
+type AdminConn struct {
+	*TCPConn
+}
+
+func DialAdmin(addr string) (*AdminConn, error) {
+	rwc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &AdminConn{TCPConn: &TCPConn{rwc: rwc}}
+	// 第一个包发送校验数据
+	firstPackage, _ := defaultAuthParser.Encode(&Package{Nonce: newNonce()})
+	if _, err := c.writeMsg(AuthMessage, firstPackage); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *AdminConn) Send(name string, i interface{}) error {
+	buf, err := defaultRawParser.Encode(&Package{Id: name, Body: i})
+	if err != nil {
+		return err
+	}
+	_, err = c.writeMsg(RawMessage, buf)
+	return err
+}
+
+// 等待指定的消息，期间收到的其他消息将被忽略
+func (c *AdminConn) Expect(name string, timeout time.Duration) ([]byte, error) {
+	c.rwc.SetReadDeadline(time.Now().Add(timeout))
+	defer c.rwc.SetReadDeadline(time.Time{})
+	for {
+		mt, buf, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if mt != RawMessage {
+			continue
+		}
+		pkg, err := defaultRawParser.Decode(buf)
+		if err != nil {
+			return nil, err
+		}
+		if pkg.Id == name {
+			return pkg.Data, nil
+		}
+	}
+}
+
+func (c *AdminConn) Close() {
+	c.rwc.Close()
+}
@@ -0,0 +1,153 @@
+package cmd
+
+// 连接准入
+// 拒绝名单优先，允许名单非空时仅允许名单内的IP，最后依次执行自定义检查（GeoIP等）
+// 仅作用于外部客户端连接，规则为CIDR或IP，多个以逗号分隔
+//   <Admission>
+//     <Allow>10.0.0.0/8,192.168.1.10</Allow>
+//     <Deny>10.0.0.1</Deny>
+//   </Admission>
+// 运行时可通过ADMIN_SetAdmissionRules更新
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	errAdmissionDeny     = errors.New("ip is denied")
+	errAdmissionNotAllow = errors.New("ip is not allowed")
+)
+
+type AdmissionHook interface {
+	Admit(ip net.IP) error
+}
+
+type AdmissionFunc func(ip net.IP) error
+
+func (f AdmissionFunc) Admit(ip net.IP) error {
+	return f(ip)
+}
+
+// CIDR或IP
+type AdmissionRules struct {
+	Allow []string
+	Deny  []string
+}
+
+type admission struct {
+	allow, deny []*net.IPNet
+	hooks       []AdmissionHook
+	mu          sync.RWMutex
+}
+
+var defaultAdmission = &admission{}
+
+func init() {
+	var rules AdmissionRules
+	if err := config.Unmarshal("Admission", &rules); err != nil {
+		log.Errorf("load admission rules %v", err)
+		return
+	}
+	if err := SetAdmissionRules(&rules); err != nil {
+		log.Errorf("set admission rules %v", err)
+	}
+}
+
+func parseCIDRs(a []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range a {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s = s + "/32"
+			} else {
+				s = s + "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 更新准入规则，规则有误时保持原规则
+func SetAdmissionRules(rules *AdmissionRules) error {
+	allow, err := parseCIDRs(rules.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseCIDRs(rules.Deny)
+	if err != nil {
+		return err
+	}
+
+	a := defaultAdmission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allow, a.deny = allow, deny
+	return nil
+}
+
+func AddAdmissionHook(h AdmissionHook) {
+	a := defaultAdmission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hooks = append(a.hooks, h)
+}
+
+// 检查远程地址是否允许连接
+func Admit(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	a := defaultAdmission
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if containsIP(a.deny, ip) {
+		return errAdmissionDeny
+	}
+	if len(a.allow) > 0 && !containsIP(a.allow, ip) {
+		return errAdmissionNotAllow
+	}
+	for _, h := range a.hooks {
+		if err := h.Admit(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func funcSetAdmissionRules(ctx *Context, data interface{}) {
+	rules := data.(*AdmissionRules)
+	if err := SetAdmissionRules(rules); err != nil {
+		log.Errorf("set admission rules %v", err)
+		return
+	}
+	log.Infof("set admission rules allow %v deny %v", rules.Allow, rules.Deny)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", " 192.168.1.10 ", "", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("parse %d nets", len(nets))
+	}
+	samples := map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+		"::1":          true,
+		"::2":          false,
+	}
+	for s, ok := range samples {
+		if containsIP(nets, net.ParseIP(s)) != ok {
+			t.Errorf("%s %v", s, ok)
+		}
+	}
+	if _, err := parseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid cidr")
+	}
+	if _, err := parseCIDRs([]string{"host"}); err == nil {
+		t.Error("invalid ip")
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	defer SetAdmissionRules(&AdmissionRules{})
+
+	rules := &AdmissionRules{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}}
+	if err := SetAdmissionRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	samples := map[string]error{
+		"10.0.0.1:1234": errAdmissionDeny, // 拒绝名单优先
+		"10.0.0.2:1234": nil,
+		"10.0.0.2":      nil,
+		"172.16.0.1:80": errAdmissionNotAllow,
+	}
+	for addr, want := range samples {
+		if err := Admit(addr); err != want {
+			t.Errorf("%s %v %v", addr, err, want)
+		}
+	}
+
+	// 规则有误时保持原规则
+	if err := SetAdmissionRules(&AdmissionRules{Deny: []string{"bad"}}); err == nil {
+		t.Error("invalid rules")
+	}
+	if err := Admit("10.0.0.1:1234"); err != errAdmissionDeny {
+		t.Error("rules changed", err)
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+// 会话的服务实例
+// 路由向网关推送各服务已连接且未下线的实例，实例变化时重新推送
+// 会话首次路由至服务时，网关按会话ID一致性哈希选择实例，之后会话的消息均转发至该实例
+// 网关至同一实例的连接由会话共用，连接断开后不再重连，会话重新选择
+// 尚未收到服务的实例列表时使用连接池中的连接，由路由随机选择实例
+
+import (
+	"github.com/guogeer/husky/util"
+	"sync"
+	"sync/atomic"
+)
+
+type InstanceInfo struct {
+	Addr    string
+	Version string `json:",omitempty"`
+	Weight  int    `json:",omitempty"`
+}
+
+type InstanceListArgs struct {
+	AppId      string `json:",omitempty"`
+	ServerName string
+	Instances  []InstanceInfo
+}
+
+// 按会话选择实例的哈希环，各版本分别构建
+type InstanceRing struct {
+	instances []InstanceInfo
+	rings     map[string]*util.HashRing
+	mu        sync.Mutex
+}
+
+var (
+	instanceRings = make(map[string]*InstanceRing) // 应用及服务名 -> 实例
+	instanceMu    sync.RWMutex
+)
+
+func NewInstanceRing(instances []InstanceInfo) *InstanceRing {
+	return &InstanceRing{instances: instances, rings: make(map[string]*util.HashRing)}
+}
+
+// 优先选择指定版本，版本不存在时选择默认版本，仅存在带版本的实例时从全部实例中选择
+func (r *InstanceRing) Choose(version, key string) string {
+	for _, v := range []string{version, "", "*"} {
+		if ring := r.ring(v); ring != nil {
+			return ring.GetNode(key)
+		}
+	}
+	return ""
+}
+
+// 权重按最大值缩放至节点权重上限内
+func (r *InstanceRing) ring(version string) *util.HashRing {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ring, ok := r.rings[version]; ok {
+		return ring
+	}
+
+	var matched []InstanceInfo
+	maxWeight := 1
+	for _, inst := range r.instances {
+		if version == "*" || inst.Version == version {
+			matched = append(matched, inst)
+			if inst.Weight > maxWeight {
+				maxWeight = inst.Weight
+			}
+		}
+	}
+	var ring *util.HashRing
+	if len(matched) > 0 {
+		ring = util.NewHashRing(0)
+		for _, inst := range matched {
+			weight := inst.Weight
+			if maxWeight > util.MaxNodeWeight {
+				weight = weight * util.MaxNodeWeight / maxWeight
+			}
+			ring.AddWeighted(inst.Addr, weight)
+		}
+	}
+	r.rings[version] = ring
+	return ring
+}
+
+// 路由推送的实例列表，为空时删除
+func funcSetInstances(ctx *Context, data interface{}) {
+	args := data.(*InstanceListArgs)
+	setInstances(args)
+}
+
+func setInstances(args *InstanceListArgs) {
+	key := clientKey(args.AppId, args.ServerName, "")
+	instanceMu.Lock()
+	defer instanceMu.Unlock()
+	if len(args.Instances) == 0 {
+		delete(instanceRings, key)
+	} else {
+		instanceRings[key] = NewInstanceRing(args.Instances)
+	}
+}
+
+func chooseInstance(app, serverName, version, ssid string) string {
+	instanceMu.RLock()
+	r := instanceRings[clientKey(app, serverName, "")]
+	instanceMu.RUnlock()
+	if r == nil {
+		return ""
+	}
+	return r.Choose(version, ssid)
+}
+
+func (cm *clientManage) sessionClient(ssid, app, serverName, version string, stripe int) *Client {
+	addr := chooseInstance(app, serverName, version, ssid)
+	if addr == "" || cm.isDrained(addr) {
+		return cm.getStripe(app, serverName, version, stripe)
+	}
+	return cm.getInstance(app, serverName, version, addr, stripe)
+}
+
+// 至指定地址实例的连接，不存在时创建并连接
+func (cm *clientManage) getInstance(app, serverName, version, addr string, stripe int) *Client {
+	key := stripeKey(clientKey(app, serverName, version)+"@"+addr, stripe)
+	cm.mu.RLock()
+	client, ok := cm.clients[key]
+	cm.mu.RUnlock()
+	if ok {
+		return client
+	}
+
+	cm.mu.Lock()
+	client, ok = cm.clients[key]
+	if !ok {
+		client = newClient(serverName)
+		client.version = version
+		client.app = app
+		client.stripe = stripe
+		client.instance = true
+		client.addr.Store(addr)
+		cm.clients[key] = client
+	}
+	cm.mu.Unlock()
+	if !ok {
+		cm.connect(client)
+	}
+	return client
+}
+
+// 连接不再使用，已绑定的会话重新选择
+func (cm *clientManage) retire(client *Client) {
+	atomic.StoreInt32(&client.retired, 1)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for key, c := range cm.clients {
+		if c == client {
+			delete(cm.clients, key)
+		}
+	}
+}
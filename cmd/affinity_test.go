@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInstanceRing(t *testing.T) {
+	r := NewInstanceRing([]InstanceInfo{
+		{Addr: "127.0.0.1:9001"},
+		{Addr: "127.0.0.1:9002", Weight: 300},
+		{Addr: "127.0.0.1:9003", Version: "v2", Weight: 100},
+	})
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		ssid := fmt.Sprintf("s%d", i)
+		addr := r.Choose("", ssid)
+		if addr != r.Choose("", ssid) {
+			t.Fatal("unstable", ssid)
+		}
+		counts[addr]++
+	}
+	// 权重按比例缩放，权重为1的实例仍可被选择
+	if counts["127.0.0.1:9003"] != 0 || counts["127.0.0.1:9001"] == 0 || counts["127.0.0.1:9002"] < 5*counts["127.0.0.1:9001"] {
+		t.Error("default version", counts)
+	}
+	if addr := r.Choose("v2", "s1"); addr != "127.0.0.1:9003" {
+		t.Error("version", addr)
+	}
+	if addr := r.Choose("v3", "s1"); addr != r.Choose("", "s1") {
+		t.Error("fallback to default version", addr)
+	}
+	if addr := NewInstanceRing([]InstanceInfo{{Addr: "127.0.0.1:9003", Version: "v2"}}).Choose("", "s1"); addr != "127.0.0.1:9003" {
+		t.Error("versioned only", addr)
+	}
+}
+
+func TestSessionInstance(t *testing.T) {
+	setInstances(&InstanceListArgs{ServerName: "affinity", Instances: []InstanceInfo{{Addr: "127.0.0.1:9001"}, {Addr: "127.0.0.1:9002"}}})
+	defer setInstances(&InstanceListArgs{ServerName: "affinity"})
+
+	cm := &clientManage{clients: make(map[string]*Client), drains: make(map[string]bool)}
+	a := newTestDrainClient(cm, "affinity@127.0.0.1:9001", "127.0.0.1:9001")
+	b := newTestDrainClient(cm, "affinity@127.0.0.1:9002", "127.0.0.1:9002")
+	a.instance, b.instance = true, true
+	pool := newTestDrainClient(cm, "nolist", "127.0.0.1:9003")
+
+	sessions := make(map[string]*Session)
+	route := func(serverName, ssid string) {
+		ss := sessions[ssid]
+		if ss == nil {
+			ss = &Session{Id: ssid}
+			sessions[ssid] = ss
+		}
+		cm.routeSession(ss, "", serverName, "", []byte(ssid))
+	}
+	var ssidA string
+	for i := 0; i < 20; i++ {
+		ssid := fmt.Sprintf("s%d", i)
+		route("affinity", ssid)
+		if chooseInstance("", "affinity", "", ssid) == "127.0.0.1:9001" {
+			ssidA = ssid
+		}
+	}
+	if len(a.send)+len(b.send) != 20 || len(a.send) == 0 || len(b.send) == 0 {
+		t.Fatal("choose instance", len(a.send), len(b.send))
+	}
+	// 未收到实例列表的服务使用连接池
+	route("nolist", "s1")
+	if len(pool.send) != 1 {
+		t.Error("pool", len(pool.send))
+	}
+
+	// 实例列表变化时已绑定的会话不变
+	n := len(a.send)
+	setInstances(&InstanceListArgs{ServerName: "affinity", Instances: []InstanceInfo{{Addr: "127.0.0.1:9002"}}})
+	route("affinity", ssidA)
+	if len(a.send) != n+1 {
+		t.Error("session moved", len(a.send))
+	}
+
+	// 实例连接断开后重新选择
+	atomic.StoreInt32(&a.state, StateClosed)
+	cm.retire(a)
+	m := len(b.send)
+	route("affinity", ssidA)
+	if len(a.send) != n+1 || len(b.send) != m+1 || cm.clients["affinity@127.0.0.1:9001"] != nil {
+		t.Error("rebind after close", len(a.send), len(b.send))
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+// 消息别名，服务端消息改名后兼容已发布的客户端
+// 网关收到客户端的旧消息ID时按新消息ID处理，发送新消息ID时改回旧消息ID
+// 指定MaxVersion时仅版本不高于MaxVersion的客户端收到旧消息ID，未携带版本视为最低版本
+//   <MessageAliases>
+//     <Alias Old="EnterRoom" New="room.Enter"/>
+//     <Alias Old="RoomInfo" New="RoomDetail" MaxVersion="1.3.0"/>
+//   </MessageAliases>
+// 运行时可通过ADMIN_SetMessageAliases更新
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sync/atomic"
+)
+
+type MessageAlias struct {
+	Old        string
+	New        string
+	MaxVersion string `json:",omitempty"`
+}
+
+type messageAliasesConfig struct {
+	Aliases []MessageAlias `config:"Alias"`
+}
+
+type messageAliases struct {
+	inbound  map[string]string       // 旧消息ID -> 新消息ID
+	outbound map[string]MessageAlias // 新消息ID -> 别名
+}
+
+var defaultAliases atomic.Value
+
+func init() {
+	defaultAliases.Store(&messageAliases{})
+
+	var cfg messageAliasesConfig
+	if err := config.Unmarshal("MessageAliases", &cfg); err != nil {
+		log.Errorf("load message aliases %v", err)
+		return
+	}
+	SetMessageAliases(cfg.Aliases)
+}
+
+// 替换全部别名
+func SetMessageAliases(aliases []MessageAlias) {
+	m := &messageAliases{
+		inbound:  make(map[string]string),
+		outbound: make(map[string]MessageAlias),
+	}
+	for _, alias := range aliases {
+		if alias.Old == "" || alias.New == "" {
+			continue
+		}
+		m.inbound[alias.Old] = alias.New
+		m.outbound[alias.New] = alias
+	}
+	defaultAliases.Store(m)
+}
+
+// 客户端消息的新消息ID
+func resolveAlias(id string) string {
+	m := defaultAliases.Load().(*messageAliases)
+	if name, ok := m.inbound[id]; ok {
+		return name
+	}
+	return id
+}
+
+// 发送至客户端的消息ID，旧版本客户端使用旧消息ID
+func clientMessageName(ssid, name string) string {
+	m := defaultAliases.Load().(*messageAliases)
+	alias, ok := m.outbound[name]
+	if !ok {
+		return name
+	}
+	if alias.MaxVersion != "" {
+		if ss := GetSession(ssid); ss != nil {
+			version, _ := ss.Get(SessionKeyClientVersion).(string)
+			if version != "" && CompareVersion(version, alias.MaxVersion) > 0 {
+				return name
+			}
+		}
+	}
+	return alias.Old
+}
+
+func funcSetMessageAliases(ctx *Context, data interface{}) {
+	args := data.(*messageAliasesConfig)
+	SetMessageAliases(args.Aliases)
+	log.Infof("set message aliases %v", args.Aliases)
+}
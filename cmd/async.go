@@ -0,0 +1,90 @@
+package cmd
+
+// 异步任务，阻塞操作（数据库、HTTP）在工作协程中执行，结果回到主循环处理
+//   cmd.Go(func() interface{} { return db.Query() }, func(ctx *cmd.Context, result interface{}) { ... })
+// 任务异常时结果为error
+
+import (
+	"fmt"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultAsyncWorkers = 64
+
+type asyncTask struct {
+	ctx  *Context
+	task func() interface{}
+	then Handler
+}
+
+var (
+	asyncWorkers = defaultAsyncWorkers
+	asyncPool    *util.WorkerPool
+	asyncStarted atomic.Value
+	asyncOnce    sync.Once
+)
+
+func init() {
+	PublishVar("async_tasks", func() interface{} {
+		return AsyncStats()
+	})
+}
+
+// 设置工作协程数量，需在首次调用Go前设置
+func SetAsyncWorkers(n int) {
+	if n > 0 {
+		asyncWorkers = n
+	}
+}
+
+func runAsyncTask(t *asyncTask) (result interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Error(err)
+			log.Errorf("%s", buf)
+			result = fmt.Errorf("async task panic: %v", err)
+		}
+	}()
+	return t.task()
+}
+
+func startAsyncWorkers() {
+	asyncPool = util.NewWorkerPool(asyncWorkers, 16<<10)
+	asyncStarted.Store(asyncPool)
+}
+
+// 异步任务的队列统计，未执行过异步任务时为nil
+func AsyncStats() *util.WorkerPoolStats {
+	if pool, ok := asyncStarted.Load().(*util.WorkerPool); ok {
+		return pool.Stats()
+	}
+	return nil
+}
+
+// 执行异步任务，then在主循环中执行，可为nil
+func Go(task func() interface{}, then Handler) {
+	goTask(&Context{}, task, then)
+}
+
+// 执行异步任务，then使用当前上下文
+func (ctx *Context) Go(task func() interface{}, then Handler) {
+	goTask(ctx, task, then)
+}
+
+func goTask(ctx *Context, task func() interface{}, then Handler) {
+	asyncOnce.Do(startAsyncWorkers)
+	t := &asyncTask{ctx: ctx, task: task, then: then}
+	asyncPool.Submit(func() {
+		result := runAsyncTask(t)
+		if t.then != nil {
+			enqueueMessage(&Message{ctx: t.ctx, h: t.then, args: result})
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package cmd
+
+// 会话令牌，登录服务签发，网关验证时无需再请求登录服务
+// 令牌格式为 base64(声明).base64(HMAC-SHA256签名)，需配置专用密钥，不使用服务间校验的Sign
+// 密钥未配置时拒绝签发及验证令牌
+//   <AuthToken Key="xxx" TTL="24h"/>
+// 客户端携带令牌连接网关 ws://host/ws?token=xx，验证通过后会话直接完成登录
+// 网关转发会话消息时携带声明，服务通过Context.Claims获取
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+	errNoTokenKey   = errors.New("auth token key not configured")
+)
+
+type TokenClaims struct {
+	UId    int
+	AppId  string            `json:",omitempty"`
+	Expire int64             // 过期时间，unix秒
+	Data   map[string]string `json:",omitempty"` // 自定义声明
+}
+
+type authTokenConfig struct {
+	Key string
+	TTL time.Duration `default:"24h"`
+}
+
+var (
+	authTokenKey []byte
+	authTokenTTL = 24 * time.Hour
+)
+
+func init() {
+	var cfg authTokenConfig
+	if err := config.Unmarshal("AuthToken", &cfg); err != nil {
+		log.Errorf("load auth token %v", err)
+	}
+	authTokenKey = []byte(cfg.Key)
+	if cfg.TTL > 0 {
+		authTokenTTL = cfg.TTL
+	}
+}
+
+func signToken(payload string) string {
+	mac := hmac.New(sha256.New, authTokenKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 签发令牌，未指定过期时间时使用配置的有效期
+func IssueToken(claims *TokenClaims) (string, error) {
+	if len(authTokenKey) == 0 {
+		log.Error("issue token without AuthToken.Key")
+		return "", errNoTokenKey
+	}
+	c := *claims
+	if c.Expire == 0 {
+		c.Expire = time.Now().Add(authTokenTTL).Unix()
+	}
+	buf, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	return payload + "." + signToken(payload), nil
+}
+
+func VerifyToken(token string) (*TokenClaims, error) {
+	if len(authTokenKey) == 0 {
+		log.Error("verify token without AuthToken.Key")
+		return nil, errNoTokenKey
+	}
+	n := strings.IndexByte(token, '.')
+	if n < 0 {
+		return nil, errInvalidToken
+	}
+	payload, sig := token[:n], token[n+1:]
+	if !hmac.Equal([]byte(sig), []byte(signToken(payload))) {
+		return nil, errInvalidToken
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(buf, claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expire {
+		return nil, errTokenExpired
+	}
+	return claims, nil
+}
+
+func decodeClaims(buf json.RawMessage) *TokenClaims {
+	if len(buf) == 0 {
+		return nil
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(buf, claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// 网关上的会话声明，随会话消息转发至服务
+func (ss *Session) SetClaims(claims *TokenClaims) {
+	var buf json.RawMessage
+	if claims != nil {
+		buf, _ = json.Marshal(claims)
+	}
+	ss.mu.Lock()
+	ss.claims = buf
+	ss.mu.Unlock()
+}
+
+func (ss *Session) Claims() *TokenClaims {
+	return decodeClaims(ss.claimsData())
+}
+
+func (ss *Session) claimsData() json.RawMessage {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.claims
+}
+
+// 会话声明，未携带令牌登录时为nil
+func (ctx *Context) Claims() *TokenClaims {
+	if ctx.isGateway {
+		if ss := GetSession(ctx.Ssid); ss != nil {
+			return ss.Claims()
+		}
+		return nil
+	}
+	return decodeClaims(ctx.claims)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthToken(t *testing.T) {
+	key := authTokenKey
+	defer func() { authTokenKey = key }()
+
+	// 未配置密钥时拒绝签发及验证
+	authTokenKey = nil
+	if _, err := IssueToken(&TokenClaims{UId: 1}); err != errNoTokenKey {
+		t.Fatal(err)
+	}
+	forged := "eyJVSWQiOjF9." + signToken("eyJVSWQiOjF9")
+	if _, err := VerifyToken(forged); err != errNoTokenKey {
+		t.Fatal(err)
+	}
+
+	authTokenKey = []byte("key")
+	token, err := IssueToken(&TokenClaims{UId: 1, AppId: "game"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyToken(token)
+	if err != nil || claims.UId != 1 || claims.AppId != "game" {
+		t.Fatal(claims, err)
+	}
+	tampered := []byte(token)
+	tampered[1] ^= 1 // 修改声明
+	if _, err := VerifyToken(string(tampered)); err != errInvalidToken {
+		t.Error("tampered", err)
+	}
+	authTokenKey = []byte("other")
+	if _, err := VerifyToken(token); err != errInvalidToken {
+		t.Error("other key", err)
+	}
+
+	authTokenKey = []byte("key")
+	expired, _ := IssueToken(&TokenClaims{UId: 1, Expire: time.Now().Unix() - 1})
+	if _, err := VerifyToken(expired); err != errTokenExpired {
+		t.Error("expired", err)
+	}
+}
+
+func TestServeWsInvalidToken(t *testing.T) {
+	key := authTokenKey
+	defer func() { authTokenKey = key }()
+	authTokenKey = nil
+
+	// 不返回具体的校验错误
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/ws?token=forged", nil)
+	serveWs(w, r, defaultWsOptions)
+	if w.Code != http.StatusUnauthorized || strings.TrimSpace(w.Body.String()) != "unauthorized" {
+		t.Error(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+// 批量回复
+// 处理函数需回复多条消息时开启批量，之后的回复暂存，处理结束后合并发送
+// 网关转发的会话消息合并为一条FUNC_RouteBatch，由网关按顺序下发客户端；其他连接按顺序写入
+//   ctx.Batch()
+//   ctx.WriteJSON("Cards", cards)
+//   ctx.Ok(score)
+// 可调用Flush提前发送；网关不支持时退化为逐条FUNC_Route
+
+const FeatureRouteBatch = "routebatch" // 网关支持FUNC_RouteBatch，由网关注册
+
+type routeItem struct {
+	Id       string
+	Data     interface{}
+	Compress bool `json:",omitempty"`
+}
+
+type routeBatchArgs struct {
+	Messages []routeItem
+}
+
+// 对端声明的特性
+type peerFeatureConn interface {
+	PeerHasFeature(name string) bool
+}
+
+// 开启批量回复，处理结束后自动发送
+func (ctx *Context) Batch() {
+	if ctx.batch != nil {
+		return
+	}
+	ctx.batch = &routeBatchArgs{}
+	ctx.Defer(func() { ctx.Flush() })
+}
+
+// 发送暂存的回复并结束批量
+func (ctx *Context) Flush() error {
+	batch := ctx.batch
+	ctx.batch = nil
+	if batch == nil || len(batch.Messages) == 0 {
+		return nil
+	}
+
+	fc, _ := ctx.Out.(peerFeatureConn)
+	if ctx.Ssid != "" && !ctx.isGateway && fc != nil && fc.PeerHasFeature(FeatureRouteBatch) {
+		ss := &Session{Id: ctx.Ssid, Out: ctx.Out}
+		ss.WriteJSON("FUNC_RouteBatch", batch)
+		return nil
+	}
+	for _, item := range batch.Messages {
+		if err := ctx.write(item.Id, item.Data, item.Compress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestBatchFlush(t *testing.T) {
+	for _, sample := range []struct {
+		features []string
+		ids      []string
+	}{
+		{[]string{FeatureRouteBatch}, []string{"FUNC_RouteBatch"}},
+		{nil, []string{"FUNC_Route", "FUNC_Route"}},
+	} {
+		out := &recordConn{features: sample.features}
+		ctx := &Context{Out: out, Ssid: "s1"}
+		ctx.Batch()
+		ctx.WriteJSON("Cards", 1)
+		ctx.WriteJSON("Score", 2)
+		if len(out.bufs) != 0 {
+			t.Fatal("write before flush")
+		}
+		ctx.Flush()
+
+		var ids []string
+		for _, buf := range out.bufs {
+			pkg, err := defaultRawParser.Decode(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, pkg.Id)
+		}
+		if len(ids) != len(sample.ids) || ids[0] != sample.ids[0] {
+			t.Error(sample.features, ids)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package cmd
+
+// 大数据传输，如游戏快照、录像
+// 数据按块发送，每块携带CRC32，最后一块携带完整数据的SHA256
+// 发送失败后使用相同的传输ID重新发送，从接收方已收到的位置继续
+//   接收方：cmd.BindBlob("snapshot", func(ctx *cmd.Context, blob *cmd.Blob) { ... })
+//   发送方：cmd.SendBlob("room", "snapshot", f, size, &cmd.BlobOptions{Id: id})
+// SendBlob阻塞至传输完成，不可在主循环中调用
+// 接收方在主循环外按块顺序写入服务端生成的临时文件，同时累计SHA256，传输ID仅用于标识传输
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBlobChunkSize = 16 << 10 // base64编码后小于帧上限
+	defaultBlobWindow    = 8        // 未确认的块数量上限
+	defaultBlobTimeout   = 10 * time.Second
+	blobIdleTimeout      = 10 * time.Minute // 未完成的传输保留时间
+	maxBlobIdLen         = 64
+)
+
+var (
+	errBlobTimeout  = errors.New("blob transfer timeout")
+	errBlobChecksum = errors.New("blob checksum mismatch")
+	errBlobId       = errors.New("invalid blob id")
+)
+
+type BlobOptions struct {
+	Id        string                  // 传输ID，为空时自动生成，续传时使用相同的ID
+	ChunkSize int                     // 块大小，默认16K
+	Window    int                     // 未确认的块数量上限，默认8
+	Timeout   time.Duration           // 等待确认超时，默认10s
+	Progress  func(sent, total int64) // 已确认的数据量
+}
+
+type blobChunk struct {
+	Id     string
+	Name   string `json:",omitempty"`
+	Offset int64
+	Total  int64
+	Data   []byte `json:",omitempty"`
+	CRC    uint32 `json:",omitempty"`
+	Sum    string `json:",omitempty"` // 完整数据的SHA256，最后一块携带
+	IsOpen bool   `json:",omitempty"` // 开始传输，接收方回复已收到的位置
+}
+
+type blobAck struct {
+	Id     string
+	Offset int64  // 已收到的数据量
+	Err    string `json:",omitempty"`
+}
+
+// 接收完成的数据，保存在临时文件中，处理完毕后删除
+type Blob struct {
+	Id   string
+	Name string
+	Size int64
+	Path string
+}
+
+func (blob *Blob) Open() (*os.File, error) {
+	return os.Open(blob.Path)
+}
+
+type BlobHandler func(*Context, *Blob)
+
+// 发送方
+type blobSender struct {
+	acks chan *blobAck
+}
+
+// 接收方
+type blobReceiver struct {
+	name       string
+	f          *os.File
+	sum        hash.Hash // 已收到数据的SHA256
+	offset     int64
+	total      int64
+	lastActive time.Time
+}
+
+var (
+	blobSenders   = make(map[string]*blobSender)
+	blobReceivers = make(map[string]*blobReceiver)
+	blobHandlers  = make(map[string]BlobHandler)
+	blobMu        sync.Mutex
+)
+
+func init() {
+	BindWithName("FUNC_BlobChunk", funcBlobChunk, (*blobChunk)(nil), WithConcurrency(RunSerialGlobal))
+	BindWithName("FUNC_BlobAck", funcBlobAck, (*blobAck)(nil), WithConcurrency(RunConcurrent))
+}
+
+// 接收指定名称的数据
+func BindBlob(name string, h BlobHandler) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	blobHandlers[name] = h
+}
+
+func SendBlob(serverName, name string, r io.ReaderAt, total int64, opts *BlobOptions) error {
+	if opts == nil {
+		opts = &BlobOptions{}
+	}
+	id, chunkSize, window, timeout := opts.Id, opts.ChunkSize, opts.Window, opts.Timeout
+	if id == "" {
+		id = util.GUID()
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultBlobChunkSize
+	}
+	if window <= 0 {
+		window = defaultBlobWindow
+	}
+	if timeout <= 0 {
+		timeout = defaultBlobTimeout
+	}
+
+	sender := &blobSender{acks: make(chan *blobAck, window+1)}
+	blobMu.Lock()
+	blobSenders[id] = sender
+	blobMu.Unlock()
+	defer func() {
+		blobMu.Lock()
+		delete(blobSenders, id)
+		blobMu.Unlock()
+	}()
+
+	wait := func() (*blobAck, error) {
+		select {
+		case ack := <-sender.acks:
+			if ack.Err != "" {
+				return nil, errors.New(ack.Err)
+			}
+			if opts.Progress != nil {
+				opts.Progress(ack.Offset, total)
+			}
+			return ack, nil
+		case <-time.After(timeout):
+			return nil, errBlobTimeout
+		}
+	}
+
+	// 查询已收到的位置
+	Route(serverName, "FUNC_BlobChunk", &blobChunk{Id: id, Name: name, Total: total, IsOpen: true})
+	ack, err := wait()
+	if err != nil {
+		return err
+	}
+
+	// 计算完整数据的校验值
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, total)); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	pending, isFinal := 0, false
+	buf := make([]byte, chunkSize)
+	for offset := ack.Offset; !isFinal || pending > 0; {
+		if !isFinal && pending < window {
+			n, err := r.ReadAt(buf[:minInt64(int64(chunkSize), total-offset)], offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			chunk := &blobChunk{
+				Id:     id,
+				Offset: offset,
+				Total:  total,
+				Data:   buf[:n],
+				CRC:    crc32.ChecksumIEEE(buf[:n]),
+			}
+			offset += int64(n)
+			if offset >= total {
+				chunk.Sum, isFinal = sum, true
+			}
+			Route(serverName, "FUNC_BlobChunk", chunk)
+			pending++
+			continue
+		}
+		if _, err := wait(); err != nil {
+			return err
+		}
+		pending--
+	}
+	return nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func blobDir() string {
+	return filepath.Join(os.TempDir(), "husky-blob")
+}
+
+// 清理长时间未完成的传输
+func sweepBlobReceivers() {
+	for id, r := range blobReceivers {
+		if time.Since(r.lastActive) > blobIdleTimeout {
+			r.f.Close()
+			os.Remove(r.f.Name())
+			delete(blobReceivers, id)
+		}
+	}
+}
+
+func funcBlobChunk(ctx *Context, data interface{}) {
+	chunk := data.(*blobChunk)
+	ack := &blobAck{Id: chunk.Id}
+	if err := receiveBlobChunk(ctx, chunk, ack); err != nil {
+		log.Warnf("receive blob %s %v", chunk.Id, err)
+		ack.Err = err.Error()
+	}
+	ctx.Out.WriteJSON("FUNC_BlobAck", ack)
+}
+
+func receiveBlobChunk(ctx *Context, chunk *blobChunk, ack *blobAck) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	if chunk.Id == "" || len(chunk.Id) > maxBlobIdLen {
+		return errBlobId
+	}
+	r := blobReceivers[chunk.Id]
+	if chunk.IsOpen {
+		sweepBlobReceivers()
+		if _, ok := blobHandlers[chunk.Name]; !ok {
+			return errors.New("blob " + chunk.Name + " not bound")
+		}
+		if r == nil || r.total != chunk.Total {
+			if r != nil {
+				r.f.Close()
+				os.Remove(r.f.Name())
+			}
+			os.MkdirAll(blobDir(), 0755)
+			f, err := os.CreateTemp(blobDir(), "blob-*")
+			if err != nil {
+				return err
+			}
+			r = &blobReceiver{name: chunk.Name, f: f, sum: sha256.New(), total: chunk.Total}
+			blobReceivers[chunk.Id] = r
+		}
+		r.lastActive = time.Now()
+		ack.Offset = r.offset
+		return nil
+	}
+
+	if r == nil {
+		return errors.New("blob transfer not open")
+	}
+	r.lastActive = time.Now()
+	// 重复的块
+	if chunk.Offset < r.offset {
+		ack.Offset = r.offset
+		return nil
+	}
+	if chunk.Offset != r.offset {
+		return errors.New("blob chunk out of order")
+	}
+	if crc32.ChecksumIEEE(chunk.Data) != chunk.CRC {
+		return errBlobChecksum
+	}
+	if _, err := r.f.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return err
+	}
+	r.sum.Write(chunk.Data)
+	r.offset += int64(len(chunk.Data))
+	ack.Offset = r.offset
+	if r.offset < r.total {
+		return nil
+	}
+
+	// 接收完毕
+	delete(blobReceivers, chunk.Id)
+	path := r.f.Name()
+	err := r.f.Close()
+	if err == nil && hex.EncodeToString(r.sum.Sum(nil)) != chunk.Sum {
+		err = errBlobChecksum
+	}
+	if err != nil {
+		os.Remove(path)
+		ack.Offset = 0
+		return err
+	}
+
+	blob := &Blob{Id: chunk.Id, Name: r.name, Size: r.total, Path: path}
+	handler := blobHandlers[r.name]
+	Enqueue(ctx, func(ctx *Context, _ interface{}) {
+		defer os.Remove(path)
+		handler(ctx, blob)
+	}, nil)
+	return nil
+}
+
+func funcBlobAck(ctx *Context, data interface{}) {
+	ack := data.(*blobAck)
+	blobMu.Lock()
+	sender := blobSenders[ack.Id]
+	blobMu.Unlock()
+	if sender != nil {
+		select {
+		case sender.acks <- ack:
+		default:
+		}
+	}
+}
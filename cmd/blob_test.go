@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestChunk(id string, offset, total int64, data []byte) *blobChunk {
+	return &blobChunk{Id: id, Offset: offset, Total: total, Data: data, CRC: crc32.ChecksumIEEE(data)}
+}
+
+func TestBlobPath(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	BindBlob("path", func(ctx *Context, blob *Blob) {})
+	ctx := &Context{Out: &recordConn{}}
+
+	for _, id := range []string{"../../escape", "/etc/passwd", `..\..\escape`} {
+		if err := receiveBlobChunk(ctx, &blobChunk{Id: id, Name: "path", Total: 4, IsOpen: true}, &blobAck{}); err != nil {
+			t.Fatal(id, err)
+		}
+		r := blobReceivers[id]
+		if dir := filepath.Dir(r.f.Name()); dir != blobDir() {
+			t.Error("blob file outside dir", id, r.f.Name())
+		}
+		r.f.Close()
+		os.Remove(r.f.Name())
+		delete(blobReceivers, id)
+	}
+	if _, err := os.Stat(filepath.Join(blobDir(), "..", "escape")); err == nil {
+		t.Error("file created outside dir")
+	}
+
+	long := make([]byte, maxBlobIdLen+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	for _, id := range []string{"", string(long)} {
+		if err := receiveBlobChunk(ctx, &blobChunk{Id: id, Name: "path", IsOpen: true}, &blobAck{}); err != errBlobId {
+			t.Error("invalid id accepted", len(id), err)
+		}
+	}
+}
+
+func TestBlobReassembly(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	var received []string
+	BindBlob("snapshot", func(ctx *Context, blob *Blob) {
+		data, _ := os.ReadFile(blob.Path)
+		received = append(received, string(data))
+	})
+	ctx := &Context{Out: &recordConn{}}
+
+	data := []byte("hello blob world")
+	sum := sha256.Sum256(data)
+	total := int64(len(data))
+	receive := func(chunk *blobChunk) (*blobAck, error) {
+		ack := &blobAck{}
+		err := receiveBlobChunk(ctx, chunk, ack)
+		return ack, err
+	}
+
+	if _, err := receive(&blobChunk{Id: "t1", Name: "snapshot", Total: total, IsOpen: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receive(newTestChunk("t1", 0, total, data[:6])); err != nil {
+		t.Fatal(err)
+	}
+	// 乱序及校验错误
+	if _, err := receive(newTestChunk("t1", 10, total, data[10:])); err == nil {
+		t.Error("out of order accepted")
+	}
+	bad := newTestChunk("t1", 6, total, data[6:10])
+	bad.CRC++
+	if _, err := receive(bad); err != errBlobChecksum {
+		t.Error("bad crc", err)
+	}
+	// 续传从已收到的位置开始，重复的块忽略
+	ack, _ := receive(&blobChunk{Id: "t1", Name: "snapshot", Total: total, IsOpen: true})
+	if ack.Offset != 6 {
+		t.Fatal("resume offset", ack.Offset)
+	}
+	if ack, err := receive(newTestChunk("t1", 0, total, data[:6])); err != nil || ack.Offset != 6 {
+		t.Error("duplicate chunk", ack.Offset, err)
+	}
+	receive(newTestChunk("t1", 6, total, data[6:10]))
+	last := newTestChunk("t1", 10, total, data[10:])
+	last.Sum = hex.EncodeToString(sum[:])
+	if ack, err := receive(last); err != nil || ack.Offset != total {
+		t.Fatal("last chunk", ack.Offset, err)
+	}
+	for GetMessageQueue().Len() > 0 {
+		RunOnce()
+	}
+	if len(received) != 1 || received[0] != string(data) {
+		t.Error("reassembly", received)
+	}
+
+	// 完整数据校验失败
+	receive(&blobChunk{Id: "t2", Name: "snapshot", Total: 4, IsOpen: true})
+	last = newTestChunk("t2", 0, 4, []byte("abcd"))
+	last.Sum = hex.EncodeToString(sum[:])
+	if _, err := receive(last); err != errBlobChecksum {
+		t.Error("sha256 mismatch", err)
+	}
+	if blobReceivers["t2"] != nil {
+		t.Error("receiver not removed")
+	}
+}
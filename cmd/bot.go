@@ -0,0 +1,226 @@
+package cmd
+
+// 机器人识别，网关按连接特征打分：建立连接后首个消息的耗时、消息间隔的规律性、处理失败的消息比例
+// 可通过RegisterBotHeuristic增加特征，分数为各特征之和
+// 分数达到阈值时：
+//   Flag      向会话所在的服务发送FUNC_SuspiciousSession
+//   Throttle  每个消息延迟处理
+//   Challenge 向客户端发送BotChallenge，超时未回复BotChallengeAnswer时断开
+//   <BotDetection Enable="true" Flag="50" Throttle="70" Challenge="90" ChallengeTimeout="10s"/>
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const botThrottleDelay = 200 * time.Millisecond
+
+type BotDetectionOptions struct {
+	Enable           bool
+	Flag             float64       `default:"50"`
+	Throttle         float64       `default:"70"`
+	Challenge        float64       `default:"90"`
+	ChallengeTimeout time.Duration `default:"10s"`
+}
+
+// 连接特征
+type Fingerprint struct {
+	ConnectTime  time.Time
+	FirstMessage time.Duration // 建立连接至首个消息的耗时
+	Messages     int
+	Invalid      int // 处理失败的消息数量
+
+	last         time.Time
+	mean, m2     float64 // 消息间隔的均值及方差累计，秒
+	intervalSize int
+}
+
+func (fp *Fingerprint) observe(now time.Time, ok bool) {
+	if fp.Messages == 0 {
+		fp.FirstMessage = now.Sub(fp.ConnectTime)
+	} else {
+		x := now.Sub(fp.last).Seconds()
+		fp.intervalSize++
+		delta := x - fp.mean
+		fp.mean += delta / float64(fp.intervalSize)
+		fp.m2 += delta * (x - fp.mean)
+	}
+	fp.last = now
+	fp.Messages++
+	if !ok {
+		fp.Invalid++
+	}
+}
+
+// 消息间隔的变异系数，越小越规律，消息不足时返回-1
+func (fp *Fingerprint) IntervalCV() float64 {
+	if fp.intervalSize < 2 || fp.mean <= 0 {
+		return -1
+	}
+	return math.Sqrt(fp.m2/float64(fp.intervalSize)) / fp.mean
+}
+
+type BotHeuristic func(fp *Fingerprint) float64
+
+type suspiciousSessionArgs struct {
+	Ssid    string
+	Score   float64
+	Reasons []string
+}
+
+type botChallengeArgs struct {
+	Nonce  string
+	Answer string `json:",omitempty"`
+}
+
+var (
+	botOptions    BotDetectionOptions
+	botHeuristics = make(map[string]BotHeuristic)
+	botVerifier   = func(nonce, answer string) bool { return answer == nonce }
+	botMu         sync.RWMutex
+)
+
+func init() {
+	if err := config.Unmarshal("BotDetection", &botOptions); err != nil {
+		log.Errorf("load bot detection %v", err)
+	}
+
+	RegisterBotHeuristic("handshake", func(fp *Fingerprint) float64 {
+		if fp.Messages > 0 && fp.FirstMessage < 50*time.Millisecond {
+			return 30
+		}
+		return 0
+	})
+	RegisterBotHeuristic("cadence", func(fp *Fingerprint) float64 {
+		if cv := fp.IntervalCV(); fp.Messages >= 20 && cv >= 0 && cv < 0.1 {
+			return 40
+		}
+		return 0
+	})
+	RegisterBotHeuristic("invalid", func(fp *Fingerprint) float64 {
+		if fp.Messages >= 10 && fp.Invalid*10 > fp.Messages*3 {
+			return 40
+		}
+		return 0
+	})
+}
+
+// 增加或替换特征，h为nil时删除
+func RegisterBotHeuristic(name string, h BotHeuristic) {
+	botMu.Lock()
+	defer botMu.Unlock()
+	if h == nil {
+		delete(botHeuristics, name)
+	} else {
+		botHeuristics[name] = h
+	}
+}
+
+// 校验客户端的回复，默认回复原样返回Nonce即可
+func SetBotChallengeVerifier(f func(nonce, answer string) bool) {
+	botMu.Lock()
+	defer botMu.Unlock()
+	botVerifier = f
+}
+
+type botDetector struct {
+	fp        Fingerprint
+	flagged   bool
+	throttled bool
+
+	challenged bool
+	nonce      string // 未回复的验证
+	mu         sync.Mutex
+}
+
+func newBotDetector() *botDetector {
+	if !botOptions.Enable {
+		return nil
+	}
+	return &botDetector{fp: Fingerprint{ConnectTime: time.Now()}}
+}
+
+func (d *botDetector) evaluate() (float64, []string) {
+	botMu.RLock()
+	defer botMu.RUnlock()
+	var score float64
+	var reasons []string
+	for name, h := range botHeuristics {
+		if s := h(&d.fp); s > 0 {
+			score += s
+			reasons = append(reasons, name)
+		}
+	}
+	sort.Strings(reasons)
+	return score, reasons
+}
+
+// 在连接的读协程中调用
+func (d *botDetector) observe(c *WsConn, ok bool) {
+	if d == nil {
+		return
+	}
+	d.fp.observe(time.Now(), ok)
+	score, reasons := d.evaluate()
+
+	opts := botOptions
+	if score >= opts.Flag && !d.flagged {
+		d.flagged = true
+		log.Infof("suspicious session %s score %.0f %v", c.ssid, score, reasons)
+		if ss := GetSession(c.ssid); ss != nil {
+			if server, _ := ss.Get(SessionKeyServer).(string); server != "" {
+				ss.Route(server, "FUNC_SuspiciousSession", &suspiciousSessionArgs{Ssid: c.ssid, Score: score, Reasons: reasons})
+			}
+		}
+	}
+	if score >= opts.Challenge && !d.challenged {
+		d.challenged = true
+		d.challenge(c, opts.ChallengeTimeout)
+	}
+	if score >= opts.Throttle {
+		d.throttled = true
+	}
+	if d.throttled {
+		time.Sleep(botThrottleDelay)
+	}
+}
+
+func (d *botDetector) challenge(c *WsConn, timeout time.Duration) {
+	nonce := newResumeToken()
+	d.mu.Lock()
+	d.nonce = nonce
+	d.mu.Unlock()
+	c.WriteJSON("BotChallenge", &botChallengeArgs{Nonce: nonce})
+
+	time.AfterFunc(timeout, func() {
+		d.mu.Lock()
+		pending := d.nonce == nonce
+		d.mu.Unlock()
+		if pending {
+			log.Infof("session %s bot challenge timeout", c.ssid)
+			Enqueue(&Context{Out: c, Ssid: c.ssid}, funcClose, nil)
+		}
+	})
+}
+
+func funcBotChallengeAnswer(ctx *Context, data interface{}) {
+	args := data.(*botChallengeArgs)
+	c, ok := ctx.Out.(*WsConn)
+	if !ok || c.bot == nil {
+		return
+	}
+	botMu.RLock()
+	verify := botVerifier
+	botMu.RUnlock()
+
+	d := c.bot
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nonce != "" && d.nonce == args.Nonce && verify(args.Nonce, args.Answer) {
+		d.nonce = ""
+	}
+}
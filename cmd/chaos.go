@@ -0,0 +1,157 @@
+package cmd
+
+// 故障注入，用于上线前测试服务的容错能力，禁止在生产环境开启
+// 按消息ID的概率对收到的消息随机延迟、丢弃、重复、乱序，或断开连接
+//   <Chaos Enable="true">
+//     <Rule Id="*" Delay="0.1" MaxDelay="500ms" Drop="0.01"/>
+//     <Rule Id="C2S_Login" Duplicate="0.2" Reorder="0.2" Kill="0.001"/>
+//   </Chaos>
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const chaosReorderWait = 200 * time.Millisecond // 乱序消息等待后续消息的时间
+
+type ChaosRule struct {
+	Id        string        // 消息ID，*表示全部消息
+	Delay     float64       `json:",omitempty"`
+	MaxDelay  time.Duration `json:",omitempty" default:"1s"`
+	Drop      float64       `json:",omitempty"`
+	Duplicate float64       `json:",omitempty"`
+	Reorder   float64       `json:",omitempty"` // 与同一连接的下一个消息交换顺序
+	Kill      float64       `json:",omitempty"` // 断开连接
+}
+
+type ChaosOptions struct {
+	Enable bool
+	Rules  []ChaosRule `config:"Rule"`
+}
+
+type heldMessage struct {
+	ctx  *Context
+	id   string
+	data []byte
+}
+
+type chaosInjector struct {
+	rules atomic.Value // map[string]ChaosRule，未开启时为nil
+
+	held map[Conn]*heldMessage // 乱序暂存的消息
+	mu   sync.Mutex
+}
+
+var defaultChaos = &chaosInjector{held: make(map[Conn]*heldMessage)}
+
+func init() {
+	var opts ChaosOptions
+	if err := config.Unmarshal("Chaos", &opts); err != nil {
+		log.Errorf("load chaos %v", err)
+	}
+	SetChaos(opts)
+}
+
+func SetChaos(opts ChaosOptions) {
+	if !opts.Enable {
+		defaultChaos.rules.Store(map[string]ChaosRule(nil))
+		return
+	}
+	log.Warnf("chaos fault injection enabled")
+	m := make(map[string]ChaosRule)
+	for _, rule := range opts.Rules {
+		if rule.Id == "" {
+			rule.Id = "*"
+		}
+		m[rule.Id] = rule
+	}
+	defaultChaos.rules.Store(m)
+}
+
+func (ci *chaosInjector) match(id string) (ChaosRule, bool) {
+	m := ci.rules.Load().(map[string]ChaosRule)
+	if m == nil {
+		return ChaosRule{}, false
+	}
+	if rule, ok := m[id]; ok {
+		return rule, true
+	}
+	rule, ok := m["*"]
+	return rule, ok
+}
+
+func hit(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// 返回true时消息已由故障注入接管
+func (ci *chaosInjector) inject(s *CmdSet, ctx *Context, id string, data []byte) bool {
+	rule, ok := ci.match(id)
+	if !ok {
+		return false
+	}
+
+	handle := func() { s.handle(ctx, id, data) }
+	switch {
+	case hit(rule.Kill):
+		log.Debugf("chaos kill %s on %s", ctx.Out.RemoteAddr(), id)
+		Enqueue(ctx, funcClose, nil)
+		return true
+	case hit(rule.Drop):
+		log.Debugf("chaos drop %s", id)
+		return true
+	case hit(rule.Duplicate):
+		handle()
+		handle()
+		return true
+	case hit(rule.Delay) && rule.MaxDelay > 0:
+		d := time.Duration(rand.Int63n(int64(rule.MaxDelay)))
+		time.AfterFunc(d, handle)
+		return true
+	case hit(rule.Reorder):
+		ci.hold(s, &heldMessage{ctx: ctx, id: id, data: data})
+		return true
+	}
+	return ci.release(s, ctx, id, data)
+}
+
+// 暂存消息，超时未收到后续消息时处理
+func (ci *chaosInjector) hold(s *CmdSet, msg *heldMessage) {
+	ci.mu.Lock()
+	prev := ci.held[msg.ctx.Out]
+	ci.held[msg.ctx.Out] = msg
+	ci.mu.Unlock()
+	if prev != nil {
+		s.handle(prev.ctx, prev.id, prev.data)
+	}
+
+	time.AfterFunc(chaosReorderWait, func() {
+		ci.mu.Lock()
+		cur := ci.held[msg.ctx.Out]
+		if cur == msg {
+			delete(ci.held, msg.ctx.Out)
+		}
+		ci.mu.Unlock()
+		if cur == msg {
+			s.handle(msg.ctx, msg.id, msg.data)
+		}
+	})
+}
+
+// 先处理当前消息，再处理暂存的消息
+func (ci *chaosInjector) release(s *CmdSet, ctx *Context, id string, data []byte) bool {
+	ci.mu.Lock()
+	prev, ok := ci.held[ctx.Out]
+	delete(ci.held, ctx.Out)
+	ci.mu.Unlock()
+	if !ok {
+		return false
+	}
+	s.handle(ctx, id, data)
+	s.handle(prev.ctx, prev.id, prev.data)
+	return true
+}
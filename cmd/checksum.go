@@ -0,0 +1,103 @@
+package cmd
+
+// 数据帧校验，双方均通过RegisterFeature开启
+// 协商特性crc32或hmac后，数据帧尾部追加校验码，帧头版本标识置checksumFlag位
+// hmac需各服务配置相同的Sign，未配置时退化为crc32
+// 收到带校验的数据帧后，连接不再接受未校验的数据帧
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+const (
+	FeatureCRC32 = "crc32"
+	FeatureHMAC  = "hmac"
+)
+
+const (
+	checksumFlag = 0x10 // 数据帧头部版本标识，0x11~0x1f表示带校验
+
+	crc32Size = 4
+	hmacSize  = 16 // 截取HMAC-SHA256前16字节
+)
+
+var (
+	errChecksum         = errors.New("invalid frame checksum")
+	errMissingChecksum  = errors.New("missing frame checksum")
+	errUnexpectChecksum = errors.New("unexpected frame checksum")
+)
+
+type checksumFunc func([]byte) []byte
+
+func authKey() string {
+	if h, ok := defaultAuthParser.(*hashParser); ok {
+		return h.key
+	}
+	return ""
+}
+
+func crc32Sum(data []byte) []byte {
+	var sum [crc32Size]byte
+	n := crc32.ChecksumIEEE(data)
+	sum[0], sum[1], sum[2], sum[3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+	return sum[:]
+}
+
+func hmacSum(key string) checksumFunc {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(data)
+		return mac.Sum(nil)[:hmacSize]
+	}
+}
+
+// 协商后的校验方式，未协商时为nil
+func (c *TCPConn) checksum() checksumFunc {
+	if key := authKey(); key != "" && c.HasFeature(FeatureHMAC) {
+		return hmacSum(key)
+	}
+	if c.HasFeature(FeatureCRC32) || c.HasFeature(FeatureHMAC) {
+		return crc32Sum
+	}
+	return nil
+}
+
+// 追加校验码
+func (c *TCPConn) sealFrame(data []byte) ([]byte, bool) {
+	sum := c.checksum()
+	if sum == nil {
+		return data, false
+	}
+	buf := make([]byte, 0, len(data)+hmacSize)
+	buf = append(buf, data...)
+	return append(buf, sum(data)...), true
+}
+
+// 校验并去除校验码
+func (c *TCPConn) openFrame(buf []byte, isSealed bool) ([]byte, error) {
+	if !isSealed {
+		if atomic.LoadInt32(&c.sealed) != 0 {
+			return nil, errMissingChecksum
+		}
+		return buf, nil
+	}
+
+	sum := c.checksum()
+	if sum == nil {
+		return nil, errUnexpectChecksum
+	}
+	n := len(buf) - len(sum(nil))
+	if n < 0 {
+		return nil, errChecksum
+	}
+	data := buf[:n]
+	if !hmac.Equal(sum(data), buf[n:]) {
+		return nil, errChecksum
+	}
+	atomic.StoreInt32(&c.sealed, 1)
+	return data, nil
+}
@@ -2,27 +2,39 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/guogeer/husky/config"
 	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	name string
+	name    string
+	version string // 服务版本，空表示默认
+	app     string // 服务所属应用
+	stripe  int    // 连接池中的序号
 	*TCPConn
 
-	reg interface{}
+	state int32        // 连接状态
+	addr  atomic.Value // 已连接的地址
+
+	reg      interface{}
+	buffered routeBuffer // 连接断开期间暂存的消息
+	retired  int32       // 实例下线，仅转发已绑定的会话，断开后不再重连
+	instance bool        // 至会话选择的实例的连接，地址固定
 }
 
 func newClient(name string) *Client {
 	client := &Client{
-		name: name,
-		TCPConn: &TCPConn{
-			send: make(chan []byte, sendQueueSize),
-		},
+		name:    name,
+		TCPConn: &TCPConn{},
 	}
+	client.send = client.queue.init(QueueClassClient)
 	return client
 }
 
@@ -30,23 +42,43 @@ func (c *Client) ServerName() string {
 	return c.name
 }
 
+func (c *Client) ServerVersion() string {
+	return c.version
+}
+
+func clientKey(app, name, version string) string {
+	if version != "" {
+		name = name + "@" + version
+	}
+	if app != "" {
+		name = app + "/" + name
+	}
+	return name
+}
+
 func (c *Client) start() {
 	defaultCmdSet.RecoverService(c.name) // 恢复服务
+	c.resetClose()
 
 	doneCtx, cancel := context.WithCancel(context.Background())
+	go c.keepalive(doneCtx.Done(), func() error {
+		_, err := c.writeMsg(PingMessage, nil)
+		return err
+	}, func() { c.rwc.Close() })
 	go func() {
-		ticker := time.NewTicker(pingPeriod)
 		defer func() {
-			ticker.Stop() // 关闭定时器
 			c.rwc.Close() // 关闭连接
+			atomic.StoreInt32(&c.state, StateClosed)
 
 			// 关闭后，自动重连，并消息通知
-			defaultCmdSet.Handle(&Context{Out: c}, "CMD_AutoConnect", nil)
-			defaultCmdSet.Handle(&Context{Out: c}, "FUNC_ServerClose", nil)
+			defaultCmdSet.HandleEvent(&Context{Out: c}, "CMD_AutoConnect")
+			if c.stripe == 0 && !c.instance && !c.isRetired() {
+				defaultCmdSet.HandleEvent(&Context{Out: c}, "FUNC_ServerClose")
+			}
 		}()
 
-		// 第一个包发送校验数据
-		firstPackage, err := defaultAuthParser.Encode(&Package{})
+		// 第一个包发送校验数据及版本协商数据
+		firstPackage, err := defaultAuthParser.Encode(&Package{Body: newHandshake(), Nonce: newNonce()})
 		if err != nil {
 			return
 		}
@@ -57,15 +89,15 @@ func (c *Client) start() {
 			select {
 			case buf, ok := <-c.send:
 				if ok == false {
+					c.shutdown(doneCtx.Done())
 					return
 				}
 				if _, err := c.writeMsg(RawMessage, buf); err != nil {
 					return
 				}
-			case <-ticker.C: // heart beat
-				if _, err := c.writeMsg(PingMessage, nil); err != nil {
-					return
-				}
+			case <-c.peerClosed():
+				c.replyClose()
+				return
 			case <-doneCtx.Done():
 				return
 			}
@@ -81,8 +113,22 @@ func (c *Client) start() {
 			log.Debugf("read %v", err)
 			return
 		}
+		if mt >= MinCustomFrame && mt <= MaxCustomFrame {
+			c.handleFrame(c, mt, buf)
+		}
 		switch mt {
-		case PingMessage, PongMessage:
+		case CloseMessage:
+			c.notifyPeerClose()
+		case PingMessage:
+		case PongMessage:
+			c.pong()
+		case HandshakeMessage:
+			h := &Handshake{}
+			if err := json.Unmarshal(buf, h); err != nil {
+				log.Debugf("handshake %v", err)
+				return
+			}
+			c.setHandshake(h)
 		case RawMessage:
 			// log.Info("read", string(buf[:n]))
 			pkg, err := defaultRawParser.Decode(buf)
@@ -91,7 +137,11 @@ func (c *Client) start() {
 			}
 
 			id, ssid, data := pkg.Id, pkg.Ssid, pkg.Data
-			err = defaultCmdSet.Handle(&Context{Out: c, Ssid: ssid}, id, data)
+			ctx := &Context{Out: c, Ssid: ssid, Version: c.ProtocolVersion(), AppId: pkg.AppId, TraceId: pkg.TraceId}
+			ctx.rtt = time.Duration(pkg.RTT) * time.Millisecond
+			ctx.meta = pkg.Meta
+			ctx.claims = pkg.Claims
+			err = defaultCmdSet.Handle(ctx, id, data)
 			if err != nil {
 				log.Errorf("handle message[%s] %v", id, err)
 			}
@@ -102,83 +152,158 @@ func (c *Client) start() {
 type clientManage struct {
 	clients map[string]*Client // 已存在的连接不会被删除
 	mu      sync.RWMutex
+
+	isDrain bool
+	topics  map[string]bool // 已订阅的主题
+	drains  map[string]bool // 路由推送的下线实例地址
+}
+
+type drainArgs struct {
+	IsDrain bool
 }
 
 var defaultClientManage = &clientManage{
 	clients: make(map[string]*Client),
+	topics:  make(map[string]bool),
+	drains:  make(map[string]bool),
 }
 
-func (cm *clientManage) Route(serverName string, data []byte) {
+func (cm *clientManage) Route(serverName string, data []byte) error {
+	return cm.RouteVersion(serverName, "", data)
+}
+
+// 路由至指定版本的服务，版本为空时路由至默认服务
+func (cm *clientManage) RouteVersion(serverName, version string, data []byte) error {
+	return cm.routeApp(localAppId, serverName, version, "", data)
+}
+
+// 路由至指定应用的服务，会话消息按会话ID选择连接池中的连接
+func (cm *clientManage) routeApp(app, serverName, version, ssid string, data []byte) error {
 	if serverName == "" {
-		return
+		return errors.New("empty server name")
+	}
+
+	client := cm.getStripe(app, serverName, version, chooseStripe(serverName, ssid))
+	return cm.writeClient(client, data)
+}
+
+func (cm *clientManage) writeClient(client *Client, data []byte) error {
+	if ok, err := client.bufferRoute(data); ok {
+		return err
+	}
+	if err := client.Write(data); err != nil {
+		log.Errorf("route %s data %d error: %v", client.key(), len(data), err)
+		return err
 	}
+	return nil
+}
 
+// 获取服务的连接，不存在时创建并连接
+func (cm *clientManage) getClient(app, serverName, version string) *Client {
+	return cm.getStripe(app, serverName, version, 0)
+}
+
+func (cm *clientManage) getStripe(app, serverName, version string, stripe int) *Client {
+	// 路由由全部应用共用
+	if serverName == ServerRouter {
+		app = ""
+	}
+	key := stripeKey(clientKey(app, serverName, version), stripe)
 	cm.mu.RLock()
-	client, ok := cm.clients[serverName]
+	client, ok := cm.clients[key]
 	cm.mu.RUnlock()
 
 	if ok == false {
 		cm.mu.Lock()
-		_, ok2 := cm.clients[serverName]
+		_, ok2 := cm.clients[key]
 		if ok2 == false {
 			client = newClient(serverName)
-			cm.clients[serverName] = client
+			client.version = version
+			client.app = app
+			client.stripe = stripe
+			cm.clients[key] = client
 		}
-		client = cm.clients[serverName]
+		client = cm.clients[key]
 		cm.mu.Unlock()
 		// 防止重复连接
 		if ok2 == false {
-			cm.connect(serverName)
+			cm.connect(client)
 		}
 	}
+	return client
+}
 
-	if err := client.Write(data); err != nil {
-		log.Errorf("route %s data %d error: %v", serverName, len(data), err)
-	}
+// 连接失败后重试，仍失败时稍后自动重连
+var connectRetryPolicy = &util.RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Multiplier:   4,
+	Jitter:       0.2,
 }
 
 // 第一步向路由查询地址
 // 第二步建立连接
-func (cm *clientManage) connect(serverName string) {
-	cm.mu.RLock()
-	client := cm.clients[serverName]
-	cm.mu.RUnlock()
-
+func (cm *clientManage) connect(client *Client) {
+	serverName, version, app := client.name, client.version, client.app
+	atomic.StoreInt32(&client.state, StateConecting)
 	go func() {
-		addr := config.Config().Server("router").Addr
-		for try, ms := range []int{100, 400, 1600, 3200, 5000} {
-			if serverName != "router" {
-				addr2, err := RequestServerAddr(serverName)
+		var rwc net.Conn
+		var addr string
+		err := util.Retry(context.Background(), connectRetryPolicy, func(attempt int) error {
+			addr = config.Config().Server("router").Addr
+			if client.instance {
+				addr = client.Addr()
+			} else if serverName != "router" {
+				addr2, err := requestServerAddr(app, serverName, version)
 				if err != nil {
-					log.Errorf("connect %d %v", serverName, err)
+					log.Errorf("connect %s %v", serverName, err)
 				}
 				addr = addr2
 			}
+			if cm.isDrained(addr) {
+				return errors.New("server " + serverName + " " + addr + " is draining")
+			}
 			if addr == "" {
-				continue
+				return errors.New("server " + serverName + " address not found")
 			}
-			rwc, err := net.Dial("tcp", addr)
-			if err == nil {
-				client.rwc = rwc
-				client.start()
-				return
+			var err error
+			if rwc, err = net.Dial("tcp", addr); err != nil {
+				log.Infof("connect %v, retry %d", err, attempt)
 			}
-
-			// 间隔时间
-			log.Infof("connect %v, retry %d after %dms", err, try, ms)
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return err
+		})
+		if err == nil {
+			client.rwc = rwc
+			client.addr.Store(addr)
+			client.flushRouteBuffer()
+			client.start()
+			return
 		}
-		defaultCmdSet.Handle(&Context{Out: client}, "CMD_AutoConnect", nil)
+		atomic.StoreInt32(&client.state, StateClosed)
+		defaultCmdSet.HandleEvent(&Context{Out: client}, "CMD_AutoConnect")
 	}()
 }
 
 func (cm *clientManage) Route3(serverName, messageId string, i interface{}) {
+	cm.routeSsid("", serverName, messageId, i)
+}
+
+// 携带会话ID，按会话ID选择连接池中的连接
+func (cm *clientManage) routeSsid(ssid, serverName, messageId string, i interface{}) {
 	serverName, messageId = routeMessage(serverName, messageId)
-	msg, err := Encode(&Package{Id: messageId, Body: i, IsRaw: true})
+	msg, err := Encode(&Package{Id: messageId, Body: i, Ssid: ssid, IsRaw: true})
 	if err != nil {
 		return
 	}
-	cm.Route(serverName, msg)
+	if err := cm.routeApp(localAppId, serverName, "", ssid, msg); err != nil {
+		HandleDeadLetter(nil, &DeadLetter{
+			ServerName: serverName,
+			MessageId:  messageId,
+			Reason:     err.Error(),
+			Data:       msg,
+		})
+	}
 }
 
 func (cm *clientManage) RegisterService(args *ServiceConfig) {
@@ -189,12 +314,28 @@ func (cm *clientManage) RegisterService(args *ServiceConfig) {
 	cm.mu.Unlock()
 }
 
-func funcTest(ctx *Context, iArgs interface{}) {
-	// empty
+func (cm *clientManage) Drain(isDrain bool) {
+	cm.mu.Lock()
+	cm.isDrain = isDrain
+	cm.mu.Unlock()
+
+	cm.Route3(ServerRouter, "C2S_Drain", drainArgs{IsDrain: isDrain})
+}
+
+// 重新注册并恢复下线状态
+func (cm *clientManage) register(reg interface{}) {
+	cm.Route3(ServerRouter, "C2S_Register", reg)
+
+	cm.mu.RLock()
+	isDrain := cm.isDrain
+	cm.mu.RUnlock()
+	if isDrain {
+		cm.Route3(ServerRouter, "C2S_Drain", drainArgs{IsDrain: isDrain})
+	}
 }
 
-func funcRegisterOk(ctx *Context, iArgs interface{}) {
-	// TODO
+func funcTest(ctx *Context, iArgs interface{}) {
+	// empty
 }
 
 // Client自动重连
@@ -204,9 +345,25 @@ func funcAutoConnect(ctx *Context, iArgs interface{}) {
 
 	cm := defaultClientManage
 	reg, name := client.reg, client.name
-	defaultCmdSet.RemoveService(name)
+	if client.isRetired() {
+		log.Infof("retired connection %s %s closed", client.key(), client.Addr())
+		return
+	}
+	// 实例连接断开后不再重连，会话重新选择实例
+	if client.instance {
+		cm.retire(client)
+		log.Infof("instance connection %s %s closed", client.key(), client.Addr())
+		return
+	}
+	if client.stripe == 0 {
+		defaultCmdSet.RemoveService(name)
+	}
 	if reg != nil && name == ServerRouter {
-		cm.Route3(name, "C2S_Register", reg)
+		cm.register(reg)
+	}
+	if name == ServerRouter {
+		cm.resubscribe()
+		cm.rewatchRegistry()
 	}
-	cm.connect(name)
+	cm.connect(client)
 }
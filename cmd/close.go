@@ -0,0 +1,70 @@
+package cmd
+
+// tcp连接关闭握手，避免关闭时截断未发送的回复
+// 主动关闭：发送完写队列及溢出数据后发送CloseMessage，等待对端CloseMessage或超时后关闭连接
+// 被动关闭：收到CloseMessage后发送完已排队的数据，回复CloseMessage后关闭连接
+// 等待时间通过配置CloseTimeout指定，默认5s
+
+import (
+	"github.com/guogeer/husky/config"
+	"sync"
+	"time"
+)
+
+var closeTimeout = config.Duration("CloseTimeout", 5*time.Second)
+
+type closeState struct {
+	peerClose chan struct{} // 对端已发送CloseMessage
+	once      sync.Once
+}
+
+// 建立连接后、启动读写协程前调用
+func (c *TCPConn) resetClose() {
+	c.closing = &closeState{peerClose: make(chan struct{})}
+}
+
+func (c *TCPConn) peerClosed() <-chan struct{} {
+	if c.closing == nil {
+		return nil
+	}
+	return c.closing.peerClose
+}
+
+// 读协程收到对端CloseMessage
+func (c *TCPConn) notifyPeerClose() {
+	if cs := c.closing; cs != nil {
+		cs.once.Do(func() { close(cs.peerClose) })
+	}
+}
+
+// 写队列关闭后调用，done为读协程退出通知
+func (c *TCPConn) shutdown(done <-chan struct{}) {
+	if _, err := c.writeMsg(CloseMessage, nil); err != nil {
+		return
+	}
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.peerClosed():
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// 对端关闭后发送已排队的数据并回复CloseMessage
+func (c *TCPConn) replyClose() error {
+	for n := len(c.send); n > 0; n-- {
+		buf, ok := <-c.send
+		if !ok {
+			break
+		}
+		if _, err := c.writeMsg(RawMessage, buf); err != nil {
+			return err
+		}
+	}
+	if err := c.flushSpill(); err != nil {
+		return err
+	}
+	_, err := c.writeMsg(CloseMessage, nil)
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
 	"net"
 	"reflect"
 	"runtime"
@@ -26,32 +28,93 @@ func init() {
 	if h, ok := defaultHashParser.(*hashParser); ok {
 		h.key = config.Config().ProductKey
 	}
+	// 异步写日志
+	//   <AsyncLog Enable="true" BufferSize="8192" Overflow="drop"/>
+	var logOpts log.AsyncOptions
+	if err := config.Unmarshal("AsyncLog", &logOpts); err != nil {
+		log.Errorf("load async log %v", err)
+	}
+	log.SetAsync(logOpts)
+	// 服务器时区，影响跨天判断及定时器，默认为本地时区
+	//   <TimeZone>Asia/Shanghai</TimeZone>
+	if tz := config.String("TimeZone", ""); tz != "" {
+		if err := util.SetTimeZone(tz); err != nil {
+			log.Errorf("load time zone %v", err)
+		}
+	}
 
-	BindWithName("C2S_RegisterOk", funcRegisterOk, (*cmdArgs)(nil))
+	BindWithName("C2S_RegisterOk", funcRegisterOk, (*registerOkArgs)(nil))
+	BindWithName("FUNC_SetNamespaces", funcSetNamespaces, (*NamespaceArgs)(nil))
+	BindWithName("FUNC_ConfigTable", funcConfigTable, (*ConfigTableChunk)(nil))
+	BindWithName("FUNC_RegistryEvent", funcRegistryEvent, (*RegistryEvent)(nil))
+	BindWithName("FUNC_LeaseExpired", funcLeaseExpired, (*cmdArgs)(nil))
 
 	// 某些情况下需要发送一个包去探路，这个包可能会发送失败
 	BindWithName("FUNC_Test", funcTest, (*cmdArgs)(nil))
 	// 断线后自动重连
 	BindWithName("CMD_AutoConnect", funcAutoConnect, (*cmdArgs)(nil))
 	BindWithName("CMD_Close", funcClose, (*cmdArgs)(nil))
+	// 登录等服务指定会话路由的服务版本
+	BindWithName("FUNC_SetServerVersion", funcSetServerVersion, (*cmdArgs)(nil))
+	// 路由下发的灰度比例
+	BindWithName("FUNC_SetRollout", funcSetRollout, (*RolloutRule)(nil))
+	// 路由推送的服务实例，按会话选择实例
+	BindWithName("FUNC_SetInstances", funcSetInstances, (*InstanceListArgs)(nil))
+	BindWithName("FUNC_SetSessionValue", funcSetSessionValue, (*sessionValueArgs)(nil))
+	// 需确认的推送
+	BindWithName("FUNC_Push", funcPush, (*pushArgs)(nil))
+	BindWithName("FUNC_PushAck", funcPushAck, (*pushArgs)(nil))
+	BindWithName("PushAck", funcClientPushAck, (*pushArgs)(nil))
+	BindWithName("StreamAck", funcStreamAck, (*StreamAck)(nil))
+	BindWithName("BotChallengeAnswer", funcBotChallengeAnswer, (*botChallengeArgs)(nil))
+	// 跨服务流程
+	BindWithName("FUNC_SagaStep", funcSagaStep, (*sagaStepArgs)(nil))
+	BindWithName("FUNC_SagaResult", funcSagaResult, (*sagaResultArgs)(nil))
+
+	BindAdmin("ADMIN_SetAdmissionRules", funcSetAdmissionRules, (*AdmissionRules)(nil))
+	BindAdmin("ADMIN_SetClientVersionRules", funcSetClientVersionRules, (*VersionRules)(nil))
+	BindAdmin("ADMIN_SetProfiling", funcSetProfiling, (*profilingArgs)(nil))
+	BindAdmin("ADMIN_TraceSession", funcTraceSession, (*traceArgs)(nil))
+	BindAdmin("ADMIN_SetRouteRules", funcSetRouteRules, (*routeRulesConfig)(nil))
+	BindAdmin("ADMIN_SetMessageAliases", funcSetMessageAliases, (*messageAliasesConfig)(nil))
+	BindAdmin("ADMIN_SetMessageExposure", funcSetMessageExposure, (*messageExposureConfig)(nil))
+	BindAdmin("ADMIN_GetMessageTopN", funcGetMessageTopN, (*messageTopNArgs)(nil))
+	BindAdmin("ADMIN_SetRequestLog", funcSetRequestLog, (*RequestLogRule)(nil))
 }
 
-func BindWithName(name string, h Handler, args interface{}) {
-	defaultCmdSet.Bind(name, h, args)
+func BindWithName(name string, h Handler, args interface{}, opts ...BindOption) {
+	defaultCmdSet.Bind(name, h, args, opts...)
+}
+
+func RebindWithName(name string, h Handler, args interface{}, opts ...BindOption) {
+	defaultCmdSet.Rebind(name, h, args, opts...)
+}
+
+func Unbind(name string) {
+	defaultCmdSet.Unbind(name)
+}
+
+// 冻结消息绑定，解冻时变更一次性生效
+func FreezeBind() {
+	defaultCmdSet.Freeze()
+}
+
+func UnfreezeBind() {
+	defaultCmdSet.Unfreeze()
 }
 
 func RegisterServiceInGateway(name string) {
 	defaultCmdSet.RegisterService(name)
 }
 
-func Bind(h Handler, args interface{}) {
+func Bind(h Handler, args interface{}, opts ...BindOption) {
 	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
 	n := strings.LastIndexByte(name, '.')
 	if n >= 0 {
 		name = name[n+1:]
 	}
 	// log.Debug("method name =", name)
-	BindWithName(name, h, args)
+	BindWithName(name, h, args, opts...)
 }
 
 func Handle(ctx *Context, name string, args interface{}) {
@@ -70,15 +133,42 @@ func Route(serverName, messageId string, data interface{}) {
 	defaultClientManage.Route3(serverName, messageId, data)
 }
 
+// 代替会话发送消息，适用于本进程不存在该会话时
+func RouteSession(ssid, serverName, messageId string, data interface{}) {
+	defaultClientManage.routeSsid(ssid, serverName, messageId, data)
+}
+
 func RegisterService(config *ServiceConfig) {
+	if config.AppId == "" {
+		config.AppId = localAppId
+	}
+	config.Lease = true
 	defaultClientManage.RegisterService(config)
 }
 
+// 通知路由停止向本服务分配新会话，已有会话不受影响
+func Drain() {
+	defaultClientManage.Drain(true)
+}
+
+// 恢复分配新会话
+func Undrain() {
+	defaultClientManage.Drain(false)
+}
+
 type ServiceConfig struct {
 	ServerName string      `json:",omitempty"`
 	ServerAddr string      `json:",omitempty"`
 	ServerData interface{} `json:",omitempty"`
 	ServerType string      `json:",omitempty"` // center,gateway etc
+
+	ServerVersion string `json:",omitempty"` // 服务版本，用于灰度发布
+	InstanceId    string `json:",omitempty"` // 实例ID，默认为服务地址
+	Weight        int    `json:",omitempty"` // 实例权重，默认1
+
+	MessagePrefixes []string `json:",omitempty"` // 服务拥有的消息ID前缀
+	AppId           string   `json:",omitempty"` // 所属应用，默认使用配置AppId
+	Lease           bool     `json:",omitempty"` // 支持注册租约续期
 }
 
 type cmdArgs ServiceConfig
@@ -87,6 +177,7 @@ type ForwardArgs struct {
 	ServerList []string
 	Name       string
 	Data       json.RawMessage
+	HashKey    string `json:",omitempty"` // 不为空时路由按键一致性哈希选择服务实例
 }
 
 // 消息通过router转发
@@ -115,6 +206,21 @@ func Forward(servers interface{}, messageId string, i interface{}) {
 	Route("router", "C2S_Route", args)
 }
 
+// 消息通过router转发至服务的一个实例，相同的key选择相同的实例，如按房间号分片
+func ForwardByKey(serverName, key, messageId string, i interface{}) {
+	buf, err := marshalJSON(i)
+	if err != nil {
+		return
+	}
+	args := &ForwardArgs{
+		ServerList: []string{serverName},
+		Name:       messageId,
+		Data:       buf,
+		HashKey:    key,
+	}
+	Route("router", "C2S_Route", args)
+}
+
 // 同步请求
 func Request(serverName, msgId string, in interface{}) ([]byte, error) {
 	var addr string
@@ -134,7 +240,7 @@ func Request(serverName, msgId string, in interface{}) ([]byte, error) {
 
 	c := &TCPConn{rwc: rwc}
 	// 第一个包发送校验数据
-	firstPackage, _ := defaultAuthParser.Encode(&Package{})
+	firstPackage, _ := defaultAuthParser.Encode(&Package{Nonce: newNonce()})
 	if _, err := c.writeMsg(AuthMessage, firstPackage); err != nil {
 		return nil, err
 	}
@@ -166,7 +272,12 @@ func Request(serverName, msgId string, in interface{}) ([]byte, error) {
 
 // 向路由请求服务器地址
 func RequestServerAddr(name string) (string, error) {
-	req := cmdArgs{ServerName: name}
+	return requestServerAddr(localAppId, name, "")
+}
+
+// 指定版本不存在时，路由返回默认服务地址
+func requestServerAddr(app, name, version string) (string, error) {
+	req := cmdArgs{ServerName: name, ServerVersion: version, AppId: app}
 	buf, err := Request("router", "C2S_GetServerAddr", req)
 	if err != nil {
 		return "", err
@@ -0,0 +1,275 @@
+package cmd
+
+// 消息体JSON编码选项
+// 客户端（JS/Unity）可能需要与Go结构体不同的字段命名方式
+// 仅作用于消息体Data，消息头Id/Data/Sign等字段保持不变
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// 字段命名方式
+const (
+	PascalCase = iota // 默认，与Go结构体字段一致
+	CamelCase
+)
+
+type JSONOptions struct {
+	FieldNaming int  // 未指定json标签名的字段使用的命名方式
+	OmitEmpty   bool // 忽略所有零值字段
+}
+
+var jsonOptions atomic.Value
+
+func init() {
+	jsonOptions.Store(JSONOptions{})
+}
+
+// 设置消息体编码选项，各服务需保持一致
+func SetJSONOptions(opts JSONOptions) {
+	jsonOptions.Store(opts)
+}
+
+func GetJSONOptions() JSONOptions {
+	return jsonOptions.Load().(JSONOptions)
+}
+
+func encodeJSON(i interface{}) ([]byte, error) {
+	opts := GetJSONOptions()
+	if opts.FieldNaming == PascalCase && opts.OmitEmpty == false {
+		return json.Marshal(i)
+	}
+	return json.Marshal(convertValue(reflect.ValueOf(i), opts))
+}
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+// 保持字段顺序的对象
+type jsonObject []jsonField
+
+func (obj jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for k, field := range obj {
+		if k > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func convertValue(v reflect.Value, opts JSONOptions) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	// 自定义编码保持不变，可寻址时与encoding/json一致调用指针方法
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if pt := reflect.PtrTo(v.Type()); pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+			return v.Addr().Interface()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return convertValue(v.Elem(), opts)
+	case reflect.Struct:
+		var obj jsonObject
+		appendFields(&obj, v, opts)
+		return obj
+	case reflect.Map:
+		if v.IsNil() {
+			return v.Interface()
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			name, ok := mapKeyName(key)
+			if !ok {
+				return v.Interface() // 由encoding/json返回错误
+			}
+			m[name] = convertValue(v.MapIndex(key), opts)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		// []byte编码为base64
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		a := make([]interface{}, v.Len())
+		for i := range a {
+			a[i] = convertValue(v.Index(i), opts)
+		}
+		return a
+	}
+	return v.Interface()
+}
+
+// 与encoding/json中map键的规则一致：字符串、TextMarshaler、整数
+func mapKeyName(key reflect.Value) (string, bool) {
+	if key.Kind() == reflect.String {
+		return key.String(), true
+	}
+	if tm, ok := key.Interface().(encoding.TextMarshaler); ok {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", true
+		}
+		b, err := tm.MarshalText()
+		return string(b), err == nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+	return "", false
+}
+
+// 标签,string：基础类型的值编码为JSON字符串
+func quotedValue(v reflect.Value) (interface{}, bool) {
+	if v.Kind() == reflect.Ptr && v.Type().Name() == "" {
+		if v.IsNil() {
+			return nil, isQuotable(v.Type().Elem().Kind())
+		}
+		v = v.Elem()
+	}
+	if !isQuotable(v.Kind()) {
+		return nil, false
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, false
+	}
+	return string(b), true
+}
+
+func isQuotable(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func appendFields(obj *jsonObject, v reflect.Value, opts JSONOptions) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, omitEmpty, quoted := tag, false, false
+		if n := strings.IndexByte(tag, ','); n >= 0 {
+			name = tag[:n]
+			for _, opt := range strings.Split(tag[n+1:], ",") {
+				omitEmpty = omitEmpty || opt == "omitempty"
+				quoted = quoted || opt == "string"
+			}
+		}
+
+		fv := v.Field(i)
+		// 匿名结构体字段展开
+		if sf.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				appendFields(obj, fv, opts)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue // 未导出
+		}
+		if (omitEmpty || opts.OmitEmpty) && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+			if opts.FieldNaming == CamelCase {
+				name = toCamelCase(name)
+			}
+		}
+		if quoted {
+			if value, ok := quotedValue(fv); ok {
+				*obj = append(*obj, jsonField{name: name, value: value})
+				continue
+			}
+		}
+		*obj = append(*obj, jsonField{name: name, value: convertValue(fv, opts)})
+	}
+}
+
+// 与encoding/json中omitempty规则一致
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// UserId -> userId, ID -> id, URLPath -> urlPath
+func toCamelCase(s string) string {
+	runes := []rune(s)
+	n := 0
+	for n < len(runes) && unicode.IsUpper(runes[n]) {
+		n++
+	}
+	if n > 1 && n < len(runes) {
+		n-- // 保留下一个单词的首字母
+	}
+	for i := 0; i < n; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
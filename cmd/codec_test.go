@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type codecPtrMarshaler struct{ N int }
+
+func (m *codecPtrMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`"custom"`), nil
+}
+
+type codecPtrText struct{ S string }
+
+func (t *codecPtrText) MarshalText() ([]byte, error) {
+	return []byte(strings.ToUpper(t.S)), nil
+}
+
+type codecKey struct{ A, B int }
+
+func (k codecKey) MarshalText() ([]byte, error) {
+	b, _ := json.Marshal([]int{k.A, k.B})
+	return b, nil
+}
+
+type codecEmbed struct {
+	Level int
+}
+
+type codecSample struct {
+	codecEmbed
+	Id      int64   `json:",string"`
+	Score   float64 `json:"score,string"`
+	Name    string  `json:",string"`
+	Ok      bool    `json:",string,omitempty"`
+	Ptr     *int    `json:",string"`
+	NilPtr  *int    `json:",string"`
+	Items   []int   `json:",string"` // 非基础类型忽略
+	Custom  codecPtrMarshaler
+	Text    codecPtrText
+	List    []codecPtrMarshaler
+	IntMap  map[int]string
+	UintMap map[uint8][]codecPtrText
+	KeyMap  map[codecKey]int
+	Bytes   []byte
+	Skip    int `json:"-"`
+	Html    string
+	private int
+}
+
+func TestConvertValue(t *testing.T) {
+	n := 7
+	sample := &codecSample{
+		codecEmbed: codecEmbed{Level: 3},
+		Id:         1 << 60,
+		Score:      1.5,
+		Name:       "a<b>",
+		Ok:         true,
+		Ptr:        &n,
+		Items:      []int{1},
+		Text:       codecPtrText{S: "text"},
+		List:       []codecPtrMarshaler{{N: 1}},
+		IntMap:     map[int]string{-1: "a", 2: "b"},
+		UintMap:    map[uint8][]codecPtrText{3: {{S: "x"}}},
+		KeyMap:     map[codecKey]int{{1, 2}: 3},
+		Bytes:      []byte("bytes"),
+		Html:       "<&>",
+	}
+	samples := []interface{}{
+		sample,
+		*sample,
+		[]*codecSample{sample, nil},
+		map[string]interface{}{"a": sample, "b": []int{1, 2}},
+		&codecSample{},
+	}
+	for i, v := range samples {
+		expect, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := json.Marshal(convertValue(reflect.ValueOf(v), JSONOptions{}))
+		if err != nil || string(buf) != string(expect) {
+			t.Errorf("sample %d\n%s\n%s", i, buf, expect)
+		}
+	}
+
+	// 字段命名方式
+	SetJSONOptions(JSONOptions{FieldNaming: CamelCase, OmitEmpty: true})
+	defer SetJSONOptions(JSONOptions{})
+	buf, err := encodeJSON(&struct {
+		UserId  int
+		URLPath string `json:",omitempty"`
+		Zero    int
+		Id      int `json:"ID,string"`
+	}{UserId: 1, URLPath: "/", Id: 2})
+	if expect := `{"userId":1,"urlPath":"/","ID":"2"}`; err != nil || string(buf) != expect {
+		t.Error(string(buf), err)
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+// 客户端与网关的数据压缩
+// 客户端连接时携带参数compress=deflate，网关开启压缩时回复Compress消息，此后双方每帧为二进制数据：标识(1) + 数据
+// 标识0x00未压缩，0x01为deflate压缩，较短的帧不压缩；同时开启加密时先压缩后加密
+//   <Compression Enable="true" MinSize="256" MaxInflatedSize="32KB" MaxRatio="64"/>
+// 客户端的帧解压后超过MaxInflatedSize或压缩率超过MaxRatio时断开连接，防止解压炸弹
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	compressAlgorithm = "deflate"
+
+	frameUncompressed = 0x00
+	frameDeflate      = 0x01
+)
+
+var (
+	errInflateTooLarge = errors.New("inflated frame too large")
+	errInflateRatio    = errors.New("inflate ratio too high")
+	errInvalidFrame    = errors.New("invalid compressed frame")
+)
+
+type CompressionOptions struct {
+	Enable          bool
+	MinSize         int     `default:"256"`  // 超过该长度的帧才压缩
+	MaxInflatedSize int64   `default:"32KB"` // 解压后的长度上限
+	MaxRatio        float64 `default:"64"`   // 解压后与压缩前的长度比上限
+}
+
+type compressArgs struct {
+	Algorithm string
+}
+
+var compressOpts CompressionOptions
+
+func init() {
+	if err := config.Unmarshal("Compression", &compressOpts); err != nil {
+		log.Errorf("load compression %v", err)
+	}
+}
+
+func deflateFrame(data []byte) []byte {
+	if len(data) >= compressOpts.MinSize {
+		var buf bytes.Buffer
+		buf.WriteByte(frameDeflate)
+		w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		w.Write(data)
+		// 压缩后更长时不压缩
+		if w.Close() == nil && buf.Len() <= len(data) {
+			return buf.Bytes()
+		}
+	}
+	return append([]byte{frameUncompressed}, data...)
+}
+
+// 限制解压后的长度及压缩率
+func inflateFrame(frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, errInvalidFrame
+	}
+	data := frame[1:]
+	switch frame[0] {
+	case frameUncompressed:
+		return data, nil
+	case frameDeflate:
+	default:
+		return nil, errInvalidFrame
+	}
+
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readInflated(r, len(data), compressOpts.MaxInflatedSize)
+}
+
+// 限制解压后的长度及压缩率，n为压缩数据的长度，0时不限制压缩率
+func readInflated(r io.Reader, n int, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = maxMessageSize
+	}
+	ratioLimit := int64(compressOpts.MaxRatio * float64(n))
+	limitErr := errInflateTooLarge
+	if n > 0 && compressOpts.MaxRatio > 0 && ratioLimit < limit {
+		limit, limitErr = ratioLimit, errInflateRatio
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errInvalidFrame
+	}
+	if int64(len(buf)) > limit {
+		return nil, limitErr
+	}
+	return buf, nil
+}
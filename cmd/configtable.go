@@ -0,0 +1,175 @@
+package cmd
+
+// 共享配置表，由路由统一加载并下发，服务无需读取共享目录
+//   cmd.OnConfigTable("item", func(name string, data []byte) error { ... })
+// 服务注册成功后订阅已声明的配置表，路由按校验值下发完整数据或二进制差异，
+// 数据按块发送。回调返回错误时保留旧版本，成功后GetConfigTable返回新数据
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"sync"
+)
+
+const configTableChunkSize = 16 << 10
+
+var errConfigTableChecksum = errors.New("config table checksum mismatch")
+
+type ConfigTableHandler func(name string, data []byte) error
+
+// 路由下发的配置表数据块
+type ConfigTableChunk struct {
+	Name     string
+	Version  int
+	Base     string `json:",omitempty"` // 差异对应的旧版本校验值，为空表示完整数据
+	Checksum string // 新版本校验值
+	Offset   int
+	Total    int
+	Data     []byte `json:",omitempty"`
+}
+
+type ConfigTableSubscribeArgs struct {
+	Tables map[string]string // 配置表 -> 当前校验值
+}
+
+type configTable struct {
+	h        ConfigTableHandler
+	version  int
+	checksum string
+	data     []byte
+	recv     []byte // 接收中的数据
+}
+
+var (
+	configTables  = make(map[string]*configTable)
+	configTableMu sync.RWMutex
+)
+
+func ConfigTableChecksum(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 声明使用的配置表，需在服务注册前调用
+func OnConfigTable(name string, h ConfigTableHandler) {
+	configTableMu.Lock()
+	defer configTableMu.Unlock()
+	configTables[name] = &configTable{h: h}
+}
+
+// 当前生效的配置表数据
+func GetConfigTable(name string) ([]byte, int) {
+	configTableMu.RLock()
+	defer configTableMu.RUnlock()
+	if t, ok := configTables[name]; ok {
+		return t.data, t.version
+	}
+	return nil, 0
+}
+
+// 向路由订阅配置表，携带当前校验值
+func subscribeConfigTables(tables map[string]string) {
+	if len(tables) == 0 {
+		return
+	}
+	Route(ServerRouter, "C2S_SubscribeConfigTables", &ConfigTableSubscribeArgs{Tables: tables})
+}
+
+func subscribeAllConfigTables() {
+	configTableMu.RLock()
+	tables := make(map[string]string, len(configTables))
+	for name, t := range configTables {
+		tables[name] = t.checksum
+	}
+	configTableMu.RUnlock()
+	subscribeConfigTables(tables)
+}
+
+func funcConfigTable(ctx *Context, data interface{}) {
+	chunk := data.(*ConfigTableChunk)
+
+	configTableMu.Lock()
+	t, ok := configTables[chunk.Name]
+	if !ok {
+		configTableMu.Unlock()
+		return
+	}
+	if chunk.Offset == 0 {
+		t.recv = t.recv[:0]
+	}
+	if chunk.Offset != len(t.recv) {
+		configTableMu.Unlock()
+		log.Warnf("config table %s chunk offset %d expect %d", chunk.Name, chunk.Offset, len(t.recv))
+		return
+	}
+	t.recv = append(t.recv, chunk.Data...)
+	if len(t.recv) < chunk.Total {
+		configTableMu.Unlock()
+		return
+	}
+	payload := append([]byte(nil), t.recv...)
+	t.recv = nil
+	base, baseChecksum := t.data, t.checksum
+	configTableMu.Unlock()
+
+	newData, err := applyConfigTable(chunk, base, baseChecksum, payload)
+	if err == nil {
+		err = t.h(chunk.Name, newData)
+	}
+	if err != nil {
+		log.Errorf("apply config table %s version %d: %v", chunk.Name, chunk.Version, err)
+		// 差异无法应用时重新订阅完整数据
+		if chunk.Base != "" {
+			subscribeConfigTables(map[string]string{chunk.Name: ""})
+		}
+		return
+	}
+
+	configTableMu.Lock()
+	t.data, t.version, t.checksum = newData, chunk.Version, chunk.Checksum
+	configTableMu.Unlock()
+	log.Infof("config table %s update to version %d", chunk.Name, chunk.Version)
+}
+
+func applyConfigTable(chunk *ConfigTableChunk, base []byte, baseChecksum string, payload []byte) ([]byte, error) {
+	data := payload
+	if chunk.Base != "" {
+		if chunk.Base != baseChecksum {
+			return nil, errConfigTableChecksum
+		}
+		var err error
+		if data, err = util.Patch(base, payload); err != nil {
+			return nil, err
+		}
+	}
+	if ConfigTableChecksum(data) != chunk.Checksum {
+		return nil, errConfigTableChecksum
+	}
+	return data, nil
+}
+
+// 按块发送配置表，base为空时发送完整数据
+func WriteConfigTable(out Conn, name string, version int, data, base []byte) {
+	chunk := ConfigTableChunk{Name: name, Version: version, Checksum: ConfigTableChecksum(data)}
+	payload := data
+	if base != nil {
+		chunk.Base = ConfigTableChecksum(base)
+		payload = util.Diff(base, data)
+	}
+	chunk.Total = len(payload)
+	for {
+		n := len(payload) - chunk.Offset
+		if n > configTableChunkSize {
+			n = configTableChunkSize
+		}
+		chunk.Data = payload[chunk.Offset : chunk.Offset+n]
+		out.WriteJSON("FUNC_ConfigTable", &chunk)
+		chunk.Offset += n
+		if chunk.Offset >= chunk.Total {
+			break
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"reflect"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +30,8 @@ const (
 	PingMessage  = 0xf1
 	PongMessage  = 0xf2
 	AuthMessage  = 0xf3
+
+	HandshakeMessage = 0xf4
 )
 
 const (
@@ -39,10 +42,20 @@ const (
 )
 
 type TCPConn struct {
+	rttMeter // 保持64位对齐
+
 	rwc     net.Conn
 	ssid    string
 	send    chan []byte
 	isClose bool
+
+	wmu       sync.Mutex   // 写锁
+	handshake atomic.Value // 版本协商结果
+	remote    atomic.Value // 对端声明支持的特性
+	spill     *spillQueue  // 写队列满后溢出至磁盘
+	sealed    int32        // 已收到带校验的数据帧
+	queue     sendQueueMeter
+	closing   *closeState // 关闭握手，每次建立连接时重置
 }
 
 func (c *TCPConn) Close() {
@@ -65,25 +78,42 @@ func (c *TCPConn) ReadMessage() (mt uint8, buf []byte, err error) {
 	}
 
 	// 0x01~0x0f 表示版本
+	// 0x11~0x1f 表示版本，数据帧带校验码
 	// 0xf0 写队列尾部标识
 	// 0xf1 PING
 	// 0xf2 PONG
+	// 0xf4 版本协商
+	// 0x20~0xef 自定义帧
 	n := int(binary.BigEndian.Uint16(head[1:3]))
 
 	// 消息
 	mt = uint8(head[0])
+	isSealed := mt&0xf0 == checksumFlag
+	if v := mt &^ checksumFlag; v >= MinProtocolVersion && v <= MaxProtocolVersion {
+		mt = RawMessage
+	}
 	switch mt {
 	case PingMessage, PongMessage, CloseMessage:
 		return
-	case AuthMessage, RawMessage:
+	case AuthMessage, RawMessage, HandshakeMessage:
 		if n > 0 && n < maxMessageSize {
 			buf = make([]byte, n)
-			if _, err = io.ReadFull(c.rwc, buf); err == nil {
+			if _, err = io.ReadFull(c.rwc, buf); err != nil {
 				return
 			}
+			if mt == RawMessage {
+				buf, err = c.openFrame(buf, isSealed)
+			}
+			return
+		}
+	default:
+		if getFrameHandler(mt) != nil && n < maxMessageSize {
+			buf = make([]byte, n)
+			_, err = io.ReadFull(c.rwc, buf)
+			return
 		}
 	}
-	err = errors.New("invalid data")
+	err = errInvalidData
 	return
 }
 
@@ -107,6 +137,8 @@ func (c *TCPConn) WriteJSON(name string, i interface{}) error {
 	if err != nil {
 		return err
 	}
+	tapMessage(TapOutbound, name, c.ssid, pkg.Data)
+	traceHop(TraceSend, c.ssid, name, 0)
 	return c.Write(buf)
 }
 
@@ -114,33 +146,64 @@ func (c *TCPConn) Write(data []byte) error {
 	if c.isClose == true {
 		return errors.New("connection is closed")
 	}
-	select {
-	case c.send <- data:
-	default:
-		return errors.New("write too busy")
+	// 溢出数据未发送完毕前继续写入磁盘，保证顺序
+	if c.spill != nil && c.spill.Len() > 0 {
+		return c.spillWrite(data)
 	}
-	return nil
+	if c.queue.admit(len(c.send)) {
+		select {
+		case c.send <- data:
+			return nil
+		default:
+		}
+	}
+	if c.spill != nil {
+		return c.spillWrite(data)
+	}
+	return errors.New("write too busy")
+}
+
+func (c *TCPConn) SendQueue() *QueueStats {
+	return c.queue.stats(len(c.send))
 }
 
 func (c *TCPConn) writeMsg(mt int, msg []byte) (int, error) {
+	// 数据包头部标识协商后的版本
+	if mt == RawMessage {
+		mt = c.ProtocolVersion()
+		if sealed, ok := c.sealFrame(msg); ok {
+			msg, mt = sealed, mt|checksumFlag
+		}
+	}
 	buf, err := c.NewMessageBytes(mt, msg)
 	if err != nil {
 		return 0, err
 	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 	return c.rwc.Write(buf)
 }
 
 type Handler func(*Context, interface{})
 
 type cmdEntry struct {
+	name  string
 	h     Handler
 	type_ reflect.Type
+	mode  int // 并发方式
+
+	logSample float64 // 请求日志采样率
+	compress  bool    // 回复数据较大时压缩
 }
 
 type CmdSet struct {
 	services map[string]bool // 内部服务
 	e        map[string]*cmdEntry
 	mu       sync.RWMutex
+
+	frozen bool
+	staged map[string]*cmdEntry // 冻结期间的变更，nil表示解绑
 }
 
 var defaultCmdSet = &CmdSet{
@@ -169,29 +232,133 @@ func (s *CmdSet) RecoverService(name string) {
 	}
 }
 
-func (s *CmdSet) Bind(name string, h Handler, i interface{}) {
+func (s *CmdSet) Bind(name string, h Handler, i interface{}, opts ...BindOption) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if _, ok := s.e[name]; ok {
 		log.Warnf("%s exist", name)
 	}
+	s.bind(name, newCmdEntry(name, h, i, opts...))
+}
+
+// 运行时替换消息处理
+func (s *CmdSet) Rebind(name string, h Handler, i interface{}, opts ...BindOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bind(name, newCmdEntry(name, h, i, opts...))
+}
+
+func (s *CmdSet) Unbind(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bind(name, nil)
+}
+
+func newCmdEntry(name string, h Handler, i interface{}, opts ...BindOption) *cmdEntry {
 	type_ := reflect.TypeOf(i)
-	s.e[name] = &cmdEntry{h: h, type_: type_}
+	e := &cmdEntry{name: name, h: h, type_: type_}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func (s *CmdSet) bind(name string, e *cmdEntry) {
+	if s.frozen {
+		s.staged[name] = e
+		return
+	}
+	if e == nil {
+		delete(s.e, name)
+	} else {
+		s.e[name] = e
+	}
+}
+
+// 冻结后绑定、解绑暂不生效，解冻时一次性生效
+// 用于插件、脚本加载整组消息处理
+func (s *CmdSet) Freeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen == false {
+		s.frozen = true
+		s.staged = make(map[string]*cmdEntry)
+	}
+}
+
+func (s *CmdSet) Unfreeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen == false {
+		return
+	}
+	s.frozen = false
+	for name, e := range s.staged {
+		s.bind(name, e)
+	}
+	s.staged = nil
 }
 
 func (s *CmdSet) Handle(ctx *Context, messageID string, data []byte) error {
+	if ctx.exec.Received.IsZero() {
+		ctx.exec.Received = time.Now()
+	}
+	tapMessage(TapInbound, messageID, ctx.Ssid, data)
+	traceHop(TraceRecv, ctx.Ssid, messageID, 0)
+	defaultMessageStats.recv(messageID, len(data))
+	if defaultChaos.inject(s, ctx, messageID, data) {
+		return nil
+	}
+	code, err := s.handle(ctx, messageID, data)
+	if err != nil {
+		writeClientError(ctx, code, messageID, err)
+	}
+	return err
+}
+
+func (s *CmdSet) handle(ctx *Context, messageID string, data []byte) (int, error) {
 	// 空数据使用默认JSON格式数据
 	if data == nil || len(data) == 0 {
 		data = []byte("{}")
 	}
 
-	serverName, name := routeMessage("", messageID)
+	if ctx.isGateway {
+		messageID = resolveAlias(messageID)
+	}
+	serverName, name := splitMessage(messageID)
+	isRuleRoute := false // 按路由规则转发
 	// 网关转发的消息ID仅允许包含字母、数字
 	if ctx.isGateway == true {
 		match, err := regexp.MatchString("^[A-Za-z0-9]+$", name)
 		if err == nil && !match {
-			return errors.New("invalid message id")
+			return ErrCodeInvalidMessage, errors.New("invalid message id")
 		}
+		if rule, message := matchGatewayRule(messageID); rule != nil {
+			switch rule.Action {
+			case RouteActionReject:
+				return ErrCodeInvalidMessage, errRejectedMessage
+			case RouteActionLocal:
+				serverName, name = "", message
+			default:
+				serverName, name = rule.Server, message
+				isRuleRoute = true
+			}
+		} else {
+			// 网关本地处理的消息优先
+			s.mu.RLock()
+			_, isLocal := s.e[name]
+			s.mu.RUnlock()
+			if serverName != "" || !isLocal {
+				serverName, name = routeMessage("", messageID)
+			}
+		}
+	}
+	if ctx.isGateway && !isMessageExposed(serverName, name) {
+		return ErrCodeInvalidMessage, errHiddenMessage
+	}
+	ctx.MsgId = name
+	if ctx.isGateway && ctx.TraceId == "" {
+		ctx.TraceId = newTraceId()
 	}
 
 	s.mu.RLock()
@@ -202,29 +369,61 @@ func (s *CmdSet) Handle(ctx *Context, messageID string, data []byte) error {
 	if len(serverName) > 0 {
 		if ctx.isGateway == true {
 			// 网关仅允许转发已注册的逻辑服务器
-			if isService == false {
-				return errors.New("gateway try to route invalid service")
+			if isService == false && !isRuleRoute {
+				err := errors.New("gateway try to route invalid service")
+				HandleDeadLetter(ctx, &DeadLetter{
+					ServerName: serverName,
+					MessageId:  name,
+					Ssid:       ctx.Ssid,
+					Reason:     err.Error(),
+					Data:       data,
+				})
+				return ErrCodeUnroutable, err
 			}
 		}
 
 		if ss := GetSession(ctx.Ssid); ss != nil {
-			ss.Route(serverName, name, data)
+			if ctx.isGateway {
+				if err := ss.checkClientVersion(serverName); err != nil {
+					return ErrCodeUpgrade, err
+				}
+			}
+			if err := ss.route(serverName, name, data, ctx.TraceId); err != nil {
+				return ErrCodeUnroutable, err
+			}
 		}
-		return nil
+		return 0, nil
 	}
 
 	if e == nil {
-		return errInvalidMessageID
+		HandleDeadLetter(ctx, &DeadLetter{
+			MessageId: name,
+			Ssid:      ctx.Ssid,
+			Reason:    errInvalidMessageID.Error(),
+			Data:      data,
+		})
+		return ErrCodeInvalidMessage, errInvalidMessageID
 	}
 
 	// unmarshal argument
 	args := reflect.New(e.type_.Elem()).Interface()
 	if err := json.Unmarshal(data, args); err != nil {
-		return err
+		return ErrCodeInvalidArgs, err
 	}
+	logRequest(ctx, e, data)
+
+	dispatch(ctx, e, args)
+	return 0, nil
+}
 
-	Enqueue(ctx, e.h, args)
-	return nil
+// 框架内部事件，未绑定时忽略
+func (s *CmdSet) HandleEvent(ctx *Context, name string) {
+	s.mu.RLock()
+	_, ok := s.e[name]
+	s.mu.RUnlock()
+	if ok {
+		s.Handle(ctx, name, nil)
+	}
 }
 
 func funcClose(ctx *Context, i interface{}) {
@@ -0,0 +1,105 @@
+package cmd
+
+// 客户端与网关的数据加密
+// 客户端连接时通过参数pubkey携带X25519公钥（32字节，base64），网关回复KeyExchange消息携带公钥
+// 双方以共享密钥的SHA256作为AES-256-GCM密钥，此后每帧为二进制数据：nonce(12) + 密文
+// nonce由方向(4字节)及递增序号(8字节)组成，接收方校验序号防止重放
+// 密钥交换未认证网关公钥，仅防止被动监听，无法防止中间人，需要时应使用wss
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	nonceServerToClient = 0
+	nonceClientToServer = 1
+)
+
+var (
+	errFrameDecrypt     = errors.New("decrypt frame failed")
+	errInvalidPublicKey = errors.New("invalid client public key")
+)
+
+type keyExchangeArgs struct {
+	PublicKey []byte
+}
+
+type frameCipher struct {
+	aead             cipher.AEAD
+	sendDir, recvDir uint32
+	sendSeq, recvSeq uint64
+}
+
+// 网关根据客户端公钥生成密钥，返回网关公钥
+func newServerCipher(clientKey []byte) (*frameCipher, []byte, error) {
+	curve := ecdh.X25519()
+	pub, err := curve.NewPublicKey(clientKey)
+	if err != nil {
+		return nil, nil, errInvalidPublicKey
+	}
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, nil, errInvalidPublicKey
+	}
+	fc, err := newFrameCipher(secret, nonceServerToClient, nonceClientToServer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fc, priv.PublicKey().Bytes(), nil
+}
+
+// 共享密钥固定32字节，SHA256后作为AES-256-GCM密钥
+func newFrameCipher(secret []byte, sendDir, recvDir uint32) (*frameCipher, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &frameCipher{aead: aead, sendDir: sendDir, recvDir: recvDir}, nil
+}
+
+func makeNonce(dir uint32, seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce, dir)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// 仅在写协程中调用
+func (fc *frameCipher) Seal(data []byte) []byte {
+	fc.sendSeq++
+	nonce := makeNonce(fc.sendDir, fc.sendSeq)
+	return fc.aead.Seal(nonce, nonce, data, nil)
+}
+
+// 仅在读协程中调用
+func (fc *frameCipher) Open(buf []byte) ([]byte, error) {
+	if len(buf) < 12 {
+		return nil, errFrameDecrypt
+	}
+	nonce, ciphertext := buf[:12], buf[12:]
+	dir, seq := binary.BigEndian.Uint32(nonce), binary.BigEndian.Uint64(nonce[4:])
+	if dir != fc.recvDir || seq != fc.recvSeq+1 {
+		return nil, errFrameDecrypt
+	}
+	data, err := fc.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errFrameDecrypt
+	}
+	fc.recvSeq = seq
+	return data, nil
+}
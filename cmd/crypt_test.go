@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// 模拟客户端完成密钥交换
+func newTestClientCipher(t *testing.T) (client, server *frameCipher) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, serverKey, err := newServerCipher(priv.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := priv.ECDH(pub)
+	client, err = newFrameCipher(secret, nonceClientToServer, nonceServerToClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestFrameCipher(t *testing.T) {
+	for i := 0; i < 64; i++ {
+		client, server := newTestClientCipher(t)
+		for _, msg := range []string{"hello", "", "world"} {
+			data, err := server.Open(client.Seal([]byte(msg)))
+			if err != nil || string(data) != msg {
+				t.Fatal("client to server", err)
+			}
+			data, err = client.Open(server.Seal([]byte(msg)))
+			if err != nil || string(data) != msg {
+				t.Fatal("server to client", err)
+			}
+		}
+	}
+}
+
+func TestFrameCipherMismatch(t *testing.T) {
+	client, server := newTestClientCipher(t)
+	other, _ := newTestClientCipher(t)
+
+	// 其他会话的密钥
+	if _, err := server.Open(other.Seal([]byte("hi"))); err != errFrameDecrypt {
+		t.Error("other key", err)
+	}
+	// 重放及方向错误
+	frame := client.Seal([]byte("hi"))
+	if _, err := server.Open(frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Open(frame); err != errFrameDecrypt {
+		t.Error("replay", err)
+	}
+	if _, err := client.Open(client.Seal([]byte("hi"))); err != errFrameDecrypt {
+		t.Error("reflect", err)
+	}
+	// 篡改密文
+	frame = client.Seal([]byte("hi"))
+	frame[len(frame)-1] ^= 1
+	if _, err := server.Open(frame); err != errFrameDecrypt {
+		t.Error("tamper", err)
+	}
+
+	if _, _, err := newServerCipher(bytes.Repeat([]byte{1}, 65)); err != errInvalidPublicKey {
+		t.Error("invalid key", err)
+	}
+	if _, _, err := newServerCipher(make([]byte, 32)); err != errInvalidPublicKey {
+		t.Error("low order key", err)
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+// 无法投递的消息
+// 目标服务不存在、连接异常或消息未绑定处理时，交由死信处理，便于发现错误路由
+
+import (
+	"github.com/guogeer/husky/log"
+	"sync"
+	"sync/atomic"
+)
+
+type DeadLetter struct {
+	ServerName string `json:",omitempty"` // 目标服务
+	MessageId  string // 消息ID
+	Ssid       string `json:",omitempty"`
+	Reason     string // 原因
+
+	Data []byte `json:"-"`
+}
+
+type DeadLetterHandler func(*Context, *DeadLetter)
+
+var (
+	deadLetterCounter int64
+	deadLetterNack    int32 // 是否回复发送方
+	deadLetterHandler DeadLetterHandler
+	deadLetterMu      sync.RWMutex
+)
+
+func defaultDeadLetterHandler(ctx *Context, letter *DeadLetter) {
+	log.Warnf("dead letter server %s message %s ssid %s: %s",
+		letter.ServerName, letter.MessageId, letter.Ssid, letter.Reason)
+}
+
+// 设置死信处理，默认打印日志
+func SetDeadLetterHandler(h DeadLetterHandler) {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	deadLetterHandler = h
+}
+
+// 开启后向发送方回复S2C_DeadLetter
+func EnableDeadLetterNack(enable bool) {
+	var n int32
+	if enable {
+		n = 1
+	}
+	atomic.StoreInt32(&deadLetterNack, n)
+}
+
+// 累计死信数量
+func DeadLetterCount() int64 {
+	return atomic.LoadInt64(&deadLetterCounter)
+}
+
+func HandleDeadLetter(ctx *Context, letter *DeadLetter) {
+	atomic.AddInt64(&deadLetterCounter, 1)
+
+	deadLetterMu.RLock()
+	h := deadLetterHandler
+	deadLetterMu.RUnlock()
+	if h == nil {
+		h = defaultDeadLetterHandler
+	}
+	h(ctx, letter)
+
+	if atomic.LoadInt32(&deadLetterNack) == 1 && ctx != nil && ctx.Out != nil {
+		ctx.Out.WriteJSON("S2C_DeadLetter", letter)
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+// 服务间直连
+// 由路由查询服务地址后直接建立已校验的tcp连接，断开后重新查询地址并重连
+// Route发送的消息均经直连转发，Connect可提前建立连接，避免首个消息等待
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+type ServiceConnStats struct {
+	ServerName    string
+	ServerVersion string `json:",omitempty"`
+	AppId         string `json:",omitempty"`
+	Stripe        int    `json:",omitempty"` // 连接池中的序号
+	Addr          string `json:",omitempty"`
+	State         int
+	Queue         *QueueStats
+}
+
+// 提前建立至服务的直连
+func Connect(serverName string) {
+	ConnectVersion(serverName, "")
+}
+
+func ConnectVersion(serverName, version string) {
+	if serverName == "" {
+		return
+	}
+	defaultClientManage.getClient(localAppId, serverName, version)
+}
+
+func (c *Client) State() int {
+	return int(atomic.LoadInt32(&c.state))
+}
+
+func (c *Client) Addr() string {
+	addr, _ := c.addr.Load().(string)
+	return addr
+}
+
+// 当前服务间连接
+func GetServiceConns() []*ServiceConnStats {
+	cm := defaultClientManage
+	cm.mu.RLock()
+	clients := make([]*Client, 0, len(cm.clients))
+	for _, client := range cm.clients {
+		clients = append(clients, client)
+	}
+	cm.mu.RUnlock()
+
+	var stats []*ServiceConnStats
+	for _, client := range clients {
+		stats = append(stats, &ServiceConnStats{
+			ServerName:    client.name,
+			ServerVersion: client.version,
+			AppId:         client.app,
+			Stripe:        client.stripe,
+			Addr:          client.Addr(),
+			State:         client.State(),
+			Queue:         client.SendQueue(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		return stripeKey(clientKey(a.AppId, a.ServerName, a.ServerVersion), a.Stripe) < stripeKey(clientKey(b.AppId, b.ServerName, b.ServerVersion), b.Stripe)
+	})
+	return stats
+}
@@ -0,0 +1,111 @@
+package cmd
+
+// 消息处理的并发控制，绑定时指定
+// 默认在主循环RunOnce中串行执行
+
+import (
+	"github.com/guogeer/husky/log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	RunInLoop        = iota // 默认，主循环中执行
+	RunConcurrent           // 并发执行
+	RunSerialSession        // 同一会话串行执行，不同会话间并发
+	RunSerialGlobal         // 该消息全局串行执行，不占用主循环
+	RunSharded              // 按会话分片至多个队列，同一会话串行执行
+)
+
+type BindOption func(*cmdEntry)
+
+// 指定消息处理的并发方式
+func WithConcurrency(mode int) BindOption {
+	return func(e *cmdEntry) {
+		e.mode = mode
+	}
+}
+
+// 串行执行队列，空闲时回收协程
+type serialQueue struct {
+	msgs    []*Message
+	running bool
+}
+
+type serialRunner struct {
+	queues map[string]*serialQueue
+	mu     sync.Mutex
+}
+
+func newSerialRunner() *serialRunner {
+	return &serialRunner{queues: make(map[string]*serialQueue)}
+}
+
+func (r *serialRunner) Run(key string, msg *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[key]
+	if !ok {
+		q = &serialQueue{}
+		r.queues[key] = q
+	}
+	q.msgs = append(q.msgs, msg)
+	if q.running == false {
+		q.running = true
+		go r.drain(key, q)
+	}
+}
+
+func (r *serialRunner) drain(key string, q *serialQueue) {
+	for {
+		r.mu.Lock()
+		if len(q.msgs) == 0 {
+			delete(r.queues, key)
+			r.mu.Unlock()
+			return
+		}
+		msg := q.msgs[0]
+		q.msgs[0] = nil
+		q.msgs = q.msgs[1:]
+		r.mu.Unlock()
+
+		safeRun(msg)
+	}
+}
+
+var (
+	sessionRunner = newSerialRunner()
+	globalRunner  = newSerialRunner()
+)
+
+// 主循环外执行，异常不影响其他消息
+func safeRun(msg *Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Error(err)
+			log.Errorf("%s", buf)
+		}
+	}()
+	runMessage(msg)
+}
+
+func dispatch(ctx *Context, e *cmdEntry, args interface{}) {
+	msg := &Message{ctx: ctx, h: e.h, args: args, enqueued: time.Now()}
+	switch e.mode {
+	case RunConcurrent:
+		go safeRun(msg)
+	case RunSerialSession:
+		sessionRunner.Run(ctx.Ssid, msg)
+	case RunSerialGlobal:
+		globalRunner.Run(e.name, msg)
+	case RunSharded:
+		defaultLanes.Enqueue(msg)
+	default:
+		enqueueMessage(msg)
+	}
+}
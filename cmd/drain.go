@@ -0,0 +1,72 @@
+package cmd
+
+// 服务实例下线时网关不再为新会话绑定该实例
+// 路由推送FUNC_ServerDrain后，网关将至该实例的连接移出连接表，之后的新会话选择其他实例
+// 已绑定的会话继续使用原连接，直至原连接断开。原连接断开后不再重连，会话改用新连接
+
+import (
+	"sync/atomic"
+)
+
+type ServerDrainArgs struct {
+	ServerInfo
+	IsDrain bool
+}
+
+// 网关收到路由推送的下线状态
+func SetServerDrain(addr string, isDrain bool) {
+	defaultClientManage.setDrain(addr, isDrain)
+}
+
+func (cm *clientManage) setDrain(addr string, isDrain bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if !isDrain {
+		delete(cm.drains, addr)
+		return
+	}
+	cm.drains[addr] = true
+	for key, client := range cm.clients {
+		if client.name != ServerRouter && client.Addr() == addr {
+			atomic.StoreInt32(&client.retired, 1)
+			delete(cm.clients, key)
+		}
+	}
+}
+
+func (cm *clientManage) isDrained(addr string) bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.drains[addr]
+}
+
+func (c *Client) isRetired() bool {
+	return atomic.LoadInt32(&c.retired) == 1
+}
+
+// 会话消息使用首次路由时的连接，连接所在实例下线并断开后重新选择
+func (cm *clientManage) routeSession(ss *Session, app, serverName, version string, data []byte) error {
+	stripe := chooseStripe(serverName, ss.Id)
+	key := stripeKey(clientKey(app, serverName, version), stripe)
+	client := ss.boundClient(key)
+	if client == nil || (client.isRetired() && client.State() != StateConnected) {
+		client = cm.sessionClient(ss.Id, app, serverName, version, stripe)
+		ss.bindClient(key, client)
+	}
+	return cm.writeClient(client, data)
+}
+
+func (ss *Session) boundClient(key string) *Client {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.clients[key]
+}
+
+func (ss *Session) bindClient(key string, client *Client) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.clients == nil {
+		ss.clients = make(map[string]*Client)
+	}
+	ss.clients[key] = client
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func newTestDrainClient(cm *clientManage, key, addr string) *Client {
+	client := newClient("hall")
+	client.addr.Store(addr)
+	atomic.StoreInt32(&client.state, StateConnected)
+	cm.clients[key] = client
+	return client
+}
+
+func TestServerDrain(t *testing.T) {
+	cm := &clientManage{clients: make(map[string]*Client), drains: make(map[string]bool)}
+	old := newTestDrainClient(cm, "hall", "127.0.0.1:9001")
+
+	bound := &Session{Id: "bound"}
+	if err := cm.routeSession(bound, "", "hall", "", []byte("a")); err != nil || len(old.send) != 1 {
+		t.Fatal("route before drain", err)
+	}
+
+	cm.setDrain("127.0.0.1:9001", true)
+	if !old.isRetired() || cm.clients["hall"] != nil || !cm.isDrained("127.0.0.1:9001") {
+		t.Fatal("drain not applied")
+	}
+	// 模拟新会话连接其他实例
+	next := newTestDrainClient(cm, "hall", "127.0.0.1:9002")
+
+	// 已绑定的会话仍转发至下线的实例，新会话使用新连接
+	cm.routeSession(bound, "", "hall", "", []byte("b"))
+	cm.routeSession(&Session{Id: "new"}, "", "hall", "", []byte("c"))
+	if len(old.send) != 2 || len(next.send) != 1 {
+		t.Error("route during drain", len(old.send), len(next.send))
+	}
+
+	// 下线实例断开后改用新连接
+	atomic.StoreInt32(&old.state, StateClosed)
+	cm.routeSession(bound, "", "hall", "", []byte("d"))
+	if len(old.send) != 2 || len(next.send) != 2 {
+		t.Error("route after close", len(old.send), len(next.send))
+	}
+
+	cm.setDrain("127.0.0.1:9001", false)
+	if cm.isDrained("127.0.0.1:9001") {
+		t.Error("undrain")
+	}
+}
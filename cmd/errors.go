@@ -0,0 +1,43 @@
+package cmd
+
+// 框架错误码，网关客户端的请求处理失败时回复S2C_Error
+// 业务错误码使用ReplyEnvelope，与框架错误码区分
+// 客户端仅收到错误码对应的固定描述，详细错误记录在服务端日志
+
+import (
+	"github.com/guogeer/husky/log"
+)
+
+const (
+	ErrCodeInvalidMessage = 1001 // 消息ID非法或未绑定
+	ErrCodeInvalidArgs    = 1002 // 消息数据解析失败
+	ErrCodeRateLimit      = 1003 // 发送过于频繁
+	ErrCodeUnroutable     = 1004 // 目标服务不存在或无法连接
+	ErrCodeUpgrade        = 1005 // 客户端版本不在允许范围，需升级
+)
+
+var clientErrorMessages = map[int]string{
+	ErrCodeInvalidMessage: "invalid message",
+	ErrCodeInvalidArgs:    "invalid arguments",
+	ErrCodeRateLimit:      "too many requests",
+	ErrCodeUnroutable:     "service unavailable",
+	ErrCodeUpgrade:        "client upgrade required",
+}
+
+type ErrorPackage struct {
+	Code    int
+	Msg     string
+	Request string `json:",omitempty"` // 请求的消息ID
+}
+
+func writeClientError(ctx *Context, code int, request string, err error) {
+	if ctx == nil || ctx.Out == nil || !ctx.isGateway {
+		return
+	}
+	log.Warnf("session %s request %s error %d: %v", ctx.Ssid, request, code, err)
+	msg, ok := clientErrorMessages[code]
+	if !ok {
+		msg = "internal error"
+	}
+	ctx.Out.WriteJSON("S2C_Error", &ErrorPackage{Code: code, Msg: msg, Request: request})
+}
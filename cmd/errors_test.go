@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestClientError(t *testing.T) {
+	out := &recordConn{}
+	ctx := &Context{Out: out, Ssid: "s1", isGateway: true}
+	writeClientError(ctx, ErrCodeUnroutable, "Login", errors.New("dial tcp 10.0.0.1:9001: connection refused"))
+	writeClientError(ctx, 9999, "Login", errors.New("detail"))
+	// 内部连接不回复
+	writeClientError(&Context{Out: out}, ErrCodeInvalidArgs, "Login", errors.New("detail"))
+
+	if len(out.bufs) != 2 {
+		t.Fatal("write count", len(out.bufs))
+	}
+	for i, msg := range []string{"service unavailable", "internal error"} {
+		pkg, err := defaultRawParser.Decode(out.bufs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e ErrorPackage
+		json.Unmarshal(pkg.Data, &e)
+		if e.Msg != msg || e.Request != "Login" {
+			t.Error(i, e)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+// 客户端消息暴露策略，网关按路由后的服务及消息ID检查，未通过时返回InvalidMessage
+// Allow不为空时仅允许列出的消息，Deny优先于Allow，支持*结尾的前缀匹配
+// Server为*时作用于未单独配置的服务，为空时作用于网关本地处理的消息
+// FUNC_、ADMIN_、S2C_等内部消息始终拒绝，即使经路由规则改写
+//   <MessageExposure>
+//     <Service><Server>*</Server><Deny>Debug*</Deny></Service>
+//     <Service><Server>hall</Server><Allow>Login,EnterRoom,Room*</Allow></Service>
+//   </MessageExposure>
+// 运行时可通过ADMIN_SetMessageExposure更新
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"strings"
+	"sync/atomic"
+)
+
+var errHiddenMessage = errors.New("message not exposed to client")
+
+// 内部消息前缀
+var internalMessagePrefixes = []string{"FUNC_", "ADMIN_", "S2C_", "C2S_"}
+
+type MessageExposure struct {
+	Server string
+	Allow  []string
+	Deny   []string
+}
+
+type messageExposureConfig struct {
+	Services []MessageExposure `config:"Service"`
+}
+
+var messageExposures atomic.Value
+
+func init() {
+	messageExposures.Store(map[string]MessageExposure{})
+
+	var cfg messageExposureConfig
+	if err := config.Unmarshal("MessageExposure", &cfg); err != nil {
+		log.Errorf("load message exposure %v", err)
+		return
+	}
+	SetMessageExposure(cfg.Services)
+}
+
+// 替换全部暴露策略
+func SetMessageExposure(services []MessageExposure) {
+	m := make(map[string]MessageExposure)
+	for _, e := range services {
+		m[e.Server] = e
+	}
+	messageExposures.Store(m)
+}
+
+func matchMessagePatterns(patterns []string, name string) bool {
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, p[:len(p)-1]) {
+				return true
+			}
+		} else if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 客户端是否允许发送消息至服务，serverName为空表示网关本地处理
+func isMessageExposed(serverName, name string) bool {
+	for _, prefix := range internalMessagePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+
+	m := messageExposures.Load().(map[string]MessageExposure)
+	e, ok := m[serverName]
+	if !ok && serverName != "" {
+		e, ok = m["*"]
+	}
+	if !ok {
+		return true
+	}
+	if matchMessagePatterns(e.Deny, name) {
+		return false
+	}
+	return len(e.Allow) == 0 || matchMessagePatterns(e.Allow, name)
+}
+
+func funcSetMessageExposure(ctx *Context, data interface{}) {
+	args := data.(*messageExposureConfig)
+	SetMessageExposure(args.Services)
+	log.Infof("set message exposure %v", args.Services)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+// 自定义帧类型，用于语音转发等不经过消息处理的数据
+// 帧类型范围0x20~0xef，处理函数在连接的读协程中调用，不阻塞主循环
+//   cmd.RegisterFrameHandler(0x20, func(c cmd.Conn, payload []byte) { ... })
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	MinCustomFrame = 0x20
+	MaxCustomFrame = 0xef
+)
+
+var errInvalidFrameType = errors.New("invalid custom frame type")
+
+type FrameHandler func(c Conn, payload []byte)
+
+var (
+	frameHandlers = make(map[uint8]FrameHandler)
+	frameMu       sync.RWMutex
+)
+
+// 注册自定义帧，h为nil时取消
+func RegisterFrameHandler(mt uint8, h FrameHandler) error {
+	if mt < MinCustomFrame || mt > MaxCustomFrame {
+		return errInvalidFrameType
+	}
+
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	if h == nil {
+		delete(frameHandlers, mt)
+	} else {
+		frameHandlers[mt] = h
+	}
+	return nil
+}
+
+func getFrameHandler(mt uint8) FrameHandler {
+	frameMu.RLock()
+	defer frameMu.RUnlock()
+	return frameHandlers[mt]
+}
+
+// 直接发送自定义帧，不经过写队列
+func (c *TCPConn) WriteFrame(mt uint8, payload []byte) error {
+	if mt < MinCustomFrame || mt > MaxCustomFrame {
+		return errInvalidFrameType
+	}
+	_, err := c.writeMsg(int(mt), payload)
+	return err
+}
+
+func (c *TCPConn) handleFrame(out Conn, mt uint8, payload []byte) {
+	if h := getFrameHandler(mt); h != nil {
+		h(out, payload)
+	}
+}
@@ -4,12 +4,14 @@ package cmd
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/guogeer/husky/log"
 	"github.com/guogeer/husky/util"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -19,6 +21,7 @@ const (
 
 var (
 	heartBeatMessage = []byte(`{"Id":"HeartBeat","Data":{}}`)
+	errSendQueueFull = errors.New("send queue is full")
 )
 
 var upgrader = websocket.Upgrader{
@@ -28,11 +31,19 @@ var upgrader = websocket.Upgrader{
 }
 
 type WsConn struct {
-	ws   *websocket.Conn
-	ssid string
-	send chan []byte
+	rttMeter // 保持64位对齐
+
+	ws     *websocket.Conn
+	ssid   string
+	send   chan []byte
+	cipher *frameCipher // 加密，未开启时为nil
+	queue  sendQueueMeter
+	shaper sendShaper
+	bot    *botDetector // 机器人识别，未开启时为nil
 	// args       interface{}
 	isClose bool
+	deflate bool // 开启压缩
+	gzip    bool // 客户端支持gzip编码的消息数据
 }
 
 func (c *WsConn) RemoteAddr() string {
@@ -49,12 +60,24 @@ func (c *WsConn) Close() {
 }
 
 func (c *WsConn) WriteJSON(name string, i interface{}) error {
+	name = clientMessageName(c.ssid, name)
 	// 消息格式
 	pkg := &Package{Id: name, Body: i}
+	// 开启补发时消息携带序号
+	if ss := GetSession(c.ssid); ss != nil && resendWindow > 0 {
+		if err := ss.outbox.write(c, pkg, defaultRawParser); err != nil {
+			return err
+		}
+		tapMessage(TapOutbound, name, c.ssid, pkg.Data)
+		traceHop(TraceSend, c.ssid, name, 0)
+		return nil
+	}
 	buf, err := defaultRawParser.Encode(pkg)
 	if err != nil {
 		return err
 	}
+	tapMessage(TapOutbound, name, c.ssid, pkg.Data)
+	traceHop(TraceSend, c.ssid, name, 0)
 	return c.Write(buf)
 }
 
@@ -62,7 +85,8 @@ func (c *WsConn) Write(data []byte) error {
 	if c.isClose {
 		return nil
 	}
-
+	// 写满时阻塞，开启自适应时扩容
+	c.queue.admit(len(c.send))
 	select {
 	case c.send <- data:
 		return nil
@@ -70,13 +94,87 @@ func (c *WsConn) Write(data []byte) error {
 	return errors.New("write too busy")
 }
 
+// 不阻塞写入发送队列，队列已满时返回错误；连接已断开时忽略，由重连补发
+func (c *WsConn) offer(data []byte) error {
+	if c.isClose {
+		return nil
+	}
+	c.queue.admit(len(c.send))
+	select {
+	case c.send <- data:
+		return nil
+	default:
+	}
+	return errSendQueueFull
+}
+
+func (c *WsConn) HasFeature(name string) bool {
+	return name == FeatureCompress && c.gzip
+}
+
+func (c *WsConn) SendQueue() *QueueStats {
+	return c.queue.stats(len(c.send))
+}
+
 func (c *WsConn) writeMessage(mt int, payload []byte) error {
 	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
 	return c.ws.WriteMessage(mt, payload)
 }
 
 func ServeWs(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	serveWs(w, r, defaultWsOptions)
+}
+
+func serveWs(w http.ResponseWriter, r *http.Request, opts *ListenOptions) {
+	if opts.External {
+		if err := Admit(r.RemoteAddr); err != nil {
+			log.Debugf("reject %s %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	policy := getWsPolicy()
+	if !policy.checkSubprotocols(r) {
+		http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+		return
+	}
+	// 携带令牌时直接完成登录
+	var claims *TokenClaims
+	if token := r.URL.Query().Get("token"); token != "" {
+		var err error
+		if claims, err = VerifyToken(token); err != nil {
+			// 不向客户端暴露令牌校验失败的原因
+			log.Debugf("reject %s %v", r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	ip, ok := policy.acquireIP(r.RemoteAddr)
+	if !ok {
+		log.Debugf("reject %s too many connections", r.RemoteAddr)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer releaseIP(ip)
+
+	// 客户端公钥
+	var fc *frameCipher
+	var serverKey []byte
+	if s := r.URL.Query().Get("pubkey"); s != "" {
+		clientKey, err := base64.StdEncoding.DecodeString(s)
+		if err == nil {
+			fc, serverKey, err = newServerCipher(clientKey)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if opts.RequireEncryption {
+		http.Error(w, "encryption required", http.StatusForbidden)
+		return
+	}
+
+	ws, err := policy.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		log.Errorf("%v", err)
 		return
@@ -85,22 +183,72 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 	c := &WsConn{
 		ssid: id,
 		ws:   ws,
-		send: make(chan []byte, 1<<10),
 	}
-	addSession(&Session{Id: id, Out: c})
+	c.send = c.queue.init(QueueClassGateway)
+	c.bot = newBotDetector()
+	c.gzip = r.URL.Query().Get("codec") == CodecGzip
+	if r.URL.Query().Get("compress") == compressAlgorithm && compressOpts.Enable {
+		buf, err := defaultRawParser.Encode(&Package{Id: "Compress", Body: &compressArgs{Algorithm: compressAlgorithm}})
+		if err != nil || c.writeMessage(websocket.TextMessage, buf) != nil {
+			ws.Close()
+			return
+		}
+		c.deflate = true
+	}
+	if fc != nil {
+		buf, err := defaultRawParser.Encode(&Package{Id: "KeyExchange", Body: &keyExchangeArgs{PublicKey: serverKey}})
+		if err != nil || c.writeMessage(websocket.TextMessage, buf) != nil {
+			ws.Close()
+			return
+		}
+		c.cipher = fc
+	}
+	ss, old := claimSession(r.URL.Query().Get("resume"), c)
+	if ss != nil {
+		c.ssid = ss.Id
+		ack, err := strconv.ParseInt(r.URL.Query().Get("ack"), 10, 64)
+		if err != nil {
+			ack = -1 // 未携带时不补发
+		}
+		ss.rebind(c, old, opts.External, ack)
+	} else {
+		ss = &Session{Id: id, Out: c}
+		if version := r.URL.Query().Get("version"); IsValidClientVersion(version) {
+			ss.Set(SessionKeyClientVersion, version)
+		} else if version != "" {
+			log.Debugf("session %s invalid client version %.32q", id, version)
+		}
+		ss.SetAppId(r.URL.Query().Get("app"))
+		policy.routeSNI(ss, r)
+		addSession(ss)
+		fireConnect(&Context{Ssid: id, Out: c, isGateway: opts.External})
+		if claims != nil {
+			ss.SetClaims(claims)
+			if claims.AppId != "" {
+				ss.SetAppId(claims.AppId)
+			}
+			ss.SetAuth()
+		}
+	}
+	if opts.Label != "" {
+		ss.Set(SessionKeyLabel, opts.Label)
+	}
+	ss.issueToken(c)
 
 	doneCtx, cancel := context.WithCancel(context.Background())
+	// 控制帧可与数据帧并发写入
+	go c.keepalive(doneCtx.Done(), func() error {
+		return c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+	}, func() { c.ws.Close() })
 	go func() {
-		ticker := time.NewTicker(pingPeriod)
 		defer func() {
 			// c.writeMessage(websocket.CloseMessage, []byte{})
 			c.ws.Close()
-			ticker.Stop() // 关闭定时器
 
-			ctx := &Context{Ssid: c.ssid, Out: c}
-			defaultCmdSet.Handle(ctx, "CMD_Close", nil)
-			defaultCmdSet.Handle(ctx, "FUNC_Close", nil)
-			removeSession(c.ssid)
+			ctx := &Context{Ssid: c.ssid, Out: c, isGateway: opts.External}
+			if !ss.detach(c, ctx) {
+				closeSession(ctx)
+			}
 		}()
 
 		for {
@@ -109,12 +257,15 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 				if ok == false {
 					return
 				}
-				if err := c.writeMessage(websocket.TextMessage, buf); err != nil {
-					log.Debug("write message", err)
-					return
+				mt := websocket.TextMessage
+				if c.deflate {
+					mt, buf = websocket.BinaryMessage, deflateFrame(buf)
 				}
-			case <-ticker.C:
-				if err := c.writeMessage(websocket.PingMessage, nil); err != nil {
+				if c.cipher != nil {
+					mt, buf = websocket.BinaryMessage, c.cipher.Seal(buf)
+				}
+				if err := c.writeMessage(mt, buf); err != nil {
+					log.Debug("write message", err)
 					return
 				}
 			case <-doneCtx.Done():
@@ -124,8 +275,12 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	c.ws.SetReadLimit(4 << 10)
-	c.ws.SetReadDeadline(time.Now().Add(pongWait))
-	c.ws.SetPongHandler(func(string) error { c.ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		c.pong()
+		c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+		return nil
+	})
 
 	defer cancel()
 
@@ -141,13 +296,30 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		pkg, err := Decode(message)
+		if c.cipher != nil {
+			if message, err = c.cipher.Open(message); err != nil {
+				log.Warnf("client %s %v", remoteAddr, err)
+				return
+			}
+		}
+		if c.deflate {
+			if message, err = inflateFrame(message); err != nil {
+				log.Warnf("client %s %v", remoteAddr, err)
+				return
+			}
+		}
+		pkg, err := opts.parser().Decode(message)
 		if err != nil {
 			log.Error(err)
 			return
 		}
 
 		id, data := pkg.Id, pkg.Data
+		// 重复或过期的消息
+		if !ss.acceptSeq(pkg.Seq) {
+			log.Warnf("client %s replay message %s seq %d", remoteAddr, id, pkg.Seq)
+			continue
+		}
 		if recvPackageCounter == -1 && rand.Intn(7) == 0 {
 			recvPackageCounter = 0
 			deadline = time.Now().Add(2 * time.Second)
@@ -159,14 +331,17 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 			}
 			if recvPackageCounter >= clientPackageSpeedPer2s {
 				log.Errorf("client %s send too busy", remoteAddr)
+				ctx := &Context{Out: c, Ssid: c.ssid, isGateway: opts.External}
+				writeClientError(ctx, ErrCodeRateLimit, id, errors.New("send too busy"))
 				time.Sleep(2 * time.Second)
 			}
 		}
 		// log.Info("read", c.ssid)
-		ctx := &Context{Out: c, Ssid: c.ssid, isGateway: true}
-		defaultCmdSet.Handle(ctx, id, data)
+		ctx := &Context{Out: c, Ssid: c.ssid, isGateway: opts.External}
+		err = defaultCmdSet.Handle(ctx, id, data)
 		if err != nil {
 			log.Errorf("handle client %s %v", remoteAddr, err)
 		}
+		c.bot.observe(c, err == nil)
 	}
 }
@@ -0,0 +1,124 @@
+package cmd
+
+// 会话分组，如房间
+// 分组由网关维护，服务通过路由向全部网关广播一次即可发送至分组内全部会话
+// 分组按应用隔离，会话加入所属应用的分组，服务仅可广播至同一应用的分组
+//   ss.JoinGroup("room1")
+//   cmd.BroadcastGroup("room1", "S2C_Chat", data)
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+type GroupArgs struct {
+	Group string
+	Ssid  string          `json:",omitempty"`
+	Id    string          `json:",omitempty"`
+	Data  json.RawMessage `json:",omitempty"`
+	AppId string          `json:",omitempty"` // 广播的应用，由路由填写
+}
+
+type GroupManage struct {
+	groups   map[string]map[string]bool // 应用内分组的会话
+	sessions map[string]map[string]bool // 会话所在的应用内分组
+	mu       sync.RWMutex
+}
+
+var defaultGroupManage = &GroupManage{
+	groups:   make(map[string]map[string]bool),
+	sessions: make(map[string]map[string]bool),
+}
+
+func GetGroupManage() *GroupManage {
+	return defaultGroupManage
+}
+
+// 应用内的分组
+func groupKey(app, group string) string {
+	if app == "" {
+		return group
+	}
+	return app + "/" + group
+}
+
+func (gm *GroupManage) Join(app, group, ssid string) {
+	group = groupKey(app, group)
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if gm.groups[group] == nil {
+		gm.groups[group] = make(map[string]bool)
+	}
+	gm.groups[group][ssid] = true
+	if gm.sessions[ssid] == nil {
+		gm.sessions[ssid] = make(map[string]bool)
+	}
+	gm.sessions[ssid][group] = true
+}
+
+func (gm *GroupManage) leave(group, ssid string) {
+	if members := gm.groups[group]; members != nil {
+		delete(members, ssid)
+		if len(members) == 0 {
+			delete(gm.groups, group)
+		}
+	}
+	if groups := gm.sessions[ssid]; groups != nil {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(gm.sessions, ssid)
+		}
+	}
+}
+
+func (gm *GroupManage) Leave(app, group, ssid string) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	gm.leave(groupKey(app, group), ssid)
+}
+
+// 会话离开全部分组
+func (gm *GroupManage) LeaveAll(ssid string) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	for group := range gm.sessions[ssid] {
+		gm.leave(group, ssid)
+	}
+}
+
+func (gm *GroupManage) Members(app, group string) []string {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+	var members []string
+	for ssid := range gm.groups[groupKey(app, group)] {
+		members = append(members, ssid)
+	}
+	return members
+}
+
+// 发送至本进程分组内同一应用的会话
+func (gm *GroupManage) Broadcast(app, group, name string, i interface{}) {
+	for _, ssid := range gm.Members(app, group) {
+		if ss := GetSession(ssid); ss != nil && ss.AppId() == app {
+			WriteBroadcast(ss.Out, name, i)
+		}
+	}
+}
+
+// 会话所在网关加入分组
+func (ss *Session) JoinGroup(group string) {
+	ss.Out.WriteJSON("FUNC_JoinGroup", &GroupArgs{Group: group, Ssid: ss.Id})
+}
+
+func (ss *Session) LeaveGroup(group string) {
+	ss.Out.WriteJSON("FUNC_LeaveGroup", &GroupArgs{Group: group, Ssid: ss.Id})
+}
+
+// 经路由发送至全部网关上分组内的会话
+func BroadcastGroup(group, name string, i interface{}) {
+	data, err := marshalJSON(i)
+	if err != nil {
+		return
+	}
+	Route(ServerRouter, "C2S_BroadcastGroup", &GroupArgs{Group: group, Id: name, Data: data})
+}
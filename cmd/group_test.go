@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestGroupApp(t *testing.T) {
+	c1, c2 := &recordConn{}, &recordConn{}
+	ss1 := &Session{Id: "group1", Out: c1}
+	ss2 := &Session{Id: "group2", Out: c2}
+	ss1.SetAppId("game1")
+	ss2.SetAppId("game2")
+	addSession(ss1)
+	addSession(ss2)
+	defer removeSession(ss1.Id)
+	defer removeSession(ss2.Id)
+
+	gm := &GroupManage{groups: make(map[string]map[string]bool), sessions: make(map[string]map[string]bool)}
+	gm.Join("game1", "room", ss1.Id)
+	gm.Join("game2", "room", ss2.Id)
+	if m := gm.Members("game1", "room"); len(m) != 1 || m[0] != ss1.Id {
+		t.Fatal(m)
+	}
+
+	gm.Broadcast("game1", "room", "Chat", map[string]string{"Msg": "hi"})
+	if len(c1.Names()) != 1 || len(c2.Names()) != 0 {
+		t.Fatal("broadcast other app", c1.Names(), c2.Names())
+	}
+	// 会话修改所属应用后不再收到原应用的广播
+	ss1.SetAppId("game2")
+	gm.Broadcast("game1", "room", "Chat", map[string]string{"Msg": "hi"})
+	if len(c1.Names()) != 1 {
+		t.Fatal("broadcast after app changed")
+	}
+
+	gm.LeaveAll(ss1.Id)
+	gm.Leave("game2", "room", ss2.Id)
+	if len(gm.groups) != 0 || len(gm.sessions) != 0 {
+		t.Error("leave", gm.groups, gm.sessions)
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+// 处理函数的耗时统计及延后执行
+//   func Enter(ctx *cmd.Context, data interface{}) {
+//     start := time.Now()
+//     ctx.Defer(func() { metrics.Observe("enter", ctx.Stats().Elapsed(), time.Since(start)) })
+//     ...
+//   }
+// Defer在处理函数返回、回复写入发送队列后按相反顺序执行
+
+import (
+	"github.com/guogeer/husky/log"
+	"time"
+)
+
+type HandlerStats struct {
+	Received time.Time // 收到消息
+	Enqueued time.Time // 进入执行队列
+	Dequeued time.Time // 开始执行
+	Attempt  int       // 第几次投递，从1开始，如saga步骤的重试
+}
+
+// 排队耗时
+func (stats HandlerStats) QueueWait() time.Duration {
+	if stats.Enqueued.IsZero() || stats.Dequeued.IsZero() {
+		return 0
+	}
+	return stats.Dequeued.Sub(stats.Enqueued)
+}
+
+// 收到消息至今的耗时
+func (stats HandlerStats) Elapsed() time.Duration {
+	if stats.Received.IsZero() {
+		return 0
+	}
+	return time.Since(stats.Received)
+}
+
+func (ctx *Context) Stats() HandlerStats {
+	return ctx.exec
+}
+
+// 处理结束后执行，用于清理及统计
+func (ctx *Context) Defer(f func()) {
+	ctx.defers = append(ctx.defers, f)
+}
+
+func (ctx *Context) runDefers() {
+	defers := ctx.defers
+	ctx.defers = nil
+	for i := len(defers) - 1; i >= 0; i-- {
+		runDefer(defers[i])
+	}
+}
+
+// 异常不影响其他延后执行的函数
+func runDefer(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("context defer panic: %v", err)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,130 @@
+package cmd
+
+// 协议版本协商
+// 客户端在第一个校验包中携带支持的版本、特性，服务端回复协商结果
+// 未携带协商数据的旧客户端默认使用版本1，服务端不回复
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	MinProtocolVersion = 0x01
+	MaxProtocolVersion = 0x0f
+	ProtocolVersion    = 0x01 // 当前版本
+)
+
+// 协议特性
+const (
+	FeatureCompress = "compress" // 压缩
+	FeatureBigFrame = "bigframe" // 大数据帧
+	FeatureProtobuf = "protobuf"
+)
+
+type Handshake struct {
+	Versions []int    `json:",omitempty"` // 支持的版本
+	Version  int      `json:",omitempty"` // 协商后的版本
+	Features []string `json:",omitempty"` // 支持的特性
+}
+
+func (h *Handshake) HasFeature(name string) bool {
+	if h == nil {
+		return false
+	}
+	for _, s := range h.Features {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	supportedFeatures []string
+	featureMu         sync.RWMutex
+)
+
+// 注册本进程支持的协议特性
+func RegisterFeature(name string) {
+	featureMu.Lock()
+	defer featureMu.Unlock()
+	for _, s := range supportedFeatures {
+		if s == name {
+			return
+		}
+	}
+	supportedFeatures = append(supportedFeatures, name)
+}
+
+func newHandshake() *Handshake {
+	featureMu.RLock()
+	defer featureMu.RUnlock()
+
+	h := &Handshake{Features: append([]string(nil), supportedFeatures...)}
+	for v := MinProtocolVersion; v <= ProtocolVersion; v++ {
+		h.Versions = append(h.Versions, v)
+	}
+	return h
+}
+
+// 选择双方支持的最高版本及共同特性
+func negotiate(remote *Handshake) *Handshake {
+	local := newHandshake()
+	agreed := &Handshake{Version: MinProtocolVersion}
+	for _, v := range remote.Versions {
+		if v > agreed.Version && v <= ProtocolVersion {
+			agreed.Version = v
+		}
+	}
+	for _, s := range remote.Features {
+		if local.HasFeature(s) {
+			agreed.Features = append(agreed.Features, s)
+		}
+	}
+	return agreed
+}
+
+func (c *TCPConn) setHandshake(h *Handshake) {
+	c.handshake.Store(h)
+}
+
+// 协商结果，未协商时为nil
+func (c *TCPConn) Handshake() *Handshake {
+	h, _ := c.handshake.Load().(*Handshake)
+	return h
+}
+
+func (c *TCPConn) ProtocolVersion() int {
+	if h := c.Handshake(); h != nil && h.Version > 0 {
+		return h.Version
+	}
+	return MinProtocolVersion
+}
+
+func (c *TCPConn) HasFeature(name string) bool {
+	return c.Handshake().HasFeature(name)
+}
+
+// 对端声明支持的特性，本进程无需注册，如网关注册的FeatureRouteBatch
+func (c *TCPConn) PeerHasFeature(name string) bool {
+	h, _ := c.remote.Load().(*Handshake)
+	return h.HasFeature(name)
+}
+
+// 服务端处理客户端的协商数据
+func (c *TCPConn) acceptHandshake(data []byte) error {
+	remote := &Handshake{}
+	if err := json.Unmarshal(data, remote); err != nil {
+		return err
+	}
+	agreed := negotiate(remote)
+	c.remote.Store(remote)
+	buf, err := json.Marshal(agreed)
+	if err != nil {
+		return err
+	}
+	c.setHandshake(agreed)
+	_, err = c.writeMsg(HandshakeMessage, buf)
+	return err
+}
@@ -0,0 +1,107 @@
+package cmd
+
+// 连接事件回调，在主循环中执行
+//   OnConnect    建立连接
+//   OnAuth       tcp连接通过校验，websocket会话由登录服务验证后调用Session.SetAuth
+//   OnDisconnect 连接关闭
+//   OnResume     断线后在宽限时间内重连，会话不变
+//   OnSessionBind 网关会话首次路由至某服务
+
+import (
+	"sync"
+)
+
+type ConnHook func(ctx *Context)
+
+type SessionBindHook func(ss *Session, serverName string)
+
+type connHooks struct {
+	connect, auth, disconnect, resume []ConnHook
+	bind                              []SessionBindHook
+	mu                                sync.RWMutex
+}
+
+var defaultConnHooks connHooks
+
+func OnConnect(h ConnHook) {
+	defaultConnHooks.mu.Lock()
+	defer defaultConnHooks.mu.Unlock()
+	defaultConnHooks.connect = append(defaultConnHooks.connect, h)
+}
+
+func OnAuth(h ConnHook) {
+	defaultConnHooks.mu.Lock()
+	defer defaultConnHooks.mu.Unlock()
+	defaultConnHooks.auth = append(defaultConnHooks.auth, h)
+}
+
+func OnDisconnect(h ConnHook) {
+	defaultConnHooks.mu.Lock()
+	defer defaultConnHooks.mu.Unlock()
+	defaultConnHooks.disconnect = append(defaultConnHooks.disconnect, h)
+}
+
+func OnResume(h ConnHook) {
+	defaultConnHooks.mu.Lock()
+	defer defaultConnHooks.mu.Unlock()
+	defaultConnHooks.resume = append(defaultConnHooks.resume, h)
+}
+
+func OnSessionBind(h SessionBindHook) {
+	defaultConnHooks.mu.Lock()
+	defer defaultConnHooks.mu.Unlock()
+	defaultConnHooks.bind = append(defaultConnHooks.bind, h)
+}
+
+func fireHooks(ctx *Context, hooks *[]ConnHook) {
+	defaultConnHooks.mu.RLock()
+	a := *hooks
+	defaultConnHooks.mu.RUnlock()
+	if len(a) == 0 {
+		return
+	}
+	Enqueue(ctx, func(ctx *Context, _ interface{}) {
+		for _, h := range a {
+			h(ctx)
+		}
+	}, nil)
+}
+
+func fireConnect(ctx *Context) {
+	fireHooks(ctx, &defaultConnHooks.connect)
+}
+
+func fireAuth(ctx *Context) {
+	fireHooks(ctx, &defaultConnHooks.auth)
+}
+
+func fireDisconnect(ctx *Context) {
+	fireHooks(ctx, &defaultConnHooks.disconnect)
+}
+
+func fireResume(ctx *Context) {
+	fireHooks(ctx, &defaultConnHooks.resume)
+}
+
+func fireSessionBind(ss *Session, serverName string) {
+	defaultConnHooks.mu.RLock()
+	a := defaultConnHooks.bind
+	defaultConnHooks.mu.RUnlock()
+	if len(a) == 0 {
+		return
+	}
+	Enqueue(&Context{Ssid: ss.Id, Out: ss.Out}, func(*Context, interface{}) {
+		for _, h := range a {
+			h(ss, serverName)
+		}
+	}, nil)
+}
+
+// 会话已通过验证
+func (ss *Session) SetAuth() {
+	if ss.Get(SessionKeyAuth) == true {
+		return
+	}
+	ss.Set(SessionKeyAuth, true)
+	fireAuth(&Context{Out: ss.Out, Ssid: ss.Id, isGateway: true})
+}
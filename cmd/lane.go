@@ -0,0 +1,56 @@
+package cmd
+
+// 消息分片队列，绑定时指定WithConcurrency(RunSharded)的消息按会话ID哈希至多个队列
+// 每个队列由独立的协程处理，同一会话的消息按序执行，不同队列间并发，可利用多核
+// 队列数量通过配置MessageLanes指定，默认为CPU核数；处理函数访问共享数据时需自行加锁
+
+import (
+	"github.com/guogeer/husky/config"
+	"hash/crc32"
+	"runtime"
+	"sync"
+)
+
+type messageLanes struct {
+	queues []*SafeQueue
+	once   sync.Once
+}
+
+var defaultLanes = newMessageLanes(config.Int("MessageLanes", runtime.NumCPU()))
+
+func newMessageLanes(n int) *messageLanes {
+	if n <= 0 {
+		n = 1
+	}
+	lanes := &messageLanes{}
+	for i := 0; i < n; i++ {
+		lanes.queues = append(lanes.queues, NewSafeQueue(4<<10))
+	}
+	return lanes
+}
+
+func (lanes *messageLanes) lane(ssid string) int {
+	if len(lanes.queues) == 1 {
+		return 0
+	}
+	return int(crc32.ChecksumIEEE([]byte(ssid)) % uint32(len(lanes.queues)))
+}
+
+// 首次使用时启动各队列的处理协程
+func (lanes *messageLanes) Enqueue(msg *Message) {
+	lanes.once.Do(func() {
+		for _, q := range lanes.queues {
+			go func(q *SafeQueue) {
+				for {
+					safeRun(q.Dequeue(-1).(*Message))
+				}
+			}(q)
+		}
+	})
+	lanes.queues[lanes.lane(msg.ctx.Ssid)].Enqueue(msg)
+}
+
+// 分片队列数量
+func MessageLanes() int {
+	return len(defaultLanes.queues)
+}
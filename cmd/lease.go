@@ -0,0 +1,39 @@
+package cmd
+
+// 注册租约续期
+// 路由回复C2S_RegisterOk时携带租约时长，服务每隔1/3租约时长发送C2S_RenewLease续期
+// 超时未续期时路由删除注册信息并关闭连接，服务重连后重新注册；收到FUNC_LeaseExpired时立即重新注册
+
+import (
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+var leaseTimer *util.Timer
+
+// 注册成功后开始续期，重新注册时重置
+func startLeaseRenewal(ttl time.Duration) {
+	util.StopTimer(leaseTimer)
+	leaseTimer = nil
+	if ttl <= 0 {
+		return
+	}
+	leaseTimer = util.NewPeriodTimer(func() {
+		Route(ServerRouter, "C2S_RenewLease", struct{}{})
+	}, "2001-01-01", ttl/3)
+}
+
+func funcLeaseExpired(ctx *Context, data interface{}) {
+	cm := defaultClientManage
+	cm.mu.RLock()
+	var reg interface{}
+	if client := cm.clients[ServerRouter]; client != nil {
+		reg = client.reg
+	}
+	cm.mu.RUnlock()
+	log.Warnf("router lease expired, register again")
+	if reg != nil {
+		cm.register(reg)
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+// 进程生命周期，组件按依赖顺序启动，收到SIGTERM、SIGINT或调用Shutdown后按相反顺序停止
+//   cmd.RegisterComponent(&cmd.Component{Name: "store", Start: store.Load, Stop: store.Save})
+//   cmd.RegisterComponent(&cmd.Component{Name: "listener", DependsOn: []string{"store"}, Start: ..., StopAccept: srv.Close})
+//   cmd.Run() // 代替 for { util.TickTimerRun(); cmd.RunOnce() }
+// 停止顺序：组件停止接收(StopAccept) -> 定时器 -> 处理主循环队列中剩余的消息 -> 组件停止并保存(Stop) -> 关闭连接
+// 组件的启动、停止均在主循环所在的协程中执行
+//   <ShutdownTimeout>10s</ShutdownTimeout>
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type Component struct {
+	Name      string
+	DependsOn []string // 依赖的组件，先于本组件启动，后于本组件停止
+	Start     func() error
+	Stop      func() error // 主循环队列处理完毕后调用，可保存数据
+
+	StopAccept func() error // 停止接收新的连接及消息，如关闭监听端口
+}
+
+var (
+	components     []*Component
+	shutdownSignal = make(chan os.Signal, 1)
+)
+
+// 注册组件，需在Run前调用
+func RegisterComponent(c *Component) {
+	components = append(components, c)
+}
+
+// 主动停止，Run处理完毕后返回
+func Shutdown() {
+	select {
+	case shutdownSignal <- syscall.SIGTERM:
+	default:
+	}
+}
+
+// 按依赖排序，同级按注册顺序
+func sortComponents(cs []*Component) ([]*Component, error) {
+	byName := make(map[string]*Component)
+	for _, c := range cs {
+		if _, ok := byName[c.Name]; ok {
+			return nil, errors.New("duplicate component " + c.Name)
+		}
+		byName[c.Name] = c
+	}
+
+	var sorted []*Component
+	state := make(map[string]int) // 1访问中，2已完成
+	var visit func(c *Component) error
+	visit = func(c *Component) error {
+		switch state[c.Name] {
+		case 1:
+			return errors.New("component dependency cycle " + c.Name)
+		case 2:
+			return nil
+		}
+		state[c.Name] = 1
+		for _, name := range c.DependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				return errors.New("component " + c.Name + " depends on unknown " + name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[c.Name] = 2
+		sorted = append(sorted, c)
+		return nil
+	}
+	for _, c := range cs {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// 启动组件并执行主循环，停止后返回
+func Run() {
+	sorted, err := sortComponents(components)
+	if err != nil {
+		log.Fatalf("lifecycle %v", err)
+	}
+	for _, c := range sorted {
+		if c.Start == nil {
+			continue
+		}
+		log.Infof("start component %s", c.Name)
+		if err := c.Start(); err != nil {
+			log.Fatalf("start component %s: %v", c.Name, err)
+		}
+	}
+
+	signal.Notify(shutdownSignal, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(shutdownSignal)
+	for {
+		select {
+		case sig := <-shutdownSignal:
+			log.Infof("receive signal %v, shutdown", sig)
+			stopRuntime(sorted, config.Duration("ShutdownTimeout", 10*time.Second))
+			return
+		default:
+		}
+		util.TickTimerRun()
+		RunOnce()
+	}
+}
+
+func stopRuntime(sorted []*Component, timeout time.Duration) {
+	stopComponents(sorted, "stop accepting", func(c *Component) func() error { return c.StopAccept })
+
+	// 不再执行定时器，处理队列中剩余的消息
+	deadline := time.Now().Add(timeout)
+	for GetMessageQueue().Len() > 0 && time.Now().Before(deadline) {
+		RunOnce()
+	}
+	if n := GetMessageQueue().Len(); n > 0 {
+		log.Warnf("shutdown timeout, %d messages dropped", n)
+	}
+
+	// 剩余的消息处理完毕后再停止组件，保存的数据包括最后的修改
+	stopComponents(sorted, "stop component", func(c *Component) func() error { return c.Stop })
+
+	for _, ss := range GetSessionManage().GetList() {
+		ss.Out.Close()
+	}
+	cm := defaultClientManage
+	cm.mu.RLock()
+	for _, client := range cm.clients {
+		client.Close()
+	}
+	cm.mu.RUnlock()
+	log.Info("shutdown complete")
+	log.Flush()
+}
+
+// 按启动的相反顺序执行
+func stopComponents(sorted []*Component, action string, hook func(c *Component) func() error) {
+	for i := len(sorted) - 1; i >= 0; i-- {
+		c := sorted[i]
+		f := hook(c)
+		if f == nil {
+			continue
+		}
+		log.Infof("%s %s", action, c.Name)
+		if err := f(); err != nil {
+			log.Errorf("%s %s: %v", action, c.Name, err)
+		}
+	}
+}
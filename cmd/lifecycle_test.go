@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStopRuntimeOrder(t *testing.T) {
+	var steps []string
+	var saved int
+	changes := 0
+	record := func(step string) func() error {
+		return func() error { steps = append(steps, step); return nil }
+	}
+	store := &Component{
+		Name:  "store",
+		Start: record("start store"),
+		Stop: func() error {
+			saved = changes
+			steps = append(steps, "stop store")
+			return nil
+		},
+	}
+	listener := &Component{
+		Name:       "listener",
+		DependsOn:  []string{"store"},
+		StopAccept: record("stop accept listener"),
+		Stop:       record("stop listener"),
+	}
+	sorted, err := sortComponents([]*Component{listener, store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 停止前队列中尚未处理的修改
+	for i := 0; i < 3; i++ {
+		Enqueue(&Context{}, func(ctx *Context, data interface{}) { changes++ }, nil)
+	}
+	stopRuntime(sorted, time.Second)
+
+	expect := []string{"stop accept listener", "stop listener", "stop store"}
+	if !reflect.DeepEqual(steps, expect) {
+		t.Error(steps)
+	}
+	if changes != 3 || saved != 3 {
+		t.Error("queued changes lost", changes, saved)
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+// 多端口监听，各端口可指定不同的传输方式、TLS、编码及校验
+//   srv := &cmd.Server{}
+//   srv.Listen(&cmd.ListenOptions{Addr: ":8201", Transport: cmd.TransportWs, External: true})
+//   srv.Listen(&cmd.ListenOptions{Addr: ":9010"})
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	TransportTCP = "tcp"
+	TransportWs  = "ws"
+)
+
+type ListenOptions struct {
+	Addr      string
+	Transport string      // 默认tcp
+	Path      string      // websocket路径，默认/ws
+	TLSConfig *tls.Config // 不为空时开启TLS
+
+	External bool // 外部客户端连接，不允许发送内部消息
+	SkipAuth bool // tcp连接第一个包不作为校验包
+
+	RequireEncryption bool          // websocket客户端必须开启加密
+	Parser            PackageParser // 数据包编码，默认内部连接不校验签名，外部连接校验签名
+
+	Label string // 地址标签，如线路telecom、unicom，网关会话记录于SessionKeyLabel
+}
+
+func (opts *ListenOptions) transport() string {
+	if opts.Transport == "" {
+		return TransportTCP
+	}
+	return opts.Transport
+}
+
+func (opts *ListenOptions) parser() PackageParser {
+	if opts.Parser != nil {
+		return opts.Parser
+	}
+	if opts.External {
+		return defaultHashParser
+	}
+	return defaultRawParser
+}
+
+var (
+	defaultTCPOptions = &ListenOptions{}
+	defaultWsOptions  = &ListenOptions{Transport: TransportWs, External: true}
+)
+
+type serverListeners struct {
+	listeners []io.Closer
+	mu        sync.Mutex
+}
+
+func (sl *serverListeners) add(l io.Closer) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.listeners = append(sl.listeners, l)
+}
+
+// 监听新端口，不阻塞
+func (srv *Server) Listen(opts *ListenOptions) error {
+	if opts == nil {
+		opts = defaultTCPOptions
+	}
+	transport := opts.transport()
+	f := getTransport(transport)
+	if f == nil {
+		return errors.New("unsupported transport " + transport)
+	}
+
+	l, err := f(srv, opts)
+	if err != nil {
+		return err
+	}
+	srv.listeners.add(l)
+	return nil
+}
+
+// 监听tcp端口，按需开启TLS
+func ListenTCP(opts *ListenOptions) (net.Listener, error) {
+	l, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLSConfig != nil {
+		l = tls.NewListener(l, opts.TLSConfig)
+	}
+	return l, nil
+}
+
+func listenTCP(srv *Server, opts *ListenOptions) (io.Closer, error) {
+	l, err := ListenTCP(opts)
+	if err != nil {
+		return nil, err
+	}
+	go srv.serve(l, opts)
+	return l, nil
+}
+
+func listenWs(srv *Server, opts *ListenOptions) (io.Closer, error) {
+	l, err := ListenTCP(opts)
+	if err != nil {
+		return nil, err
+	}
+	path := opts.Path
+	if path == "" {
+		path = "/ws"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		serveWs(w, r, opts)
+	})
+	go http.Serve(l, mux)
+	return l, nil
+}
+
+// 关闭全部监听端口，已建立的连接不受影响
+func (srv *Server) Close() error {
+	srv.listeners.mu.Lock()
+	defer srv.listeners.mu.Unlock()
+
+	var err error
+	for _, l := range srv.listeners.listeners {
+		if err2 := l.Close(); err2 != nil {
+			err = err2
+		}
+	}
+	srv.listeners.listeners = nil
+	return err
+}
@@ -0,0 +1,205 @@
+package cmd
+
+// 会话位置，记录会话所在的网关及绑定的服务
+// 网关开启复制后，位置变更同步至路由，其他网关或服务可向路由查询
+//   C2S_QuerySession {Ssid, UId} -> S2C_QuerySession
+
+import (
+	"encoding/json"
+	"github.com/guogeer/husky/util"
+	"sync"
+	"time"
+)
+
+type SessionLocation struct {
+	Ssid          string
+	UId           int      `json:",omitempty"`
+	ServerName    string   `json:",omitempty"`
+	ServerVersion string   `json:",omitempty"`
+	Services      []string `json:",omitempty"` // 会话路由过的服务
+	Gateway       string   `json:",omitempty"` // 网关地址，由路由填写
+	IsDelete      bool     `json:",omitempty"`
+}
+
+type SessionLocator struct {
+	ttl       time.Duration // 0表示不过期
+	replicate bool          // 同步至路由
+
+	locs      *util.Cache // 会话ID -> 位置
+	uids      map[int]string
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// 位置在会话断开时删除，不按数量淘汰，避免在线会话的位置丢失；ttl用于清理未收到删除的位置
+func NewSessionLocator(ttl time.Duration) *SessionLocator {
+	sl := &SessionLocator{
+		ttl:  ttl,
+		uids: make(map[int]string),
+	}
+	sl.locs = util.NewCache(&util.CacheOptions{
+		TTL:     ttl,
+		OnEvict: sl.onEvict,
+	})
+	return sl
+}
+
+// 位置过期或删除，由持有sl.mu的调用触发
+func (sl *SessionLocator) onEvict(key, value interface{}) {
+	loc := value.(SessionLocation)
+	if loc.UId != 0 && sl.uids[loc.UId] == loc.Ssid {
+		delete(sl.uids, loc.UId)
+	}
+}
+
+// 位置变更同步至路由
+func (sl *SessionLocator) EnableReplication(enable bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.replicate = enable
+}
+
+func (sl *SessionLocator) Set(loc SessionLocation) {
+	sl.mu.Lock()
+	now := util.Now()
+	// 保留已绑定的服务
+	if old, ok := sl.get(loc.Ssid); ok && loc.Services == nil {
+		loc.Services = old.Services
+	}
+	sl.locs.Delete(loc.Ssid)
+	sl.locs.Set(loc.Ssid, loc)
+	if loc.UId != 0 {
+		sl.uids[loc.UId] = loc.Ssid
+	}
+	// 定期清理过期的位置
+	if sl.ttl > 0 && now.Sub(sl.lastSweep) > sl.ttl {
+		sl.lastSweep = now
+		sl.locs.Expire()
+	}
+	replicate := sl.replicate
+	sl.mu.Unlock()
+
+	if replicate {
+		Route(ServerRouter, "C2S_SetSessionLocation", loc)
+	}
+}
+
+// 会话绑定新的服务，仅更新已记录的会话
+func (sl *SessionLocator) Bind(ssid, serverName string) {
+	sl.mu.Lock()
+	loc, ok := sl.get(ssid)
+	if !ok {
+		sl.mu.Unlock()
+		return
+	}
+	for _, name := range loc.Services {
+		if name == serverName {
+			sl.mu.Unlock()
+			return
+		}
+	}
+	services := make([]string, 0, len(loc.Services)+1)
+	services = append(services, loc.Services...)
+	loc.Services = append(services, serverName)
+	sl.locs.Set(ssid, loc)
+	replicate := sl.replicate
+	sl.mu.Unlock()
+
+	if replicate {
+		Route(ServerRouter, "C2S_SetSessionLocation", loc)
+	}
+}
+
+// 延长有效期
+func (sl *SessionLocator) Touch(ssid string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if loc, ok := sl.get(ssid); ok {
+		sl.locs.Set(ssid, loc)
+	}
+}
+
+func (sl *SessionLocator) get(ssid string) (SessionLocation, bool) {
+	v, ok := sl.locs.Get(ssid)
+	if !ok {
+		return SessionLocation{}, false
+	}
+	return v.(SessionLocation), true
+}
+
+func (sl *SessionLocator) Get(ssid string) (SessionLocation, bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.get(ssid)
+}
+
+func (sl *SessionLocator) GetByUId(uid int) (SessionLocation, bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	ssid, ok := sl.uids[uid]
+	if !ok {
+		return SessionLocation{}, false
+	}
+	return sl.get(ssid)
+}
+
+func (sl *SessionLocator) Delete(ssid string) {
+	sl.mu.Lock()
+	ok := sl.locs.Delete(ssid)
+	replicate := sl.replicate
+	sl.mu.Unlock()
+
+	if ok && replicate {
+		Route(ServerRouter, "C2S_SetSessionLocation", SessionLocation{Ssid: ssid, IsDelete: true})
+	}
+}
+
+// 删除网关上的全部会话位置
+func (sl *SessionLocator) DeleteByGateway(gateway string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	var ssids []string
+	sl.locs.Range(func(key, value interface{}) bool {
+		if value.(SessionLocation).Gateway == gateway {
+			ssids = append(ssids, key.(string))
+		}
+		return true
+	})
+	for _, ssid := range ssids {
+		sl.locs.Delete(ssid)
+	}
+}
+
+// 全部会话位置
+func (sl *SessionLocator) List() []SessionLocation {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	locs := make([]SessionLocation, 0, sl.locs.Len())
+	sl.locs.Range(func(key, value interface{}) bool {
+		locs = append(locs, value.(SessionLocation))
+		return true
+	})
+	return locs
+}
+
+func (sl *SessionLocator) Len() int {
+	return sl.locs.Len()
+}
+
+type querySessionArgs struct {
+	Ssid string `json:",omitempty"`
+	UId  int    `json:",omitempty"`
+}
+
+// 向路由查询会话所在的网关及服务，ssid为空时按uid查询
+func QuerySession(ssid string, uid int) (*SessionLocation, error) {
+	buf, err := Request(ServerRouter, "C2S_QuerySession", &querySessionArgs{Ssid: ssid, UId: uid})
+	if err != nil {
+		return nil, err
+	}
+	loc := &SessionLocation{}
+	if err := json.Unmarshal(buf, loc); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/guogeer/husky/util"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSessionLocator(t *testing.T) {
+	// 在线会话不因数量被淘汰，断开时删除
+	sl := NewSessionLocator(0)
+	for i := 1; i <= 1000; i++ {
+		sl.Set(SessionLocation{Ssid: strconv.Itoa(i), UId: i, ServerName: "hall"})
+	}
+	sl.Bind("1", "game")
+	if loc, ok := sl.Get("1"); !ok || len(loc.Services) != 1 || loc.Services[0] != "game" {
+		t.Fatal(loc)
+	}
+	if loc, ok := sl.GetByUId(1000); !ok || loc.Ssid != "1000" {
+		t.Fatal(loc)
+	}
+	sl.Delete("1")
+	if _, ok := sl.GetByUId(1); ok || sl.Len() != 999 {
+		t.Fatal("delete", sl.Len())
+	}
+}
+
+func TestSessionLocatorTTL(t *testing.T) {
+	clock := util.NewVirtualClock(time.Now())
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	sl := NewSessionLocator(time.Minute)
+	sl.Set(SessionLocation{Ssid: "a", UId: 1})
+	clock.Advance(30 * time.Second)
+	sl.Touch("a")
+	clock.Advance(45 * time.Second)
+	if _, ok := sl.Get("a"); !ok {
+		t.Fatal("touched location expired")
+	}
+	clock.Advance(time.Minute)
+	if _, ok := sl.GetByUId(1); ok {
+		t.Fatal("location not expired")
+	}
+}
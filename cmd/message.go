@@ -6,7 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/buger/jsonparser"
-	// "github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/log"
+	"runtime/pprof"
 	"strings"
 	"time"
 )
@@ -27,14 +28,55 @@ type Conn interface {
 type Context struct {
 	Out       Conn   // 连接
 	Ssid      string // 发送方会话ID
-	Version   int    // 协议版本，当前未生效
+	Version   int    // 协商后的协议版本
+	MsgId     string // 当前处理的消息ID
+	AppId     string // 网关转发的会话所属应用
+	TraceId   string // 客户端消息的追踪ID，随转发的消息传递
 	isGateway bool   // 网关
+
+	rtt     time.Duration   // 网关转发的会话往返时间
+	meta    json.RawMessage // 网关转发的会话数据
+	claims  json.RawMessage // 网关转发的会话令牌声明
+	logSize int             // 大于0时打印回复
+
+	exec   HandlerStats // 处理的耗时统计
+	defers []func()     // 处理结束后执行
+
+	batch *routeBatchArgs // 批量回复暂存的消息
+}
+
+// 会话心跳往返时间，未测量时为0
+func (ctx *Context) RTT() time.Duration {
+	if ctx.rtt > 0 {
+		return ctx.rtt
+	}
+	if c, ok := ctx.Out.(rttConn); ok {
+		return c.RTT()
+	}
+	return 0
+}
+
+// 附带会话ID、消息ID及追踪ID的日志
+func (ctx *Context) Logger() *log.Logger {
+	var fields []interface{}
+	if ctx.Ssid != "" {
+		fields = append(fields, "ssid", ctx.Ssid)
+	}
+	if ctx.MsgId != "" {
+		fields = append(fields, "msg", ctx.MsgId)
+	}
+	if ctx.TraceId != "" {
+		fields = append(fields, "trace", ctx.TraceId)
+	}
+	return log.With(fields...)
 }
 
 type Message struct {
 	h    Handler
 	ctx  *Context
 	args interface{}
+
+	enqueued time.Time // 进入主循环队列的时间
 }
 
 type SafeQueue struct {
@@ -82,6 +124,8 @@ func GetMessageQueue() *SafeQueue {
 }
 
 func RunOnce() {
+	// 标记主循环协程，慢消息检测时据此获取堆栈
+	pprof.SetGoroutineLabels(mainLoopLabels)
 	delay := 40 * time.Millisecond
 	for i := 0; i < 64; i++ {
 		front := GetMessageQueue().Dequeue(delay)
@@ -89,12 +133,23 @@ func RunOnce() {
 			break
 		}
 		msg := front.(*Message)
-		msg.h(msg.ctx, msg.args)
+		if !msg.enqueued.IsZero() {
+			queueWaits.add(time.Since(msg.enqueued))
+		}
+		runMessage(msg)
 	}
 }
 
 func Enqueue(ctx *Context, h Handler, args interface{}) {
-	GetMessageQueue().Enqueue(&Message{ctx: ctx, h: h, args: args})
+	enqueueMessage(&Message{ctx: ctx, h: h, args: args})
+}
+
+// 加入主循环队列
+func enqueueMessage(msg *Message) {
+	q := GetMessageQueue()
+	msg.enqueued = time.Now()
+	q.Enqueue(msg)
+	checkQueueSaturation(q)
 }
 
 type Package struct {
@@ -104,6 +159,14 @@ type Package struct {
 	Ssid     string          `json:",omitempty"`    // 会话ID
 	Version  int             `json:"Ver,omitempty"` // 版本
 	SendTime int64           `json:",omitempty"`    // 发送的时间戳
+	RTT      int64           `json:",omitempty"`    // 会话往返时间，毫秒
+	Seq      int64           `json:",omitempty"`    // 消息序号，从1递增，网关下发时用于重连补发
+	Nonce    string          `json:",omitempty"`    // 校验包随机数，防重放
+	AppId    string          `json:",omitempty"`    // 会话所属应用
+	TraceId  string          `json:",omitempty"`    // 追踪ID，网关收到客户端消息时生成
+	Claims   json.RawMessage `json:",omitempty"`    // 网关验证的会话令牌声明
+	Meta     json.RawMessage `json:",omitempty"`    // 会话数据
+	Codec    string          `json:",omitempty"`    // 数据编码，gzip时Data为压缩后的base64字符串
 
 	Body  interface{} `json:"-"` // 传入的参数
 	IsRaw bool        `json:"-"`
@@ -119,6 +182,9 @@ func (pkg *Package) beforeEncode(parser PackageParser) (err error) {
 	if body == nil {
 		return
 	}
+	if b, ok := body.(*codecBody); ok {
+		pkg.Codec = b.codec
+	}
 	pkg.Data, err = marshalJSON(body)
 	return
 }
@@ -168,7 +234,7 @@ func (parser *hashParser) Decode(buf []byte) (*Package, error) {
 	if secs := int64(parser.secs); secs > 0 {
 		ts := pkg.SendTime
 		ts0 := time.Now().Unix()
-		if ts > ts0 || ts+secs < ts0 {
+		if ts > ts0+secs || ts+secs < ts0 {
 			return nil, errPackageExpire
 		}
 	}
@@ -180,6 +246,9 @@ func (parser *hashParser) Decode(buf []byte) (*Package, error) {
 	if pkg.Sign != sign {
 		return pkg, ErrInvalidSign
 	}
+	if err := pkg.decodeData(); err != nil {
+		return pkg, err
+	}
 	return pkg, nil
 
 }
@@ -219,7 +288,12 @@ func Encode(pkg *Package) ([]byte, error) {
 	if pkg.IsRaw == true {
 		parser = defaultRawParser
 	}
-	return parser.Encode(pkg)
+	buf, err := parser.Encode(pkg)
+	if err == nil {
+		tapMessage(TapOutbound, pkg.Id, pkg.Ssid, pkg.Data)
+		traceHop(TraceSend, pkg.Ssid, pkg.Id, 0)
+	}
+	return buf, err
 }
 
 func Decode(buf []byte) (*Package, error) {
@@ -237,13 +311,28 @@ func marshalJSON(i interface{}) ([]byte, error) {
 	case string:
 		return []byte(i.(string)), nil
 	}
-	return json.Marshal(i)
+	return encodeJSON(i)
 }
 
+// 依次匹配路由规则、命名空间、server.message格式
 func routeMessage(server, message string) (string, string) {
-	if server != "" {
+	explicit := server != ""
+	if explicit {
 		message = server + "." + message
 	}
+	if server, message, ok := matchRouteRules(message); ok {
+		return server, message
+	}
+	// 已指定服务时不匹配命名空间
+	if server, ok := matchNamespace(message); ok && !explicit && !strings.Contains(message, ".") {
+		return server, message
+	}
+	return splitMessage(message)
+}
+
+// server.message格式
+func splitMessage(message string) (string, string) {
+	var server string
 	if subs := strings.SplitN(message, ".", 2); len(subs) > 1 {
 		server, message = subs[0], subs[1]
 	}
@@ -0,0 +1,112 @@
+package cmd
+
+// 按消息压缩回复数据，如全量背包同步
+//   cmd.Bind(C2S_SyncBag, (*Args)(nil), cmd.WithCompressResponse())
+// 对方支持压缩且数据超过Threshold时，Data为gzip压缩后的base64字符串，Codec为gzip，处理函数无需改动
+// 内部连接通过版本协商的compress特性确认，websocket客户端连接时携带参数codec=gzip
+// 网关转发的回复由网关按客户端是否支持决定压缩，收到的压缩数据自动解压，解压后的长度上限为MaxInflatedSize
+//   <MessageCodec Threshold="4KB" MaxInflatedSize="4MB"/>
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+)
+
+const CodecGzip = "gzip"
+
+type MessageCodecOptions struct {
+	Threshold       int   `default:"4KB"` // 超过该长度才压缩
+	MaxInflatedSize int64 `default:"4MB"` // 服务间消息解压后的长度上限，与客户端连接的Compression.MaxInflatedSize无关
+}
+
+var codecOpts MessageCodecOptions
+
+func init() {
+	if err := config.Unmarshal("MessageCodec", &codecOpts); err != nil {
+		log.Errorf("load message codec %v", err)
+	}
+	RegisterFeature(FeatureCompress)
+}
+
+// 绑定时指定回复数据较大时压缩
+func WithCompressResponse() BindOption {
+	return func(e *cmdEntry) {
+		e.compress = true
+	}
+}
+
+// 压缩后的数据，编码为base64字符串
+type codecBody struct {
+	codec string
+	data  []byte
+}
+
+func (b *codecBody) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.data)
+}
+
+type featureConn interface {
+	HasFeature(name string) bool
+}
+
+// 对方支持压缩且数据较大时返回压缩后的数据
+func CompressBody(c Conn, body interface{}) interface{} {
+	if fc, ok := c.(featureConn); !ok || !fc.HasFeature(FeatureCompress) {
+		return body
+	}
+	data, err := marshalJSON(body)
+	if err != nil || len(data) < codecOpts.Threshold {
+		return body
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil || buf.Len() >= len(data) {
+		return json.RawMessage(data)
+	}
+	return &codecBody{codec: CodecGzip, data: buf.Bytes()}
+}
+
+// 当前处理的消息绑定时是否指定压缩回复
+func (ctx *Context) compressResponse() bool {
+	if ctx.MsgId == "" {
+		return false
+	}
+	s := defaultCmdSet
+	s.mu.RLock()
+	e := s.e[ctx.MsgId]
+	s.mu.RUnlock()
+	return e != nil && e.compress
+}
+
+// 解压收到的数据
+func (pkg *Package) decodeData() error {
+	switch pkg.Codec {
+	case "":
+		return nil
+	case CodecGzip:
+	default:
+		return errInvalidFrame
+	}
+
+	var raw []byte
+	if err := json.Unmarshal(pkg.Data, &raw); err != nil {
+		return err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return errInvalidFrame
+	}
+	defer r.Close()
+	// 正常数据的压缩率可能较高，仅限制解压后的长度
+	data, err := readInflated(r, 0, codecOpts.MaxInflatedSize)
+	if err != nil {
+		return err
+	}
+	pkg.Data, pkg.Codec = data, ""
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeLargeCompressedBody(t *testing.T) {
+	out := &recordConn{features: []string{FeatureCompress}}
+	items := make([]string, 0, 4096)
+	for i := 0; i < cap(items); i++ {
+		items = append(items, strings.Repeat("item", 4))
+	}
+	body := CompressBody(out, map[string]interface{}{"Items": items})
+	if _, ok := body.(*codecBody); !ok {
+		t.Fatal("body not compressed")
+	}
+
+	buf, err := defaultRawParser.Encode(&Package{Id: "S2C_SyncBag", Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := defaultRawParser.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Data) <= int(compressOpts.MaxInflatedSize) {
+		t.Fatal("payload too small", len(pkg.Data))
+	}
+	var m struct{ Items []string }
+	if err := json.Unmarshal(pkg.Data, &m); err != nil || len(m.Items) != len(items) {
+		t.Error("decode", err, len(m.Items))
+	}
+
+	// 超过服务间的解压上限
+	defer func(n int64) { codecOpts.MaxInflatedSize = n }(codecOpts.MaxInflatedSize)
+	codecOpts.MaxInflatedSize = 64 << 10
+	if _, err := defaultRawParser.Decode(buf); err != errInflateTooLarge {
+		t.Error("inflate limit", err)
+	}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+// 消息统计排行
+// 按消息ID滚动统计最近一段时间的次数、字节数及平均处理耗时，
+// 通过ADMIN_GetMessageTopN或/debug/vars中的messages查询
+//   <MessageStats Window="1m" Slots="6"/>
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MessageStatsByCount = "count"
+	MessageStatsByBytes = "bytes"
+	MessageStatsByCost  = "cost"
+)
+
+type MessageStatsOptions struct {
+	Window time.Duration `default:"1m"` // 统计时长
+	Slots  int           `default:"6"`  // 分段数量，按段滚动
+}
+
+type MessageStat struct {
+	Id      string
+	Count   int64
+	Bytes   int64
+	AvgCost time.Duration // 平均处理耗时
+	runs    int64
+	cost    time.Duration
+}
+
+type messageTopNArgs struct {
+	N  int
+	By string // count、bytes、cost，默认count
+}
+
+type messageSlot struct {
+	start time.Time
+	stats map[string]*MessageStat
+}
+
+type messageStats struct {
+	slots []messageSlot
+	span  time.Duration
+	mu    sync.Mutex
+}
+
+var defaultMessageStats = &messageStats{}
+
+func init() {
+	var opts MessageStatsOptions
+	if err := config.Unmarshal("MessageStats", &opts); err != nil {
+		log.Errorf("load message stats %v", err)
+	}
+	defaultMessageStats.reset(opts)
+	PublishVar("messages", func() interface{} {
+		return MessageTopN(20, MessageStatsByCount)
+	})
+}
+
+func (ms *messageStats) reset(opts MessageStatsOptions) {
+	if opts.Slots <= 0 {
+		opts.Slots = 6
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Minute
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.slots = make([]messageSlot, opts.Slots)
+	ms.span = opts.Window / time.Duration(opts.Slots)
+}
+
+// 当前时间所在分段，过期的分段清空
+func (ms *messageStats) current(now time.Time) map[string]*MessageStat {
+	start := now.Truncate(ms.span)
+	k := int(start.UnixNano()/int64(ms.span)) % len(ms.slots)
+	slot := &ms.slots[k]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.stats = make(map[string]*MessageStat)
+	}
+	return slot.stats
+}
+
+func (ms *messageStats) get(id string) *MessageStat {
+	stats := ms.current(time.Now())
+	st, ok := stats[id]
+	if !ok {
+		st = &MessageStat{Id: id}
+		stats[id] = st
+	}
+	return st
+}
+
+func (ms *messageStats) recv(id string, n int) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	st := ms.get(id)
+	st.Count++
+	st.Bytes += int64(n)
+}
+
+func (ms *messageStats) exec(id string, d time.Duration) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	st := ms.get(id)
+	st.runs++
+	st.cost += d
+}
+
+// 合并统计时长内的分段
+func (ms *messageStats) merge() []*MessageStat {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	window := ms.span * time.Duration(len(ms.slots))
+	since := time.Now().Add(-window)
+	all := make(map[string]*MessageStat)
+	for _, slot := range ms.slots {
+		if slot.start.IsZero() || !slot.start.After(since) {
+			continue
+		}
+		for id, st := range slot.stats {
+			sum, ok := all[id]
+			if !ok {
+				sum = &MessageStat{Id: id}
+				all[id] = sum
+			}
+			sum.Count += st.Count
+			sum.Bytes += st.Bytes
+			sum.runs += st.runs
+			sum.cost += st.cost
+		}
+	}
+
+	a := make([]*MessageStat, 0, len(all))
+	for _, st := range all {
+		if st.runs > 0 {
+			st.AvgCost = st.cost / time.Duration(st.runs)
+		}
+		a = append(a, st)
+	}
+	return a
+}
+
+func SetMessageStats(opts MessageStatsOptions) {
+	defaultMessageStats.reset(opts)
+}
+
+// 按次数、字节数或平均耗时排序的前n条消息
+func MessageTopN(n int, by string) []*MessageStat {
+	a := defaultMessageStats.merge()
+	sort.Slice(a, func(i, j int) bool {
+		switch by {
+		case MessageStatsByBytes:
+			return a[i].Bytes > a[j].Bytes
+		case MessageStatsByCost:
+			return a[i].AvgCost > a[j].AvgCost
+		}
+		return a[i].Count > a[j].Count
+	})
+	if n > 0 && len(a) > n {
+		a = a[:n]
+	}
+	return a
+}
+
+func funcGetMessageTopN(ctx *Context, data interface{}) {
+	args := data.(*messageTopNArgs)
+	n := args.N
+	if n <= 0 {
+		n = 20
+	}
+	ctx.Out.WriteJSON("S2C_GetMessageTopN", map[string]interface{}{"Messages": MessageTopN(n, args.By)})
+}
@@ -0,0 +1,70 @@
+package cmd
+
+// 消息ID命名空间
+// 服务注册时声明拥有的消息ID前缀，路由校验唯一后同步至网关
+// 网关按最长前缀将客户端消息路由至对应服务，客户端无需携带服务名
+
+import (
+	"github.com/guogeer/husky/log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type NamespaceArgs struct {
+	Namespaces map[string]string // 前缀 -> 服务名
+}
+
+type namespace struct {
+	prefix, server string
+}
+
+type registerOkArgs struct {
+	Conflicts []string // 已被其他服务占用的前缀
+	Lease     int      // 注册租约时长，秒，0不过期
+}
+
+var namespaces atomic.Value
+
+func init() {
+	namespaces.Store([]namespace(nil))
+}
+
+func setNamespaces(m map[string]string) {
+	var a []namespace
+	for prefix, server := range m {
+		a = append(a, namespace{prefix: prefix, server: server})
+	}
+	// 最长前缀优先
+	sort.Slice(a, func(i, j int) bool { return len(a[i].prefix) > len(a[j].prefix) })
+	namespaces.Store(a)
+}
+
+func matchNamespace(id string) (string, bool) {
+	for _, ns := range namespaces.Load().([]namespace) {
+		if strings.HasPrefix(id, ns.prefix) {
+			return ns.server, true
+		}
+	}
+	return "", false
+}
+
+// 路由同步命名空间
+func funcSetNamespaces(ctx *Context, data interface{}) {
+	args := data.(*NamespaceArgs)
+	setNamespaces(args.Namespaces)
+	for _, server := range args.Namespaces {
+		RegisterServiceInGateway(server)
+	}
+}
+
+func funcRegisterOk(ctx *Context, data interface{}) {
+	args := data.(*registerOkArgs)
+	if len(args.Conflicts) > 0 {
+		log.Errorf("message prefix %v is registered by other server", args.Conflicts)
+	}
+	// 注册成功后订阅配置表，重连后重新订阅
+	subscribeAllConfigTables()
+	startLeaseRenewal(time.Duration(args.Lease) * time.Second)
+}
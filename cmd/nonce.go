@@ -0,0 +1,86 @@
+package cmd
+
+// 校验包防重放
+// 校验包携带随机数及时间戳，时间戳与本机时间相差不超过AuthSkew（默认5s），
+// 窗口内重复的随机数视为重放。AuthRequireNonce开启后拒绝未携带随机数的校验包
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/guogeer/husky/config"
+	"sync"
+	"time"
+)
+
+var (
+	errReplayAuth   = errors.New("replayed auth package")
+	errMissingNonce = errors.New("auth package without nonce")
+)
+
+type nonceCache struct {
+	seen      map[string]time.Time
+	lastPurge time.Time
+	mu        sync.Mutex
+}
+
+var (
+	authNonces       = &nonceCache{seen: make(map[string]time.Time)}
+	authRequireNonce bool
+)
+
+func init() {
+	skew := config.Duration("AuthSkew", 5*time.Second)
+	if h, ok := defaultAuthParser.(*hashParser); ok && skew > 0 {
+		h.secs = int((skew + time.Second - 1) / time.Second)
+	}
+	authRequireNonce = config.Bool("AuthRequireNonce", false)
+}
+
+func newNonce() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// 随机数首次出现时返回true，记录保留ttl
+func (nc *nonceCache) Check(nonce string, ttl time.Duration) bool {
+	now := time.Now()
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if now.Sub(nc.lastPurge) > ttl {
+		for k, expire := range nc.seen {
+			if now.After(expire) {
+				delete(nc.seen, k)
+			}
+		}
+		nc.lastPurge = now
+	}
+	if expire, ok := nc.seen[nonce]; ok && now.Before(expire) {
+		return false
+	}
+	nc.seen[nonce] = now.Add(ttl)
+	return true
+}
+
+// 校验包已通过签名及时间戳校验
+func checkAuthNonce(pkg *Package) error {
+	if pkg.Nonce == "" {
+		if authRequireNonce {
+			return errMissingNonce
+		}
+		return nil
+	}
+	secs := 5
+	if h, ok := defaultAuthParser.(*hashParser); ok {
+		secs = h.secs
+	}
+	// 时间戳前后各允许secs秒
+	if !authNonces.Check(pkg.Nonce, 2*time.Duration(secs+1)*time.Second) {
+		return errReplayAuth
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+// 下发消息的序号及重连补发
+// 网关下发给客户端的消息携带递增的序号Seq，最近ResendWindow条消息暂存在会话中
+// 客户端断线重连时携带已收到的最大序号（?resume=token&ack=N），网关按顺序补发之后的消息
+// 补发的消息可能重复，客户端按序号去重；超出暂存范围无法补发时下发ResendLost {From, To}
+//   <ResendWindow>256</ResendWindow>
+// 默认0不开启，需同时配置ReconnectGrace
+
+import (
+	"github.com/guogeer/husky/config"
+	"sync"
+)
+
+var resendWindow = config.Int("ResendWindow", 0)
+
+type outboxFrame struct {
+	seq int64
+	buf []byte
+}
+
+type outbox struct {
+	seq    int64
+	frames []outboxFrame
+	mu     sync.Mutex
+}
+
+type resendLostArgs struct {
+	From, To int64
+}
+
+// 分配序号并写入连接，连接接收后才暂存并递增序号，队列已满丢弃时不产生序号空洞
+// 写入发送队列不阻塞，持有锁保证序号与入队顺序一致，实际发送在连接的写协程中执行
+func (o *outbox) write(c *WsConn, pkg *Package, parser PackageParser) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pkg.Seq = o.seq + 1
+	buf, err := parser.Encode(pkg)
+	if err != nil {
+		return err
+	}
+	if err := c.offer(buf); err != nil {
+		return err
+	}
+	o.seq++
+	o.frames = append(o.frames, outboxFrame{seq: o.seq, buf: buf})
+	if n := len(o.frames) - resendWindow; n > 0 {
+		o.frames = append(o.frames[:0], o.frames[n:]...)
+	}
+	return nil
+}
+
+// 补发序号大于ack的消息
+func (o *outbox) resend(c Conn, ack int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if ack >= o.seq || ack < 0 {
+		return
+	}
+	if len(o.frames) == 0 || o.frames[0].seq > ack+1 {
+		to := o.seq
+		if len(o.frames) > 0 {
+			to = o.frames[0].seq - 1
+		}
+		buf, err := defaultRawParser.Encode(&Package{Id: "ResendLost", Body: &resendLostArgs{From: ack + 1, To: to}})
+		if err == nil {
+			c.Write(buf)
+		}
+	}
+	for _, frame := range o.frames {
+		if frame.seq > ack {
+			c.Write(frame.buf)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func frameSeq(t *testing.T, buf []byte) int64 {
+	pkg, err := defaultRawParser.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg.Seq
+}
+
+func TestOutboxSeq(t *testing.T) {
+	window := resendWindow
+	resendWindow = 8
+	defer func() { resendWindow = window }()
+
+	var o outbox
+	c := &WsConn{send: make(chan []byte, 2)}
+	write := func() error {
+		return o.write(c, &Package{Id: "Notice", Body: json.RawMessage(`{}`)}, defaultRawParser)
+	}
+	for i := 0; i < 3; i++ {
+		err := write()
+		if (i < 2) != (err == nil) {
+			t.Fatal(i, err)
+		}
+	}
+	if o.seq != 2 || len(o.frames) != 2 {
+		t.Fatal("dropped frame consumed seq", o.seq, len(o.frames))
+	}
+
+	// 队列腾出后序号连续
+	for len(c.send) > 0 {
+		<-c.send
+	}
+	if err := write(); err != nil {
+		t.Fatal(err)
+	}
+	if seq := frameSeq(t, <-c.send); seq != 3 {
+		t.Error("seq gap", seq)
+	}
+
+	// 断开期间仅暂存，重连后补发
+	c.Close()
+	write()
+	next := &WsConn{send: make(chan []byte, 8)}
+	o.resend(next, 1)
+	var seqs []int64
+	for len(next.send) > 0 {
+		seqs = append(seqs, frameSeq(t, <-next.send))
+	}
+	if len(seqs) != 3 || seqs[0] != 2 || seqs[2] != 4 {
+		t.Error("resend", seqs)
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+// 基于net.Pipe的内存连接，用于服务单元测试
+// 无需监听端口或启动路由即可测试已绑定的消息处理
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var errExpectTimeout = errors.New("expect message timeout")
+
+type PipeConn struct {
+	*TCPConn
+	peer *TCPConn            // 对端，读取写入的消息
+	recv chan pipeReadResult // 对端收到的消息
+}
+
+type pipeReadResult struct {
+	pkg *Package
+	err error
+}
+
+func NewPipeConn() *PipeConn {
+	c1, c2 := net.Pipe()
+	c := &PipeConn{
+		TCPConn: &TCPConn{rwc: c1},
+		peer:    &TCPConn{rwc: c2},
+		recv:    make(chan pipeReadResult, 64),
+	}
+	c.send = c.queue.init(QueueClassClient)
+	go c.serve()
+	go c.readPeer()
+	return c
+}
+
+func (c *PipeConn) serve() {
+	defer c.rwc.Close()
+	for buf := range c.send {
+		if _, err := c.writeMsg(RawMessage, buf); err != nil {
+			return
+		}
+	}
+}
+
+// 对端持续读取完整的帧，读取不设超时，避免帧读取一半时超时导致后续数据错位
+func (c *PipeConn) readPeer() {
+	defer close(c.recv)
+	for {
+		mt, buf, err := c.peer.ReadMessage()
+		if err != nil {
+			return
+		}
+		if mt == RawMessage {
+			pkg, err := defaultRawParser.Decode(buf)
+			c.recv <- pipeReadResult{pkg: pkg, err: err}
+		}
+	}
+}
+
+// 读取对端收到的下一个消息
+func (c *PipeConn) ReadPackage(timeout time.Duration) (*Package, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r, ok := <-c.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return r.pkg, r.err
+	case <-timer.C:
+		return nil, errExpectTimeout
+	}
+}
+
+// 创建测试上下文，会话同时加入会话管理
+func NewTestContext(ssid string) *Context {
+	c := NewPipeConn()
+	c.ssid = ssid
+	addSession(&Session{Id: ssid, Out: c})
+	return &Context{Out: c, Ssid: ssid}
+}
+
+// 关闭测试上下文
+func CloseTestContext(ctx *Context) {
+	removeSession(ctx.Ssid)
+	ctx.Out.Close()
+}
+
+// 会话上下文的回复经FUNC_Route发送，取出其中回复客户端的消息
+func unwrapRoute(pkg *Package) (string, json.RawMessage) {
+	if pkg.Id != "FUNC_Route" {
+		return pkg.Id, pkg.Data
+	}
+	var args struct {
+		Id   string
+		Data json.RawMessage
+	}
+	if err := json.Unmarshal(pkg.Data, &args); err != nil {
+		return pkg.Id, pkg.Data
+	}
+	return args.Id, args.Data
+}
+
+// 发送消息并执行处理，返回期望的回复消息数据
+// 期间收到的其他消息将被忽略
+func SendAndExpect(ctx *Context, name string, args interface{}, expect string, timeout time.Duration) ([]byte, error) {
+	c, ok := ctx.Out.(*PipeConn)
+	if !ok {
+		return nil, errors.New("context is not created by NewTestContext")
+	}
+
+	Handle(ctx, name, args)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		RunOnce()
+		for {
+			pkg, err := c.ReadPackage(time.Millisecond)
+			if err != nil {
+				break
+			}
+			if id, data := unwrapRoute(pkg); id == expect {
+				return data, nil
+			}
+		}
+	}
+	return nil, errExpectTimeout
+}
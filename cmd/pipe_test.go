@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 编码一个完整的帧
+func encodeTestFrame(t *testing.T, name string) []byte {
+	buf, err := Encode(&Package{Id: name, Body: map[string]int{"N": 1}, IsRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	go func() {
+		(&TCPConn{rwc: a}).writeMsg(RawMessage, buf)
+		a.Close()
+	}()
+	frame, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestPipeSlowFrame(t *testing.T) {
+	c := NewPipeConn()
+	defer c.Close()
+
+	frame, next := encodeTestFrame(t, "Half"), encodeTestFrame(t, "Next")
+	half := len(frame) / 2
+	go func() {
+		c.rwc.Write(frame[:half])
+		time.Sleep(20 * time.Millisecond)
+		c.rwc.Write(frame[half:])
+		c.rwc.Write(next)
+	}()
+
+	// 帧未完整时超时，不影响后续读取
+	for i := 0; i < 5; i++ {
+		if _, err := c.ReadPackage(time.Millisecond); err != errExpectTimeout {
+			t.Fatal("read half frame", err)
+		}
+	}
+	for _, name := range []string{"Half", "Next"} {
+		pkg, err := c.ReadPackage(time.Second)
+		if err != nil || pkg.Id != name {
+			t.Fatal(name, pkg, err)
+		}
+	}
+}
+
+type pipeEchoArgs struct {
+	N int
+}
+
+func TestSendAndExpect(t *testing.T) {
+	BindWithName("PipeEcho", func(ctx *Context, data interface{}) {
+		args := data.(*pipeEchoArgs)
+		ctx.WriteJSON("PipeOther", args)
+		ctx.WriteJSON("PipeEchoResp", &pipeEchoArgs{N: args.N + 1})
+	}, (*pipeEchoArgs)(nil))
+
+	ctx := NewTestContext("pipe-ssid")
+	defer CloseTestContext(ctx)
+	if GetSession("pipe-ssid") == nil {
+		t.Fatal("session not added")
+	}
+
+	// 忽略期间收到的其他消息
+	data, err := SendAndExpect(ctx, "PipeEcho", &pipeEchoArgs{N: 1}, "PipeEchoResp", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"N":2}` {
+		t.Errorf("reply %s", data)
+	}
+
+	start := time.Now()
+	if _, err := SendAndExpect(ctx, "PipeEcho", &pipeEchoArgs{N: 1}, "PipeNoResp", 50*time.Millisecond); err != errExpectTimeout {
+		t.Error("expect timeout", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Error("timeout cost", d)
+	}
+	if _, err := SendAndExpect(&Context{Out: &recordConn{}}, "PipeEcho", nil, "PipeEchoResp", time.Millisecond); err == nil {
+		t.Error("not pipe context")
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+// 服务连接池
+// 网关至繁忙服务可建立多个并行连接，会话消息按会话ID分配连接，同一会话的消息保持有序，
+// 避免单个连接的写队列及队头阻塞限制吞吐。非会话消息使用第一个连接
+// 连接数默认ServicePoolSize=1，可按服务设置 <ServicePool hall="4"/>
+
+import (
+	"github.com/guogeer/husky/config"
+	"hash/crc32"
+	"strconv"
+	"sync"
+)
+
+var (
+	defaultPoolSize = config.Int("ServicePoolSize", 1)
+	poolSizes       = make(map[string]int)
+	poolMu          sync.RWMutex
+)
+
+// 设置至服务的连接数，n<=0时恢复配置值
+func SetServicePoolSize(serverName string, n int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if n <= 0 {
+		delete(poolSizes, serverName)
+	} else {
+		poolSizes[serverName] = n
+	}
+}
+
+func servicePoolSize(serverName string) int {
+	if serverName == ServerRouter {
+		return 1
+	}
+	poolMu.RLock()
+	n, ok := poolSizes[serverName]
+	poolMu.RUnlock()
+	if !ok {
+		n = config.Int("ServicePool."+serverName, defaultPoolSize)
+		poolMu.Lock()
+		poolSizes[serverName] = n
+		poolMu.Unlock()
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func chooseStripe(serverName, ssid string) int {
+	if ssid == "" {
+		return 0
+	}
+	n := servicePoolSize(serverName)
+	if n <= 1 {
+		return 0
+	}
+	return int(crc32.ChecksumIEEE([]byte(ssid)) % uint32(n))
+}
+
+func stripeKey(key string, stripe int) string {
+	if stripe == 0 {
+		return key
+	}
+	return key + "#" + strconv.Itoa(stripe)
+}
+
+func (c *Client) key() string {
+	return stripeKey(clientKey(c.app, c.name, c.version), c.stripe)
+}
@@ -0,0 +1,193 @@
+package cmd
+
+// 性能分析接口，提供/debug/pprof/、/debug/vars及/debug/protocol
+// 请求需携带管理令牌：Authorization: Bearer <Token>，未配置令牌时不开启
+// 默认关闭，可通过ADMIN_SetProfiling开启
+//   <Profiling Addr="127.0.0.1:6060" Token="xxx" Enable="false"/>
+// 处理函数仅注册在私有的ServeMux上，不使用net/http/pprof及expvar，避免暴露在http.DefaultServeMux
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"net"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoProfilingToken = errors.New("profiling token not configured")
+
+type ProfilingOptions struct {
+	Addr   string
+	Token  string
+	Enable bool
+}
+
+type profilingArgs struct {
+	Enable bool
+	Addr   string // 为空时使用配置的地址
+}
+
+type profiler struct {
+	enable  int32
+	token   string
+	addr    string
+	serving bool
+	mu      sync.Mutex
+}
+
+var defaultProfiler = &profiler{}
+
+func init() {
+	var opts ProfilingOptions
+	if err := config.Unmarshal("Profiling", &opts); err != nil {
+		log.Errorf("load profiling %v", err)
+	}
+	defaultProfiler.token = opts.Token
+	if opts.Addr != "" {
+		defaultProfiler.addr = opts.Addr
+	}
+	if opts.Enable {
+		if err := EnableProfiling(true, ""); err != nil {
+			log.Errorf("start profiling %v", err)
+		}
+	}
+}
+
+func (p *profiler) authorized(r *http.Request) bool {
+	if atomic.LoadInt32(&p.enable) == 0 || p.token == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) == 1
+}
+
+func (p *profiler) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.authorized(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (p *profiler) listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", p.wrap(http.HandlerFunc(servePprof)))
+	mux.Handle("/debug/vars", p.wrap(http.HandlerFunc(serveVars)))
+	mux.Handle("/debug/protocol", p.wrap(http.HandlerFunc(serveProtocol)))
+	go http.Serve(l, mux)
+	p.addr = l.Addr().String()
+	log.Infof("profiling listen %s", p.addr)
+	return nil
+}
+
+// 开启或关闭性能分析接口，首次开启时监听地址，关闭后不再响应请求
+func EnableProfiling(enable bool, addr string) error {
+	p := defaultProfiler
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if enable && p.token == "" {
+		return errNoProfilingToken
+	}
+	if enable && !p.serving {
+		if addr == "" {
+			addr = p.addr
+		}
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		if err := p.listen(addr); err != nil {
+			return err
+		}
+		p.serving = true
+	}
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&p.enable, v)
+	return nil
+}
+
+func funcSetProfiling(ctx *Context, data interface{}) {
+	args := data.(*profilingArgs)
+	if err := EnableProfiling(args.Enable, args.Addr); err != nil {
+		log.Errorf("set profiling %v", err)
+		return
+	}
+	log.Infof("set profiling %v", args.Enable)
+}
+
+// 采样时长，默认def秒
+func profileSeconds(r *http.Request, def int) time.Duration {
+	sec, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// 与net/http/pprof的路径及参数一致，go tool pprof可直接使用
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprintln(w, "-\tprofile\n-\ttrace\n-\tcmdline")
+	case "cmdline":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+	case "profile":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(profileSeconds(r, 30))
+		pprof.StopCPUProfile()
+	case "trace":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := trace.Start(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(profileSeconds(r, 1))
+		trace.Stop()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		debug, _ := strconv.Atoi(r.FormValue("debug"))
+		if debug == 0 {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		p.WriteTo(w, debug)
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	p := defaultProfiler
+	token := p.token
+	defer func() {
+		EnableProfiling(false, "")
+		p.token = token
+	}()
+
+	p.token = ""
+	if err := EnableProfiling(true, "127.0.0.1:0"); err != errNoProfilingToken {
+		t.Fatal(err)
+	}
+	p.token = "secret"
+	if err := EnableProfiling(true, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path, token string) (int, string) {
+		req, _ := http.NewRequest("GET", "http://"+p.addr+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(buf)
+	}
+	if code, _ := get("/debug/vars", ""); code != http.StatusForbidden {
+		t.Error("no token", code)
+	}
+	if code, body := get("/debug/vars", "secret"); code != http.StatusOK || !strings.Contains(body, "async_tasks") {
+		t.Error("vars", code)
+	}
+	if code, body := get("/debug/pprof/goroutine?debug=1", "secret"); code != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Error("pprof", code)
+	}
+
+	// 默认ServeMux未注册
+	for _, path := range []string{"/debug/vars", "/debug/pprof/"} {
+		w := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Error("default mux", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+// 协议描述，按已绑定的客户端消息及参数类型生成，供客户端生成代码及一致性测试
+// 仅包含网关允许转发的消息，即消息ID只包含字母、数字
+// 通过性能分析接口/debug/protocol查询
+//   {"Server":"hall","Messages":[{"Id":"Enter","Type":"EnterArgs"}],"Types":{"EnterArgs":[{"Name":"UId","Type":"int"}]}}
+// 字段类型为int、float、string、bool、bytes、any、[]T、map[K]T或结构体类型名
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	clientMessageRegexp = regexp.MustCompile("^[A-Za-z0-9]+$")
+
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+type ProtocolField struct {
+	Name     string
+	Type     string
+	Optional bool `json:",omitempty"` // omitempty
+}
+
+type ProtocolMessage struct {
+	Id   string
+	Type string // 参数类型，空表示无参数
+}
+
+type Protocol struct {
+	Server   string `json:",omitempty"`
+	Gateway  bool   `json:",omitempty"` // 网关本地处理的消息，客户端发送时不加服务名
+	Messages []ProtocolMessage
+	Types    map[string][]ProtocolField
+}
+
+func localServiceConfig() *ServiceConfig {
+	cm := defaultClientManage
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if client, ok := cm.clients[ServerRouter]; ok {
+		if reg, ok := client.reg.(*ServiceConfig); ok {
+			return reg
+		}
+	}
+	return nil
+}
+
+// 当前进程的客户端协议
+func GetProtocol() *Protocol {
+	p := &Protocol{Types: make(map[string][]ProtocolField)}
+	if reg := localServiceConfig(); reg != nil {
+		p.Server = reg.ServerName
+		p.Gateway = reg.ServerType == "gateway"
+	}
+
+	s := defaultCmdSet
+	s.mu.RLock()
+	entries := make([]*cmdEntry, 0, len(s.e))
+	for name, e := range s.e {
+		if clientMessageRegexp.MatchString(name) {
+			entries = append(entries, e)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	seen := make(map[string]reflect.Type)
+	for _, e := range entries {
+		msg := ProtocolMessage{Id: e.name}
+		if e.type_ != nil {
+			msg.Type = p.typeName(e.type_, seen)
+		}
+		p.Messages = append(p.Messages, msg)
+	}
+	return p
+}
+
+func (p *Protocol) typeName(t reflect.Type, seen map[string]reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == rawMessageType:
+		return "any"
+	case t == timeType:
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "[]" + p.typeName(t.Elem(), seen)
+	case reflect.Map:
+		return "map[" + p.typeName(t.Key(), seen) + "]" + p.typeName(t.Elem(), seen)
+	case reflect.Struct:
+		return p.structName(t, seen)
+	}
+	return "any"
+}
+
+// 结构体类型名，匿名或不同包的同名类型加序号区分
+func (p *Protocol) structName(t reflect.Type, seen map[string]reflect.Type) string {
+	base := t.Name()
+	if base == "" {
+		base = "object"
+	}
+	name := base
+	for k := 1; seen[name] != nil && seen[name] != t; k++ {
+		name = base + strconv.Itoa(k)
+	}
+	if seen[name] == t {
+		return name
+	}
+	seen[name] = t
+	p.Types[name] = nil // 防止递归类型
+	p.Types[name] = p.fields(t, seen)
+	return name
+}
+
+// 与encoding/json的规则一致，匿名结构体字段展开
+func (p *Protocol) fields(t reflect.Type, seen map[string]reflect.Type) []ProtocolField {
+	var fields []ProtocolField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if n := strings.IndexByte(tag, ','); n >= 0 {
+			name, opts = tag[:n], tag[n+1:]
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, p.fields(ft, seen)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue // 未导出
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, ProtocolField{
+			Name:     name,
+			Type:     p.typeName(f.Type, seen),
+			Optional: strings.Contains(opts, "omitempty"),
+		})
+	}
+	return fields
+}
+
+func serveProtocol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetProtocol())
+}
@@ -0,0 +1,151 @@
+package cmd
+
+// 重要推送确认，如邮件、奖励
+// 服务调用Session.PushReliable推送，网关以Push消息下发，客户端处理后回复PushAck
+//   客户端收到 Push {"PushId":"xx","Id":"Mail","Data":{...}}
+//   客户端回复 PushAck {"PushId":"xx"}
+// 未确认的推送暂存在网关，会话断线重连后重新下发。每个会话暂存数量有上限，
+// 超出时丢弃最早的推送。服务通过GetPushStatus查询推送状态
+
+import (
+	"encoding/json"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/util"
+	"sync"
+)
+
+const (
+	PushUnknown   = iota // 未记录或已淘汰
+	PushPending          // 待客户端确认
+	PushDelivered        // 客户端已确认
+	PushDropped          // 会话关闭或超出暂存上限
+)
+
+var (
+	maxPendingPushes = config.Int("PushAck.MaxPending", 64)    // 网关单个会话暂存上限
+	maxPushStatus    = config.Int("PushAck.MaxStatus", 100000) // 服务记录的推送状态上限
+)
+
+type pushArgs struct {
+	PushId string
+	Id     string          `json:",omitempty"`
+	Data   json.RawMessage `json:",omitempty"`
+	Status int             `json:",omitempty"`
+}
+
+type pendingPush struct {
+	args *pushArgs
+	from Conn // 推送来源的服务
+}
+
+// 服务记录的推送状态，按推送先后淘汰
+type pushStatusTable struct {
+	status map[string]int
+	order  []string
+	mu     sync.Mutex
+}
+
+var defaultPushStatus = &pushStatusTable{status: make(map[string]int)}
+
+func (t *pushStatusTable) add(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status[id] = PushPending
+	t.order = append(t.order, id)
+	for len(t.order) > 0 && len(t.order) > maxPushStatus {
+		delete(t.status, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+func (t *pushStatusTable) update(id string, status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.status[id]; ok {
+		t.status[id] = status
+	}
+}
+
+func (t *pushStatusTable) get(id string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status[id]
+}
+
+// 查询推送状态
+func GetPushStatus(pushId string) int {
+	return defaultPushStatus.get(pushId)
+}
+
+// 经网关向客户端推送需确认的消息，返回推送ID
+func (ss *Session) PushReliable(name string, i interface{}) (string, error) {
+	buf, err := marshalJSON(i)
+	if err != nil {
+		return "", err
+	}
+	args := &pushArgs{PushId: util.GUID(), Id: name, Data: buf}
+	defaultPushStatus.add(args.PushId)
+	ss.WriteJSON("FUNC_Push", args)
+	return args.PushId, nil
+}
+
+// 网关暂存并下发推送，仅在主循环中访问会话的暂存推送
+func funcPush(ctx *Context, data interface{}) {
+	args := data.(*pushArgs)
+	ss := GetSession(ctx.Ssid)
+	if ss == nil {
+		ctx.Out.WriteJSON("FUNC_PushAck", &pushArgs{PushId: args.PushId, Status: PushDropped})
+		return
+	}
+	ss.pushes = append(ss.pushes, &pendingPush{args: args, from: ctx.Out})
+	for len(ss.pushes) > maxPendingPushes {
+		ss.pushes[0].notify(PushDropped)
+		ss.pushes = ss.pushes[1:]
+	}
+	ss.Out.WriteJSON("Push", args)
+}
+
+// 客户端确认
+func funcClientPushAck(ctx *Context, data interface{}) {
+	args := data.(*pushArgs)
+	ss := GetSession(ctx.Ssid)
+	if ss == nil || !ctx.isGateway {
+		return
+	}
+	for k, push := range ss.pushes {
+		if push.args.PushId == args.PushId {
+			push.notify(PushDelivered)
+			ss.pushes = append(ss.pushes[:k], ss.pushes[k+1:]...)
+			break
+		}
+	}
+}
+
+// 服务收到网关通知
+func funcPushAck(ctx *Context, data interface{}) {
+	args := data.(*pushArgs)
+	status := args.Status
+	if status == 0 {
+		status = PushDelivered
+	}
+	defaultPushStatus.update(args.PushId, status)
+}
+
+func (push *pendingPush) notify(status int) {
+	push.from.WriteJSON("FUNC_PushAck", &pushArgs{PushId: push.args.PushId, Status: status})
+}
+
+// 重连后重新下发未确认的推送
+func (ss *Session) redeliverPushes() {
+	for _, push := range ss.pushes {
+		ss.Out.WriteJSON("Push", push.args)
+	}
+}
+
+// 会话关闭，未确认的推送作废
+func (ss *Session) dropPushes() {
+	for _, push := range ss.pushes {
+		push.notify(PushDropped)
+	}
+	ss.pushes = nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+// 读超时及异常数据隔离
+// 客户端tcp连接（External）在StrikeWindow（默认1m）内发送异常数据（帧格式错误、解码或校验失败）
+// 达到MaxStrikes次后，其IP在QuarantineTime（默认10m）内拒绝连接。MaxStrikes默认0不开启
+// 内部连接及websocket连接不计数，websocket的远程地址可能为代理或NAT地址，隔离会影响其后的全部客户端
+// 读超时：AuthTimeout新连接等待校验包（默认5s），ReadTimeout等待后续数据（默认60s）
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errInvalidData  = errors.New("invalid data")
+	errQuarantined  = errors.New("ip is quarantined")
+	authReadTimeout = 5 * time.Second
+	readTimeout     = pongWait
+)
+
+type strikeRecord struct {
+	strikes int
+	start   time.Time // 统计窗口开始时间
+	until   time.Time // 隔离结束时间
+}
+
+type quarantine struct {
+	maxStrikes int
+	window     time.Duration
+	duration   time.Duration
+
+	records map[string]*strikeRecord
+	mu      sync.Mutex
+}
+
+type QuarantineStats struct {
+	Strikes     int64 // 累计异常次数
+	Quarantined int64 // 累计隔离次数
+	Current     int   // 隔离中的IP数量
+}
+
+var (
+	defaultQuarantine = &quarantine{records: make(map[string]*strikeRecord)}
+	strikeCounter     int64
+	quarantineCounter int64
+)
+
+func init() {
+	authReadTimeout = config.Duration("AuthTimeout", authReadTimeout)
+	readTimeout = config.Duration("ReadTimeout", readTimeout)
+
+	q := defaultQuarantine
+	q.maxStrikes = config.Int("MaxStrikes", 0)
+	q.window = config.Duration("StrikeWindow", time.Minute)
+	q.duration = config.Duration("QuarantineTime", 10*time.Minute)
+	if q.maxStrikes > 0 {
+		AddAdmissionHook(q)
+		go q.purgeLoop()
+	}
+}
+
+func (q *quarantine) Admit(ip net.IP) error {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r, ok := q.records[ip.String()]; ok && now.Before(r.until) {
+		return errQuarantined
+	}
+	return nil
+}
+
+// 记录异常数据，达到上限时隔离
+func (q *quarantine) Strike(addr string) {
+	if q.maxStrikes <= 0 {
+		return
+	}
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		ip = addr
+	}
+	atomic.AddInt64(&strikeCounter, 1)
+
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, ok := q.records[ip]
+	if !ok {
+		r = &strikeRecord{start: now}
+		q.records[ip] = r
+	} else if now.Sub(r.start) > q.window {
+		// 重新计数，隔离中的IP保留隔离期限
+		r.strikes, r.start = 0, now
+	}
+	if r.strikes++; r.strikes >= q.maxStrikes && now.After(r.until) {
+		r.until = now.Add(q.duration)
+		atomic.AddInt64(&quarantineCounter, 1)
+		log.Warnf("quarantine %s %v after %d strikes", ip, q.duration, r.strikes)
+	}
+}
+
+// 定时清理过期的记录
+func (q *quarantine) purgeLoop() {
+	ticker := time.NewTicker(q.window)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		q.purge(now)
+	}
+}
+
+func (q *quarantine) purge(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ip, r := range q.records {
+		if now.Sub(r.start) > q.window && now.After(r.until) {
+			delete(q.records, ip)
+		}
+	}
+}
+
+func GetQuarantineStats() *QuarantineStats {
+	q := defaultQuarantine
+	now := time.Now()
+	q.mu.Lock()
+	current := 0
+	for _, r := range q.records {
+		if now.Before(r.until) {
+			current++
+		}
+	}
+	q.mu.Unlock()
+	return &QuarantineStats{
+		Strikes:     atomic.LoadInt64(&strikeCounter),
+		Quarantined: atomic.LoadInt64(&quarantineCounter),
+		Current:     current,
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQuarantine(t *testing.T) {
+	// 默认不开启
+	defaultQuarantine.Strike("10.0.0.1:1000")
+	if len(defaultQuarantine.records) != 0 {
+		t.Error("quarantine enabled by default")
+	}
+
+	q := &quarantine{maxStrikes: 2, window: time.Minute, duration: 10 * time.Minute, records: make(map[string]*strikeRecord)}
+	ip := net.ParseIP("10.0.0.2")
+	q.Strike("10.0.0.2:1000")
+	if err := q.Admit(ip); err != nil {
+		t.Error(err)
+	}
+	q.Strike("10.0.0.2:1001")
+	if err := q.Admit(ip); err != errQuarantined {
+		t.Error("not quarantined", err)
+	}
+	if err := q.Admit(net.ParseIP("10.0.0.3")); err != nil {
+		t.Error(err)
+	}
+
+	q.purge(time.Now().Add(5 * time.Minute))
+	if len(q.records) != 1 {
+		t.Error("purged during quarantine")
+	}
+	q.purge(time.Now().Add(11 * time.Minute))
+	if len(q.records) != 0 || q.Admit(ip) != nil {
+		t.Error("not purged")
+	}
+}
+
+func TestQuarantineKeepUntil(t *testing.T) {
+	q := &quarantine{maxStrikes: 2, window: time.Minute, duration: 10 * time.Minute, records: make(map[string]*strikeRecord)}
+	ip := net.ParseIP("10.0.0.4")
+	q.Strike("10.0.0.4:1000")
+	q.Strike("10.0.0.4:1000")
+	if err := q.Admit(ip); err != errQuarantined {
+		t.Fatal("not quarantined", err)
+	}
+
+	// 计数窗口过期后的异常不解除隔离
+	r := q.records["10.0.0.4"]
+	until := r.until
+	r.start = r.start.Add(-2 * time.Minute)
+	q.Strike("10.0.0.4:1001")
+	if r.strikes != 1 || !r.until.Equal(until) {
+		t.Errorf("strikes %d until %v %v", r.strikes, r.until, until)
+	}
+	if err := q.Admit(ip); err != errQuarantined {
+		t.Error("quarantine lifted", err)
+	}
+}
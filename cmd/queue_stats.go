@@ -0,0 +1,125 @@
+package cmd
+
+// 主循环消息队列的长度及排队耗时，队列使用率超过阈值时告警
+//   <MessageQueue Alarm="0.8" AlarmInterval="10s"/>
+// 通过DispatchQueueStats或/debug/vars中的message_queue查询，SetQueueAlarm设置告警处理，默认打印日志
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const queueWaitSamples = 1024
+
+type MessageQueueOptions struct {
+	Alarm         float64       `default:"0.8"` // 使用率阈值，0关闭告警
+	AlarmInterval time.Duration `default:"10s"` // 告警的最小间隔
+}
+
+type DispatchStats struct {
+	Depth      int
+	Capacity   int
+	Saturation float64       // 使用率，0~1
+	WaitP50    time.Duration // 最近的消息排队耗时
+	WaitP90    time.Duration
+	WaitP99    time.Duration
+	WaitMax    time.Duration
+}
+
+type QueueAlarm func(stats *DispatchStats)
+
+type queueWaitRing struct {
+	samples [queueWaitSamples]time.Duration
+	n       int
+	mu      sync.Mutex
+}
+
+var (
+	queueOpts      MessageQueueOptions
+	queueAlarm     atomic.Value
+	lastQueueAlarm int64 // 纳秒
+	queueWaits     queueWaitRing
+)
+
+func init() {
+	if err := config.Unmarshal("MessageQueue", &queueOpts); err != nil {
+		log.Errorf("load message queue %v", err)
+	}
+	SetQueueAlarm(func(stats *DispatchStats) {
+		log.Warnf("message queue saturation %.2f depth %d/%d wait p99 %v",
+			stats.Saturation, stats.Depth, stats.Capacity, stats.WaitP99)
+	})
+	PublishVar("message_queue", func() interface{} {
+		return DispatchQueueStats()
+	})
+}
+
+func SetQueueAlarm(h QueueAlarm) {
+	queueAlarm.Store(h)
+}
+
+func (r *queueWaitRing) add(d time.Duration) {
+	r.mu.Lock()
+	r.samples[r.n%queueWaitSamples] = d
+	r.n++
+	r.mu.Unlock()
+}
+
+func (r *queueWaitRing) sorted() []time.Duration {
+	r.mu.Lock()
+	n := r.n
+	if n > queueWaitSamples {
+		n = queueWaitSamples
+	}
+	a := make([]time.Duration, n)
+	copy(a, r.samples[:n])
+	r.mu.Unlock()
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	return a
+}
+
+func (h *SafeQueue) Len() int {
+	return len(h.q)
+}
+
+func (h *SafeQueue) Cap() int {
+	return cap(h.q)
+}
+
+// 主循环队列的统计
+func DispatchQueueStats() *DispatchStats {
+	q := GetMessageQueue()
+	stats := &DispatchStats{Depth: q.Len(), Capacity: q.Cap()}
+	if stats.Capacity > 0 {
+		stats.Saturation = float64(stats.Depth) / float64(stats.Capacity)
+	}
+	a := queueWaits.sorted()
+	if n := len(a); n > 0 {
+		at := func(p int) time.Duration { return a[(n-1)*p/100] }
+		stats.WaitP50, stats.WaitP90, stats.WaitP99 = at(50), at(90), at(99)
+		stats.WaitMax = a[n-1]
+	}
+	return stats
+}
+
+// 入队后检查使用率
+func checkQueueSaturation(q *SafeQueue) {
+	if queueOpts.Alarm <= 0 || q.Cap() == 0 {
+		return
+	}
+	if float64(q.Len()) < queueOpts.Alarm*float64(q.Cap()) {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastQueueAlarm)
+	if now-last < int64(queueOpts.AlarmInterval) || !atomic.CompareAndSwapInt64(&lastQueueAlarm, last, now) {
+		return
+	}
+	if h, ok := queueAlarm.Load().(QueueAlarm); ok && h != nil {
+		go h(DispatchQueueStats())
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+// 服务注册信息订阅，用于中心服务等需要掌握全部服务的场景
+// 订阅后路由先推送全部已连接的服务，之后推送服务的新增及断开
+// 与路由重连后自动重新订阅，路由重新推送全部服务
+//   cmd.WatchRegistry(func(ev *cmd.RegistryEvent) { ... })
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	RegistrySnapshot = "snapshot" // 全部服务，替换本地记录
+	RegistryAdd      = "add"      // 新增或重新注册
+	RegistryRemove   = "remove"   // 断开连接或过期
+)
+
+type ServerInfo struct {
+	Name     string
+	Addr     string
+	Type     string
+	Version  string
+	Instance string
+	App      string
+	Data     json.RawMessage
+}
+
+type RegistryEvent struct {
+	Op      string
+	Servers []ServerInfo
+}
+
+type RegistryHandler func(ev *RegistryEvent)
+
+var (
+	registryHandlers []RegistryHandler
+	registryMu       sync.RWMutex
+)
+
+// 订阅服务注册信息，回调在主循环中执行
+func WatchRegistry(h RegistryHandler) {
+	registryMu.Lock()
+	registryHandlers = append(registryHandlers, h)
+	first := len(registryHandlers) == 1
+	registryMu.Unlock()
+	if first {
+		defaultClientManage.Route3(ServerRouter, "C2S_WatchRegistry", struct{}{})
+	}
+}
+
+// 与路由重连后重新订阅
+func (cm *clientManage) rewatchRegistry() {
+	registryMu.RLock()
+	n := len(registryHandlers)
+	registryMu.RUnlock()
+	if n > 0 {
+		cm.Route3(ServerRouter, "C2S_WatchRegistry", struct{}{})
+	}
+}
+
+func funcRegistryEvent(ctx *Context, data interface{}) {
+	ev := data.(*RegistryEvent)
+	registryMu.RLock()
+	handlers := registryHandlers
+	registryMu.RUnlock()
+	for _, h := range handlers {
+		h(ev)
+	}
+}
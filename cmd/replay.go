@@ -0,0 +1,60 @@
+package cmd
+
+// 客户端消息序号去重
+// 滑动窗口内重复或早于窗口的序号视为重放，保护购买、领奖等非幂等的消息处理
+
+import (
+	"sync"
+)
+
+const seqWindowSize = 64
+
+type seqWindow struct {
+	max    int64  // 已收到的最大序号
+	bitmap uint64 // 第i位表示序号max-i已收到
+	mu     sync.Mutex
+}
+
+func (w *seqWindow) Accept(seq int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if seq <= 0 {
+		return false
+	}
+	if seq > w.max {
+		if diff := seq - w.max; diff < seqWindowSize {
+			w.bitmap = w.bitmap<<uint(diff) | 1
+		} else {
+			w.bitmap = 1
+		}
+		w.max = seq
+		return true
+	}
+
+	diff := w.max - seq
+	if diff >= seqWindowSize {
+		return false // 早于窗口
+	}
+	mask := uint64(1) << uint(diff)
+	if w.bitmap&mask != 0 {
+		return false // 重复
+	}
+	w.bitmap |= mask
+	return true
+}
+
+// 是否收到过序号
+func (w *seqWindow) started() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.max > 0
+}
+
+// 未携带序号的消息不校验，会话携带过序号后拒绝未携带序号的消息，防止去掉序号重放
+func (ss *Session) acceptSeq(seq int64) bool {
+	if seq == 0 {
+		return !ss.seqs.started()
+	}
+	return ss.seqs.Accept(seq)
+}
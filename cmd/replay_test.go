@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestSeqWindow(t *testing.T) {
+	var w seqWindow
+	samples := []struct {
+		seq int64
+		ok  bool
+	}{
+		{1, true}, {3, true}, {2, true}, {3, false}, {0, false}, {-1, false},
+		{100, true}, {36, false}, {37, true}, {37, false}, {99, true},
+	}
+	for _, sample := range samples {
+		if w.Accept(sample.seq) != sample.ok {
+			t.Error(sample)
+		}
+	}
+}
+
+func TestSessionAcceptSeq(t *testing.T) {
+	ss := &Session{}
+	// 未使用序号的旧客户端
+	if !ss.acceptSeq(0) || !ss.acceptSeq(0) {
+		t.Fatal("no seq")
+	}
+	if !ss.acceptSeq(1) || ss.acceptSeq(1) {
+		t.Fatal("replay")
+	}
+	// 去掉序号重放
+	if ss.acceptSeq(0) {
+		t.Fatal("drop seq")
+	}
+}
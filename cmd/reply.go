@@ -0,0 +1,61 @@
+package cmd
+
+// 统一的回复格式
+// C2S_XXX的回复消息为S2C_XXX，其他消息回复同名消息
+
+import (
+	"strings"
+)
+
+type ReplyEnvelope struct {
+	Code int         // 0表示成功
+	Msg  string      `json:",omitempty"`
+	Data interface{} `json:",omitempty"`
+}
+
+func responseName(name string) string {
+	if strings.HasPrefix(name, "C2S_") {
+		return "S2C_" + name[len("C2S_"):]
+	}
+	return name
+}
+
+// 回复发送方。网关转发的会话消息经网关FUNC_Route回复客户端
+func (ctx *Context) WriteJSON(name string, i interface{}) error {
+	logResponse(ctx, name, i)
+	compress := ctx.compressResponse()
+	if ctx.batch != nil {
+		ctx.batch.Messages = append(ctx.batch.Messages, routeItem{Id: name, Data: i, Compress: compress})
+		return nil
+	}
+	return ctx.write(name, i, compress)
+}
+
+func (ctx *Context) write(name string, i interface{}, compress bool) error {
+	if ctx.Ssid == "" || ctx.isGateway {
+		if compress {
+			i = CompressBody(ctx.Out, i)
+		}
+		return ctx.Out.WriteJSON(name, i)
+	}
+	ss := &Session{Id: ctx.Ssid, Out: ctx.Out}
+	args := map[string]interface{}{"Id": name, "Data": i}
+	// 由网关按客户端是否支持压缩
+	if compress {
+		args["Compress"] = true
+	}
+	ss.WriteJSON("FUNC_Route", args)
+	return nil
+}
+
+func (ctx *Context) Ok(body interface{}) error {
+	return ctx.WriteJSON(responseName(ctx.MsgId), &ReplyEnvelope{Data: body})
+}
+
+func (ctx *Context) Error(code int, msg string) error {
+	return ctx.WriteJSON(responseName(ctx.MsgId), &ReplyEnvelope{Code: code, Msg: msg})
+}
+
+func (ctx *Context) Reply(name string, body interface{}) error {
+	return ctx.WriteJSON(name, &ReplyEnvelope{Data: body})
+}
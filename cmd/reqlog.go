@@ -0,0 +1,89 @@
+package cmd
+
+// 按消息ID采样打印请求及回复，无需重新部署即可排查指定流程
+//   cmd.Bind(C2S_Login, (*loginArgs)(nil), cmd.WithRequestLog(0.1))
+// 运行时通过ADMIN_SetRequestLog {Id, Sample, MaxSize} 调整，Sample为0时关闭
+// 数据超过MaxSize时截断，默认RequestLogMaxSize=1024
+
+import (
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"math/rand"
+	"sync"
+)
+
+var defaultRequestLogMaxSize = config.Int("RequestLogMaxSize", 1024)
+
+type RequestLogRule struct {
+	Id      string  // 消息ID
+	Sample  float64 // 采样率0~1
+	MaxSize int     // 截断长度，0使用默认值
+}
+
+var (
+	requestLogRules = make(map[string]RequestLogRule) // 运行时设置，优先于绑定选项
+	requestLogMu    sync.RWMutex
+)
+
+// 绑定时开启请求日志
+func WithRequestLog(sample float64) BindOption {
+	return func(e *cmdEntry) {
+		e.logSample = sample
+	}
+}
+
+func SetRequestLog(rule RequestLogRule) {
+	requestLogMu.Lock()
+	defer requestLogMu.Unlock()
+	requestLogRules[rule.Id] = rule
+}
+
+func funcSetRequestLog(ctx *Context, data interface{}) {
+	rule := data.(*RequestLogRule)
+	log.Infof("set request log %s sample %v", rule.Id, rule.Sample)
+	SetRequestLog(*rule)
+}
+
+// 本次请求是否打印，返回截断长度
+func sampleRequestLog(e *cmdEntry) (int, bool) {
+	requestLogMu.RLock()
+	rule, ok := requestLogRules[e.name]
+	requestLogMu.RUnlock()
+	if !ok {
+		rule = RequestLogRule{Sample: e.logSample}
+	}
+	if rule.Sample <= 0 || (rule.Sample < 1 && rand.Float64() >= rule.Sample) {
+		return 0, false
+	}
+	if rule.MaxSize <= 0 {
+		rule.MaxSize = defaultRequestLogMaxSize
+	}
+	return rule.MaxSize, true
+}
+
+func truncateLog(data []byte, n int) string {
+	if len(data) > n {
+		return string(data[:n]) + "..."
+	}
+	return string(data)
+}
+
+func logRequest(ctx *Context, e *cmdEntry, data []byte) {
+	n, ok := sampleRequestLog(e)
+	if !ok {
+		return
+	}
+	ctx.logSize = n
+	log.Infof("request %s ssid %s from %s: %s", e.name, ctx.Ssid, ctx.Out.RemoteAddr(), truncateLog(data, n))
+}
+
+func logResponse(ctx *Context, name string, i interface{}) {
+	if ctx.logSize <= 0 {
+		return
+	}
+	buf, err := marshalJSON(i)
+	if err != nil {
+		return
+	}
+	log.Infof("response %s ssid %s: %s", name, ctx.Ssid, truncateLog(buf, ctx.logSize))
+}
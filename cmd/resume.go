@@ -0,0 +1,169 @@
+package cmd
+
+// 断线重连
+// 客户端连接断开后会话保留一段时间，期间携带令牌重连（?resume=token）可恢复原会话，
+// 无需重新登录，服务通过OnResume得到通知。超时未重连时按正常断开处理
+// 宽限时间通过配置ReconnectGrace指定，默认0不保留会话
+// 重连后消息序号延续，令牌每次重连后更换，未收到的消息可补发，见outbox.go
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type sessionTokenArgs struct {
+	Ssid  string
+	Token string
+	Grace int // 宽限时间，秒
+}
+
+type resumeState struct {
+	token    string
+	owner    Conn // 当前绑定的连接
+	detached bool
+	gen      int
+}
+
+var (
+	reconnectGrace int64                     // 纳秒
+	resumeTokens   = make(map[string]string) // 令牌 -> 会话ID
+	resumeMu       sync.Mutex
+)
+
+func init() {
+	SetReconnectGrace(config.Duration("ReconnectGrace", 0))
+}
+
+func SetReconnectGrace(d time.Duration) {
+	atomic.StoreInt64(&reconnectGrace, int64(d))
+}
+
+func getReconnectGrace() time.Duration {
+	return time.Duration(atomic.LoadInt64(&reconnectGrace))
+}
+
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// 下发新令牌，旧令牌失效
+func (ss *Session) issueToken(c Conn) {
+	grace := getReconnectGrace()
+	if grace <= 0 {
+		return
+	}
+	token := newResumeToken()
+	if token == "" {
+		return
+	}
+
+	resumeMu.Lock()
+	if old := ss.resume.token; old != "" {
+		delete(resumeTokens, old)
+	}
+	ss.resume.token = token
+	ss.resume.owner = c
+	resumeTokens[token] = ss.Id
+	resumeMu.Unlock()
+
+	// 不携带序号，重连时先于补发的消息下发
+	buf, err := defaultRawParser.Encode(&Package{Id: "SessionToken", Body: &sessionTokenArgs{Ssid: ss.Id, Token: token, Grace: int(grace / time.Second)}})
+	if err == nil {
+		c.Write(buf)
+	}
+}
+
+// 新连接接管令牌对应的会话，返回未断开的原连接
+func claimSession(token string, c Conn) (*Session, Conn) {
+	if token == "" {
+		return nil, nil
+	}
+
+	resumeMu.Lock()
+	ss := GetSession(resumeTokens[token])
+	if ss == nil || ss.resume.token != token {
+		resumeMu.Unlock()
+		return nil, nil
+	}
+	old, detached := ss.resume.owner, ss.resume.detached
+	ss.resume.owner = c
+	ss.resume.detached = false
+	ss.resume.gen++
+	resumeMu.Unlock()
+
+	if detached {
+		old = nil
+	}
+	return ss, old
+}
+
+// 连接断开时保留会话，返回true时不再按断开处理
+func (ss *Session) detach(c Conn, ctx *Context) bool {
+	grace := getReconnectGrace()
+
+	resumeMu.Lock()
+	defer resumeMu.Unlock()
+	if ss.resume.owner != c {
+		return ss.resume.owner != nil // 会话已被新连接接管
+	}
+	if grace <= 0 || ss.resume.token == "" {
+		return false
+	}
+	ss.resume.detached = true
+	ss.resume.gen++
+	gen := ss.resume.gen
+	time.AfterFunc(grace, func() {
+		Enqueue(ctx, func(ctx *Context, _ interface{}) { ss.expire(ctx, gen) }, nil)
+	})
+	return true
+}
+
+// 宽限时间内未重连
+func (ss *Session) expire(ctx *Context, gen int) {
+	resumeMu.Lock()
+	if !ss.resume.detached || ss.resume.gen != gen {
+		resumeMu.Unlock()
+		return
+	}
+	delete(resumeTokens, ss.resume.token)
+	ss.resume = resumeState{}
+	resumeMu.Unlock()
+
+	log.Debugf("session %s reconnect timeout", ss.Id)
+	closeSession(ctx)
+}
+
+func closeSession(ctx *Context) {
+	if ss := GetSession(ctx.Ssid); ss != nil {
+		Enqueue(ctx, func(*Context, interface{}) { ss.dropPushes() }, nil)
+	}
+	defaultCmdSet.HandleEvent(ctx, "CMD_Close")
+	defaultCmdSet.HandleEvent(ctx, "FUNC_Close")
+	fireDisconnect(ctx)
+	removeSession(ctx.Ssid)
+}
+
+// 在主循环中绑定新连接并关闭原连接
+// ack为客户端已收到的最大序号，补发之后的消息
+func (ss *Session) rebind(c, old Conn, isGateway bool, ack int64) {
+	ctx := &Context{Ssid: ss.Id, Out: c, isGateway: isGateway}
+	Enqueue(ctx, func(ctx *Context, _ interface{}) {
+		if old != nil {
+			old.Close()
+		}
+		ss.Out = c
+		ss.outbox.resend(c, ack)
+		log.Debugf("session %s resume", ss.Id)
+		ss.redeliverPushes()
+		fireResume(ctx)
+	}, nil)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+// 灰度发布，按比例将会话分配至服务的新版本
+// 路由下发各服务的版本比例，网关在会话首次路由至服务且未指定版本时，按账号UId（未登录时为会话ID）的稳定哈希选择版本，
+// 会话之后保持该版本。同一账号始终落在同一区间，调高比例时已分配新版本的账号不变；比例清零即回滚，新会话使用默认版本
+// 登录等服务通过FUNC_SetServerVersion指定的版本优先
+//   huskyctl rollout hall v2=10    10%的账号使用v2，其余使用默认版本
+//   huskyctl rollout hall          取消
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type RolloutRule struct {
+	AppId      string         `json:",omitempty"`
+	ServerName string         // 服务名
+	Weights    map[string]int `json:",omitempty"` // 版本 -> 百分比，剩余比例使用默认版本，为空时取消
+}
+
+var (
+	rollouts  = make(map[string]*RolloutRule)
+	rolloutMu sync.RWMutex
+)
+
+func rolloutKey(app, serverName string) string {
+	return clientKey(app, serverName, "")
+}
+
+// 网关收到路由下发的版本比例
+func SetRollout(rule *RolloutRule) {
+	rolloutMu.Lock()
+	defer rolloutMu.Unlock()
+	if len(rule.Weights) == 0 {
+		delete(rollouts, rolloutKey(rule.AppId, rule.ServerName))
+		return
+	}
+	rollouts[rolloutKey(rule.AppId, rule.ServerName)] = rule
+}
+
+// 各版本分别按账号及版本的哈希落在[0,100)的位置判断，调整某一版本的比例时不影响已分配其他版本（排序靠后的版本除外）的账号
+// 版本按名称排序依次判断保证各网关一致，排序靠后的版本实际比例为剩余账号中的比例
+func rolloutVersion(app, serverName, key string) string {
+	rolloutMu.RLock()
+	rule := rollouts[rolloutKey(app, serverName)]
+	rolloutMu.RUnlock()
+	if rule == nil {
+		return ""
+	}
+
+	versions := make([]string, 0, len(rule.Weights))
+	for version := range rule.Weights {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		n := int(crc32.ChecksumIEEE([]byte(serverName+"/"+key+"/"+version)) % 100)
+		if n < rule.Weights[version] {
+			return version
+		}
+	}
+	return ""
+}
+
+// 灰度使用的账号，未登录时使用会话ID
+func (ss *Session) rolloutKey() string {
+	if claims := ss.Claims(); claims != nil && claims.UId != 0 {
+		return strconv.Itoa(claims.UId)
+	}
+	if uid, ok := ss.Get(SessionKeyUId).(int); ok && uid != 0 {
+		return strconv.Itoa(uid)
+	}
+	return ss.Id
+}
+
+// 会话首次路由至服务时分配灰度版本
+func (ss *Session) assignRollout(app, serverName string) {
+	if ss.GetServerVersion(serverName) != "" {
+		return
+	}
+	if version := rolloutVersion(app, serverName, ss.rolloutKey()); version != "" {
+		ss.SetServerVersion(serverName, version)
+	}
+}
+
+func funcSetRollout(ctx *Context, data interface{}) {
+	SetRollout(data.(*RolloutRule))
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRolloutVersion(t *testing.T) {
+	defer SetRollout(&RolloutRule{ServerName: "hall"})
+
+	const n = 10000
+	SetRollout(&RolloutRule{ServerName: "hall", Weights: map[string]int{"v2": 10}})
+	selected := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		key := strconv.Itoa(i)
+		if rolloutVersion("", "hall", key) == "v2" {
+			selected[key] = true
+		}
+		if rolloutVersion("", "hall", key) != rolloutVersion("", "hall", key) {
+			t.Fatal("unstable version", key)
+		}
+	}
+	if len(selected) < n*8/100 || len(selected) > n*12/100 {
+		t.Error("v2 percent", len(selected))
+	}
+	if v := rolloutVersion("app", "hall", "1"); v != "" {
+		t.Error("other app", v)
+	}
+
+	// 调高比例时已分配的账号不变
+	SetRollout(&RolloutRule{ServerName: "hall", Weights: map[string]int{"v2": 30}})
+	for key := range selected {
+		if rolloutVersion("", "hall", key) != "v2" {
+			t.Fatal("moved after increase", key)
+		}
+	}
+
+	// 会话指定的版本优先，回滚后新会话使用默认版本
+	ss := &Session{Id: "s1"}
+	ss.SetServerVersion("hall", "v1")
+	ss.assignRollout("", "hall")
+	if v := ss.GetServerVersion("hall"); v != "v1" {
+		t.Error("explicit version", v)
+	}
+	SetRollout(&RolloutRule{ServerName: "hall"})
+	for key := range selected {
+		ss := &Session{Id: key}
+		ss.assignRollout("", "hall")
+		if v := ss.GetServerVersion("hall"); v != "" {
+			t.Fatal("rollback", key, v)
+		}
+	}
+}
+
+func TestRolloutMultiVersion(t *testing.T) {
+	defer SetRollout(&RolloutRule{ServerName: "hall"})
+
+	const n = 10000
+	assign := func(weights map[string]int) map[string]string {
+		SetRollout(&RolloutRule{ServerName: "hall", Weights: weights})
+		m := make(map[string]string)
+		for i := 0; i < n; i++ {
+			key := strconv.Itoa(i)
+			m[key] = rolloutVersion("", "hall", key)
+		}
+		return m
+	}
+	count := func(m map[string]string, version string) int {
+		c := 0
+		for _, v := range m {
+			if v == version {
+				c++
+			}
+		}
+		return c
+	}
+
+	// 排序靠后的版本为剩余账号中的比例
+	before := assign(map[string]int{"v2": 10, "v3": 10})
+	if c := count(before, "v2"); c < n*8/100 || c > n*12/100 {
+		t.Error("v2 percent", c)
+	}
+	if c := count(before, "v3"); c < n*7/100 || c > n*11/100 {
+		t.Error("v3 percent", c)
+	}
+
+	// 调高某一版本的比例时，该版本及其他版本已分配的账号不变，排序靠后的版本除外
+	for _, weights := range []map[string]int{{"v2": 10, "v3": 30}, {"v2": 30, "v3": 10}} {
+		after := assign(weights)
+		for key, v := range before {
+			if v == "v3" && weights["v2"] > 10 {
+				continue // 可能改为排序靠前的版本
+			}
+			if v != "" && after[key] != v {
+				t.Fatal("moved", weights, key, v, after[key])
+			}
+		}
+	}
+}
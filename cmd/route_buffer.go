@@ -0,0 +1,134 @@
+package cmd
+
+// 服务连接断开期间(如重启)路由的消息暂存在内存中，重新连接后按序发送
+// 超过MaxSize的消息及暂存超过TTL的消息丢弃，记为死信。MaxSize为0时不暂存
+//   <RouteBuffer MaxSize="1MB" TTL="10s"/>
+// 累计暂存、过期数量可通过RouteBufferStats或/debug/vars中的route_buffer查询
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errRouteBufferFull = errors.New("route buffer is full")
+
+type RouteBufferOptions struct {
+	MaxSize int64         `default:"1MB"`
+	TTL     time.Duration `default:"10s"`
+}
+
+var (
+	routeBufferOpts      RouteBufferOptions
+	routeBufferedCounter int64
+	routeExpiredCounter  int64
+	routeOverflowCounter int64
+	routeFlushedCounter  int64
+)
+
+func init() {
+	if err := config.Unmarshal("RouteBuffer", &routeBufferOpts); err != nil {
+		log.Errorf("load route buffer %v", err)
+	}
+	PublishVar("route_buffer", func() interface{} {
+		buffered, expired := RouteBufferStats()
+		return map[string]int64{
+			"buffered": buffered,
+			"expired":  expired,
+			"overflow": atomic.LoadInt64(&routeOverflowCounter),
+			"flushed":  atomic.LoadInt64(&routeFlushedCounter),
+		}
+	})
+}
+
+// 累计暂存及过期丢弃的消息数量
+func RouteBufferStats() (buffered, expired int64) {
+	return atomic.LoadInt64(&routeBufferedCounter), atomic.LoadInt64(&routeExpiredCounter)
+}
+
+type routeBufferItem struct {
+	data []byte
+	t    time.Time
+}
+
+type routeBuffer struct {
+	items []routeBufferItem
+	size  int64
+	mu    sync.Mutex
+}
+
+// 移除过期的消息
+func (b *routeBuffer) expire(now time.Time) []routeBufferItem {
+	var n int
+	for n < len(b.items) && now.Sub(b.items[n].t) > routeBufferOpts.TTL {
+		b.size -= int64(len(b.items[n].data))
+		n++
+	}
+	expired := b.items[:n]
+	b.items = b.items[n:]
+	return expired
+}
+
+// 死信处理可能再次路由，在锁外执行
+func (c *Client) dropRouteBuffer(items []routeBufferItem, reason string) {
+	for _, item := range items {
+		HandleDeadLetter(nil, &DeadLetter{ServerName: c.name, Reason: reason, Data: item.data})
+	}
+}
+
+// 连接未建立时暂存消息，已连接时返回false
+func (c *Client) bufferRoute(data []byte) (bool, error) {
+	if routeBufferOpts.MaxSize <= 0 {
+		return false, nil
+	}
+	b := &c.buffered
+	b.mu.Lock()
+	if atomic.LoadInt32(&c.state) == StateConnected {
+		b.mu.Unlock()
+		return false, nil
+	}
+
+	var err error
+	expired := b.expire(time.Now())
+	if b.size+int64(len(data)) > routeBufferOpts.MaxSize {
+		atomic.AddInt64(&routeOverflowCounter, 1)
+		err = errRouteBufferFull
+	} else {
+		b.items = append(b.items, routeBufferItem{data: data, t: time.Now()})
+		b.size += int64(len(data))
+		atomic.AddInt64(&routeBufferedCounter, 1)
+	}
+	b.mu.Unlock()
+
+	atomic.AddInt64(&routeExpiredCounter, int64(len(expired)))
+	c.dropRouteBuffer(expired, "route buffer expired")
+	return true, err
+}
+
+// 连接建立后将暂存的消息写入发送队列，同时标记为已连接
+func (c *Client) flushRouteBuffer() {
+	b := &c.buffered
+	b.mu.Lock()
+	atomic.StoreInt32(&c.state, StateConnected)
+
+	var failed []routeBufferItem
+	expired := b.expire(time.Now())
+	for _, item := range b.items {
+		if err := c.Write(item.data); err != nil {
+			failed = append(failed, item)
+		}
+	}
+	if n := len(b.items); n > 0 {
+		log.Infof("flush %d buffered messages to %s", n, c.key())
+		atomic.AddInt64(&routeFlushedCounter, int64(n-len(failed)))
+	}
+	b.items, b.size = nil, 0
+	b.mu.Unlock()
+
+	atomic.AddInt64(&routeExpiredCounter, int64(len(expired)))
+	c.dropRouteBuffer(expired, "route buffer expired")
+	c.dropRouteBuffer(failed, "route buffer flush failed")
+}
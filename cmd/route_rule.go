@@ -0,0 +1,151 @@
+package cmd
+
+// 消息路由规则，按顺序匹配消息ID，匹配成功后路由至指定服务
+// 仅作用于网关转发的客户端消息及Route，未匹配时沿用server.message格式
+//   <RouteRules>
+//     <Rule><Id>Login</Id><Server>login</Server></Rule>
+//     <Rule><Prefix>Hall</Prefix><Server>hall</Server></Rule>
+//     <Rule><Regexp>^room\.(\w+)$</Regexp><Server>room2</Server><Rewrite>$1</Rewrite></Rule>
+//     <Rule><Prefix>Debug</Prefix><Action>reject</Action></Rule>
+//   </RouteRules>
+// 网关按规则转发客户端消息时不要求服务已由路由注册；reject拒绝消息，local由网关本地处理
+// reject、local仅作用于网关；网关中规则优先于本地绑定的消息。运行时可通过ADMIN_SetRouteRules更新
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	RouteActionRoute  = "route"  // 路由至服务，默认
+	RouteActionReject = "reject" // 拒绝
+	RouteActionLocal  = "local"  // 网关本地处理
+)
+
+var errRejectedMessage = errors.New("message rejected by route rule")
+
+type RouteRule struct {
+	Id      string // 完整匹配
+	Prefix  string // 前缀匹配
+	Regexp  string // 正则匹配
+	Server  string // 目标服务
+	Rewrite string // 改写消息ID，为空时不改写。前缀规则替换前缀，正则规则支持$1
+	Action  string // route、reject、local
+}
+
+type routeRule struct {
+	RouteRule
+	re *regexp.Regexp
+}
+
+type routeRulesConfig struct {
+	Rules []RouteRule `config:"Rule"`
+}
+
+var routeRules atomic.Value
+
+func init() {
+	routeRules.Store([]*routeRule(nil))
+
+	var cfg routeRulesConfig
+	if err := config.Unmarshal("RouteRules", &cfg); err != nil {
+		log.Errorf("load route rules %v", err)
+		return
+	}
+	if err := SetRouteRules(cfg.Rules); err != nil {
+		log.Errorf("load route rules %v", err)
+	}
+}
+
+// 替换全部路由规则
+func SetRouteRules(rules []RouteRule) error {
+	var compiled []*routeRule
+	for _, rule := range rules {
+		r := &routeRule{RouteRule: rule}
+		switch rule.Action {
+		case "":
+			r.Action = RouteActionRoute
+		case RouteActionRoute, RouteActionReject, RouteActionLocal:
+		default:
+			return errors.New("unknown route rule action " + rule.Action)
+		}
+		if r.Action == RouteActionRoute && rule.Server == "" {
+			return errors.New("route rule without server")
+		}
+		if rule.Regexp != "" {
+			re, err := regexp.Compile(rule.Regexp)
+			if err != nil {
+				return err
+			}
+			r.re = re
+		} else if rule.Id == "" && rule.Prefix == "" {
+			return errors.New("route rule without pattern")
+		}
+		compiled = append(compiled, r)
+	}
+	routeRules.Store(compiled)
+	return nil
+}
+
+func (r *routeRule) match(id string) (string, bool) {
+	switch {
+	case r.re != nil:
+		if !r.re.MatchString(id) {
+			return "", false
+		}
+		if r.Rewrite != "" {
+			return r.re.ReplaceAllString(id, r.Rewrite), true
+		}
+	case r.Id != "":
+		if id != r.Id {
+			return "", false
+		}
+		if r.Rewrite != "" {
+			return r.Rewrite, true
+		}
+	default:
+		if !strings.HasPrefix(id, r.Prefix) {
+			return "", false
+		}
+		if r.Rewrite != "" {
+			return r.Rewrite + id[len(r.Prefix):], true
+		}
+	}
+	return id, true
+}
+
+// 网关外的消息忽略reject、local规则
+func matchRouteRules(id string) (string, string, bool) {
+	for _, r := range routeRules.Load().([]*routeRule) {
+		if r.Action != RouteActionRoute {
+			continue
+		}
+		if message, ok := r.match(id); ok {
+			return r.Server, message, true
+		}
+	}
+	return "", "", false
+}
+
+// 网关客户端消息匹配的第一条规则
+func matchGatewayRule(id string) (*routeRule, string) {
+	for _, r := range routeRules.Load().([]*routeRule) {
+		if message, ok := r.match(id); ok {
+			return r, message
+		}
+	}
+	return nil, ""
+}
+
+func funcSetRouteRules(ctx *Context, data interface{}) {
+	args := data.(*routeRulesConfig)
+	if err := SetRouteRules(args.Rules); err != nil {
+		log.Errorf("set route rules %v", err)
+		return
+	}
+	log.Infof("set %d route rules", len(args.Rules))
+}
@@ -0,0 +1,95 @@
+package cmd
+
+// 心跳往返时间
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type rttMeter struct {
+	pingTime int64 // 最近一次PING的时间，纳秒
+	rtt      int64
+}
+
+func (m *rttMeter) ping() {
+	atomic.StoreInt64(&m.pingTime, time.Now().UnixNano())
+}
+
+func (m *rttMeter) pong() {
+	if t := atomic.LoadInt64(&m.pingTime); t > 0 {
+		atomic.StoreInt64(&m.rtt, time.Now().UnixNano()-t)
+	}
+}
+
+// 定时发送心跳，不经过写队列，写队列积压时不会因心跳超时断开
+// 发送失败时调用onError关闭连接
+func (m *rttMeter) keepalive(done <-chan struct{}, writePing func() error, onError func()) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.ping()
+			if err := writePing(); err != nil {
+				onError()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// 最近一次心跳的往返时间，未测量时为0
+func (m *rttMeter) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.rtt))
+}
+
+type rttConn interface {
+	RTT() time.Duration
+}
+
+// 延迟分布，单位毫秒
+type LatencyStats struct {
+	Count int
+	P50   int64
+	P90   int64
+	P99   int64
+}
+
+func percentile(a []int64, p int) int64 {
+	if len(a) == 0 {
+		return 0
+	}
+	n := len(a) * p / 100
+	if n >= len(a) {
+		n = len(a) - 1
+	}
+	return a[n]
+}
+
+// 当前会话的延迟分布
+func (sm *SessionManage) Latency() *LatencyStats {
+	var a []int64
+	for _, ss := range sm.GetList() {
+		if d := ss.RTT(); d > 0 {
+			a = append(a, int64(d/time.Millisecond))
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	return &LatencyStats{
+		Count: len(a),
+		P50:   percentile(a, 50),
+		P90:   percentile(a, 90),
+		P99:   percentile(a, 99),
+	}
+}
+
+func (sm *SessionManage) RTT(id string) time.Duration {
+	if ss := sm.Get(id); ss != nil {
+		return ss.RTT()
+	}
+	return 0
+}
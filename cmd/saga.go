@@ -0,0 +1,260 @@
+package cmd
+
+// 跨服务的多步骤流程(saga)，如大厅扣除货币后游戏发放道具
+// 协调方按序向各服务投递步骤，失败或超时后重试，重试耗尽后按相反顺序投递已完成步骤的补偿
+//   cmd.StartSaga(&cmd.Saga{
+//     Steps: []cmd.SagaStep{
+//       {Server: "hall", Action: "DeductGold", Compensate: "RefundGold", Args: args},
+//       {Server: "game", Action: "GrantItem", Args: args},
+//     },
+//     Done: func(err error) { ... },
+//   })
+// 参与方通过BindSagaStep绑定步骤，返回nil表示成功
+//   cmd.BindSagaStep("DeductGold", func(ctx *cmd.Context, data interface{}) error { ... }, (*Args)(nil))
+// 超时的步骤可能已执行，同样会补偿；步骤及补偿可能重复投递，处理需幂等
+// 协调方及参与方均在主循环中执行
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"reflect"
+	"time"
+)
+
+const (
+	defaultSagaTimeout = 5 * time.Second
+	defaultSagaRetries = 3
+)
+
+var (
+	errSagaTimeout     = errors.New("saga step timeout")
+	errSagaUnknownStep = errors.New("unknown saga step")
+)
+
+var sagaRetryPolicy = &util.RetryPolicy{
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Jitter:       0.2,
+}
+
+type SagaStep struct {
+	Server     string
+	Action     string
+	Compensate string // 补偿消息，为空时不补偿
+	Args       interface{}
+
+	data json.RawMessage
+}
+
+type Saga struct {
+	Id      string
+	Steps   []SagaStep
+	Timeout time.Duration   // 每次投递等待结果的时间，默认5s
+	Retries int             // 失败后的重试次数，默认3
+	Done    func(err error) // 全部成功时err为nil，否则为*SagaError
+
+	step         int
+	attempt      int
+	compensating bool
+	timer        *util.Timer
+	err          *SagaError
+}
+
+type SagaError struct {
+	Step          int    // 失败的步骤
+	Err           string // 失败原因
+	Uncompensated []int  // 补偿失败的步骤
+}
+
+func (e *SagaError) Error() string {
+	if len(e.Uncompensated) > 0 {
+		return fmt.Sprintf("saga step %d: %s, uncompensated steps %v", e.Step, e.Err, e.Uncompensated)
+	}
+	return fmt.Sprintf("saga step %d: %s", e.Step, e.Err)
+}
+
+type sagaStepArgs struct {
+	Saga       string
+	Step       int
+	Compensate bool `json:",omitempty"`
+	Attempt    int
+	Id         string
+	From       string // 协调方所在的服务
+	Data       json.RawMessage
+}
+
+type sagaResultArgs struct {
+	Saga       string
+	Step       int
+	Compensate bool   `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+type SagaStepHandler func(ctx *Context, data interface{}) error
+
+type sagaStepEntry struct {
+	h     SagaStepHandler
+	type_ reflect.Type
+}
+
+var (
+	sagas        = make(map[string]*Saga)
+	sagaHandlers = make(map[string]*sagaStepEntry)
+)
+
+// 绑定参与方的步骤，需在初始化时调用
+func BindSagaStep(name string, h SagaStepHandler, args interface{}) {
+	sagaHandlers[name] = &sagaStepEntry{h: h, type_: reflect.TypeOf(args)}
+}
+
+// 开始执行，需在主循环中调用
+func StartSaga(s *Saga) error {
+	if len(s.Steps) == 0 {
+		return errors.New("empty saga")
+	}
+	if localServerName() == "" {
+		return errors.New("saga requires registered service")
+	}
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		data, err := json.Marshal(step.Args)
+		if err != nil {
+			return err
+		}
+		step.data = data
+	}
+	if s.Id == "" {
+		s.Id = util.GUID()
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultSagaTimeout
+	}
+	if s.Retries <= 0 {
+		s.Retries = defaultSagaRetries
+	}
+	sagas[s.Id] = s
+	s.send()
+	return nil
+}
+
+func (s *Saga) send() {
+	step := s.Steps[s.step]
+	name := step.Action
+	if s.compensating {
+		name = step.Compensate
+	}
+	s.attempt++
+	defaultClientManage.Route3(step.Server, "FUNC_SagaStep", &sagaStepArgs{
+		Saga:       s.Id,
+		Step:       s.step,
+		Compensate: s.compensating,
+		Attempt:    s.attempt,
+		Id:         name,
+		From:       localServerName(),
+		Data:       step.data,
+	})
+	s.timer = util.NewTimer(func() { s.fail(errSagaTimeout) }, s.Timeout)
+}
+
+func (s *Saga) succeed() {
+	util.StopTimer(s.timer)
+	s.attempt = 0
+	if s.compensating {
+		s.step--
+		s.compensate()
+		return
+	}
+	s.step++
+	if s.step >= len(s.Steps) {
+		s.finish()
+		return
+	}
+	s.send()
+}
+
+func (s *Saga) fail(err error) {
+	util.StopTimer(s.timer)
+	if s.attempt <= s.Retries {
+		log.Debugf("saga %s step %d retry %d: %v", s.Id, s.step, s.attempt, err)
+		s.timer = util.NewTimer(s.send, sagaRetryPolicy.Backoff(s.attempt))
+		return
+	}
+
+	s.attempt = 0
+	if s.compensating {
+		log.Errorf("saga %s compensate step %d: %v", s.Id, s.step, err)
+		s.err.Uncompensated = append(s.err.Uncompensated, s.step)
+		s.step--
+		s.compensate()
+		return
+	}
+	log.Warnf("saga %s step %d: %v", s.Id, s.step, err)
+	s.err = &SagaError{Step: s.step, Err: err.Error()}
+	s.compensating = true
+	// 超时的步骤可能已执行
+	if err != errSagaTimeout {
+		s.step--
+	}
+	s.compensate()
+}
+
+// 跳过无需补偿的步骤
+func (s *Saga) compensate() {
+	for s.step >= 0 && s.Steps[s.step].Compensate == "" {
+		s.step--
+	}
+	if s.step < 0 {
+		s.finish()
+		return
+	}
+	s.send()
+}
+
+func (s *Saga) finish() {
+	delete(sagas, s.Id)
+	if s.Done == nil {
+		return
+	}
+	if s.err != nil {
+		s.Done(s.err)
+	} else {
+		s.Done(nil)
+	}
+}
+
+func funcSagaStep(ctx *Context, data interface{}) {
+	args := data.(*sagaStepArgs)
+	result := &sagaResultArgs{Saga: args.Saga, Step: args.Step, Compensate: args.Compensate}
+
+	var err error
+	ctx.exec.Attempt = args.Attempt
+	if e, ok := sagaHandlers[args.Id]; ok {
+		v := reflect.New(e.type_.Elem()).Interface()
+		if err = json.Unmarshal(args.Data, v); err == nil {
+			err = e.h(ctx, v)
+		}
+	} else {
+		err = errSagaUnknownStep
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	defaultClientManage.Route3(args.From, "FUNC_SagaResult", result)
+}
+
+func funcSagaResult(ctx *Context, data interface{}) {
+	args := data.(*sagaResultArgs)
+	s, ok := sagas[args.Saga]
+	// 忽略重复或过时的结果
+	if !ok || s.step != args.Step || s.compensating != args.Compensate || s.attempt == 0 {
+		return
+	}
+	if args.Error == "" {
+		s.succeed()
+	} else {
+		s.fail(errors.New(args.Error))
+	}
+}
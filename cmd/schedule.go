@@ -0,0 +1,36 @@
+package cmd
+
+// 延迟消息及定时广播，基于util定时器，需在主循环中调用
+// 数据在调用时序列化，返回的定时器可通过util.StopTimer取消
+//   cmd.SendAfter(5*time.Second, "hall", "Notice", data)
+//   cmd.BroadcastAt(t, "Announcement", data)
+
+import (
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+// 延迟发送至服务，target格式同Route
+func SendAfter(delay time.Duration, target, name string, i interface{}) *util.Timer {
+	data, err := marshalJSON(i)
+	if err != nil {
+		log.Errorf("send %s after %v: %v", name, delay, err)
+		return nil
+	}
+	return util.NewTimer(func() {
+		Route(target, name, data)
+	}, delay)
+}
+
+// 指定时间经路由广播至全部网关的会话，时间已过时立即广播
+func BroadcastAt(t time.Time, name string, i interface{}) *util.Timer {
+	data, err := marshalJSON(i)
+	if err != nil {
+		log.Errorf("broadcast %s at %v: %v", name, t, err)
+		return nil
+	}
+	return util.NewTimer(func() {
+		Route(ServerRouter, "C2S_Broadcast", &Package{Id: name, Data: data})
+	}, time.Until(t))
+}
@@ -0,0 +1,198 @@
+package cmd
+
+// 写队列容量及统计
+// 按连接类型配置容量，如：
+//   <SendQueue>
+//     <Gateway Size="1024" Adaptive="true" MinSize="256" MaxSize="4096"/>
+//   </SendQueue>
+// 开启自适应后，写满时容量翻倍直至MaxSize；统计周期内峰值低于容量1/4时减半直至MinSize
+
+import (
+	"github.com/guogeer/husky/config"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 连接类型
+const (
+	QueueClassClient  = "Client"  // 连接其他服务
+	QueueClassServer  = "Server"  // 服务接受的tcp连接
+	QueueClassGateway = "Gateway" // 网关websocket连接
+)
+
+const queueShrinkPeriod = 10 * time.Second
+
+type SendQueueOptions struct {
+	Size     int // 初始容量
+	Adaptive bool
+	MinSize  int
+	MaxSize  int
+}
+
+type QueueStats struct {
+	Class     string `json:",omitempty"`
+	Count     int    `json:",omitempty"` // 汇总的连接数
+	Depth     int    // 当前长度
+	HighWater int    // 历史最大长度
+	Capacity  int    // 当前容量
+}
+
+var (
+	sendQueueOptions = map[string]SendQueueOptions{
+		QueueClassClient:  {Size: sendQueueSize},
+		QueueClassServer:  {Size: 32 << 10},
+		QueueClassGateway: {Size: 1 << 10},
+	}
+	sendQueueMu sync.RWMutex
+)
+
+func init() {
+	for class, opts := range sendQueueOptions {
+		config.Unmarshal("SendQueue."+class, &opts)
+		SetSendQueueOptions(class, opts)
+	}
+}
+
+// 仅影响新建的连接
+func SetSendQueueOptions(class string, opts SendQueueOptions) {
+	if opts.Size <= 0 {
+		opts.Size = sendQueueSize
+	}
+	if !opts.Adaptive {
+		opts.MinSize, opts.MaxSize = opts.Size, opts.Size
+	}
+	if opts.MinSize <= 0 || opts.MinSize > opts.Size {
+		opts.MinSize = opts.Size
+	}
+	if opts.MaxSize < opts.Size {
+		opts.MaxSize = opts.Size
+	}
+
+	sendQueueMu.Lock()
+	defer sendQueueMu.Unlock()
+	sendQueueOptions[class] = opts
+}
+
+func getSendQueueOptions(class string) SendQueueOptions {
+	sendQueueMu.RLock()
+	defer sendQueueMu.RUnlock()
+	return sendQueueOptions[class]
+}
+
+type sendQueueMeter struct {
+	class     string
+	opts      SendQueueOptions
+	limit     int32 // 当前容量
+	highWater int32
+
+	peak        int // 统计周期内的峰值
+	periodStart time.Time
+	mu          sync.Mutex
+}
+
+// 创建写队列，通道按最大容量分配
+func (m *sendQueueMeter) init(class string) chan []byte {
+	m.class = class
+	m.opts = getSendQueueOptions(class)
+	m.limit = int32(m.opts.Size)
+	m.periodStart = time.Now()
+	return make(chan []byte, m.opts.MaxSize)
+}
+
+// 队列长度为depth时能否继续写入，写满时按需扩容
+func (m *sendQueueMeter) admit(depth int) bool {
+	limit := atomic.LoadInt32(&m.limit)
+	if int32(depth) < limit {
+		m.observe(depth + 1)
+		return true
+	}
+	m.observe(depth)
+	if m.opts.Adaptive && int(limit) < m.opts.MaxSize {
+		newLimit := 2 * limit
+		if int(newLimit) > m.opts.MaxSize {
+			newLimit = int32(m.opts.MaxSize)
+		}
+		atomic.CompareAndSwapInt32(&m.limit, limit, newLimit)
+	}
+	return false
+}
+
+func (m *sendQueueMeter) observe(depth int) {
+	for {
+		old := atomic.LoadInt32(&m.highWater)
+		if int32(depth) <= old || atomic.CompareAndSwapInt32(&m.highWater, old, int32(depth)) {
+			break
+		}
+	}
+	if !m.opts.Adaptive {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if depth > m.peak {
+		m.peak = depth
+	}
+	if time.Since(m.periodStart) < queueShrinkPeriod {
+		return
+	}
+	limit := int(atomic.LoadInt32(&m.limit))
+	if m.peak < limit/4 && limit > m.opts.MinSize {
+		limit /= 2
+		if limit < m.opts.MinSize {
+			limit = m.opts.MinSize
+		}
+		atomic.StoreInt32(&m.limit, int32(limit))
+	}
+	m.peak, m.periodStart = 0, time.Now()
+}
+
+func (m *sendQueueMeter) stats(depth int) *QueueStats {
+	return &QueueStats{
+		Class:     m.class,
+		Depth:     depth,
+		HighWater: int(atomic.LoadInt32(&m.highWater)),
+		Capacity:  int(atomic.LoadInt32(&m.limit)),
+	}
+}
+
+type queueConn interface {
+	SendQueue() *QueueStats
+}
+
+// 会话的写队列，连接不支持时返回nil
+func (sm *SessionManage) SendQueue(id string) *QueueStats {
+	if ss := sm.Get(id); ss != nil {
+		if c, ok := ss.Out.(queueConn); ok {
+			return c.SendQueue()
+		}
+	}
+	return nil
+}
+
+// 按连接类型汇总写队列，长度累加，历史最大长度及容量取最大值
+func (sm *SessionManage) SendQueueStats() map[string]*QueueStats {
+	all := make(map[string]*QueueStats)
+	for _, ss := range sm.GetList() {
+		c, ok := ss.Out.(queueConn)
+		if !ok {
+			continue
+		}
+		st := c.SendQueue()
+		total, ok := all[st.Class]
+		if !ok {
+			total = &QueueStats{Class: st.Class}
+			all[st.Class] = total
+		}
+		total.Count++
+		total.Depth += st.Depth
+		if st.HighWater > total.HighWater {
+			total.HighWater = st.HighWater
+		}
+		if st.Capacity > total.Capacity {
+			total.Capacity = st.Capacity
+		}
+	}
+	return all
+}
@@ -18,9 +18,18 @@ const (
 
 type Server struct {
 	Addr string
+
+	SpillDir     string // 写队列满后溢出至该目录，为空时丢弃
+	SpillMaxSize int64  // 单个连接溢出数据上限，0不限制
+
+	listeners serverListeners
 }
 
 func (srv *Server) Serve(l net.Listener) error {
+	return srv.serve(l, defaultTCPOptions)
+}
+
+func (srv *Server) serve(l net.Listener, opts *ListenOptions) error {
 	defer l.Close()
 	var tempDelay time.Duration
 	for {
@@ -42,17 +51,38 @@ func (srv *Server) Serve(l net.Listener) error {
 		}
 		tempDelay = 0
 
+		// 仅检查外部客户端，内部服务间连接不受准入规则限制
+		if opts.External {
+			if err := Admit(rwc.RemoteAddr().String()); err != nil {
+				log.Debugf("reject %s %v", rwc.RemoteAddr(), err)
+				rwc.Close()
+				continue
+			}
+		}
+
 		ssid := util.GUID()
 		c := &ServeConn{
 			server: srv,
+			opts:   opts,
 			TCPConn: &TCPConn{
 				ssid: ssid,
 				rwc:  rwc,
-				send: make(chan []byte, 32<<10),
 			},
 		}
+		if opts.External {
+			c.shaper = &sendShaper{}
+		}
+		c.send = c.queue.init(QueueClassServer)
+		c.resetClose()
+		if srv.SpillDir != "" {
+			c.spill = newSpillQueue(srv.SpillDir, srv.SpillMaxSize)
+		}
 		// log.Info("create guid", ssid)
 		addSession(&Session{Id: ssid, Out: c})
+		fireConnect(c.newContext())
+		if opts.SkipAuth {
+			fireAuth(c.newContext())
+		}
 		go c.serve()
 	}
 }
@@ -73,11 +103,24 @@ func ListenAndServe(addr string) error {
 
 type ServeConn struct {
 	server *Server
+	opts   *ListenOptions
+	shaper *sendShaper // 外部客户端广播限速
 	*TCPConn
 }
 
+func (c *ServeConn) newContext() *Context {
+	return &Context{Ssid: c.ssid, Out: c, isGateway: c.opts.External}
+}
+
 // 网络连接异常关闭后，优先通知主逻辑Goroutine，写Goroutine收到回复后
 // 继续读取写队列，缓存回收完毕后，关闭写队列，关闭写Goroutine
+// 仅客户端连接计入异常次数
+func (c *ServeConn) strike() {
+	if c.opts.External {
+		defaultQuarantine.Strike(c.RemoteAddr())
+	}
+}
+
 func (c *ServeConn) serve() {
 	doneCtx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -85,34 +128,52 @@ func (c *ServeConn) serve() {
 			// 关闭网络连接
 			c.rwc.Close()
 			// 当前上下文
-			ctx := &Context{Ssid: c.ssid, Out: c}
-			defaultCmdSet.Handle(ctx, "CMD_Close", nil)
-			defaultCmdSet.Handle(ctx, "FUNC_Close", nil)
-
-			// 删除会话
-			removeSession(c.ssid)
+			// 关闭并删除会话
+			closeSession(c.newContext())
+			if c.spill != nil {
+				c.spill.Close()
+			}
 		}()
 
+		var spillNotify chan struct{}
+		if c.spill != nil {
+			spillNotify = c.spill.notify
+		}
 		for {
 			select {
 			case buf, ok := <-c.send:
 				if ok == false {
+					c.flushSpill()
+					c.shutdown(doneCtx.Done())
 					return
 				}
 				if _, err := c.writeMsg(RawMessage, buf); err != nil {
 					log.Debugf("write %v", err)
 					return
 				}
+			case <-spillNotify:
+			case <-c.peerClosed():
+				if err := c.replyClose(); err != nil {
+					log.Debugf("write %v", err)
+				}
+				return
 			case <-doneCtx.Done():
 				return
 			}
+			// 写队列为空后发送溢出的数据
+			if len(c.send) == 0 {
+				if err := c.flushSpill(); err != nil {
+					log.Debugf("write %v", err)
+					return
+				}
+			}
 		}
 	}()
 
 	// 读关闭通知
 	defer cancel()
-	// 新连接5s内未收到有效数据判定无效
-	c.rwc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// 新连接未及时收到有效数据判定无效
+	c.rwc.SetReadDeadline(time.Now().Add(authReadTimeout))
 
 	for seq := 0; true; seq++ {
 		mt, buf, err := c.TCPConn.ReadMessage()
@@ -120,25 +181,70 @@ func (c *ServeConn) serve() {
 			if err != io.EOF {
 				log.Error(err)
 			}
+			if err == errInvalidData || err == errChecksum || err == errMissingChecksum {
+				c.strike()
+			}
 			return
 		}
-		if seq == 0 {
-			if _, err = defaultAuthParser.Decode(buf); err != nil {
+		if seq == 0 && !c.opts.SkipAuth {
+			pkg, err := defaultAuthParser.Decode(buf)
+			if err != nil {
+				c.strike()
+				return
+			}
+			if err := checkAuthNonce(pkg); err != nil {
+				log.Warnf("auth %s %v", c.RemoteAddr(), err)
 				return
 			}
+			// 客户端携带协商数据
+			if len(pkg.Data) > 0 {
+				if err := c.acceptHandshake(pkg.Data); err != nil {
+					log.Debugf("handshake %v", err)
+				}
+			}
+			fireAuth(c.newContext())
 		}
 		if seq == 0 || mt == PingMessage || mt == PongMessage {
-			c.rwc.SetReadDeadline(time.Now().Add(pongWait))
+			c.rwc.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		if mt == CloseMessage {
+			c.notifyPeerClose()
+		}
+		// 回复心跳，客户端据此计算往返时间
+		if mt == PingMessage {
+			if _, err := c.writeMsg(PongMessage, nil); err != nil {
+				return
+			}
 		}
 
+		if mt >= MinCustomFrame && mt <= MaxCustomFrame {
+			c.handleFrame(c, mt, buf)
+		}
 		if mt == RawMessage {
-			pkg, err := defaultRawParser.Decode(buf)
+			pkg, err := c.opts.parser().Decode(buf)
 			if err != nil {
+				c.strike()
 				return
 			}
 
 			id, ssid, data := pkg.Id, pkg.Ssid, pkg.Data
-			err = defaultCmdSet.Handle(&Context{Out: c, Ssid: ssid}, id, data)
+			if c.opts.External {
+				ssid = c.ssid // 外部连接不允许指定会话
+				// 重复或过期的消息
+				if ss := GetSession(ssid); ss != nil && !ss.acceptSeq(pkg.Seq) {
+					log.Warnf("client %s replay message %s seq %d", c.RemoteAddr(), id, pkg.Seq)
+					continue
+				}
+			}
+			ctx := &Context{Out: c, Ssid: ssid, Version: c.ProtocolVersion(), isGateway: c.opts.External}
+			if !c.opts.External {
+				ctx.AppId = pkg.AppId
+				ctx.TraceId = pkg.TraceId
+				ctx.claims = pkg.Claims
+			}
+			ctx.rtt = time.Duration(pkg.RTT) * time.Millisecond
+			ctx.meta = pkg.Meta
+			err = defaultCmdSet.Handle(ctx, id, data)
 			if err != nil {
 				log.Debugf("handle msg[%s] error: %v", buf, err)
 			}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeConnRejectReplay(t *testing.T) {
+	recv := make(chan int64, 8)
+	BindWithName("ServeReplay", func(ctx *Context, data interface{}) {
+		recv <- int64(*data.(*int64))
+	}, (*int64)(nil), WithConcurrency(RunConcurrent))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{}
+	go srv.serve(l, &ListenOptions{External: true, SkipAuth: true, Parser: defaultRawParser})
+	defer l.Close()
+
+	rwc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rwc.Close()
+	c := &TCPConn{rwc: rwc}
+	for _, seq := range []int64{1, 1, 2, 0, 3} {
+		buf, err := defaultRawParser.Encode(&Package{Id: "ServeReplay", Body: seq, Seq: seq})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.writeMsg(RawMessage, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []int64
+	for len(got) < 3 {
+		select {
+		case seq := <-recv:
+			got = append(got, seq)
+		case <-time.After(2 * time.Second):
+			t.Fatal("handled", got)
+		}
+	}
+	select {
+	case seq := <-recv:
+		t.Error("replay accepted", got, seq)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got[0]+got[1]+got[2] != 6 {
+		t.Error("handled", got)
+	}
+}
@@ -1,27 +1,107 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/guogeer/husky/log"
 	"sync"
+	"time"
+)
+
+// 网关会话数据
+const (
+	SessionKeyServer        = "ServerName"    // 会话绑定的服务
+	SessionKeyAuth          = "Auth"          // 会话已通过登录验证
+	SessionKeyClientVersion = "ClientVersion" // 客户端版本
+	SessionKeyLabel         = "Label"         // 客户端连接的网关地址标签
+	SessionKeyUId           = "UId"           // 登录的账号
 )
 
 type Session struct {
 	Id  string
 	Out Conn
+
+	versions map[string]string      // 会话指定的服务版本
+	values   map[string]interface{} // 会话数据
+	routed   map[string]bool        // 已路由过的服务
+	clients  map[string]*Client     // 已绑定的服务连接
+	app      string                 // 会话所属应用
+	claims   json.RawMessage        // 令牌声明
+	mu       sync.RWMutex
+
+	seqs   seqWindow      // 客户端消息序号
+	resume resumeState    // 断线重连，由resumeMu保护
+	pushes []*pendingPush // 待客户端确认的推送，仅主循环访问
+	outbox outbox         // 下发的消息，重连后补发
 }
 
 func (ss *Session) GetServerName() string {
-	client := ss.Out.(*Client)
-	return client.name
+	if c, ok := ss.Out.(ServiceConn); ok {
+		return c.ServerName()
+	}
+	return ""
 }
 
 func (ss *Session) Route(serverName, name string, i interface{}) {
-	pkg := &Package{Id: name, Body: i, Ssid: ss.Id, IsRaw: true}
+	ss.route(serverName, name, i, "")
+}
+
+func (ss *Session) route(serverName, name string, i interface{}, traceId string) error {
+	app := ss.routeAppId()
+	pkg := &Package{Id: name, Body: i, Ssid: ss.Id, AppId: app, TraceId: traceId, IsRaw: true}
+	pkg.RTT = int64(ss.RTT() / time.Millisecond)
+	isFirst := ss.markRouted(serverName)
+	if isFirst {
+		ss.assignRollout(app, serverName)
+	}
+	pkg.Meta = ss.metaForRoute(serverName, isFirst)
+	pkg.Claims = ss.claimsData()
 	buf, err := Encode(pkg)
 	if err != nil {
-		return
+		return err
+	}
+	if isFirst {
+		fireSessionBind(ss, serverName)
+	}
+	version := ss.GetServerVersion(serverName)
+	if err := defaultClientManage.routeSession(ss, app, serverName, version, buf); err != nil {
+		HandleDeadLetter(&Context{Out: ss.Out, Ssid: ss.Id}, &DeadLetter{
+			ServerName: serverName,
+			MessageId:  name,
+			Ssid:       ss.Id,
+			Reason:     err.Error(),
+			Data:       buf,
+		})
+		return err
+	}
+	return nil
+}
+
+func (ss *Session) RTT() time.Duration {
+	if c, ok := ss.Out.(rttConn); ok {
+		return c.RTT()
+	}
+	return 0
+}
+
+// 会话后续发往serverName的消息路由至指定版本
+func (ss *Session) SetServerVersion(serverName, version string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.versions == nil {
+		ss.versions = make(map[string]string)
 	}
-	defaultClientManage.Route(serverName, buf)
+	if version == "" {
+		delete(ss.versions, serverName)
+	} else {
+		ss.versions[serverName] = version
+	}
+}
+
+func (ss *Session) GetServerVersion(serverName string) string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.versions[serverName]
 }
 
 func (ss *Session) WriteJSON(name string, i interface{}) {
@@ -86,6 +166,33 @@ func (sm *SessionManage) Count() int {
 	return len(sm.sessions)
 }
 
+// 按会话分组统计数量，f返回分组名
+func (sm *SessionManage) GroupBy(f func(*Session) string) map[string]int {
+	counts := make(map[string]int)
+	for _, ss := range sm.GetList() {
+		counts[f(ss)]++
+	}
+	return counts
+}
+
+// 按会话数据分组统计数量，未设置时分组为none
+func (sm *SessionManage) CountBy(key string) map[string]int {
+	return sm.GroupBy(func(ss *Session) string {
+		if v := ss.Get(key); v != nil && v != "" {
+			return fmt.Sprint(v)
+		}
+		return "none"
+	})
+}
+
+// 由登录等服务通过网关设置会话的服务版本
+func funcSetServerVersion(ctx *Context, i interface{}) {
+	args := i.(*cmdArgs)
+	if ss := GetSession(ctx.Ssid); ss != nil {
+		ss.SetServerVersion(args.ServerName, args.ServerVersion)
+	}
+}
+
 func addSession(s *Session) {
 	defaultSessionManage.Add(s)
 }
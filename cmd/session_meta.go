@@ -0,0 +1,117 @@
+package cmd
+
+// 会话数据，如房间ID、语言、AB测试分组
+// 可选在会话首次路由至某服务时携带，避免服务重复查询
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+var sessionMetaForward int32
+
+// 开启后，会话首次路由至服务时消息携带会话数据
+func EnableSessionMetaForward(enable bool) {
+	var n int32
+	if enable {
+		n = 1
+	}
+	atomic.StoreInt32(&sessionMetaForward, n)
+}
+
+type sessionValueArgs struct {
+	Key   string
+	Value json.RawMessage `json:",omitempty"`
+}
+
+func (ss *Session) Set(key string, value interface{}) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.values == nil {
+		ss.values = make(map[string]interface{})
+	}
+	ss.values[key] = value
+}
+
+func (ss *Session) Get(key string) interface{} {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.values[key]
+}
+
+func (ss *Session) Delete(key string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.values, key)
+}
+
+func (ss *Session) Values() map[string]interface{} {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	values := make(map[string]interface{}, len(ss.values))
+	for k, v := range ss.values {
+		values[k] = v
+	}
+	return values
+}
+
+// 设置网关上的会话数据，value为nil时删除
+func (ss *Session) SetRemote(key string, value interface{}) {
+	args := &sessionValueArgs{Key: key}
+	if value != nil {
+		buf, err := marshalJSON(value)
+		if err != nil {
+			return
+		}
+		args.Value = buf
+	}
+	ss.WriteJSON("FUNC_SetSessionValue", args)
+}
+
+// 会话首次路由至serverName时返回需携带的会话数据
+func (ss *Session) metaForRoute(serverName string, isFirst bool) json.RawMessage {
+	if atomic.LoadInt32(&sessionMetaForward) == 0 || !isFirst {
+		return nil
+	}
+	values := ss.Values()
+	if len(values) == 0 {
+		return nil
+	}
+	buf, _ := json.Marshal(values)
+	return buf
+}
+
+// 记录会话路由过的服务，首次路由时返回true
+func (ss *Session) markRouted(serverName string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.routed == nil {
+		ss.routed = make(map[string]bool)
+	}
+	isFirst := !ss.routed[serverName]
+	ss.routed[serverName] = true
+	return isFirst
+}
+
+// 网关转发的会话数据，仅会话首次路由的消息携带
+func (ctx *Context) SessionMeta() map[string]json.RawMessage {
+	if len(ctx.meta) == 0 {
+		return nil
+	}
+	var values map[string]json.RawMessage
+	json.Unmarshal(ctx.meta, &values)
+	return values
+}
+
+func funcSetSessionValue(ctx *Context, data interface{}) {
+	args := data.(*sessionValueArgs)
+	ss := GetSession(ctx.Ssid)
+	if ss == nil {
+		return
+	}
+	if len(args.Value) == 0 || string(args.Value) == "null" {
+		ss.Delete(args.Key)
+	} else {
+		ss.Set(args.Key, args.Value)
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+// 网关会话广播限速
+// 广播写入发送队列前按令牌桶限制发往单个客户端（websocket及外部tcp连接）的消息数量，超出时丢弃，
+// 避免广播风暴导致发送队列阻塞主循环。回复、错误及SessionToken等控制消息不限速
+//   cmd.WriteBroadcast(ss.Out, name, data)
+//   <SendShaping Rate="50" Burst="100"/>
+// Rate为每秒消息数量，0不限速；Burst为允许的突发数量，默认同Rate
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errSendShaped = errors.New("send rate exceeded")
+
+type ShapingOptions struct {
+	Rate  float64
+	Burst int
+}
+
+var (
+	sendShaping   atomic.Value // ShapingOptions
+	shapedCounter int64
+)
+
+func init() {
+	var opts ShapingOptions
+	if err := config.Unmarshal("SendShaping", &opts); err != nil {
+		log.Errorf("load send shaping %v", err)
+	}
+	SetSendShaping(opts)
+}
+
+func SetSendShaping(opts ShapingOptions) {
+	if opts.Burst <= 0 {
+		opts.Burst = int(opts.Rate)
+	}
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	sendShaping.Store(opts)
+}
+
+// 累计因限速丢弃的消息数量
+func ShapingStats() int64 {
+	return atomic.LoadInt64(&shapedCounter)
+}
+
+// 写入广播消息，超出限速时丢弃并返回错误
+func WriteBroadcast(out Conn, name string, i interface{}) error {
+	if s := connShaper(out); s != nil && !s.allow() {
+		return errSendShaped
+	}
+	return out.WriteJSON(name, i)
+}
+
+// 外部客户端连接的限速，内部连接不限速
+func connShaper(out Conn) *sendShaper {
+	switch c := out.(type) {
+	case *WsConn:
+		return &c.shaper
+	case *ServeConn:
+		return c.shaper
+	}
+	return nil
+}
+
+type sendShaper struct {
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func (s *sendShaper) allow() bool {
+	opts := sendShaping.Load().(ShapingOptions)
+	if opts.Rate <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.last.IsZero() {
+		s.tokens = float64(opts.Burst)
+	} else {
+		s.tokens += now.Sub(s.last).Seconds() * opts.Rate
+	}
+	if burst := float64(opts.Burst); s.tokens > burst {
+		s.tokens = burst
+	}
+	s.last = now
+	if s.tokens < 1 {
+		atomic.AddInt64(&shapedCounter, 1)
+		return false
+	}
+	s.tokens--
+	return true
+}
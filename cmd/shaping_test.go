@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestBroadcastShaping(t *testing.T) {
+	SetSendShaping(ShapingOptions{Rate: 1, Burst: 2})
+	defer SetSendShaping(ShapingOptions{})
+
+	c := &WsConn{send: make(chan []byte, 16)}
+	var shaped int
+	for i := 0; i < 5; i++ {
+		if err := WriteBroadcast(c, "Notice", map[string]int{"N": i}); err == errSendShaped {
+			shaped++
+		}
+	}
+	if shaped != 3 || len(c.send) != 2 {
+		t.Fatal("broadcast", shaped, len(c.send))
+	}
+
+	// 回复及控制消息不限速
+	for _, name := range []string{"Login", "SessionToken", "ResendLost", "Error"} {
+		if err := c.WriteJSON(name, map[string]int{}); err != nil {
+			t.Error(name, err)
+		}
+	}
+	if len(c.send) != 6 {
+		t.Error("reply shaped", len(c.send))
+	}
+}
+
+func TestServeConnShaping(t *testing.T) {
+	SetSendShaping(ShapingOptions{Rate: 1, Burst: 2})
+	defer SetSendShaping(ShapingOptions{})
+
+	newConn := func(external bool) *ServeConn {
+		c := &ServeConn{opts: &ListenOptions{External: external}, TCPConn: &TCPConn{}}
+		if external {
+			c.shaper = &sendShaper{}
+		}
+		c.send = c.queue.init(QueueClassServer)
+		return c
+	}
+	// 内部连接不限速
+	for _, external := range []bool{true, false} {
+		c := newConn(external)
+		var shaped int
+		for i := 0; i < 5; i++ {
+			if err := WriteBroadcast(c, "Notice", map[string]int{"N": i}); err == errSendShaped {
+				shaped++
+			}
+		}
+		if external && (shaped != 3 || len(c.send) != 2) {
+			t.Error("external", shaped, len(c.send))
+		}
+		if !external && (shaped != 0 || len(c.send) != 5) {
+			t.Error("internal", shaped, len(c.send))
+		}
+	}
+}
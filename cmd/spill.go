@@ -0,0 +1,174 @@
+package cmd
+
+// 写队列满后将数据暂存至磁盘，待写队列空闲后按序发送
+// 防止短暂处理缓慢的服务丢失路由的消息
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/guogeer/husky/log"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var errSpillFull = errors.New("spill queue is full")
+
+// 写队列溢出告警，isDrop表示溢出文件已满数据被丢弃
+type FlowAlarm func(addr string, spillSize int64, isDrop bool)
+
+var (
+	flowAlarm        atomic.Value
+	spilledCounter   int64
+	spillDropCounter int64
+)
+
+func SetFlowAlarm(h FlowAlarm) {
+	flowAlarm.Store(h)
+}
+
+// 累计溢出至磁盘及丢弃的消息数量
+func FlowStats() (spilled, dropped int64) {
+	return atomic.LoadInt64(&spilledCounter), atomic.LoadInt64(&spillDropCounter)
+}
+
+func alarmFlow(addr string, size int64, isDrop bool) {
+	if h, ok := flowAlarm.Load().(FlowAlarm); ok && h != nil {
+		h(addr, size, isDrop)
+	}
+}
+
+type spillQueue struct {
+	dir     string
+	maxSize int64
+
+	f          *os.File
+	rpos, wpos int64
+	n          int
+	mu         sync.Mutex
+	notify     chan struct{}
+}
+
+func newSpillQueue(dir string, maxSize int64) *spillQueue {
+	return &spillQueue{
+		dir:     dir,
+		maxSize: maxSize,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (q *spillQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+func (q *spillQueue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.wpos - q.rpos
+}
+
+func (q *spillQueue) Push(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maxSize > 0 && q.wpos-q.rpos+int64(len(data)) > q.maxSize {
+		return errSpillFull
+	}
+	// 首次溢出时创建文件
+	if q.f == nil {
+		os.MkdirAll(q.dir, 0755)
+		f, err := ioutil.TempFile(q.dir, "spill")
+		if err != nil {
+			return err
+		}
+		q.f = f
+	}
+
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	if _, err := q.f.WriteAt(buf, q.wpos); err != nil {
+		return err
+	}
+	q.wpos += int64(len(buf))
+	q.n++
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *spillQueue) Pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 {
+		return nil, false
+	}
+
+	var head [4]byte
+	if _, err := q.f.ReadAt(head[:], q.rpos); err != nil {
+		log.Errorf("read spill %v", err)
+		return nil, false
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(head[:]))
+	if _, err := q.f.ReadAt(buf, q.rpos+4); err != nil {
+		log.Errorf("read spill %v", err)
+		return nil, false
+	}
+	q.rpos += int64(4 + len(buf))
+	q.n--
+	// 读取完毕，复用文件
+	if q.n == 0 {
+		q.rpos, q.wpos = 0, 0
+		q.f.Truncate(0)
+	}
+	return buf, true
+}
+
+func (q *spillQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.f != nil {
+		q.f.Close()
+		os.Remove(q.f.Name())
+		q.f = nil
+	}
+	q.n, q.rpos, q.wpos = 0, 0, 0
+}
+
+func (c *TCPConn) spillWrite(data []byte) error {
+	isFirst := c.spill.Len() == 0
+	if err := c.spill.Push(data); err != nil {
+		atomic.AddInt64(&spillDropCounter, 1)
+		log.Errorf("%s spill %v", c.RemoteAddr(), err)
+		alarmFlow(c.RemoteAddr(), c.spill.Size(), true)
+		return err
+	}
+	atomic.AddInt64(&spilledCounter, 1)
+	if isFirst {
+		log.Warnf("%s write queue is full, spill to disk", c.RemoteAddr())
+		alarmFlow(c.RemoteAddr(), c.spill.Size(), false)
+	}
+	return nil
+}
+
+// 发送溢出至磁盘的数据
+func (c *TCPConn) flushSpill() error {
+	if c.spill == nil {
+		return nil
+	}
+	for {
+		buf, ok := c.spill.Pop()
+		if !ok {
+			return nil
+		}
+		if _, err := c.writeMsg(RawMessage, buf); err != nil {
+			return err
+		}
+	}
+}
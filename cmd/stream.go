@@ -0,0 +1,161 @@
+package cmd
+
+// 流式回复，如分页的邮件列表、管理工具实时查看日志
+//   st := ctx.OpenStream("MailList")
+//   st.Send(page1)
+//   st.Send(page2)
+//   st.Close()
+// 每块数据以StreamChunk格式发送，客户端按序号回复StreamAck，
+// 未确认的块超过窗口时暂存，暂存超过上限时Send返回错误
+//   服务端 MailList {"Stream":"xx","Server":"hall","Seq":1,"Data":{...}}
+//   客户端 hall.StreamAck {"Stream":"xx","Seq":1}
+// 流在主循环中使用，长时间未确认的流自动关闭
+
+import (
+	"errors"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+var (
+	errStreamClosed = errors.New("stream is closed")
+	errStreamFull   = errors.New("stream pending chunks full")
+)
+
+var (
+	streamWindow      = config.Int("StreamWindow", 8)       // 未确认的块数量上限
+	streamMaxPending  = config.Int("StreamMaxPending", 256) // 暂存的块数量上限
+	streamIdleTimeout = config.Duration("StreamIdleTimeout", time.Minute)
+)
+
+type StreamChunk struct {
+	Stream string
+	Server string      `json:",omitempty"` // 流所在的服务，客户端回复时使用
+	Seq    int         `json:",omitempty"`
+	Data   interface{} `json:",omitempty"`
+	End    bool        `json:",omitempty"`
+}
+
+type StreamAck struct {
+	Stream string
+	Seq    int  // 已收到的最大序号
+	Cancel bool `json:",omitempty"` // 客户端取消
+}
+
+type Stream struct {
+	Id   string
+	name string
+	ctx  *Context
+
+	seq     int // 已发送的序号
+	acked   int
+	pending []interface{}
+	closing bool
+	closed  bool
+	active  time.Time
+}
+
+var (
+	streams        = make(map[string]*Stream)
+	lastStreamScan time.Time
+)
+
+func localServerName() string {
+	if reg := localServiceConfig(); reg != nil {
+		return reg.ServerName
+	}
+	return ""
+}
+
+// 打开流，数据以name消息发送
+func (ctx *Context) OpenStream(name string) *Stream {
+	now := time.Now()
+	if now.Sub(lastStreamScan) > streamIdleTimeout {
+		lastStreamScan = now
+		for _, st := range streams {
+			if now.Sub(st.active) > streamIdleTimeout {
+				st.abort()
+			}
+		}
+	}
+
+	st := &Stream{Id: util.GUID(), name: name, ctx: ctx, active: now}
+	streams[st.Id] = st
+	return st
+}
+
+func (st *Stream) write(chunk *StreamChunk) {
+	chunk.Stream = st.Id
+	// 经网关转发时客户端需指定服务回复
+	if !st.ctx.isGateway {
+		chunk.Server = localServerName()
+	}
+	st.ctx.WriteJSON(st.name, chunk)
+}
+
+// 发送数据块，超过窗口时暂存
+func (st *Stream) Send(i interface{}) error {
+	if st.closed || st.closing {
+		return errStreamClosed
+	}
+	if st.seq-st.acked < streamWindow {
+		st.seq++
+		st.write(&StreamChunk{Seq: st.seq, Data: i})
+		return nil
+	}
+	if len(st.pending) >= streamMaxPending {
+		return errStreamFull
+	}
+	st.pending = append(st.pending, i)
+	return nil
+}
+
+// 暂存的数据发送完毕后结束
+func (st *Stream) Close() {
+	if st.closed || st.closing {
+		return
+	}
+	st.closing = true
+	st.flush()
+}
+
+func (st *Stream) IsClosed() bool {
+	return st.closed
+}
+
+func (st *Stream) flush() {
+	for len(st.pending) > 0 && st.seq-st.acked < streamWindow {
+		st.seq++
+		st.write(&StreamChunk{Seq: st.seq, Data: st.pending[0]})
+		st.pending[0] = nil
+		st.pending = st.pending[1:]
+	}
+	if st.closing && len(st.pending) == 0 {
+		st.write(&StreamChunk{Seq: st.seq, End: true})
+		st.abort()
+	}
+}
+
+func (st *Stream) abort() {
+	st.closed = true
+	st.pending = nil
+	delete(streams, st.Id)
+}
+
+func funcStreamAck(ctx *Context, data interface{}) {
+	args := data.(*StreamAck)
+	st, ok := streams[args.Stream]
+	if !ok || st.ctx.Ssid != ctx.Ssid {
+		return
+	}
+	if args.Cancel {
+		st.abort()
+		return
+	}
+	if args.Seq > st.acked && args.Seq <= st.seq {
+		st.acked = args.Seq
+	}
+	st.active = time.Now()
+	st.flush()
+}
@@ -0,0 +1,162 @@
+package cmd
+
+// 消息旁路，按消息ID白名单及采样率复制收发的消息，异步写入TapSink，供数据分析使用
+// 队列满时丢弃，不影响消息处理
+//   <Tap Ids="Buy,Win" Sample="0.1" File="log/tap.log"/>
+// Kafka、NSQ等实现TapSink后通过SetTapSink设置
+
+import (
+	"encoding/json"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TapInbound  = "in"
+	TapOutbound = "out"
+)
+
+type TapRecord struct {
+	Direction string
+	Id        string
+	Ssid      string          `json:",omitempty"`
+	Data      json.RawMessage `json:",omitempty"`
+	Time      int64           // 毫秒
+}
+
+type TapSink interface {
+	Write(*TapRecord) error
+}
+
+type TapOptions struct {
+	Ids    []string // 消息ID白名单，为空时不复制
+	Sample float64  `default:"1"` // 采样率
+	Queue  int      `default:"4096"`
+	File   string   // 写入文件，每行一个JSON
+}
+
+type tap struct {
+	ids    map[string]bool
+	sample float64
+	queue  chan *TapRecord
+	done   chan struct{}
+	sink   TapSink
+}
+
+var (
+	defaultTap atomic.Value // *tap
+	tapDropped int64
+	tapMu      sync.Mutex
+)
+
+func init() {
+	defaultTap.Store((*tap)(nil))
+
+	var opts TapOptions
+	if err := config.Unmarshal("Tap", &opts); err != nil {
+		log.Errorf("load tap %v", err)
+		return
+	}
+	if opts.File != "" && len(opts.Ids) > 0 {
+		sink, err := NewFileTapSink(opts.File)
+		if err != nil {
+			log.Errorf("open tap file %v", err)
+			return
+		}
+		SetTapSink(sink, &opts)
+	}
+}
+
+// 设置旁路，sink为nil时关闭
+func SetTapSink(sink TapSink, opts *TapOptions) {
+	tapMu.Lock()
+	defer tapMu.Unlock()
+
+	if old := defaultTap.Load().(*tap); old != nil {
+		close(old.done)
+	}
+	if sink == nil || opts == nil || len(opts.Ids) == 0 {
+		defaultTap.Store((*tap)(nil))
+		return
+	}
+
+	t := &tap{
+		ids:    make(map[string]bool),
+		sample: opts.Sample,
+		queue:  make(chan *TapRecord, opts.Queue),
+		done:   make(chan struct{}),
+		sink:   sink,
+	}
+	for _, id := range opts.Ids {
+		t.ids[strings.TrimSpace(id)] = true
+	}
+	go t.run()
+	defaultTap.Store(t)
+}
+
+// 丢弃的记录数量
+func TapStats() int64 {
+	return atomic.LoadInt64(&tapDropped)
+}
+
+func (t *tap) run() {
+	for {
+		select {
+		case r := <-t.queue:
+			if err := t.sink.Write(r); err != nil {
+				log.Debugf("tap write %v", err)
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *tap) accept(id string) bool {
+	if !t.ids[id] {
+		return false
+	}
+	return t.sample >= 1 || rand.Float64() < t.sample
+}
+
+func tapMessage(direction, id, ssid string, data []byte) {
+	t := defaultTap.Load().(*tap)
+	if t == nil || !t.accept(id) {
+		return
+	}
+	r := &TapRecord{
+		Direction: direction,
+		Id:        id,
+		Ssid:      ssid,
+		Data:      append(json.RawMessage(nil), data...),
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	select {
+	case t.queue <- r:
+	default:
+		atomic.AddInt64(&tapDropped, 1)
+	}
+}
+
+type fileTapSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func NewFileTapSink(path string) (TapSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileTapSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (sink *fileTapSink) Write(r *TapRecord) error {
+	return sink.enc.Encode(r)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+// 多租户，一套路由及网关同时服务多个游戏
+// 服务按配置AppId注册至所属应用，服务间仅可访问同一应用的服务
+// 网关会话所属应用由连接参数app或SNI指定，登录服务可在登录时修改，
+// 会话消息仅路由至同一应用的服务，转发的消息携带AppId
+//   ws://host/ws?app=game1
+
+import (
+	"github.com/guogeer/husky/config"
+)
+
+var localAppId = config.String("AppId", "")
+
+// 当前服务所属应用
+func LocalAppId() string {
+	return localAppId
+}
+
+func (ss *Session) AppId() string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.app
+}
+
+func (ss *Session) SetAppId(app string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.app = app
+}
+
+// 网关会话使用会话所属应用，服务内使用本服务所属应用
+func (ss *Session) routeAppId() string {
+	if app := ss.AppId(); app != "" {
+		return app
+	}
+	return localAppId
+}
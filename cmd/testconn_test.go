@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"sync"
+)
+
+// 记录写入数据的连接
+type recordConn struct {
+	names    []string
+	bufs     [][]byte
+	features []string
+	mu       sync.Mutex
+}
+
+func (c *recordConn) Write(buf []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bufs = append(c.bufs, buf)
+	return nil
+}
+
+func (c *recordConn) WriteJSON(name string, i interface{}) error {
+	buf, err := Encode(&Package{Id: name, Body: i, IsRaw: true})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.names = append(c.names, name)
+	c.mu.Unlock()
+	return c.Write(buf)
+}
+
+func (c *recordConn) RemoteAddr() string {
+	return "127.0.0.1:0"
+}
+
+func (c *recordConn) Close() {}
+
+func (c *recordConn) HasFeature(name string) bool {
+	for _, s := range c.features {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *recordConn) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.names...)
+}
+
+// 测试中对端与本地声明相同的特性
+func (c *recordConn) PeerHasFeature(name string) bool {
+	return c.HasFeature(name)
+}
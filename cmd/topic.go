@@ -0,0 +1,60 @@
+package cmd
+
+// 通过路由发布订阅消息
+// 订阅方收到的消息ID即主题名，需绑定同名的处理函数
+
+import (
+	"encoding/json"
+)
+
+type TopicArgs struct {
+	Topic string
+	Data  json.RawMessage `json:",omitempty"`
+}
+
+// 订阅主题，与路由重连后自动重新订阅
+func Subscribe(topic string) {
+	defaultClientManage.Subscribe(topic, true)
+}
+
+func Unsubscribe(topic string) {
+	defaultClientManage.Subscribe(topic, false)
+}
+
+// 向主题的所有订阅方发送消息
+func Publish(topic string, i interface{}) {
+	buf, err := marshalJSON(i)
+	if err != nil {
+		return
+	}
+	Route(ServerRouter, "C2S_Publish", &TopicArgs{Topic: topic, Data: buf})
+}
+
+func (cm *clientManage) Subscribe(topic string, b bool) {
+	cm.mu.Lock()
+	if b {
+		cm.topics[topic] = true
+	} else {
+		delete(cm.topics, topic)
+	}
+	cm.mu.Unlock()
+
+	name := "C2S_Subscribe"
+	if b == false {
+		name = "C2S_Unsubscribe"
+	}
+	cm.Route3(ServerRouter, name, &TopicArgs{Topic: topic})
+}
+
+func (cm *clientManage) resubscribe() {
+	var topics []string
+	cm.mu.RLock()
+	for topic := range cm.topics {
+		topics = append(topics, topic)
+	}
+	cm.mu.RUnlock()
+
+	for _, topic := range topics {
+		cm.Route3(ServerRouter, "C2S_Subscribe", &TopicArgs{Topic: topic})
+	}
+}
@@ -0,0 +1,121 @@
+package cmd
+
+// 会话追踪，开启后打印指定会话经过本进程的每个环节：收到消息、执行处理、发送消息
+// 运维工具通过ADMIN_TraceSession分别发送至网关、路由及服务，到期后自动关闭
+// 按UId追踪时需由网关、路由等通过SetTraceResolver提供UId至会话ID的查询
+
+import (
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TraceRecv = "recv" // 收到消息
+	TraceExec = "exec" // 执行处理
+	TraceSend = "send" // 发送消息
+
+	defaultTraceDuration = 10 * time.Minute
+)
+
+type traceArgs struct {
+	Ssid    string
+	UId     int
+	Minutes int // 追踪时长，默认10分钟，0关闭
+}
+
+var (
+	traceCount    int32 // 追踪中的会话数量
+	traceSessions = make(map[string]time.Time)
+	traceMu       sync.RWMutex
+	traceResolver atomic.Value // func(int) string
+
+	traceIdPrefix = util.GUID()[:8] // 进程内唯一的追踪ID前缀
+	traceIdSeq    uint64
+)
+
+// 客户端消息的追踪ID
+func newTraceId() string {
+	seq := atomic.AddUint64(&traceIdSeq, 1)
+	return traceIdPrefix + "-" + strconv.FormatUint(seq, 36)
+}
+
+// UId查询会话ID
+func SetTraceResolver(f func(uid int) string) {
+	traceResolver.Store(f)
+}
+
+// 追踪会话，d<=0时关闭
+func TraceSession(ssid string, d time.Duration) {
+	if ssid == "" {
+		return
+	}
+
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if d <= 0 {
+		delete(traceSessions, ssid)
+	} else {
+		expire := time.Now().Add(d)
+		traceSessions[ssid] = expire
+		time.AfterFunc(d, func() { untrace(ssid, expire) })
+	}
+	atomic.StoreInt32(&traceCount, int32(len(traceSessions)))
+}
+
+func untrace(ssid string, expire time.Time) {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if t, ok := traceSessions[ssid]; ok && t.Equal(expire) {
+		delete(traceSessions, ssid)
+		log.Infof("trace session %s expire", ssid)
+	}
+	atomic.StoreInt32(&traceCount, int32(len(traceSessions)))
+}
+
+func isTraced(ssid string) bool {
+	if ssid == "" || atomic.LoadInt32(&traceCount) == 0 {
+		return false
+	}
+	traceMu.RLock()
+	defer traceMu.RUnlock()
+	_, ok := traceSessions[ssid]
+	return ok
+}
+
+func traceHop(hop, ssid, id string, cost time.Duration) {
+	if !isTraced(ssid) {
+		return
+	}
+	now := time.Now().Format("15:04:05.000000")
+	if hop == TraceExec {
+		log.Infof("trace %s %s %s %s cost %v", now, ssid, hop, id, cost)
+	} else {
+		log.Infof("trace %s %s %s %s", now, ssid, hop, id)
+	}
+}
+
+func funcTraceSession(ctx *Context, data interface{}) {
+	args := data.(*traceArgs)
+	ssid := args.Ssid
+	if ssid == "" && args.UId > 0 {
+		if f, ok := traceResolver.Load().(func(int) string); ok && f != nil {
+			ssid = f(args.UId)
+		}
+	}
+	if ssid == "" {
+		return
+	}
+
+	d := defaultTraceDuration
+	if args.Minutes > 0 {
+		d = time.Duration(args.Minutes) * time.Minute
+	} else if args.Minutes < 0 {
+		d = 0
+	}
+	log.Infof("trace session %s uid %d duration %v", ssid, args.UId, d)
+	TraceSession(ssid, d)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+// 传输方式注册，新的传输方式（如KCP、内存连接）实现Conn后注册至框架
+//   cmd.RegisterTransport("kcp", func(srv *cmd.Server, opts *cmd.ListenOptions) (io.Closer, error) {
+//       l := ... // 监听并在后台接受连接
+//       // 新连接：ss := cmd.OpenSession(c, opts.External)
+//       // 收到消息：cmd.Dispatch(cmd.NewContext(c, ss.Id, opts.External), id, data)
+//       // 连接断开：cmd.CloseSession(cmd.NewContext(c, ss.Id, opts.External))
+//       return l, nil
+//   })
+//   srv.Listen(&cmd.ListenOptions{Addr: ":9100", Transport: "kcp"})
+
+import (
+	"github.com/guogeer/husky/util"
+	"io"
+	"sync"
+)
+
+// 监听并在后台处理连接，返回的Closer用于关闭监听
+type Transport func(srv *Server, opts *ListenOptions) (io.Closer, error)
+
+// 连接至其他服务
+type ServiceConn interface {
+	Conn
+	ServerName() string
+}
+
+var (
+	transports  = make(map[string]Transport)
+	transportMu sync.RWMutex
+)
+
+func init() {
+	RegisterTransport(TransportTCP, listenTCP)
+	RegisterTransport(TransportWs, listenWs)
+}
+
+func RegisterTransport(name string, t Transport) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	transports[name] = t
+}
+
+func getTransport(name string) Transport {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return transports[name]
+}
+
+// external表示外部客户端连接
+func NewContext(out Conn, ssid string, external bool) *Context {
+	return &Context{Out: out, Ssid: ssid, isGateway: external}
+}
+
+// 新连接创建会话
+func OpenSession(out Conn, external bool) *Session {
+	ss := &Session{Id: util.GUID(), Out: out}
+	addSession(ss)
+	fireConnect(NewContext(out, ss.Id, external))
+	return ss
+}
+
+// 连接断开后关闭会话
+func CloseSession(ctx *Context) {
+	closeSession(ctx)
+}
+
+// 处理收到的消息
+func Dispatch(ctx *Context, id string, data []byte) error {
+	return defaultCmdSet.Handle(ctx, id, data)
+}
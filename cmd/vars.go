@@ -0,0 +1,69 @@
+package cmd
+
+// 运行时统计变量，通过性能分析接口的/debug/vars查询
+// 不使用expvar，避免在http.DefaultServeMux上注册处理函数
+//   var dropped = cmd.NewCounter("router_dropped")
+//   cmd.PublishVar("sessions", func() interface{} { return count })
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	n int64
+}
+
+func (c *Counter) Add(delta int64) {
+	atomic.AddInt64(&c.n, delta)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+var (
+	publishedVars = make(map[string]func() interface{})
+	varsMu        sync.RWMutex
+)
+
+// 名称重复时panic
+func PublishVar(name string, f func() interface{}) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	if _, ok := publishedVars[name]; ok {
+		panic("reuse of published var name: " + name)
+	}
+	publishedVars[name] = f
+}
+
+func NewCounter(name string) *Counter {
+	c := &Counter{}
+	PublishVar(name, func() interface{} { return c.Value() })
+	return c
+}
+
+// 输出格式与expvar一致，包括cmdline及memstats
+func serveVars(w http.ResponseWriter, r *http.Request) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	vars := map[string]interface{}{"cmdline": os.Args, "memstats": &stats}
+
+	varsMu.RLock()
+	funcs := make(map[string]func() interface{}, len(publishedVars))
+	for name, f := range publishedVars {
+		funcs[name] = f
+	}
+	varsMu.RUnlock()
+	for name, f := range funcs {
+		vars[name] = f()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(vars)
+}
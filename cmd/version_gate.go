@@ -0,0 +1,134 @@
+package cmd
+
+// 客户端版本限制
+// 网关转发客户端消息时按服务校验客户端版本（连接参数version），未携带版本视为最低版本
+// 低于MinVersion的客户端路由至Legacy指定的服务版本，未指定时回复升级错误
+//   <ClientVersions>
+//     <Rule Server="hall" MinVersion="1.2.0" Legacy="v1"/>
+//     <Rule Server="*" MinVersion="1.0.0" MaxVersion="2.0.0"/>
+//   </ClientVersions>
+// 运行时可通过ADMIN_SetClientVersionRules更新
+// 连接参数version超过32字节或格式不合法时忽略，如1.2.0、1.2.0-beta
+
+import (
+	"fmt"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type VersionRule struct {
+	Server     string // 服务名，*表示全部服务
+	MinVersion string `json:",omitempty"`
+	MaxVersion string `json:",omitempty"`
+	Legacy     string `json:",omitempty"` // 旧版本客户端路由的服务版本
+}
+
+type VersionRules struct {
+	Rules []VersionRule `config:"Rule"`
+}
+
+const maxClientVersionLen = 32
+
+var (
+	versionRules         atomic.Value
+	clientVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*(-[0-9A-Za-z.]+)?$`)
+)
+
+func init() {
+	versionRules.Store(map[string]VersionRule(nil))
+
+	var cfg VersionRules
+	if err := config.Unmarshal("ClientVersions", &cfg); err != nil {
+		log.Errorf("load client version rules %v", err)
+		return
+	}
+	SetVersionRules(cfg.Rules)
+}
+
+// 替换全部规则
+func SetVersionRules(rules []VersionRule) {
+	m := make(map[string]VersionRule)
+	for _, rule := range rules {
+		if rule.Server == "" {
+			rule.Server = "*"
+		}
+		m[rule.Server] = rule
+	}
+	versionRules.Store(m)
+}
+
+// 客户端上报的版本是否合法
+func IsValidClientVersion(version string) bool {
+	return len(version) <= maxClientVersionLen && clientVersionPattern.MatchString(version)
+}
+
+// 按.分隔逐段比较，数字按大小比较
+func CompareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		n1, err1 := strconv.Atoi(x)
+		n2, err2 := strconv.Atoi(y)
+		if x == "" {
+			n1, err1 = 0, nil
+		}
+		if y == "" {
+			n2, err2 = 0, nil
+		}
+		if err1 == nil && err2 == nil {
+			if n1 != n2 {
+				if n1 < n2 {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// 校验会话的客户端版本能否访问服务，旧版本客户端绑定至Legacy服务版本
+func (ss *Session) checkClientVersion(serverName string) error {
+	rules := versionRules.Load().(map[string]VersionRule)
+	rule, ok := rules[serverName]
+	if !ok {
+		if rule, ok = rules["*"]; !ok {
+			return nil
+		}
+	}
+
+	version, _ := ss.Get(SessionKeyClientVersion).(string)
+	if rule.MinVersion != "" && (version == "" || CompareVersion(version, rule.MinVersion) < 0) {
+		if rule.Legacy == "" {
+			return fmt.Errorf("upgrade required, client version %q min %s", version, rule.MinVersion)
+		}
+		if ss.GetServerVersion(serverName) == "" {
+			ss.SetServerVersion(serverName, rule.Legacy)
+		}
+		return nil
+	}
+	if rule.MaxVersion != "" && version != "" && CompareVersion(version, rule.MaxVersion) > 0 {
+		return fmt.Errorf("client version %q max %s", version, rule.MaxVersion)
+	}
+	return nil
+}
+
+func funcSetClientVersionRules(ctx *Context, data interface{}) {
+	args := data.(*VersionRules)
+	SetVersionRules(args.Rules)
+	log.Infof("set client version rules %v", args.Rules)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidClientVersion(t *testing.T) {
+	samples := map[string]bool{
+		"1":                     true,
+		"1.2.0":                 true,
+		"1.2.0-beta.1":          true,
+		"":                      false,
+		"v1.2":                  false,
+		"1..2":                  false,
+		"1.2 ":                  false,
+		"1.2\n":                 false,
+		"other":                 false,
+		strings.Repeat("1", 33): false,
+	}
+	for version, valid := range samples {
+		if IsValidClientVersion(version) != valid {
+			t.Errorf("%q %v", version, valid)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package cmd
+
+// 慢消息检测
+// 处理时长超过预算的消息打印消息ID、参数及主循环协程的堆栈
+// 预算通过配置HandlerBudget指定，默认0不开启，如200ms
+// 堆栈通过带标签的goroutine profile获取，仅输出主循环协程；HandlerDumpInterval（默认1m）内最多输出一次
+// 首次设置预算时才启动检测协程
+//   <HandlerBudget>200ms</HandlerBudget>
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxArgsSnapshot = 512
+
+type handlerWatch struct {
+	msg      *Message
+	start    time.Time
+	reported bool
+}
+
+var (
+	handlerBudget  int64 // 纳秒
+	slowCounter    int64
+	slowHandlers   = make(map[string]int64)
+	handlerWatches = make(map[*handlerWatch]bool)
+	watchMu        sync.Mutex
+
+	dumpInterval = time.Minute
+	lastDump     time.Time // 最近一次输出堆栈的时间，由watchMu保护
+	watchdogOnce sync.Once
+
+	mainLoopLabels = pprof.WithLabels(context.Background(), pprof.Labels("husky", "mainloop"))
+)
+
+func init() {
+	SetHandlerBudget(config.Duration("HandlerBudget", 0))
+	dumpInterval = config.Duration("HandlerDumpInterval", dumpInterval)
+}
+
+func SetHandlerBudget(d time.Duration) {
+	atomic.StoreInt64(&handlerBudget, int64(d))
+	if d > 0 {
+		watchdogOnce.Do(func() { go runWatchdog() })
+	}
+}
+
+func getHandlerBudget() time.Duration {
+	return time.Duration(atomic.LoadInt64(&handlerBudget))
+}
+
+// 累计慢消息数量，及各消息的慢处理次数
+func SlowHandlerStats() (int64, map[string]int64) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	stats := make(map[string]int64, len(slowHandlers))
+	for name, n := range slowHandlers {
+		stats[name] = n
+	}
+	return atomic.LoadInt64(&slowCounter), stats
+}
+
+func messageName(msg *Message) string {
+	if msg.ctx != nil && msg.ctx.MsgId != "" {
+		return msg.ctx.MsgId
+	}
+	return runtime.FuncForPC(reflect.ValueOf(msg.h).Pointer()).Name()
+}
+
+func snapshotArgs(args interface{}) string {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return err.Error()
+	}
+	if len(b) > maxArgsSnapshot {
+		b = append(b[:maxArgsSnapshot], "..."...)
+	}
+	return string(b)
+}
+
+// 主循环协程的堆栈，goroutine profile按堆栈分组，分组间以空行分隔
+// 主循环中创建的协程继承标签，需同时在执行消息
+func mainLoopStack() []byte {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return []byte(err.Error())
+	}
+	var stacks [][]byte
+	for _, stack := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(stack, []byte(`"husky":"mainloop"`)) && bytes.Contains(stack, []byte("cmd.runMessage")) {
+			stacks = append(stacks, stack)
+		}
+	}
+	return bytes.Join(stacks, []byte("\n\n"))
+}
+
+// 限制输出堆栈的频率
+func allowDump(now time.Time) bool {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if !lastDump.IsZero() && now.Sub(lastDump) < dumpInterval {
+		return false
+	}
+	lastDump = now
+	return true
+}
+
+func countSlowHandler(name string) {
+	atomic.AddInt64(&slowCounter, 1)
+	watchMu.Lock()
+	slowHandlers[name]++
+	watchMu.Unlock()
+}
+
+// 处理消息并检测耗时
+func runMessage(msg *Message) {
+	if ctx := msg.ctx; ctx != nil {
+		ctx.exec.Enqueued = msg.enqueued
+		ctx.exec.Dequeued = time.Now()
+		if ctx.exec.Attempt == 0 {
+			ctx.exec.Attempt = 1
+		}
+		defer ctx.runDefers()
+	}
+	if ctx := msg.ctx; ctx != nil && isTraced(ctx.Ssid) {
+		start := time.Now()
+		defer func() { traceHop(TraceExec, ctx.Ssid, messageName(msg), time.Since(start)) }()
+	}
+	if ctx := msg.ctx; ctx != nil && ctx.MsgId != "" {
+		start := time.Now()
+		defer func() { defaultMessageStats.exec(ctx.MsgId, time.Since(start)) }()
+	}
+	if getHandlerBudget() <= 0 {
+		msg.h(msg.ctx, msg.args)
+		return
+	}
+
+	w := &handlerWatch{msg: msg, start: time.Now()}
+	watchMu.Lock()
+	handlerWatches[w] = true
+	watchMu.Unlock()
+	defer func() {
+		watchMu.Lock()
+		delete(handlerWatches, w)
+		reported := w.reported
+		watchMu.Unlock()
+
+		// 处理结束后打印参数，避免与处理协程同时访问
+		d := time.Since(w.start)
+		budget := getHandlerBudget()
+		if reported || (budget > 0 && d > budget) {
+			name := messageName(msg)
+			if !reported {
+				countSlowHandler(name)
+			}
+			log.Warnf("slow handler %s cost %v args %s", name, d, snapshotArgs(msg.args))
+		}
+	}()
+	msg.h(msg.ctx, msg.args)
+}
+
+// 检测执行中超时的消息，此时可获取阻塞处的堆栈
+func runWatchdog() {
+	for {
+		budget := getHandlerBudget()
+		interval := budget / 2
+		if interval < 10*time.Millisecond {
+			interval = 10 * time.Millisecond
+		}
+		time.Sleep(interval)
+		if budget <= 0 {
+			continue
+		}
+
+		var slow []*handlerWatch
+		watchMu.Lock()
+		for w := range handlerWatches {
+			if !w.reported && time.Since(w.start) > budget {
+				w.reported = true
+				slow = append(slow, w)
+			}
+		}
+		watchMu.Unlock()
+
+		for _, w := range slow {
+			name := messageName(w.msg)
+			countSlowHandler(name)
+			if allowDump(time.Now()) {
+				log.Warnf("slow handler %s running %v\n%s", name, time.Since(w.start), mainLoopStack())
+			} else {
+				log.Warnf("slow handler %s running %v", name, time.Since(w.start))
+			}
+		}
+	}
+}
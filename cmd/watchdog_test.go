@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestMainLoopStack(t *testing.T) {
+	SetHandlerBudget(time.Hour)
+	defer SetHandlerBudget(0)
+
+	block, done := make(chan bool), make(chan bool)
+	go func() {
+		pprof.SetGoroutineLabels(mainLoopLabels)
+		runMessage(&Message{h: func(ctx *Context, data interface{}) {
+			// 继承标签的协程不输出
+			go func() { <-block }()
+			<-block
+		}})
+		close(done)
+	}()
+	defer func() { close(block); <-done }()
+
+	var stack []byte
+	for i := 0; i < 100 && !bytes.Contains(stack, []byte("TestMainLoopStack.func")); i++ {
+		time.Sleep(time.Millisecond)
+		stack = mainLoopStack()
+	}
+	if !bytes.Contains(stack, []byte("TestMainLoopStack.func")) {
+		t.Fatalf("main loop stack not found\n%s", stack)
+	}
+	// 不包含其他协程
+	if bytes.Contains(stack, []byte("testing.tRunner")) || bytes.Contains(stack, []byte("func1.1.1")) {
+		t.Errorf("other goroutines dumped\n%s", stack)
+	}
+}
+
+func TestAllowDump(t *testing.T) {
+	lastDump = time.Time{}
+	now := time.Now()
+	if !allowDump(now) {
+		t.Error("first dump")
+	}
+	if allowDump(now.Add(dumpInterval / 2)) {
+		t.Error("dump within interval")
+	}
+	if !allowDump(now.Add(dumpInterval)) {
+		t.Error("dump after interval")
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+// 网关websocket连接策略
+//   <WebSocket MaxConnsPerIP="16">
+//     <Origins>https://game.example.com,*.example.com</Origins>
+//     <Subprotocols>husky.v1,husky.v2</Subprotocols>
+//     <SNI Host="eu.example.com" Server="hall" Version="eu"/>
+//   </WebSocket>
+// Origins为空时不校验来源，Subprotocols为空时不协商子协议
+// SNI按TLS握手的域名指定会话路由的服务版本
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const SessionKeyHost = "Host" // TLS握手的域名
+
+type SNIRoute struct {
+	Host    string
+	Server  string
+	Version string
+	AppId   string // 按域名指定会话所属应用
+}
+
+type WsPolicy struct {
+	Origins       []string
+	Subprotocols  []string
+	MaxConnsPerIP int
+	SNI           []SNIRoute
+}
+
+var (
+	wsPolicy  atomic.Value // *WsPolicy
+	wsConnsMu sync.Mutex
+	wsConns   = make(map[string]int) // IP -> 连接数
+)
+
+func init() {
+	policy := &WsPolicy{}
+	if err := config.Unmarshal("WebSocket", policy); err != nil {
+		log.Errorf("load websocket policy %v", err)
+	}
+	SetWsPolicy(policy)
+}
+
+func SetWsPolicy(policy *WsPolicy) {
+	if policy == nil {
+		policy = &WsPolicy{}
+	}
+	wsPolicy.Store(policy)
+}
+
+func getWsPolicy() *WsPolicy {
+	return wsPolicy.Load().(*WsPolicy)
+}
+
+// 支持*.example.com匹配子域名
+func matchOrigin(patterns []string, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*" || strings.EqualFold(pattern, origin):
+			return true
+		case strings.HasPrefix(pattern, "*."):
+			if host := strings.ToLower(u.Hostname()); strings.HasSuffix(host, strings.ToLower(pattern[1:])) {
+				return true
+			}
+		case strings.EqualFold(pattern, u.Host):
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *WsPolicy) checkOrigin(r *http.Request) bool {
+	if len(policy.Origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // 非浏览器客户端
+	}
+	return matchOrigin(policy.Origins, origin)
+}
+
+// 客户端请求子协议时至少支持一个
+func (policy *WsPolicy) checkSubprotocols(r *http.Request) bool {
+	requested := websocket.Subprotocols(r)
+	if len(policy.Subprotocols) == 0 || len(requested) == 0 {
+		return true
+	}
+	for _, p := range requested {
+		for _, allowed := range policy.Subprotocols {
+			if p == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (policy *WsPolicy) upgrader() *websocket.Upgrader {
+	u := upgrader
+	u.Subprotocols = policy.Subprotocols
+	u.CheckOrigin = policy.checkOrigin
+	return &u
+}
+
+// 占用IP连接数，超出限制时返回false
+func (policy *WsPolicy) acquireIP(remoteAddr string) (string, bool) {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+
+	wsConnsMu.Lock()
+	defer wsConnsMu.Unlock()
+	if max := policy.MaxConnsPerIP; max > 0 && wsConns[ip] >= max {
+		return ip, false
+	}
+	wsConns[ip]++
+	return ip, true
+}
+
+func releaseIP(ip string) {
+	wsConnsMu.Lock()
+	defer wsConnsMu.Unlock()
+	if wsConns[ip]--; wsConns[ip] <= 0 {
+		delete(wsConns, ip)
+	}
+}
+
+// 按TLS域名设置会话路由的服务版本
+func (policy *WsPolicy) routeSNI(ss *Session, r *http.Request) {
+	if r.TLS == nil || r.TLS.ServerName == "" {
+		return
+	}
+	host := r.TLS.ServerName
+	ss.Set(SessionKeyHost, host)
+	for _, route := range policy.SNI {
+		if !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if route.Server != "" {
+			ss.SetServerVersion(route.Server, route.Version)
+		}
+		if route.AppId != "" {
+			ss.SetAppId(route.AppId)
+		}
+	}
+}
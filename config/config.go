@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
-	"flag"
 	"github.com/go-yaml/yaml"
 	"io/ioutil"
 	"os"
@@ -60,17 +59,34 @@ func (cf Env) Path() string {
 }
 
 var defaultConfig Env
+var defaultTree = node{}
 
-func init() {
-	fs := flag.NewFlagSet("", flag.ContinueOnError)
-	f, _ := os.Open(os.DevNull)
-	fs.SetOutput(f) // 不打印错误信息
-	path := fs.String("config", "config.xml", "config file path")
-	fs.Parse(os.Args[1:])
-	f.Close()
+func loadTree(path string) node {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return node{}
+	}
+	tree, err := parseTree(b, pathlib.Ext(path))
+	if err != nil || tree == nil {
+		return node{}
+	}
+	return tree
+}
 
-	LoadConfig(*path, &defaultConfig)
-	defaultConfig.path = *path
+func init() {
+	path, _ := parseFlags(os.Args[1:])
+	LoadConfig(path, &defaultConfig)
+	defaultConfig.path = path
+	defaultTree = loadTree(path)
+	if err := resolveIncludes(&defaultConfig, defaultTree, path); err != nil {
+		panic(err)
+	}
+	// 环境变量、命令行参数覆盖配置文件
+	applyEnvOverlay(&defaultConfig, defaultTree, os.Environ())
+	applyFlagOverlay(&defaultConfig, defaultTree, os.Args[1:])
+	if err := decryptSecrets(&defaultConfig, defaultTree); err != nil {
+		panic(err)
+	}
 }
 
 func Config() Env {
@@ -1,9 +1,224 @@
 package config
 
 import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
 	t.Log("load config.xml", defaultConfig)
 }
+
+func TestParseSize(t *testing.T) {
+	samples := map[string]int64{
+		"64":    64,
+		"64B":   64,
+		"64KB":  64 << 10,
+		"1.5MB": 3 << 19,
+		"2g":    2 << 30,
+	}
+	for s, n := range samples {
+		if n2, err := ParseSize(s); err != nil || n2 != n {
+			t.Error("parse size", s, n2, err)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := `<Config>
+		<Router>
+			<Address>127.0.0.1:9003</Address>
+			<Timeout>30s</Timeout>
+			<MaxSize>64KB</MaxSize>
+			<Tags>a,b</Tags>
+			<Server><Name>a</Name></Server>
+			<Server><Name>b</Name></Server>
+		</Router>
+	</Config>`
+	tree, err := parseTree([]byte(data), ".xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testServer struct {
+		Name string
+	}
+	var cfg struct {
+		Addr    string        `config:"Address,required"`
+		Timeout time.Duration `default:"5s"`
+		MaxSize int64
+		Retry   int `default:"3"`
+		Tags    []string
+		Servers []testServer `config:"Server"`
+	}
+	if err := unmarshalTree(tree, "Router", reflect.ValueOf(&cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "127.0.0.1:9003" || cfg.Timeout != 30*time.Second ||
+		cfg.MaxSize != 64<<10 || cfg.Retry != 3 || len(cfg.Tags) != 2 ||
+		len(cfg.Servers) != 2 || cfg.Servers[1].Name != "b" {
+		t.Error("unmarshal", cfg)
+	}
+
+	var required struct {
+		Name string `config:",required"`
+	}
+	if err := unmarshalTree(tree, "Router", reflect.ValueOf(&required)); err == nil {
+		t.Error("required field")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	data := `<Config>
+		<Sign>a</Sign>
+		<ServerList>
+			<Server><Name>router</Name><Address>127.0.0.1:9003</Address></Server>
+		</ServerList>
+		<Router>
+			<StorePath>router.json</StorePath>
+			<SpillDir>spill</SpillDir>
+		</Router>
+	</Config>`
+	tree, err := parseTree([]byte(data), ".xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Env{Sign: "a", ServerList: []server{{Name: "router", Addr: "127.0.0.1:9003"}}}
+
+	environ := []string{
+		"PATH=/bin",
+		"HUSKY_SIGN=b",
+		"HUSKY_ROUTER_ADDR=10.0.0.1:9003",
+		"HUSKY_WS_GATEWAY_ADDR=10.0.0.2:8201",
+		"HUSKY_ROUTER_STOREPATH=env.json",
+		"HUSKY_ROUTER_SPILLDIR=env",
+	}
+	args := []string{"-config", "config.xml", "-set", "Router.SpillDir=flag", "--set=Router.MaxSize=1KB"}
+	applyEnvOverlay(&env, tree, environ)
+	applyFlagOverlay(&env, tree, args)
+
+	if env.Sign != "b" || env.Server("router").Addr != "10.0.0.1:9003" ||
+		env.Server("ws_gateway").Addr != "10.0.0.2:8201" {
+		t.Error("overlay env", env)
+	}
+	var cfg struct {
+		StorePath string
+		SpillDir  string
+		MaxSize   int64
+	}
+	if err := unmarshalTree(tree, "Router", reflect.ValueOf(&cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StorePath != "env.json" || cfg.SpillDir != "flag" || cfg.MaxSize != 1<<10 {
+		t.Error("overlay tree", cfg)
+	}
+}
+
+func TestFlagOrder(t *testing.T) {
+	args := []string{"-port", "9010", "-set", "Router.SpillDir=flag", "-config", "a.xml", "--set=Sign=b", "-v"}
+	path, sets := parseFlags(args)
+	if path != "a.xml" || !reflect.DeepEqual(sets, []string{"Router.SpillDir=flag", "Sign=b"}) {
+		t.Fatal(path, sets)
+	}
+
+	tree := node{"Router": node{"SpillDir": "spill"}}
+	env := Env{Sign: "a"}
+	applyFlagOverlay(&env, tree, args)
+	if dir, _ := tree.find("Router.SpillDir"); dir != "flag" || env.Sign != "b" {
+		t.Error("overlay", tree, env)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"main.xml": `<Config>
+			<Include>common.xml</Include>
+			<Router><StorePath>main.json</StorePath></Router>
+		</Config>`,
+		"common.xml": `<Config>
+			<Include Path="sub/log.json"/>
+			<Sign>common</Sign>
+			<ServerList><Server><Name>router</Name><Address>127.0.0.1:9003</Address></Server></ServerList>
+			<Router><StorePath>common.json</StorePath><SpillDir>spill</SpillDir></Router>
+		</Config>`,
+		"sub/log.json": `{"Log": {"Level": "debug"}, "Sign": "log"}`,
+		"a.xml":        `<Config><Include>b.xml</Include></Config>`,
+		"b.xml":        `<Config><Include>a.xml</Include></Config>`,
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var env Env
+	path := filepath.Join(dir, "main.xml")
+	tree := loadTree(path)
+	if err := resolveIncludes(&env, tree, path); err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		StorePath string
+		SpillDir  string
+	}
+	if err := unmarshalTree(tree, "Router", reflect.ValueOf(&cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StorePath != "main.json" || cfg.SpillDir != "spill" {
+		t.Error("include router", cfg)
+	}
+	if s, _ := tree.find("Log.Level"); s != "debug" {
+		t.Error("include log", s)
+	}
+	if env.Sign != "common" || env.Server("router").Addr != "127.0.0.1:9003" {
+		t.Error("include env", env)
+	}
+
+	path = filepath.Join(dir, "a.xml")
+	if err := resolveIncludes(&env, loadTree(path), path); err == nil {
+		t.Error("include cycle")
+	} else {
+		t.Log(err)
+	}
+	path = filepath.Join(dir, "b.xml")
+	tree = node{"Include": "missing.xml"}
+	if err := resolveIncludes(&env, tree, path); err == nil {
+		t.Error("include missing file")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, err := Encrypt(key, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Decrypt(key, enc); err != nil || s != "password" {
+		t.Error("decrypt", s, err)
+	}
+	if _, err := Decrypt([]byte("fedcba9876543210"), enc); err == nil {
+		t.Error("decrypt with wrong key")
+	}
+
+	tree := node{"DB": node{"Password": enc, "User": "root"}}
+	env := Env{Sign: enc}
+	os.Setenv("HUSKY_CONFIG_KEY", base64.StdEncoding.EncodeToString(key))
+	defer os.Unsetenv("HUSKY_CONFIG_KEY")
+	if err := decryptSecrets(&env, tree); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := tree.find("DB.Password"); s != "password" || env.Sign != "password" {
+		t.Error("decrypt secrets", tree, env)
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+// 引用其他配置文件，公共配置（服务地址、日志等）放在同一文件中
+//   <Config>
+//     <Include>common.xml</Include>
+//     <Include Path="../log.yaml"/>
+//     <Router>...</Router>
+//   </Config>
+// 相对路径基于当前文件所在目录，被引用的文件可继续引用
+// 优先级：当前文件 > 后引用的文件 > 先引用的文件，配置段按字段合并，重复节点整体覆盖
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const includeKey = "Include"
+
+// 取出并删除引用的文件列表
+func popIncludes(tree node) ([]string, error) {
+	var files []string
+	for k, v := range tree {
+		if !strings.EqualFold(k, includeKey) {
+			continue
+		}
+		delete(tree, k)
+
+		a, ok := v.([]interface{})
+		if !ok {
+			a = []interface{}{v}
+		}
+		for _, item := range a {
+			// <Include Path="common.xml"/>
+			if m, ok := item.(node); ok {
+				item, _ = m.lookup("Path")
+			}
+			s, ok := item.(string)
+			if !ok || strings.TrimSpace(s) == "" {
+				return nil, fmt.Errorf("invalid include %v", item)
+			}
+			files = append(files, strings.TrimSpace(s))
+		}
+	}
+	return files, nil
+}
+
+// 合并被引用的配置，dst已有的配置不覆盖
+func mergeTree(dst, src node) {
+	for k, v := range src {
+		key, old, ok := k, interface{}(nil), false
+		for dk, dv := range dst {
+			if strings.EqualFold(dk, k) {
+				key, old, ok = dk, dv, true
+				break
+			}
+		}
+		if !ok {
+			dst[key] = v
+			continue
+		}
+		oldNode, isNode := old.(node)
+		newNode, isNewNode := v.(node)
+		if isNode && isNewNode {
+			mergeTree(oldNode, newNode)
+		}
+	}
+}
+
+// 合并引用的配置至tree及env
+func resolveIncludes(env *Env, tree node, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return includeTree(env, tree, path, []string{abs})
+}
+
+// stack为引用链，用于检测循环引用
+func includeTree(env *Env, tree node, path string, stack []string) error {
+	files, err := popIncludes(tree)
+	if err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		file := files[i]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("config %s include %s: %v", path, files[i], err)
+		}
+		for _, p := range stack {
+			if p == abs {
+				return fmt.Errorf("config include cycle %s", strings.Join(append(stack, abs), " -> "))
+			}
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("config %s include %s: %v", path, files[i], err)
+		}
+		sub, err := parseTree(b, filepath.Ext(file))
+		if err != nil {
+			return fmt.Errorf("config %s include %s: %v", path, files[i], err)
+		}
+		if sub == nil {
+			sub = node{}
+		}
+		applyIncludedEnv(env, sub)
+		chain := append(append([]string(nil), stack...), abs)
+		if err := includeTree(env, sub, file, chain); err != nil {
+			return err
+		}
+		mergeTree(tree, sub)
+	}
+	return nil
+}
+
+// 引用文件中的签名、服务地址，已配置时不覆盖
+func applyIncludedEnv(env *Env, tree node) {
+	if s, ok := tree.lookup("Sign"); ok && env.Sign == "" {
+		env.Sign, _ = s.(string)
+	}
+	if s, ok := tree.lookup("ProductKey"); ok && env.ProductKey == "" {
+		env.ProductKey, _ = s.(string)
+	}
+	i, ok := tree.find("ServerList.Server")
+	if !ok {
+		return
+	}
+	a, ok := i.([]interface{})
+	if !ok {
+		a = []interface{}{i}
+	}
+	for _, item := range a {
+		m, ok := item.(node)
+		if !ok {
+			continue
+		}
+		name, _ := m.lookup("Name")
+		addr, _ := m.lookup("Address")
+		if s, ok := name.(string); ok && s != "" && !env.hasServer(s) {
+			addr, _ := addr.(string)
+			env.ServerList = append(env.ServerList, server{Name: s, Addr: addr})
+		}
+	}
+}
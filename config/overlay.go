@@ -0,0 +1,192 @@
+package config
+
+// 环境变量、命令行参数覆盖配置
+// 优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+//   环境变量：HUSKY_ROUTER_STOREPATH=/data/router.json，对应配置Router.StorePath
+//   命令行参数：-set Router.StorePath=/data/router.json，可重复
+// 服务地址：HUSKY_ROUTER_ADDR=127.0.0.1:9003 或 -set router.Addr=127.0.0.1:9003
+// HUSKY_CONFIG_KEY、HUSKY_CONFIG_KEYFILE为配置密钥，不作为配置值
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+const envPrefix = "HUSKY_"
+
+// 环境变量名中的_既可能是层级分隔也可能是名称的一部分，优先匹配已有配置
+func matchEnvPath(m node, parts []string) []string {
+	if len(parts) == 0 {
+		return nil
+	}
+	for n := len(parts); n > 0; n-- {
+		name := strings.Join(parts[:n], "_")
+		for k, child := range m {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			if n == len(parts) {
+				return []string{k}
+			}
+			if sub, ok := child.(node); ok {
+				if rest := matchEnvPath(sub, parts[n:]); rest != nil {
+					return append([]string{k}, rest...)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (env *Env) setServerAddr(name, addr string) {
+	for i, s := range env.ServerList {
+		if strings.EqualFold(s.Name, name) {
+			env.ServerList[i].Addr = addr
+			return
+		}
+	}
+	env.ServerList = append(env.ServerList, server{Name: name, Addr: addr})
+}
+
+func (env *Env) hasServer(name string) bool {
+	for _, s := range env.ServerList {
+		if strings.EqualFold(s.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 设置路径对应的值，不覆盖重复节点
+func (n node) set(path []string, value string) bool {
+	m := n
+	for _, key := range path[:len(path)-1] {
+		child, ok := m.lookup(key)
+		sub, isNode := child.(node)
+		if !isNode {
+			if s, isString := child.(string); ok && !(isString && s == "") {
+				return false
+			}
+			sub = node{}
+			m[key] = sub
+		}
+		m = sub
+	}
+	last := path[len(path)-1]
+	for k := range m {
+		if strings.EqualFold(k, last) {
+			last = k
+			break
+		}
+	}
+	if _, ok := m[last].(string); !ok && m[last] != nil {
+		return false
+	}
+	m[last] = value
+	return true
+}
+
+func overlay(env *Env, tree node, path []string, value string) {
+	// 服务地址，与配置段同名时优先服务
+	if n := len(path); n > 1 && strings.EqualFold(path[n-1], "addr") {
+		name := strings.Join(path[:n-1], "_")
+		if _, isSection := tree.find(strings.Join(path[:n-1], ".")); env.hasServer(name) || !isSection {
+			env.setServerAddr(name, value)
+			return
+		}
+	}
+	if len(path) == 1 {
+		switch strings.ToLower(path[0]) {
+		case "sign":
+			env.Sign = value
+		case "productkey":
+			env.ProductKey = value
+		}
+	}
+	if !tree.set(path, value) {
+		log.Printf("config: cannot override %s", strings.Join(path, "."))
+	}
+}
+
+func applyEnvOverlay(env *Env, tree node, environ []string) {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		n := strings.IndexByte(kv, '=')
+		if n < 0 {
+			continue
+		}
+		key, value := kv[len(envPrefix):n], kv[n+1:]
+		if key == "CONFIG_KEY" || key == "CONFIG_KEYFILE" {
+			continue // 配置密钥
+		}
+		parts := strings.Split(strings.ToLower(key), "_")
+
+		path := matchEnvPath(tree, parts)
+		if path == nil {
+			// 服务名可能包含_
+			if last := len(parts) - 1; last > 0 && parts[last] == "addr" {
+				path = []string{strings.Join(parts[:last], "_"), "addr"}
+			} else {
+				path = parts
+			}
+		}
+		overlay(env, tree, path, value)
+	}
+}
+
+// 可重复的-set参数
+type setFlags []string
+
+func (s *setFlags) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *setFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// 解析-config及-set key=value，参数顺序任意，忽略进程的其他参数
+func parseFlags(args []string) (string, []string) {
+	var own []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		name := strings.TrimLeft(args[i], "-")
+		if n := len(args[i]) - len(name); n == 0 || n > 2 {
+			continue // 非参数
+		}
+		if n := strings.IndexByte(name, '='); n >= 0 {
+			if name[:n] == "config" || name[:n] == "set" {
+				own = append(own, args[i])
+			}
+		} else if (name == "config" || name == "set") && i+1 < len(args) {
+			own = append(own, args[i], args[i+1])
+			i++
+		}
+	}
+
+	var sets setFlags
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard) // 不打印错误信息
+	path := fs.String("config", "config.xml", "config file path")
+	fs.Var(&sets, "set", "override config, key=value")
+	fs.Parse(own)
+	return *path, sets
+}
+
+func applyFlagOverlay(env *Env, tree node, args []string) {
+	_, sets := parseFlags(args)
+	for _, kv := range sets {
+		n := strings.IndexByte(kv, '=')
+		if n <= 0 {
+			continue
+		}
+		overlay(env, tree, strings.Split(kv[:n], "."), kv[n+1:])
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+// 配置加密，加密值格式为ENC(base64(nonce+密文))，AES-GCM算法
+// 密钥通过环境变量HUSKY_CONFIG_KEY（base64）或HUSKY_CONFIG_KEYFILE指定
+// 加载配置时自动解密
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	secretPrefix = "ENC("
+	secretSuffix = ")"
+)
+
+var errNoSecretKey = errors.New("config: secret key not found")
+
+// 读取密钥，支持长度16/24/32
+func loadSecretKey() ([]byte, error) {
+	s := os.Getenv("HUSKY_CONFIG_KEY")
+	if path := os.Getenv("HUSKY_CONFIG_KEYFILE"); s == "" && path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, errNoSecretKey
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 加密配置值，结果可直接写入配置文件
+func Encrypt(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	buf := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(buf) + secretSuffix, nil
+}
+
+func isSecret(s string) bool {
+	return strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+func Decrypt(key []byte, s string) (string, error) {
+	if !isSecret(s) {
+		return s, nil
+	}
+	buf, err := base64.StdEncoding.DecodeString(s[len(secretPrefix) : len(s)-len(secretSuffix)])
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < gcm.NonceSize() {
+		return "", errors.New("config: invalid secret")
+	}
+	nonce, ciphertext := buf[:gcm.NonceSize()], buf[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// 解密配置中全部加密值，仅在存在加密值时需要密钥
+func decryptSecrets(env *Env, tree node) error {
+	var key []byte
+	decrypt := func(path, s string) (string, error) {
+		if !isSecret(s) {
+			return s, nil
+		}
+		if key == nil {
+			var err error
+			if key, err = loadSecretKey(); err != nil {
+				return "", fmt.Errorf("decrypt %s: %v", path, err)
+			}
+		}
+		plaintext, err := Decrypt(key, s)
+		if err != nil {
+			return "", fmt.Errorf("decrypt %s: %v", path, err)
+		}
+		return plaintext, nil
+	}
+
+	var err error
+	if env.Sign, err = decrypt("Sign", env.Sign); err != nil {
+		return err
+	}
+	if env.ProductKey, err = decrypt("ProductKey", env.ProductKey); err != nil {
+		return err
+	}
+	for i, s := range env.ServerList {
+		if env.ServerList[i].Addr, err = decrypt(s.Name, s.Addr); err != nil {
+			return err
+		}
+	}
+	_, err = decryptValue("", tree, decrypt)
+	return err
+}
+
+func decryptValue(path string, i interface{}, decrypt func(string, string) (string, error)) (interface{}, error) {
+	switch v := i.(type) {
+	case string:
+		return decrypt(path, v)
+	case node:
+		for k, child := range v {
+			sub := k
+			if path != "" {
+				sub = path + "." + k
+			}
+			value, err := decryptValue(sub, child, decrypt)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = value
+		}
+	case []interface{}:
+		for k, child := range v {
+			value, err := decryptValue(path, child, decrypt)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = value
+		}
+	}
+	return i, nil
+}
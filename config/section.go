@@ -0,0 +1,384 @@
+package config
+
+// 配置段绑定至结构体
+//   type RouterConfig struct {
+//       Addr    string        `config:"Address,required"`
+//       Timeout time.Duration `default:"30s"`
+//       MaxSize int64         `default:"64KB"`
+//   }
+//   config.Unmarshal("Router", &cfg)
+// 字段名默认与配置名一致（忽略大小写），多级配置段以.分隔
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/go-yaml/yaml"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 配置树，叶子节点为string，重复节点为[]interface{}
+type node map[string]interface{}
+
+func parseTree(b []byte, ext string) (node, error) {
+	var tree interface{}
+	switch ext {
+	default:
+		return nil, errors.New("only support xml|json|yaml")
+	case ".xml":
+		return parseXMLTree(b)
+	case ".json":
+		if err := json.Unmarshal(b, &tree); err != nil {
+			return nil, err
+		}
+	case ".yaml":
+		if err := yaml.Unmarshal(b, &tree); err != nil {
+			return nil, err
+		}
+	}
+	root, _ := normalize(tree).(node)
+	return root, nil
+}
+
+// 统一json/yaml解析结果
+func normalize(i interface{}) interface{} {
+	switch v := i.(type) {
+	case map[string]interface{}:
+		m := make(node, len(v))
+		for k, child := range v {
+			m[k] = normalize(child)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(node, len(v))
+		for k, child := range v {
+			m[fmt.Sprint(k)] = normalize(child)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for k, child := range v {
+			a[k] = normalize(child)
+		}
+		return a
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(i)
+}
+
+func parseXMLTree(b []byte) (node, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	// 跳过根节点
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			child, err := parseXMLNode(d, start)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := child.(node); ok {
+				return m, nil
+			}
+			return node{}, nil
+		}
+	}
+}
+
+func parseXMLNode(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := node{}
+	for _, attr := range start.Attr {
+		m[attr.Name.Local] = attr.Value
+	}
+	var text bytes.Buffer
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			child, err := parseXMLNode(d, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			// 重复的节点
+			if old, ok := m[name]; ok {
+				if a, ok := old.([]interface{}); ok {
+					m[name] = append(a, child)
+				} else {
+					m[name] = []interface{}{old, child}
+				}
+			} else {
+				m[name] = child
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if len(m) == 0 {
+				return strings.TrimSpace(text.String()), nil
+			}
+			return m, nil
+		}
+	}
+}
+
+func (n node) lookup(key string) (interface{}, bool) {
+	if v, ok := n[key]; ok {
+		return v, true
+	}
+	for k, v := range n {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// 按a.b.c路径查找
+func (n node) find(path string) (interface{}, bool) {
+	var cur interface{} = n
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(node)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m.lookup(key); !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// 解析大小，支持B/KB/MB/GB
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		n      int64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-len(unit.suffix)]), 64)
+			if err != nil {
+				return 0, err
+			}
+			return int64(n * float64(unit.n)), nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func setValue(v reflect.Value, i interface{}) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), i)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		m, ok := i.(node)
+		if !ok {
+			return errors.New("expect section")
+		}
+		return bindStruct(v, m)
+	case reflect.Slice:
+		a, ok := i.([]interface{})
+		if !ok {
+			// 单个节点或逗号分隔
+			if s, isString := i.(string); isString && v.Type().Elem().Kind() != reflect.Struct {
+				for _, part := range strings.Split(s, ",") {
+					if part = strings.TrimSpace(part); part != "" {
+						a = append(a, part)
+					}
+				}
+			} else {
+				a = []interface{}{i}
+			}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for k, item := range a {
+			if err := setValue(slice.Index(k), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	s, ok := i.(string)
+	if !ok {
+		return errors.New("expect value")
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		var err error
+		if v.Type() == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			n = int64(d)
+		} else {
+			n, err = ParseSize(s)
+		}
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := ParseSize(s)
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func bindStruct(v reflect.Value, m node) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("config")
+		if tag == "-" {
+			continue
+		}
+		name := sf.Name
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			name = parts[0]
+		}
+		required := len(parts) > 1 && parts[1] == "required"
+
+		value, ok := m.lookup(name)
+		if !ok {
+			def, hasDefault := sf.Tag.Lookup("default")
+			if !hasDefault {
+				if required {
+					return fmt.Errorf("config %s is required", name)
+				}
+				continue
+			}
+			value = def
+		}
+		if err := setValue(v.Field(i), value); err != nil {
+			return fmt.Errorf("config %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// 绑定配置段至结构体，section为空时绑定整个配置
+func Unmarshal(section string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("config: unmarshal expect non-nil pointer")
+	}
+	return unmarshalTree(defaultTree, section, rv)
+}
+
+func unmarshalTree(tree node, section string, rv reflect.Value) error {
+	var m interface{} = tree
+	if section != "" {
+		if i, ok := tree.find(section); ok {
+			m = i
+		} else {
+			m = node{} // 配置段不存在时使用默认值
+		}
+	}
+	if _, ok := m.(node); !ok {
+		return fmt.Errorf("config %s is not a section", section)
+	}
+	return setValue(rv, m)
+}
+
+func getString(path string) (string, bool) {
+	i, ok := defaultTree.find(path)
+	if !ok {
+		return "", false
+	}
+	s, ok := i.(string)
+	return s, ok
+}
+
+func String(path string, def string) string {
+	if s, ok := getString(path); ok {
+		return s
+	}
+	return def
+}
+
+func Int(path string, def int) int {
+	if s, ok := getString(path); ok {
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func Bool(path string, def bool) bool {
+	if s, ok := getString(path); ok {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func Duration(path string, def time.Duration) time.Duration {
+	if s, ok := getString(path); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func Size(path string, def int64) int64 {
+	if s, ok := getString(path); ok {
+		if n, err := ParseSize(s); err == nil {
+			return n
+		}
+	}
+	return def
+}
@@ -0,0 +1,152 @@
+package main
+
+// 示例服务，注册至路由后处理网关转发的客户端消息，可作为本地联调、压测的目标
+//   go run ./router
+//   go run ./gateway
+//   go run ./examples/echo -name echo -addr :9101
+//   husky-bench -mix "echo.Echo=Echo:1" -data '{"Msg":"hi"}'
+// 客户端消息：
+//   echo.Echo {"Msg":"hi"}        回复Echo
+//   echo.Shout {"Msg":"hi"}       经路由广播至全部会话
+//   echo.Relay {"Msg":"hi"}       经路由转发至同名服务的FUNC_Relay，计数
+//   echo.Call {"Server":"echo"}   异步请求服务的Ping并回复
+//   echo.Stats {}                 回复处理的消息数量
+// -check 启动后请求自身的Ping，成功后退出，失败时返回1，用于验证路由及服务注册
+
+import (
+	"errors"
+	"flag"
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+	"os"
+	"time"
+)
+
+var (
+	name  = flag.String("name", "echo", "server name")
+	addr  = flag.String("addr", ":9101", "listen address")
+	check = flag.Bool("check", false, "call self through router and exit")
+)
+
+type Args struct {
+	Msg    string `json:",omitempty"`
+	Server string `json:",omitempty"`
+}
+
+type stats struct {
+	Echo, Shout, Relay, Call, Ping int
+}
+
+var gStats stats
+
+func init() {
+	cmd.Bind(Echo, (*Args)(nil))
+	cmd.Bind(Shout, (*Args)(nil))
+	cmd.Bind(Relay, (*Args)(nil))
+	cmd.Bind(Call, (*Args)(nil))
+	cmd.Bind(Stats, (*Args)(nil))
+
+	cmd.Bind(Ping, (*Args)(nil))
+	cmd.Bind(FUNC_Relay, (*Args)(nil))
+}
+
+func Echo(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	gStats.Echo++
+	ctx.Ok(args)
+}
+
+func Shout(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	gStats.Shout++
+	cmd.Route(cmd.ServerRouter, "C2S_Broadcast", &cmd.Package{Id: "Shout", Body: args})
+}
+
+// 经路由转发，可能由同名服务的其他实例处理
+func Relay(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	cmd.Forward(*name, "FUNC_Relay", args)
+	ctx.Ok(args)
+}
+
+func FUNC_Relay(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	gStats.Relay++
+	log.Debugf("relay %s", args.Msg)
+}
+
+// 同步请求在工作协程中执行，不阻塞主循环
+func Call(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	server := args.Server
+	if server == "" {
+		server = *name
+	}
+	gStats.Call++
+	ctx.Go(func() interface{} {
+		buf, err := cmd.Request(server, "Ping", &Args{Msg: "ping"})
+		if err != nil {
+			return err
+		}
+		return string(buf)
+	}, func(ctx *cmd.Context, result interface{}) {
+		if err, ok := result.(error); ok {
+			ctx.Error(1, err.Error())
+			return
+		}
+		ctx.Ok(map[string]string{"Server": server, "Pong": result.(string)})
+	})
+}
+
+func Stats(ctx *cmd.Context, data interface{}) {
+	ctx.Ok(gStats)
+}
+
+func Ping(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	gStats.Ping++
+	ctx.Ok(args)
+}
+
+// 等待注册完成后请求自身
+func selfCheck() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		time.Sleep(500 * time.Millisecond)
+		var buf []byte
+		if buf, err = cmd.Request(*name, "Ping", &Args{Msg: "check"}); err == nil {
+			log.Infof("self check ok %s", buf)
+			return nil
+		}
+	}
+	return errors.New("self check: " + err.Error())
+}
+
+func main() {
+	flag.Parse()
+
+	srv := &cmd.Server{}
+	cmd.RegisterComponent(&cmd.Component{
+		Name: "listener",
+		Start: func() error {
+			log.Infof("start %s, listen %s", *name, *addr)
+			if err := srv.Listen(&cmd.ListenOptions{Addr: *addr}); err != nil {
+				return err
+			}
+			cmd.RegisterService(&cmd.ServiceConfig{ServerName: *name, ServerAddr: *addr})
+			return nil
+		},
+		StopAccept: srv.Close,
+	})
+	if *check {
+		go func() {
+			if err := selfCheck(); err != nil {
+				log.Error(err)
+				log.Flush()
+				os.Exit(1)
+			}
+			cmd.Shutdown()
+		}()
+	}
+	cmd.Run()
+}
@@ -3,24 +3,100 @@ package main
 import (
 	"github.com/guogeer/husky/cmd"
 	"github.com/guogeer/husky/util"
+	"sort"
 	"time"
 )
 
+const maxVersionBuckets = 20 // 上报的客户端版本数，其余计入other
+
 var (
-	gSessionLocation = map[string]string{}
+	gSessionLocation = cmd.NewSessionLocator(0) // 会话断开时删除
 )
 
 type serverStatus struct {
+	Weight   int
+	Latency  *cmd.LatencyStats
+	Sessions map[string]map[string]int  // 会话分组统计
+	Queues   map[string]*cmd.QueueStats // 写队列统计
+	Labels   map[string]*labelStatus    // 各标签地址的负载
+}
+
+type labelStatus struct {
+	Addr   string
 	Weight int
 }
 
+func sessionAuthState(ss *cmd.Session) string {
+	if ss.Get(cmd.SessionKeyAuth) == true {
+		return "auth"
+	}
+	return "guest"
+}
+
+// 按客户端版本统计，保留会话数最多的版本，避免上报的分组无限增长
+func clientVersionCounts(sm *cmd.SessionManage) map[string]int {
+	counts := sm.CountBy(cmd.SessionKeyClientVersion)
+	if len(counts) <= maxVersionBuckets {
+		return counts
+	}
+
+	versions := make([]string, 0, len(counts))
+	for version := range counts {
+		if version != "none" {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if counts[versions[i]] != counts[versions[j]] {
+			return counts[versions[i]] > counts[versions[j]]
+		}
+		return versions[i] < versions[j]
+	})
+	buckets := make(map[string]int)
+	if n, ok := counts["none"]; ok {
+		buckets["none"] = n
+	}
+	for i, version := range versions {
+		if i < maxVersionBuckets-2 {
+			buckets[version] = counts[version]
+		} else {
+			buckets["other"] += counts[version]
+		}
+	}
+	return buckets
+}
+
 // update current online
 func concurrent() {
-	counter := cmd.GetSessionManage().Count()
-	data := serverStatus{Weight: counter}
+	sm := cmd.GetSessionManage()
+	data := serverStatus{
+		Weight:  sm.Count(),
+		Latency: sm.Latency(),
+		Sessions: map[string]map[string]int{
+			"Server":        sm.CountBy(cmd.SessionKeyServer),
+			"Auth":          sm.GroupBy(sessionAuthState),
+			"ClientVersion": clientVersionCounts(sm),
+		},
+		Queues: sm.SendQueueStats(),
+	}
+	labelWeights := sm.CountBy(cmd.SessionKeyLabel)
+	for _, l := range gListens {
+		if l.Label == "" {
+			continue
+		}
+		if data.Labels == nil {
+			data.Labels = make(map[string]*labelStatus)
+		}
+		data.Labels[l.Label] = &labelStatus{Addr: l.publicAddr(), Weight: labelWeights[l.Label]}
+	}
 	cmd.Route(cmd.ServerRouter, "C2S_Concurrent", data)
 }
 
 func init() {
+	gSessionLocation.EnableReplication(true)
+	cmd.SetTraceResolver(func(uid int) string {
+		loc, _ := gSessionLocation.GetByUId(uid)
+		return loc.Ssid
+	})
 	util.NewPeriodTimer(concurrent, "2001-01-01", 10*time.Second)
 }
@@ -9,32 +9,81 @@ import (
 
 type Args struct {
 	Id, ServerName string
+	AppId          string
 
 	UId  int
 	Data json.RawMessage
 
-	Name string
+	Name     string
+	Compress bool // 回复数据较大时压缩
+
+	Messages []Args // 批量回复
 }
 
 func init() {
 	cmd.Bind(FUNC_Route, (*Args)(nil))
+	cmd.Bind(FUNC_RouteBatch, (*Args)(nil))
+	cmd.RegisterFeature(cmd.FeatureRouteBatch)
 	cmd.Bind(FUNC_Broadcast, (*Args)(nil))
 	cmd.Bind(FUNC_ServerClose, (*Args)(nil))
+	cmd.Bind(FUNC_ServerExpire, (*cmd.ServerInfo)(nil))
+	cmd.Bind(FUNC_ServerDrain, (*cmd.ServerDrainArgs)(nil))
 	cmd.Bind(FUNC_HelloGateway, (*Args)(nil))
 
 	cmd.Bind(HeartBeat, (*Args)(nil))
-	cmd.Bind(FUNC_Close, (*Args)(nil))
+	cmd.OnDisconnect(onDisconnect)
+	cmd.OnResume(onResume)
+	cmd.OnSessionBind(onSessionBind)
+	cmd.OnAuth(onTokenAuth)
 
 	cmd.Bind(FUNC_RegisterServiceInGateway, (*Args)(nil))
+
+	cmd.Bind(FUNC_JoinGroup, (*cmd.GroupArgs)(nil))
+	cmd.Bind(FUNC_LeaveGroup, (*cmd.GroupArgs)(nil))
+	cmd.Bind(FUNC_BroadcastGroup, (*cmd.GroupArgs)(nil))
 }
 
-func FUNC_Close(ctx *cmd.Context, data interface{}) {
+func onDisconnect(ctx *cmd.Context) {
 	log.Debugf("session close %s", ctx.Ssid)
-	if serverName, ok := gSessionLocation[ctx.Ssid]; ok {
+	cmd.GetGroupManage().LeaveAll(ctx.Ssid)
+	if loc, ok := gSessionLocation.Get(ctx.Ssid); ok {
+		// 会话已删除，使用记录的版本路由
 		ss := &cmd.Session{Id: ctx.Ssid, Out: ctx.Out}
-		ss.Route(serverName, "Close", struct{}{})
-		delete(gSessionLocation, ctx.Ssid)
+		ss.SetServerVersion(loc.ServerName, loc.ServerVersion)
+		ss.Route(loc.ServerName, "Close", struct{}{})
+		gSessionLocation.Delete(ctx.Ssid)
+	}
+}
+
+// 断线重连，通知会话所在的服务
+func onResume(ctx *cmd.Context) {
+	log.Debugf("session resume %s", ctx.Ssid)
+	if loc, ok := gSessionLocation.Get(ctx.Ssid); ok {
+		if ss := cmd.GetSession(ctx.Ssid); ss != nil {
+			ss.Route(loc.ServerName, "Resume", struct{}{})
+		}
+	}
+}
+
+// 会话首次路由至服务，同步至路由
+func onSessionBind(ss *cmd.Session, serverName string) {
+	gSessionLocation.Bind(ss.Id, serverName)
+}
+
+// 携带令牌连接的会话已登录，记录账号
+func onTokenAuth(ctx *cmd.Context) {
+	ss := cmd.GetSession(ctx.Ssid)
+	if ss == nil {
+		return
+	}
+	claims := ss.Claims()
+	if claims == nil {
+		return
+	}
+	if !checkMaintenanceAccount(ss.Out, claims.UId) {
+		return
 	}
+	gSessionLocation.Set(cmd.SessionLocation{Ssid: ss.Id, UId: claims.UId})
 }
 
 func FUNC_HelloGateway(ctx *cmd.Context, data interface{}) {
@@ -44,9 +93,26 @@ func FUNC_HelloGateway(ctx *cmd.Context, data interface{}) {
 
 	ip := "UNKNOW"
 	if ss := cmd.GetSession(ctx.Ssid); ss != nil {
+		if !checkMaintenanceAccount(ss.Out, uid) {
+			return
+		}
 		addr := ss.Out.RemoteAddr()
 		log.Debug("hello gateway", addr)
-		gSessionLocation[ctx.Ssid] = args.ServerName
+		gSessionLocation.Set(cmd.SessionLocation{
+			Ssid:          ctx.Ssid,
+			UId:           uid,
+			ServerName:    args.ServerName,
+			ServerVersion: ss.GetServerVersion(args.ServerName),
+		})
+		ss.Set(cmd.SessionKeyServer, args.ServerName)
+		if uid != 0 {
+			ss.Set(cmd.SessionKeyUId, uid)
+		}
+		// 登录服务确认会话所属应用
+		if args.AppId != "" {
+			ss.SetAppId(args.AppId)
+		}
+		ss.SetAuth()
 		if host, _, err := net.SplitHostPort(addr); err == nil {
 			ip = host
 		}
@@ -61,24 +127,58 @@ func FUNC_Route(ctx *cmd.Context, data interface{}) {
 	if ss := cmd.GetSession(ctx.Ssid); ss != nil {
 		// client := ctx.Out.(*cmd.Client)
 		// id := fmt.Sprintf("%s.%s", client.ServerName(), args.Id)
+		writeRoute(ss, args)
+	}
+}
+
+// 按顺序下发服务的批量回复
+func FUNC_RouteBatch(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	if ss := cmd.GetSession(ctx.Ssid); ss != nil {
+		for i := range args.Messages {
+			writeRoute(ss, &args.Messages[i])
+		}
+	}
+}
+
+func writeRoute(ss *cmd.Session, args *Args) {
+	if args.Compress {
+		ss.Out.WriteJSON(args.Id, cmd.CompressBody(ss.Out, args.Data))
+	} else {
 		ss.Out.WriteJSON(args.Id, args.Data)
 	}
 }
 
+// 仅广播至同一应用的会话
 func FUNC_Broadcast(ctx *cmd.Context, data interface{}) {
 	args := data.(*Args)
 	for _, ss := range cmd.GetSessionList() {
-		ss.Out.WriteJSON(args.Id, args.Data)
+		if ss.AppId() == args.AppId {
+			cmd.WriteBroadcast(ss.Out, args.Id, args.Data)
+		}
 	}
 }
 
 func FUNC_ServerClose(ctx *cmd.Context, data interface{}) {
 	for _, ss := range cmd.GetSessionList() {
-		client := ctx.Out.(*cmd.Client)
+		client := ctx.Out.(cmd.ServiceConn)
 		ss.Out.WriteJSON("ServerClose", map[string]string{"ServerName": client.ServerName()})
 	}
 }
 
+// 服务注册租约过期，路由不再转发至该实例
+func FUNC_ServerExpire(ctx *cmd.Context, data interface{}) {
+	info := data.(*cmd.ServerInfo)
+	log.Warnf("server %s %s lease expired", info.Name, info.Addr)
+}
+
+// 服务实例下线，新会话改为绑定其他实例
+func FUNC_ServerDrain(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.ServerDrainArgs)
+	log.Infof("server %s %s drain %v", args.Name, args.Addr, args.IsDrain)
+	cmd.SetServerDrain(args.Addr, args.IsDrain)
+}
+
 func HeartBeat(ctx *cmd.Context, data interface{}) {
 	ctx.Out.WriteJSON("HeartBeat", struct{}{})
 }
@@ -87,3 +187,22 @@ func FUNC_RegisterServiceInGateway(ctx *cmd.Context, data interface{}) {
 	args := data.(*Args)
 	cmd.RegisterServiceInGateway(args.Name)
 }
+
+func FUNC_JoinGroup(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.GroupArgs)
+	if ss := cmd.GetSession(args.Ssid); ss != nil {
+		cmd.GetGroupManage().Join(ss.AppId(), args.Group, args.Ssid)
+	}
+}
+
+func FUNC_LeaveGroup(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.GroupArgs)
+	if ss := cmd.GetSession(args.Ssid); ss != nil {
+		cmd.GetGroupManage().Leave(ss.AppId(), args.Group, args.Ssid)
+	}
+}
+
+func FUNC_BroadcastGroup(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.GroupArgs)
+	cmd.GetGroupManage().Broadcast(args.AppId, args.Group, args.Id, args.Data)
+}
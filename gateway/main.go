@@ -4,33 +4,65 @@ import (
 	"flag"
 	"fmt"
 	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
 	"github.com/guogeer/husky/log"
 	"github.com/guogeer/husky/util"
-	"net/http"
+	"net"
 	"runtime"
 )
 
 var port = flag.Int("port", 8201, "gateway server port")
 var proxy = flag.String("proxy", "", "gateway server proxy addr")
 
+// 多地址监听，按线路等标签区分，未配置时使用命令行参数
+//   <Gateway>
+//     <Listen Addr=":8201" Proxy="1.1.1.1" Label="telecom"/>
+//     <Listen Addr=":8202" Proxy="2.2.2.2" Label="unicom"/>
+//   </Gateway>
+type listenConfig struct {
+	Addr  string
+	Proxy string // 客户端连接的地址，为空时由路由使用网关的地址
+	Label string
+}
+
+type gatewayConfig struct {
+	Listens []listenConfig `config:"Listen"`
+}
+
+var gListens []listenConfig
+
+// 客户端连接的地址
+func (l listenConfig) publicAddr() string {
+	_, port, _ := net.SplitHostPort(l.Addr)
+	return net.JoinHostPort(l.Proxy, port)
+}
+
 func main() {
 	flag.Parse()
-	log.Infof("start gateway, listen %d", *port)
-	addr := fmt.Sprintf("%s:%d", *proxy, *port)
-	cfg := &cmd.ServiceConfig{
+	var cfg gatewayConfig
+	if err := config.Unmarshal("Gateway", &cfg); err != nil {
+		log.Fatalf("load gateway config %v", err)
+	}
+	gListens = cfg.Listens
+	if len(gListens) == 0 {
+		gListens = []listenConfig{{Addr: fmt.Sprintf(":%d", *port), Proxy: *proxy}}
+	}
+
+	svc := &cmd.ServiceConfig{
 		ServerName: "ws_gateway",
-		ServerAddr: addr,
+		ServerAddr: gListens[0].publicAddr(),
 		ServerType: "gateway",
 	}
-	cmd.RegisterService(cfg)
+	cmd.RegisterService(svc)
 
-	addr = fmt.Sprintf(":%d", *port)
-	http.HandleFunc("/ws", cmd.ServeWs)
-	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
+	srv := &cmd.Server{}
+	for _, l := range gListens {
+		log.Infof("start gateway, listen %s label %s", l.Addr, l.Label)
+		opts := &cmd.ListenOptions{Addr: l.Addr, Transport: cmd.TransportWs, External: true, Label: l.Label}
+		if err := srv.Listen(opts); err != nil {
 			log.Fatal(err)
 		}
-	}()
+	}
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -40,6 +72,7 @@ func main() {
 			log.Error(err)
 			log.Errorf("%s", buf)
 		}
+		log.Flush()
 	}()
 
 	for {
@@ -0,0 +1,172 @@
+package main
+
+// 维护模式
+// 开启后新连接收到Maintenance消息后断开，白名单IP不受影响；
+// 配置了白名单账号时，非白名单IP的连接可登录，登录账号不在白名单中或LoginTimeout（秒，默认30）内未登录时断开
+// 指定延迟时，截止前向在线会话广播倒计时通知MaintenanceNotice
+//   ADMIN_SetMaintenance {"Enable":true,"Delay":600,"Message":"...","IPs":["1.2.3.4"],"UIds":[1001]}
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"net"
+	"time"
+)
+
+// 截止前剩余秒数为以下值时广播通知
+var maintenanceNotices = []int{600, 300, 60, 30, 10}
+
+type maintenanceArgs struct {
+	Enable       bool
+	Delay        int    // 延迟开启，秒
+	Message      string `json:",omitempty"`
+	IPs          []string
+	UIds         []int
+	LoginTimeout int `json:",omitempty"` // 维护期间等待白名单账号登录的时限，秒
+}
+
+type maintenanceNotice struct {
+	Seconds int    // 剩余秒数，0表示已开始维护
+	Message string `json:",omitempty"`
+}
+
+type maintenance struct {
+	enable  bool
+	message string
+	ips     map[string]bool
+	uids    map[int]bool
+	timers  []*util.Timer // 倒计时通知
+
+	loginTimeout time.Duration
+	logins       map[cmd.Conn]*util.Timer // 等待登录的连接
+}
+
+const defaultMaintenanceLoginTimeout = 30 * time.Second
+
+var gMaintenance = &maintenance{logins: make(map[cmd.Conn]*util.Timer)}
+
+func init() {
+	cmd.BindAdmin("ADMIN_SetMaintenance", ADMIN_SetMaintenance, (*maintenanceArgs)(nil))
+	cmd.OnConnect(onMaintenanceConnect)
+	cmd.OnDisconnect(onMaintenanceDisconnect)
+}
+
+func (m *maintenance) Set(args *maintenanceArgs) {
+	for _, t := range m.timers {
+		util.StopTimer(t)
+	}
+	m.timers = nil
+	for out := range m.logins {
+		m.stopLoginTimer(out)
+	}
+	m.loginTimeout = defaultMaintenanceLoginTimeout
+	if args.LoginTimeout > 0 {
+		m.loginTimeout = time.Duration(args.LoginTimeout) * time.Second
+	}
+	m.message = args.Message
+	m.ips = make(map[string]bool)
+	for _, ip := range args.IPs {
+		m.ips[ip] = true
+	}
+	m.uids = make(map[int]bool)
+	for _, uid := range args.UIds {
+		m.uids[uid] = true
+	}
+
+	if !args.Enable {
+		m.enable = false
+		return
+	}
+	if args.Delay <= 0 {
+		m.start()
+		return
+	}
+
+	m.notify(args.Delay)
+	for _, secs := range maintenanceNotices {
+		if secs >= args.Delay {
+			continue
+		}
+		secs := secs
+		t := util.NewTimer(func() { m.notify(secs) }, time.Duration(args.Delay-secs)*time.Second)
+		m.timers = append(m.timers, t)
+	}
+	m.timers = append(m.timers, util.NewTimer(m.start, time.Duration(args.Delay)*time.Second))
+}
+
+func (m *maintenance) start() {
+	log.Infof("gateway maintenance start")
+	m.enable = true
+	m.notify(0)
+}
+
+func (m *maintenance) notify(secs int) {
+	notice := &maintenanceNotice{Seconds: secs, Message: m.message}
+	for _, ss := range cmd.GetSessionList() {
+		ss.Out.WriteJSON("MaintenanceNotice", notice)
+	}
+}
+
+func (m *maintenance) isAllowedIP(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return m.ips[host]
+}
+
+func (m *maintenance) reject(out cmd.Conn) {
+	out.WriteJSON("Maintenance", &maintenanceNotice{Message: m.message})
+	out.Close()
+}
+
+// 超时未登录白名单账号时断开
+func (m *maintenance) startLoginTimer(out cmd.Conn) {
+	m.logins[out] = util.NewTimer(func() {
+		delete(m.logins, out)
+		log.Debugf("maintenance login timeout %s", out.RemoteAddr())
+		m.reject(out)
+	}, m.loginTimeout)
+}
+
+func (m *maintenance) stopLoginTimer(out cmd.Conn) {
+	if t, ok := m.logins[out]; ok {
+		util.StopTimer(t)
+		delete(m.logins, out)
+	}
+}
+
+func onMaintenanceConnect(ctx *cmd.Context) {
+	m := gMaintenance
+	if !m.enable || m.isAllowedIP(ctx.Out.RemoteAddr()) {
+		return
+	}
+	// 等待登录后校验账号
+	if len(m.uids) > 0 {
+		m.startLoginTimer(ctx.Out)
+		return
+	}
+	m.reject(ctx.Out)
+}
+
+func onMaintenanceDisconnect(ctx *cmd.Context) {
+	gMaintenance.stopLoginTimer(ctx.Out)
+}
+
+// 登录后校验账号，返回false时已断开连接
+func checkMaintenanceAccount(out cmd.Conn, uid int) bool {
+	m := gMaintenance
+	m.stopLoginTimer(out)
+	if !m.enable || m.isAllowedIP(out.RemoteAddr()) || m.uids[uid] {
+		return true
+	}
+	m.reject(out)
+	return false
+}
+
+func ADMIN_SetMaintenance(ctx *cmd.Context, data interface{}) {
+	args := data.(*maintenanceArgs)
+	log.Infof("set maintenance %v delay %d", args.Enable, args.Delay)
+	gMaintenance.Set(args)
+}
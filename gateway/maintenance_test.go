@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/util"
+	"testing"
+	"time"
+)
+
+type maintenanceConn struct {
+	addr   string
+	names  []string
+	closed bool
+}
+
+func (c *maintenanceConn) Write([]byte) error { return nil }
+func (c *maintenanceConn) RemoteAddr() string { return c.addr }
+func (c *maintenanceConn) Close()             { c.closed = true }
+
+func (c *maintenanceConn) WriteJSON(name string, i interface{}) error {
+	c.names = append(c.names, name)
+	return nil
+}
+
+func TestMaintenance(t *testing.T) {
+	start := time.Now()
+	clock := util.NewVirtualClock(start)
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+	defer gMaintenance.Set(&maintenanceArgs{})
+
+	online := &maintenanceConn{addr: "10.0.0.1:1000"}
+	cmd.GetSessionManage().Add(&cmd.Session{Id: "maintenance-online", Out: online})
+	defer cmd.GetSessionManage().Del("maintenance-online")
+
+	m := gMaintenance
+	m.Set(&maintenanceArgs{Enable: true, Delay: 120, IPs: []string{"1.2.3.4"}, UIds: []int{1001}})
+	// 剩余60/30/10秒时通知，120秒后开始
+	var expires []time.Duration
+	for _, timer := range m.timers {
+		expires = append(expires, timer.Expire().Sub(start))
+	}
+	if len(expires) != 4 || expires[0] != 60*time.Second || expires[1] != 90*time.Second ||
+		expires[2] != 110*time.Second || expires[3] != 120*time.Second {
+		t.Fatal("timers", expires)
+	}
+	clock.Advance(100 * time.Second)
+	if m.enable || len(online.names) != 3 {
+		t.Fatal("notice before start", m.enable, online.names)
+	}
+	clock.Advance(20 * time.Second)
+	if !m.enable || len(online.names) != 5 {
+		t.Fatal("start", m.enable, online.names)
+	}
+
+	connect := func(addr string) *maintenanceConn {
+		c := &maintenanceConn{addr: addr}
+		onMaintenanceConnect(&cmd.Context{Out: c})
+		return c
+	}
+	// 白名单IP不受影响
+	ipAllowed := connect("1.2.3.4:1000")
+	if ipAllowed.closed || len(m.logins) != 0 || !checkMaintenanceAccount(ipAllowed, 1) {
+		t.Error("ip whitelist")
+	}
+	// 白名单账号登录后不再超时断开
+	uidAllowed, uidDenied, noLogin, quit := connect("5.6.7.8:1000"), connect("5.6.7.8:1001"), connect("5.6.7.8:1002"), connect("5.6.7.8:1003")
+	if len(m.logins) != 4 {
+		t.Fatal("login timers", len(m.logins))
+	}
+	if !checkMaintenanceAccount(uidAllowed, 1001) || uidAllowed.closed {
+		t.Error("uid whitelist")
+	}
+	if checkMaintenanceAccount(uidDenied, 1002) || !uidDenied.closed {
+		t.Error("uid not in whitelist")
+	}
+	onMaintenanceDisconnect(&cmd.Context{Out: quit})
+	if len(m.logins) != 1 {
+		t.Fatal("login timers", len(m.logins))
+	}
+	clock.Advance(defaultMaintenanceLoginTimeout)
+	if !noLogin.closed || uidAllowed.closed || quit.closed || len(m.logins) != 0 {
+		t.Error("login timeout", noLogin.closed, uidAllowed.closed, quit.closed)
+	}
+
+	// 未配置白名单账号时直接断开
+	m.Set(&maintenanceArgs{Enable: true})
+	if c := connect("5.6.7.8:1004"); !c.closed || len(c.names) != 1 || c.names[0] != "Maintenance" {
+		t.Error("reject", c.closed, c.names)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+// 压测工具，模拟客户端连接网关并按比例发送消息，统计吞吐量及延迟分位数
+//   husky-bench -addr ws://127.0.0.1:8201/ws -n 1000 -d 30s -mix "HeartBeat:8,hall.Enter=hall.Enter:2"
+// 消息格式为 消息ID[=期望的回复ID]:权重，未指定回复ID时等待同名回复
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/guogeer/husky/cmd"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	addr     = flag.String("addr", "ws://127.0.0.1:8201/ws", "gateway websocket address")
+	conns    = flag.Int("n", 100, "number of client connections")
+	duration = flag.Duration("d", 30*time.Second, "test duration")
+	mix      = flag.String("mix", "HeartBeat:1", "message mix, id[=expect]:weight,...")
+	data     = flag.String("data", "{}", "message data")
+	rate     = flag.Int("rate", 0, "messages per second per connection, 0 unlimited")
+	timeout  = flag.Duration("timeout", 5*time.Second, "response timeout")
+)
+
+var errTimeout = errors.New("timeout")
+
+type benchMessage struct {
+	Id, Expect string
+	Weight     int
+}
+
+func parseMix(s string) ([]benchMessage, error) {
+	var msgs []benchMessage
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		msg := benchMessage{Weight: 1}
+		if n := strings.LastIndexByte(item, ':'); n >= 0 {
+			w, err := strconv.Atoi(item[n+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight %s", item)
+			}
+			msg.Weight, item = w, item[:n]
+		}
+		msg.Id, msg.Expect = item, item
+		if n := strings.IndexByte(item, '='); n >= 0 {
+			msg.Id, msg.Expect = item[:n], item[n+1:]
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("empty message mix")
+	}
+	return msgs, nil
+}
+
+func pickMessage(msgs []benchMessage, r *rand.Rand) benchMessage {
+	total := 0
+	for _, msg := range msgs {
+		total += msg.Weight
+	}
+	n := r.Intn(total)
+	for _, msg := range msgs {
+		if n < msg.Weight {
+			return msg
+		}
+		n -= msg.Weight
+	}
+	return msgs[0]
+}
+
+type benchResult struct {
+	latencies []time.Duration
+	timeouts  int
+	errors    int
+	mu        sync.Mutex
+}
+
+func (res *benchResult) add(d time.Duration, err error) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	switch err {
+	case nil:
+		res.latencies = append(res.latencies, d)
+	case errTimeout:
+		res.timeouts++
+	default:
+		res.errors++
+	}
+}
+
+type benchClient struct {
+	ws   *websocket.Conn
+	recv chan string
+}
+
+func dial() (*benchClient, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(*addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &benchClient{ws: ws, recv: make(chan string, 64)}
+	go func() {
+		defer close(c.recv)
+		for {
+			_, buf, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			var pkg struct{ Id string }
+			if json.Unmarshal(buf, &pkg) == nil {
+				c.recv <- pkg.Id
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (c *benchClient) request(msg benchMessage) (time.Duration, error) {
+	buf, err := cmd.Encode(&cmd.Package{Id: msg.Id, Data: json.RawMessage(*data)})
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := c.ws.WriteMessage(websocket.TextMessage, buf); err != nil {
+		return 0, err
+	}
+	deadline := time.After(*timeout)
+	for {
+		select {
+		case id, ok := <-c.recv:
+			if !ok {
+				return 0, errors.New("connection closed")
+			}
+			if id == msg.Expect {
+				return time.Since(start), nil
+			}
+		case <-deadline:
+			return 0, errTimeout
+		}
+	}
+}
+
+func runClient(k int, msgs []benchMessage, stop time.Time, res *benchResult) {
+	c, err := dial()
+	if err != nil {
+		res.add(0, err)
+		return
+	}
+	defer c.ws.Close()
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(k)))
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Second / time.Duration(*rate)
+	}
+	for next := time.Now(); time.Now().Before(stop); next = next.Add(interval) {
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
+		d, err := c.request(pickMessage(msgs, r))
+		res.add(d, err)
+		if err != nil && err != errTimeout {
+			return
+		}
+	}
+}
+
+func percentile(a []time.Duration, p int) time.Duration {
+	if len(a) == 0 {
+		return 0
+	}
+	n := len(a) * p / 100
+	if n >= len(a) {
+		n = len(a) - 1
+	}
+	return a[n]
+}
+
+func main() {
+	flag.Parse()
+	msgs, err := parseMix(*mix)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	res := &benchResult{}
+	start := time.Now()
+	stop := start.Add(*duration)
+	var wg sync.WaitGroup
+	for k := 0; k < *conns; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			runClient(k, msgs, stop, res)
+		}(k)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	a := res.latencies
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	fmt.Printf("connections %d, duration %v\n", *conns, elapsed)
+	fmt.Printf("requests %d, timeouts %d, errors %d\n", len(a), res.timeouts, res.errors)
+	fmt.Printf("throughput %.1f req/s\n", float64(len(a))/elapsed.Seconds())
+	fmt.Printf("latency p50 %v, p90 %v, p99 %v, max %v\n",
+		percentile(a, 50), percentile(a, 90), percentile(a, 99), percentile(a, 100))
+}
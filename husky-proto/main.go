@@ -0,0 +1,206 @@
+package main
+
+// 协议工具
+// 导出：从各服务的性能分析接口/debug/protocol拉取协议描述，合并后写入文件
+//   husky-proto -url http://127.0.0.1:6060/debug/protocol,http://127.0.0.1:6061/debug/protocol -token xx -o protocol.json
+// 一致性测试：按协议描述构造每个消息的数据发送至网关，收到消息ID或参数错误时失败
+//   husky-proto -check -addr ws://127.0.0.1:8201/ws -proto protocol.json
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/guogeer/husky/cmd"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	urls    = flag.String("url", "", "service protocol urls, separated by comma")
+	token   = flag.String("token", "", "profiling token")
+	output  = flag.String("o", "protocol.json", "output file")
+	check   = flag.Bool("check", false, "run conformance test")
+	addr    = flag.String("addr", "ws://127.0.0.1:8201/ws", "gateway websocket address")
+	proto   = flag.String("proto", "protocol.json", "protocol file")
+	timeout = flag.Duration("timeout", 2*time.Second, "wait error response timeout")
+)
+
+func fetch(url string) (*cmd.Protocol, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s status %s", url, resp.Status)
+	}
+	p := &cmd.Protocol{}
+	if err := json.NewDecoder(resp.Body).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func export() error {
+	var protocols []*cmd.Protocol
+	for _, url := range strings.Split(*urls, ",") {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		p, err := fetch(url)
+		if err != nil {
+			return err
+		}
+		protocols = append(protocols, p)
+	}
+	if len(protocols) == 0 {
+		return errors.New("empty protocol url")
+	}
+	buf, err := json.MarshalIndent(protocols, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*output, buf, 0644)
+}
+
+// 按类型构造数据，字段均为零值
+func sample(p *cmd.Protocol, typ string, depth int) interface{} {
+	switch {
+	case typ == "int", typ == "float":
+		return 0
+	case typ == "string", typ == "bytes":
+		return ""
+	case typ == "bool":
+		return false
+	case typ == "any":
+		return nil
+	case strings.HasPrefix(typ, "[]"):
+		return []interface{}{}
+	case strings.HasPrefix(typ, "map["):
+		return map[string]interface{}{}
+	}
+
+	obj := make(map[string]interface{})
+	if depth > 8 {
+		return obj // 递归类型
+	}
+	for _, f := range p.Types[typ] {
+		if !f.Optional {
+			obj[f.Name] = sample(p, f.Type, depth+1)
+		}
+	}
+	return obj
+}
+
+type checkResult struct {
+	Id  string
+	Err error
+}
+
+// 发送消息，等待超时未收到对应的错误回复时通过
+func checkMessage(id string, data interface{}) error {
+	ws, _, err := websocket.DefaultDialer.Dial(*addr, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	buf, err := cmd.Encode(&cmd.Package{Id: id, Data: body})
+	if err != nil {
+		return err
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, buf); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		ws.SetReadDeadline(deadline)
+		_, buf, err := ws.ReadMessage()
+		if err != nil {
+			return nil // 超时
+		}
+		var pkg cmd.Package
+		if json.Unmarshal(buf, &pkg) != nil || pkg.Id != "S2C_Error" {
+			continue
+		}
+		var e cmd.ErrorPackage
+		if json.Unmarshal(pkg.Data, &e) != nil || e.Request != id {
+			continue
+		}
+		switch e.Code {
+		case cmd.ErrCodeInvalidMessage, cmd.ErrCodeInvalidArgs, cmd.ErrCodeUnroutable:
+			return fmt.Errorf("code %d %s", e.Code, e.Msg)
+		}
+		return nil
+	}
+}
+
+func conformance() error {
+	buf, err := ioutil.ReadFile(*proto)
+	if err != nil {
+		return err
+	}
+	var protocols []*cmd.Protocol
+	if err := json.Unmarshal(buf, &protocols); err != nil {
+		return err
+	}
+
+	var results []checkResult
+	for _, p := range protocols {
+		for _, msg := range p.Messages {
+			id := msg.Id
+			if !p.Gateway && p.Server != "" {
+				id = p.Server + "." + id
+			}
+			data := interface{}(map[string]interface{}{})
+			if msg.Type != "" {
+				data = sample(p, msg.Type, 0)
+			}
+			results = append(results, checkResult{Id: id, Err: checkMessage(id, data)})
+		}
+	}
+
+	fails := 0
+	for _, res := range results {
+		if res.Err != nil {
+			fails++
+			fmt.Printf("FAIL %s: %v\n", res.Id, res.Err)
+		} else {
+			fmt.Printf("ok   %s\n", res.Id)
+		}
+	}
+	fmt.Printf("messages %d, failures %d\n", len(results), fails)
+	if fails > 0 {
+		return errors.New("conformance test failed")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	run := export
+	if *check {
+		run = conformance
+	}
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+// 路由运维工具，直连路由发送管理消息
+//   huskyctl -addr 127.0.0.1:9003 servers                  已注册的网关及服务
+//   huskyctl addr hall                                     查询服务地址
+//   huskyctl route hall FUNC_Test '{"Msg":"hi"}'           经路由转发消息至服务
+//   huskyctl broadcast Notice '{"Msg":"hi"}'               广播至全部网关的会话
+//   huskyctl drain hall | huskyctl undrain 127.0.0.1:9010  按名称或地址下线、恢复
+//   huskyctl stats -f 5s -n 10 -by bytes                   路由处理的消息统计
+//   huskyctl gateway weight 127.0.0.1:8201 0|reset         固定网关负载，0时排空
+//   huskyctl gateway block|unblock 127.0.0.1:8201          网关不参与选择
+//   huskyctl gateway pin 10086 127.0.0.1:8201|unpin 10086  测试账号指定网关
+//   huskyctl rollout hall v2=10                            10%的账号使用v2，不指定版本时取消
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/guogeer/husky/cmd"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:9003", "router address")
+	app     = flag.String("app", "", "app id")
+	timeout = flag.Duration("timeout", 3*time.Second, "response timeout")
+)
+
+var errUsage = errors.New(`usage: huskyctl [flags] command [args]
+commands:
+  servers
+  addr <server> [version]
+  route <server> <message> [json]
+  broadcast <message> [json]
+  drain <server|addr>
+  undrain <server|addr>
+  stats [-f interval] [-n N] [-by count|bytes|cost]
+  gateway [weight <addr> <n|reset> | block <addr> | unblock <addr> | pin <uid> <addr> | unpin <uid>]
+  rollout <server> [version=percent ...]`)
+
+type topologyNode struct {
+	Name      string
+	Addr      string
+	Type      string
+	Version   string
+	App       string
+	Weight    int
+	IsDrain   bool
+	IsStale   bool
+	Connected bool
+	SendRate  float64
+	RecvRate  float64
+}
+
+type topology struct {
+	Router   string
+	Gateways []topologyNode
+	Servers  []topologyNode
+}
+
+// 发送消息，expect不为空时等待回复
+func request(name string, args interface{}, expect string) ([]byte, error) {
+	c, err := cmd.DialAdmin(*addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := c.Send(name, args); err != nil {
+		return nil, err
+	}
+	if expect == "" {
+		return nil, nil
+	}
+	return c.Expect(expect, *timeout)
+}
+
+func jsonArg(args []string, i int) (json.RawMessage, error) {
+	if len(args) <= i {
+		return json.RawMessage("{}"), nil
+	}
+	if !json.Valid([]byte(args[i])) {
+		return nil, fmt.Errorf("invalid json %s", args[i])
+	}
+	return json.RawMessage(args[i]), nil
+}
+
+func listServers(args []string) error {
+	buf, err := request("ADMIN_ListServers", struct{}{}, "S2C_ListServers")
+	if err != nil {
+		return err
+	}
+	t := &topology{}
+	if err := json.Unmarshal(buf, t); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDR\tAPP\tVERSION\tWEIGHT\tSTATE\tSEND/s\tRECV/s")
+	for _, nodes := range [][]topologyNode{t.Gateways, t.Servers} {
+		for _, n := range nodes {
+			var states []string
+			if !n.Connected {
+				states = append(states, "disconnected")
+			}
+			if n.IsDrain {
+				states = append(states, "drain")
+			}
+			if n.IsStale {
+				states = append(states, "stale")
+			}
+			if len(states) == 0 {
+				states = append(states, "ok")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%.1f\t%.1f\n", n.Name, n.Addr, n.App, n.Version,
+				n.Weight, strings.Join(states, ","), n.SendRate, n.RecvRate)
+		}
+	}
+	return w.Flush()
+}
+
+func serverAddr(args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	req := map[string]string{"ServerName": args[0], "AppId": *app}
+	if len(args) > 1 {
+		req["ServerVersion"] = args[1]
+	}
+	buf, err := request("C2S_GetServerAddr", req, "S2C_GetServerAddr")
+	if err != nil {
+		return err
+	}
+	var resp struct{ ServerAddr string }
+	if err := json.Unmarshal(buf, &resp); err != nil {
+		return err
+	}
+	if resp.ServerAddr == "" {
+		return fmt.Errorf("server %s not found", args[0])
+	}
+	fmt.Println(resp.ServerAddr)
+	return nil
+}
+
+func route(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	data, err := jsonArg(args, 2)
+	if err != nil {
+		return err
+	}
+	_, err = request("C2S_Route", &cmd.ForwardArgs{ServerList: []string{args[0]}, Name: args[1], Data: data}, "")
+	return err
+}
+
+func broadcast(args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	data, err := jsonArg(args, 1)
+	if err != nil {
+		return err
+	}
+	_, err = request("C2S_Broadcast", &cmd.Package{Id: args[0], Data: data}, "")
+	return err
+}
+
+func drain(isDrain bool) func([]string) error {
+	return func(args []string) error {
+		if len(args) < 1 {
+			return errUsage
+		}
+		req := map[string]interface{}{"IsDrain": isDrain}
+		if strings.Contains(args[0], ":") {
+			req["ServerAddr"] = args[0]
+		} else {
+			req["ServerName"] = args[0]
+		}
+		buf, err := request("ADMIN_Drain", req, "S2C_Drain")
+		if err != nil {
+			return err
+		}
+		var resp struct{ Servers int }
+		if err := json.Unmarshal(buf, &resp); err != nil {
+			return err
+		}
+		if resp.Servers == 0 {
+			return fmt.Errorf("server %s not found", args[0])
+		}
+		fmt.Printf("%d servers updated\n", resp.Servers)
+		return nil
+	}
+}
+
+type gatewayOverrides struct {
+	Weights   map[string]int
+	Blacklist map[string]bool
+	Pins      map[string]string
+}
+
+// 运维设置的网关选择，无参数时列出当前设置
+func gateway(args []string) error {
+	name, expect := "ADMIN_GetGatewayOverrides", "S2C_GetGatewayOverrides"
+	req := map[string]interface{}{}
+	if len(args) > 0 {
+		switch {
+		case args[0] == "weight" && len(args) == 3:
+			name, expect = "ADMIN_SetGatewayWeight", "S2C_SetGatewayWeight"
+			req["ServerAddr"] = args[1]
+			if args[2] == "reset" {
+				req["Reset"] = true
+			} else if n, err := strconv.Atoi(args[2]); err == nil {
+				req["Weight"] = n
+			} else {
+				return errUsage
+			}
+		case (args[0] == "block" || args[0] == "unblock") && len(args) == 2:
+			name, expect = "ADMIN_BlockGateway", "S2C_BlockGateway"
+			req["ServerAddr"] = args[1]
+			req["IsBlock"] = args[0] == "block"
+		case (args[0] == "pin" && len(args) == 3) || (args[0] == "unpin" && len(args) == 2):
+			name, expect = "ADMIN_PinGateway", "S2C_PinGateway"
+			uid, err := strconv.Atoi(args[1])
+			if err != nil {
+				return errUsage
+			}
+			req["UId"] = uid
+			if args[0] == "pin" {
+				req["ServerAddr"] = args[2]
+			}
+		default:
+			return errUsage
+		}
+	}
+
+	buf, err := request(name, req, expect)
+	if err != nil {
+		return err
+	}
+	var resp gatewayOverrides
+	if err := json.Unmarshal(buf, &resp); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tKEY\tVALUE")
+	for addr, n := range resp.Weights {
+		fmt.Fprintf(w, "weight\t%s\t%d\n", addr, n)
+	}
+	for addr := range resp.Blacklist {
+		fmt.Fprintf(w, "block\t%s\t\n", addr)
+	}
+	for uid, addr := range resp.Pins {
+		fmt.Fprintf(w, "pin\t%s\t%s\n", uid, addr)
+	}
+	return w.Flush()
+}
+
+func rollout(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	weights := make(map[string]int)
+	for _, arg := range args[1:] {
+		n := strings.IndexByte(arg, '=')
+		if n <= 0 {
+			return errUsage
+		}
+		percent, err := strconv.Atoi(arg[n+1:])
+		if err != nil {
+			return errUsage
+		}
+		weights[arg[:n]] = percent
+	}
+	req := &cmd.RolloutRule{AppId: *app, ServerName: args[0], Weights: weights}
+	buf, err := request("ADMIN_SetRollout", req, "S2C_SetRollout")
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Err   string
+		Rules []cmd.RolloutRule
+	}
+	if err := json.Unmarshal(buf, &resp); err != nil {
+		return err
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tSERVER\tVERSION\tPERCENT")
+	for _, rule := range resp.Rules {
+		for version, n := range rule.Weights {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", rule.AppId, rule.ServerName, version, n)
+		}
+	}
+	return w.Flush()
+}
+
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	follow := fs.Duration("f", 0, "refresh interval, 0 once")
+	n := fs.Int("n", 20, "top n messages")
+	by := fs.String("by", cmd.MessageStatsByCount, "sort by count, bytes or cost")
+	fs.Parse(args)
+
+	c, err := cmd.DialAdmin(*addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for {
+		if err := c.Send("ADMIN_GetMessageTopN", map[string]interface{}{"N": *n, "By": *by}); err != nil {
+			return err
+		}
+		buf, err := c.Expect("S2C_GetMessageTopN", *timeout)
+		if err != nil {
+			return err
+		}
+		var resp struct{ Messages []cmd.MessageStat }
+		if err := json.Unmarshal(buf, &resp); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\n", time.Now().Format("15:04:05"))
+		fmt.Fprintln(w, "MESSAGE\tCOUNT\tBYTES\tAVG COST")
+		for _, m := range resp.Messages {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%v\n", m.Id, m.Count, m.Bytes, m.AvgCost)
+		}
+		w.Flush()
+		if *follow <= 0 {
+			return nil
+		}
+		time.Sleep(*follow)
+		fmt.Println()
+	}
+}
+
+func main() {
+	flag.Parse()
+	commands := map[string]func([]string) error{
+		"servers":   listServers,
+		"addr":      serverAddr,
+		"route":     route,
+		"broadcast": broadcast,
+		"drain":     drain(true),
+		"undrain":   drain(false),
+		"stats":     stats,
+		"gateway":   gateway,
+		"rollout":   rollout,
+	}
+
+	args := flag.Args()
+	err := errUsage
+	if len(args) > 0 {
+		if f, ok := commands[args[0]]; ok {
+			err = f(args[1:])
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,224 @@
+package log
+
+// 异步写日志，调用方格式化后写入环形缓冲区，由后台协程写文件
+// 缓冲区满时按策略处理：
+//   block 等待写入
+//   drop 丢弃，ERROR及以上级别仍等待
+//   sample 每SampleRate条等待写入1条，其余丢弃
+// 进程退出前调用Flush写入缓冲区中的日志
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	OverflowBlock  = "block"
+	OverflowDrop   = "drop"
+	OverflowSample = "sample"
+)
+
+type AsyncOptions struct {
+	Enable     bool
+	BufferSize int    `default:"8192"`
+	Overflow   string `default:"block"`
+	SampleRate int    `default:"10"`
+}
+
+type logRecord struct {
+	level int
+	t     time.Time
+	line  string
+}
+
+type asyncWriter struct {
+	l    *FileLog
+	opts AsyncOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []logRecord // 环形缓冲区
+	head, n int
+	writing bool // 后台协程正在写入
+	closed  bool
+
+	overflows int // 缓冲区满的次数，用于采样
+	dropped   int // 未写入的丢弃数量
+}
+
+func newAsyncWriter(l *FileLog, opts AsyncOptions) *asyncWriter {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 8192
+	}
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = 1
+	}
+	w := &asyncWriter{l: l, opts: opts, buf: make([]logRecord, opts.BufferSize)}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// 缓冲区满时是否等待写入
+func (w *asyncWriter) waitOnFull(level int) bool {
+	if level >= LError {
+		return true
+	}
+	switch w.opts.Overflow {
+	case OverflowDrop:
+		return false
+	case OverflowSample:
+		w.overflows++
+		return w.overflows%w.opts.SampleRate == 0
+	}
+	return true
+}
+
+func (w *asyncWriter) put(level int, t time.Time, line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n == len(w.buf) && !w.waitOnFull(level) {
+		w.dropped++
+		return
+	}
+	for w.n == len(w.buf) && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return
+	}
+	w.buf[(w.head+w.n)%len(w.buf)] = logRecord{level: level, t: t, line: line}
+	w.n++
+	w.cond.Broadcast()
+}
+
+func (w *asyncWriter) run() {
+	var batch []logRecord
+	for {
+		w.mu.Lock()
+		for w.n == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.n == 0 && w.closed {
+			w.mu.Unlock()
+			return
+		}
+		batch = batch[:0]
+		for ; w.n > 0; w.n-- {
+			batch = append(batch, w.buf[w.head])
+			w.buf[w.head] = logRecord{}
+			w.head = (w.head + 1) % len(w.buf)
+		}
+		dropped := w.dropped
+		w.dropped = 0
+		w.writing = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		if dropped > 0 {
+			now := time.Now()
+			line := formatLine(now, -1, "["+logTags[LWarn]+"] log buffer full, dropped "+strconv.Itoa(dropped)+" lines")
+			w.l.writeLine(now, line)
+		}
+		for _, rec := range batch {
+			w.l.writeLine(rec.t, rec.line)
+		}
+
+		w.mu.Lock()
+		w.writing = false
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// 等待缓冲区中的日志写入完毕
+func (w *asyncWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.n > 0 || w.writing {
+		w.cond.Wait()
+	}
+}
+
+func (w *asyncWriter) close() {
+	w.flush()
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+func (l *FileLog) writeLine(t time.Time, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Path == "" {
+		return
+	}
+	l.rotate(t)
+	if l.f != nil {
+		l.f.WriteString(line)
+	}
+	if enableDebug {
+		os.Stdout.WriteString(line)
+	}
+}
+
+// 与log.LstdFlags|log.Lshortfile格式一致，depth小于0时不记录调用位置
+func formatLine(t time.Time, depth int, s string) string {
+	b := make([]byte, 0, 64+len(s))
+	b = t.AppendFormat(b, "2006/01/02 15:04:05 ")
+	if depth >= 0 {
+		_, file, line, ok := runtime.Caller(depth)
+		if !ok {
+			file, line = "???", 0
+		}
+		for i := len(file) - 1; i > 0; i-- {
+			if file[i] == '/' {
+				file = file[i+1:]
+				break
+			}
+		}
+		b = append(b, file...)
+		b = append(b, ':')
+		b = strconv.AppendInt(b, int64(line), 10)
+		b = append(b, ": "...)
+	}
+	b = append(b, s...)
+	if len(s) == 0 || s[len(s)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	return string(b)
+}
+
+// 开启或关闭异步写入，关闭前写入缓冲区中的日志
+func SetAsync(opts AsyncOptions) {
+	l := fileLog
+	l.mu.Lock()
+	old := l.async
+	l.async = nil
+	l.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	if !opts.Enable {
+		return
+	}
+
+	w := newAsyncWriter(l, opts)
+	l.mu.Lock()
+	l.async = w
+	l.mu.Unlock()
+}
+
+// 写入缓冲区中的日志，进程退出前调用
+func Flush() {
+	fileLog.mu.Lock()
+	w := fileLog.async
+	fileLog.mu.Unlock()
+	if w != nil {
+		w.flush()
+	}
+}
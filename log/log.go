@@ -78,6 +78,8 @@ type FileLog struct {
 
 	lines int
 	size  int64
+
+	async *asyncWriter // 开启异步写入时不为空
 }
 
 // 将oldPath移动至newPath并创建新oldPath
@@ -129,12 +131,29 @@ func (l *FileLog) Output(level int, s string) {
 	}
 
 	l.mu.Lock()
-	defer l.mu.Unlock()
 	if level < l.Level || l.Path == "" {
+		l.mu.Unlock()
 		return
 	}
+	// 异步写入时在调用方格式化，保留调用位置
+	if w := l.async; w != nil {
+		l.mu.Unlock()
+		now := time.Now()
+		w.put(level, now, formatLine(now, 3, fmt.Sprintf("[%s] %s", logTags[level], s)))
+		return
+	}
+	defer l.mu.Unlock()
+
+	l.rotate(time.Now())
+	s = fmt.Sprintf("[%s] %s", logTags[level], s)
+	l.logger.Output(3, s)
+	if enableDebug {
+		log.Output(3, s)
+	}
+}
 
-	now := time.Now()
+// 按日期及文件大小切换日志文件
+func (l *FileLog) rotate(now time.Time) {
 	path := updateLogPath(l.Path)
 	newPath := path
 	datePath := fmt.Sprintf("%s.%02d-%02d", path, now.Month(), now.Day())
@@ -167,12 +186,6 @@ func (l *FileLog) Output(level int, s string) {
 	if newPath != path {
 		l.moveFile(datePath, newPath)
 	}
-
-	s = fmt.Sprintf("[%s] %s", logTags[level], s)
-	l.logger.Output(3, s)
-	if enableDebug {
-		log.Output(3, s)
-	}
 }
 
 func (l *FileLog) SetLevel(level int) {
@@ -244,11 +257,13 @@ func Error(v ...interface{}) {
 
 func Fatalf(format string, v ...interface{}) {
 	fileLog.Output(LFatal, fmt.Sprintf(format, v...))
+	Flush()
 	os.Exit(0)
 }
 
 func Fatal(v ...interface{}) {
 	fileLog.Output(LFatal, fmt.Sprintln(v...))
+	Flush()
 	os.Exit(0)
 }
 
@@ -4,6 +4,7 @@ import (
 	"os"
 	// "math/rand"
 	"testing"
+	"time"
 )
 
 func TestUpdateLogPath(t *testing.T) {
@@ -35,3 +36,30 @@ func TestDebug(t *testing.T) {
 		Debugf("%d", i)
 	}
 }
+
+func TestAsyncDrop(t *testing.T) {
+	SetAsync(AsyncOptions{Enable: true, BufferSize: 4, Overflow: OverflowDrop})
+	defer SetAsync(AsyncOptions{})
+	for i := 0; i < 64; i++ {
+		Debugf("async %d", i)
+	}
+	Flush()
+	if w := fileLog.async; w.n != 0 || w.writing {
+		t.Errorf("flush not done: %d", w.n)
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	if s := formatLine(now, -1, "[INFO] hi"); s != "2020/01/02 03:04:05 [INFO] hi\n" {
+		t.Errorf("format line %q", s)
+	}
+}
+
+func TestWith(t *testing.T) {
+	l := With("ssid", "s1").With("msg", "Login")
+	if l.prefix != "ssid=s1 msg=Login " {
+		t.Errorf("prefix %q", l.prefix)
+	}
+	l.Infof("hello %d", 1)
+}
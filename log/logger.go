@@ -0,0 +1,67 @@
+package log
+
+// 附带上下文字段的日志，字段按key、value成对传入
+//   l := log.With("ssid", ssid, "uid", uid)
+//   l.Infof("login") // [INFO] ssid=xx uid=1001 login
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Logger struct {
+	prefix string
+}
+
+func With(fields ...interface{}) *Logger {
+	return (&Logger{}).With(fields...)
+}
+
+// 返回追加字段的子日志
+func (l *Logger) With(fields ...interface{}) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	var b strings.Builder
+	b.WriteString(l.prefix)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			fmt.Fprintf(&b, "%v=%v ", fields[i], fields[i+1])
+		} else {
+			fmt.Fprintf(&b, "%v ", fields[i])
+		}
+	}
+	return &Logger{prefix: b.String()}
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	fileLog.Output(LDebug, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Debug(v ...interface{}) {
+	fileLog.Output(LDebug, l.prefix+fmt.Sprintln(v...))
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	fileLog.Output(LInfo, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Info(v ...interface{}) {
+	fileLog.Output(LInfo, l.prefix+fmt.Sprintln(v...))
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	fileLog.Output(LWarn, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Warn(v ...interface{}) {
+	fileLog.Output(LWarn, l.prefix+fmt.Sprintln(v...))
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	fileLog.Output(LError, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	fileLog.Output(LError, l.prefix+fmt.Sprintln(v...))
+}
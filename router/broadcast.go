@@ -0,0 +1,71 @@
+package main
+
+// 广播合并
+// 高频广播（如排行榜）在合并窗口内仅保留最新的数据，窗口结束时转发一次
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+type broadcastMergeArgs struct {
+	Id     string // 消息ID
+	Window int    // 合并窗口，毫秒。0取消合并
+}
+
+type broadcastMerger struct {
+	windows map[string]time.Duration
+	pending map[string]*cmd.Package
+}
+
+var gBroadcast = &broadcastMerger{
+	windows: make(map[string]time.Duration),
+	pending: make(map[string]*cmd.Package),
+}
+
+func init() {
+	cmd.BindAdmin("ADMIN_SetBroadcastMerge", ADMIN_SetBroadcastMerge, (*broadcastMergeArgs)(nil))
+}
+
+func (bm *broadcastMerger) SetWindow(id string, d time.Duration) {
+	if d > 0 {
+		bm.windows[id] = d
+	} else {
+		delete(bm.windows, id)
+	}
+}
+
+func (bm *broadcastMerger) Broadcast(pkg *cmd.Package) {
+	d := bm.windows[pkg.Id]
+	if d <= 0 {
+		broadcast(pkg)
+		return
+	}
+
+	if _, ok := bm.pending[pkg.Id]; !ok {
+		id := pkg.Id
+		util.NewTimer(func() { bm.flush(id) }, d)
+	}
+	bm.pending[pkg.Id] = pkg
+}
+
+func (bm *broadcastMerger) flush(id string) {
+	if pkg, ok := bm.pending[id]; ok {
+		delete(bm.pending, id)
+		broadcast(pkg)
+	}
+}
+
+func broadcast(pkg *cmd.Package) {
+	for _, gw := range gRouter.gateways {
+		gw.WriteJSON("FUNC_Broadcast", pkg)
+	}
+}
+
+func ADMIN_SetBroadcastMerge(ctx *cmd.Context, data interface{}) {
+	args := data.(*broadcastMergeArgs)
+	log.Infof("broadcast %s merge window %dms", args.Id, args.Window)
+	gBroadcast.SetWindow(args.Id, time.Duration(args.Window)*time.Millisecond)
+}
@@ -0,0 +1,146 @@
+package main
+
+// 共享配置表
+// 路由定期扫描配置表目录，文件名（不含扩展名）为表名，内容变化时版本递增，
+// 向订阅的服务推送与上一版本的差异
+//   <Router><ConfigTables Dir="tables" Interval="10s"/></Router>
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type configTablesConfig struct {
+	Dir      string        // 为空时不开启
+	Interval time.Duration `default:"10s"` // 扫描间隔
+}
+
+type configTableVersion struct {
+	version  int
+	checksum string
+	data     []byte
+	prev     []byte // 上一版本数据，用于计算差异
+}
+
+type configTableManage struct {
+	dir         string
+	tables      map[string]*configTableVersion
+	subscribers map[string]map[cmd.Conn]bool
+}
+
+var gConfigTables = &configTableManage{
+	tables:      make(map[string]*configTableVersion),
+	subscribers: make(map[string]map[cmd.Conn]bool),
+}
+
+func init() {
+	cmd.Bind(C2S_SubscribeConfigTables, (*cmd.ConfigTableSubscribeArgs)(nil))
+	cmd.BindAdmin("ADMIN_ReloadConfigTables", ADMIN_ReloadConfigTables, (*Args)(nil))
+}
+
+func (cm *configTableManage) Start() {
+	var cfg configTablesConfig
+	if err := config.Unmarshal("Router.ConfigTables", &cfg); err != nil {
+		log.Errorf("load config tables config %v", err)
+	}
+	if cfg.Dir == "" {
+		return
+	}
+	cm.dir = cfg.Dir
+	cm.Load()
+	util.NewPeriodTimer(cm.Reload, "2001-01-01", cfg.Interval)
+}
+
+// 加载目录中的配置表，返回变化的表名
+func (cm *configTableManage) Load() []string {
+	if cm.dir == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(cm.dir)
+	if err != nil {
+		log.Errorf("load config tables %v", err)
+		return nil
+	}
+
+	var changed []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cm.dir, f.Name()))
+		if err != nil {
+			log.Errorf("load config table %s %v", f.Name(), err)
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		checksum := cmd.ConfigTableChecksum(data)
+		t, ok := cm.tables[name]
+		if ok && t.checksum == checksum {
+			continue
+		}
+		if !ok {
+			t = &configTableVersion{}
+			cm.tables[name] = t
+		}
+		t.prev = t.data
+		t.data, t.checksum = data, checksum
+		t.version++
+		changed = append(changed, name)
+		log.Infof("config table %s version %d", name, t.version)
+	}
+	return changed
+}
+
+// 推送变化的配置表
+func (cm *configTableManage) Reload() {
+	for _, name := range cm.Load() {
+		t := cm.tables[name]
+		for out := range cm.subscribers[name] {
+			cmd.WriteConfigTable(out, name, t.version, t.data, t.prev)
+		}
+	}
+}
+
+// 按服务当前的校验值下发差异或完整数据
+func (cm *configTableManage) Subscribe(out cmd.Conn, tables map[string]string) {
+	for name, checksum := range tables {
+		subs, ok := cm.subscribers[name]
+		if !ok {
+			subs = make(map[cmd.Conn]bool)
+			cm.subscribers[name] = subs
+		}
+		subs[out] = true
+
+		t, ok := cm.tables[name]
+		if !ok || t.checksum == checksum {
+			continue
+		}
+		var base []byte
+		if checksum != "" && t.prev != nil && cmd.ConfigTableChecksum(t.prev) == checksum {
+			base = t.prev
+		}
+		cmd.WriteConfigTable(out, name, t.version, t.data, base)
+	}
+}
+
+func (cm *configTableManage) Remove(out cmd.Conn) {
+	for _, subs := range cm.subscribers {
+		delete(subs, out)
+	}
+}
+
+func C2S_SubscribeConfigTables(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.ConfigTableSubscribeArgs)
+	gConfigTables.Subscribe(ctx.Out, args.Tables)
+}
+
+// 立即重新加载配置表
+func ADMIN_ReloadConfigTables(ctx *cmd.Context, data interface{}) {
+	gConfigTables.Reload()
+}
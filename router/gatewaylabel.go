@@ -0,0 +1,50 @@
+package main
+
+// 网关多地址，按线路等标签区分
+// 网关随负载上报各标签的地址及会话数，登录服务按客户端的标签查询最优地址
+//   login -> router C2S_GetBestGateway {"Label":"telecom"}
+//   router -> login S2C_GetBestGateway {"Address":"1.1.1.1:8201","Label":"telecom"}
+// 无网关监听该标签时返回默认地址，运维为测试账号指定网关时返回指定的地址
+//   login -> router C2S_GetBestGateway {"Label":"telecom","UId":10086}
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"net"
+)
+
+type gatewayLabel struct {
+	Addr   string
+	Weight int
+}
+
+// 未指定主机的地址使用网关注册的主机
+func (server *Server) reportLabels(labels map[string]*gatewayLabel) {
+	host, _, _ := net.SplitHostPort(server.addr)
+	for _, l := range labels {
+		if h, port, err := net.SplitHostPort(l.Addr); err == nil && h == "" {
+			l.Addr = net.JoinHostPort(host, port)
+		}
+	}
+	server.labels = labels
+}
+
+func sameGateways(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for label, addr := range a {
+		if b[label] != addr {
+			return false
+		}
+	}
+	return true
+}
+
+func C2S_GetBestGateway(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	addr, ok := gRouter.pinnedGateway(args.UId)
+	if !ok {
+		addr = gRouter.GetLabelGateway(args.Label)
+	}
+	ctx.Out.WriteJSON("S2C_GetBestGateway", map[string]interface{}{"Address": addr, "Label": args.Label})
+}
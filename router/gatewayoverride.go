@@ -0,0 +1,136 @@
+package main
+
+// 运维干预网关的选择，按网关地址设置，保存在注册信息中，路由重启后恢复
+// 固定负载：忽略网关上报的负载，为0时排空，仅在无其他可用网关时选择
+// 黑名单：不参与最优网关的选择
+// 测试账号指定网关：登录服务查询最优网关时携带UId，返回指定的网关
+//   huskyctl gateway weight 1.1.1.1:8201 0
+//   huskyctl gateway block 1.1.1.1:8201
+//   huskyctl gateway pin 10086 1.1.1.1:8201
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+)
+
+type gatewayOverrides struct {
+	Weights   map[string]int  `json:",omitempty"` // 网关地址 -> 固定负载
+	Blacklist map[string]bool `json:",omitempty"`
+	Pins      map[int]string  `json:",omitempty"` // 测试账号 -> 网关地址
+}
+
+type gatewayOverrideArgs struct {
+	ServerAddr string
+	Weight     int
+	Reset      bool // 取消固定负载
+	IsBlock    bool
+	UId        int
+}
+
+var gGatewayOverrides = newGatewayOverrides()
+
+func newGatewayOverrides() *gatewayOverrides {
+	return &gatewayOverrides{
+		Weights:   make(map[string]int),
+		Blacklist: make(map[string]bool),
+		Pins:      make(map[int]string),
+	}
+}
+
+func init() {
+	cmd.BindAdmin("ADMIN_SetGatewayWeight", ADMIN_SetGatewayWeight, (*gatewayOverrideArgs)(nil))
+	cmd.BindAdmin("ADMIN_BlockGateway", ADMIN_BlockGateway, (*gatewayOverrideArgs)(nil))
+	cmd.BindAdmin("ADMIN_PinGateway", ADMIN_PinGateway, (*gatewayOverrideArgs)(nil))
+	cmd.BindAdmin("ADMIN_GetGatewayOverrides", ADMIN_GetGatewayOverrides, (*gatewayOverrideArgs)(nil))
+}
+
+// 恢复保存的设置
+func (o *gatewayOverrides) restore(saved *gatewayOverrides) {
+	if saved == nil {
+		return
+	}
+	for addr, weight := range saved.Weights {
+		o.Weights[addr] = weight
+	}
+	for addr, block := range saved.Blacklist {
+		o.Blacklist[addr] = block
+	}
+	for uid, addr := range saved.Pins {
+		o.Pins[uid] = addr
+	}
+}
+
+// 网关参与选择的负载，drain为true时仅在无其他可用网关时选择
+func (o *gatewayOverrides) weight(gw *Server, reported int) (weight int, drain bool) {
+	if w, ok := o.Weights[gw.addr]; ok {
+		return w, w == 0
+	}
+	return reported, false
+}
+
+// 账号指定的网关，网关未连接或已下线时忽略
+func (r *Router) pinnedGateway(uid int) (string, bool) {
+	addr, ok := gGatewayOverrides.Pins[uid]
+	if uid == 0 || !ok {
+		return "", false
+	}
+	if gw := r.gateways[addr]; gw == nil || gw.out == nil {
+		log.Warnf("uid %d pinned gateway %s unavailable", uid, addr)
+		return "", false
+	}
+	return addr, true
+}
+
+// 修改设置，最优网关变化时通知登录服务
+func updateGatewayOverrides(f func(o *gatewayOverrides)) {
+	best := gRouter.bestGateways()
+	f(gGatewayOverrides)
+	gStore.MarkDirty()
+	if current := gRouter.bestGateways(); !sameGateways(best, current) {
+		notifyBestGateway(current)
+	}
+}
+
+func ADMIN_SetGatewayWeight(ctx *cmd.Context, data interface{}) {
+	args := data.(*gatewayOverrideArgs)
+	log.Infof("admin set gateway %s weight %d reset %v", args.ServerAddr, args.Weight, args.Reset)
+	updateGatewayOverrides(func(o *gatewayOverrides) {
+		if args.Reset {
+			delete(o.Weights, args.ServerAddr)
+		} else {
+			o.Weights[args.ServerAddr] = args.Weight
+		}
+	})
+	ctx.Out.WriteJSON("S2C_SetGatewayWeight", gGatewayOverrides)
+}
+
+func ADMIN_BlockGateway(ctx *cmd.Context, data interface{}) {
+	args := data.(*gatewayOverrideArgs)
+	log.Infof("admin block gateway %s %v", args.ServerAddr, args.IsBlock)
+	updateGatewayOverrides(func(o *gatewayOverrides) {
+		if args.IsBlock {
+			o.Blacklist[args.ServerAddr] = true
+		} else {
+			delete(o.Blacklist, args.ServerAddr)
+		}
+	})
+	ctx.Out.WriteJSON("S2C_BlockGateway", gGatewayOverrides)
+}
+
+// 地址为空时取消
+func ADMIN_PinGateway(ctx *cmd.Context, data interface{}) {
+	args := data.(*gatewayOverrideArgs)
+	log.Infof("admin pin uid %d gateway %s", args.UId, args.ServerAddr)
+	updateGatewayOverrides(func(o *gatewayOverrides) {
+		if args.ServerAddr == "" {
+			delete(o.Pins, args.UId)
+		} else {
+			o.Pins[args.UId] = args.ServerAddr
+		}
+	})
+	ctx.Out.WriteJSON("S2C_PinGateway", gGatewayOverrides)
+}
+
+func ADMIN_GetGatewayOverrides(ctx *cmd.Context, data interface{}) {
+	ctx.Out.WriteJSON("S2C_GetGatewayOverrides", gGatewayOverrides)
+}
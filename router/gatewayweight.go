@@ -0,0 +1,65 @@
+package main
+
+// 网关负载过期
+// 网关每10秒通过C2S_Concurrent上报负载，超过Expire未上报时负载视为过期，
+// 不再参与最优网关的选择，全部网关均过期时仍按原负载选择
+//   <Router><GatewayWeight Expire="30s" Interval="5s"/></Router>
+// 过期次数通过/debug/vars中的router_stale_gateways查询
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+type gatewayWeightConfig struct {
+	Expire   time.Duration `default:"30s"`
+	Interval time.Duration `default:"5s"` // 检查间隔
+}
+
+var (
+	gatewayWeightExpire = 30 * time.Second
+	staleGatewayCount   = cmd.NewCounter("router_stale_gateways")
+)
+
+func init() {
+	var cfg gatewayWeightConfig
+	if err := config.Unmarshal("Router.GatewayWeight", &cfg); err != nil {
+		log.Errorf("load gateway weight config %v", err)
+	}
+	if cfg.Expire > 0 {
+		gatewayWeightExpire = cfg.Expire
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	util.NewPeriodTimer(checkGatewayWeights, "2001-01-01", cfg.Interval)
+}
+
+func (server *Server) reportWeight(weight int) {
+	server.weight = weight
+	server.reportTime = time.Now()
+	if server.isStale {
+		server.isStale = false
+		log.Infof("gateway %s report weight again", server.addr)
+	}
+}
+
+// 标记长时间未上报负载的网关，最优网关变化时通知登录服务
+func checkGatewayWeights() {
+	best := gRouter.bestGateways()
+	now := time.Now()
+	for _, gw := range gRouter.gateways {
+		if gw.isStale || now.Sub(gw.reportTime) < gatewayWeightExpire {
+			continue
+		}
+		gw.isStale = true
+		staleGatewayCount.Add(1)
+		log.Warnf("gateway %s weight %d expired, last report %v", gw.addr, gw.weight, gw.reportTime.Format("15:04:05"))
+	}
+	if current := gRouter.bestGateways(); !sameGateways(best, current) {
+		notifyBestGateway(current)
+	}
+}
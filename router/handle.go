@@ -5,6 +5,7 @@ import (
 	"github.com/guogeer/husky/cmd"
 	"github.com/guogeer/husky/log"
 	"net"
+	"time"
 )
 
 type Args struct {
@@ -13,16 +14,36 @@ type Args struct {
 	ServerData json.RawMessage
 	ServerType string
 	Weight     int
+	IsDrain    bool
+
+	ServerVersion   string
+	InstanceId      string
+	AppId           string
+	MessagePrefixes []string
+	Latency         *cmd.LatencyStats
+	Sessions        map[string]map[string]int
+	Queues          map[string]*cmd.QueueStats
+	Labels          map[string]*gatewayLabel
+	Label           string
+	Lease           bool
+	UId             int    // 查询最优网关的账号
+	Ssid            string // 查询服务地址的会话，按会话选择实例
 }
 
 func init() {
 	cmd.Bind(C2S_Register, (*Args)(nil))
 	cmd.Bind(C2S_GetServerAddr, (*Args)(nil))
 	cmd.Bind(C2S_Concurrent, (*Args)(nil))
+	cmd.Bind(C2S_GetBestGateway, (*Args)(nil))
+	cmd.Bind(C2S_Drain, (*Args)(nil))
 	cmd.Bind(C2S_Route, (*cmd.ForwardArgs)(nil))
 
 	cmd.Bind(C2S_Broadcast, (*cmd.Package)(nil))
-	cmd.Bind(FUNC_Close, (*Args)(nil))
+	cmd.Bind(C2S_BroadcastGroup, (*cmd.GroupArgs)(nil))
+	cmd.OnDisconnect(onDisconnect)
+	cmd.BindAdmin("ADMIN_GetGatewayStats", ADMIN_GetGatewayStats, (*Args)(nil))
+	cmd.BindAdmin("ADMIN_ListServers", ADMIN_ListServers, (*Args)(nil))
+	cmd.BindAdmin("ADMIN_Drain", ADMIN_Drain, (*Args)(nil))
 }
 
 // ServerAddr == "" 无服务
@@ -37,19 +58,46 @@ func C2S_Register(ctx *cmd.Context, data interface{}) {
 	if port != "" {
 		addr = host + ":" + port
 	}
-	log.Info("register", args.ServerName, addr)
-	// TODO
-	ctx.Out.WriteJSON("C2S_RegisterOk", struct{}{})
-
+	log.Info("register", args.AppId, args.ServerName, args.ServerVersion, addr)
 	newServer := &Server{
 		out:  ctx.Out,
 		name: args.ServerName,
 		addr: addr,
 		data: args.ServerData,
 		typ:  args.ServerType,
+
+		version: args.ServerVersion,
+		app:     args.AppId,
+	}
+	if newServer.typ != "gateway" {
+		// 实例ID默认为服务地址
+		newServer.instance = args.InstanceId
+		if newServer.instance == "" {
+			newServer.instance = addr
+		}
+		if newServer.instance == "" {
+			newServer.instance = ctx.Out.RemoteAddr()
+		}
+		newServer.weight = args.Weight
+	}
+	var conflicts []string
+	if newServer.typ != "gateway" {
+		conflicts = gNamespaces.Register(newServer.name, newServer.key(), args.MessagePrefixes)
+	}
+	lease := 0
+	if args.Lease {
+		lease = int(leaseTTL / time.Second)
+	}
+	ctx.Out.WriteJSON("C2S_RegisterOk", map[string]interface{}{"Conflicts": conflicts, "Lease": lease})
+
+	if args.Lease {
+		newServer.renewLease()
 	}
 	gRouter.AddServer(newServer)
-	// center server
+	gRegistryWatch.Notify(cmd.RegistryAdd, newServer)
+	// 新服务注册通知，替代下方S2C_AddGame等定制推送
+	gTopics.PublishEvent(newServer.app, "FUNC_ServerAdd", newServerInfo(newServer))
+	// center server，兼容旧版本，新版本使用cmd.WatchRegistry
 	if newServer.typ == "center" {
 		for _, server := range gRouter.servers {
 			ctx.Out.WriteJSON("S2C_AddGame", map[string]interface{}{
@@ -60,7 +108,7 @@ func C2S_Register(ctx *cmd.Context, data interface{}) {
 	}
 	for _, server := range gRouter.servers {
 		if server.typ == "center" && server.name != newServer.name {
-			server.out.WriteJSON("S2C_AddGame", map[string]interface{}{
+			server.WriteJSON("S2C_AddGame", map[string]interface{}{
 				"Name": newServer.name,
 				"Data": newServer.data,
 			})
@@ -69,14 +117,20 @@ func C2S_Register(ctx *cmd.Context, data interface{}) {
 
 	// 向网关注册服务
 	if newServer.typ == "gateway" {
+		gNamespaces.Sync(newServer)
+		syncRollouts(ctx.Out)
+		syncInstances(ctx.Out)
 		for _, server := range gRouter.servers {
 			ctx.Out.WriteJSON("FUNC_RegisterServiceInGateway", map[string]interface{}{
 				"Name": server.name,
 			})
+			if server.isDrain && server.addr != "" {
+				ctx.Out.WriteJSON("FUNC_ServerDrain", cmd.ServerDrainArgs{ServerInfo: newServerInfo(server), IsDrain: true})
+			}
 		}
 	} else if newServer.addr != "" {
 		for _, gw := range gRouter.gateways {
-			gw.out.WriteJSON("FUNC_RegisterServiceInGateway", map[string]interface{}{
+			gw.WriteJSON("FUNC_RegisterServiceInGateway", map[string]interface{}{
 				"Name": newServer.name,
 			})
 		}
@@ -85,18 +139,30 @@ func C2S_Register(ctx *cmd.Context, data interface{}) {
 
 func C2S_GetServerAddr(ctx *cmd.Context, data interface{}) {
 	args := data.(*Args)
-	name := args.ServerName
-	addr := gRouter.GetServerAddr(name)
-	log.Debug("get addr", name, addr)
-	response := map[string]string{"ServerName": name, "ServerAddr": addr}
+	name, version := args.ServerName, args.ServerVersion
+	addr := gRouter.GetServerAddr(args.AppId, name, version, args.Ssid)
+	log.Debug("get addr", args.AppId, name, version, args.Ssid, addr)
+	response := map[string]string{"ServerName": name, "ServerAddr": addr, "ServerVersion": version}
 	ctx.Out.WriteJSON("S2C_GetServerAddr", response)
 }
 
 func C2S_Broadcast(ctx *cmd.Context, data interface{}) {
 	pkg := data.(*cmd.Package)
-	for _, gw := range gRouter.gateways {
-		gw.out.WriteJSON("FUNC_Broadcast", pkg)
-	}
+	// 仅广播至发送方所属应用的会话
+	pkg.AppId = connApp(ctx.Out)
+	gQuota.Forward(ctx, len(pkg.Data), func() { gBroadcast.Broadcast(pkg) })
+}
+
+// 分组广播，由网关发送至分组内的会话
+func C2S_BroadcastGroup(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.GroupArgs)
+	// 仅广播至发送方所属应用的分组
+	args.AppId = connApp(ctx.Out)
+	gQuota.Forward(ctx, len(args.Data), func() {
+		for _, gw := range gRouter.gateways {
+			gw.WriteJSON("FUNC_BroadcastGroup", args)
+		}
+	})
 }
 
 // 更新网关负载
@@ -105,25 +171,81 @@ func C2S_Concurrent(ctx *cmd.Context, data interface{}) {
 	for _, gw := range gRouter.gateways {
 		if gw.out == ctx.Out {
 			// log.Debug("test ", gw.addr, gw.weight)
-			gw.weight = args.Weight
+			if gw.weight != args.Weight {
+				gStore.MarkDirty()
+			}
+			gw.reportWeight(args.Weight)
+			if !gw.leaseExpire.IsZero() {
+				gw.renewLease()
+			}
+			gw.latency = args.Latency
+			gw.sessions = args.Sessions
+			gw.queues = args.Queues
+			gw.reportLabels(args.Labels)
 		}
 	}
 
-	addr := gRouter.GetBestGateway()
 	// log.Debug("concurrent", addr, args.Weight)
-	if s := gRouter.GetServer("login"); s != nil {
-		response := map[string]interface{}{"Address": addr}
-		s.out.WriteJSON("S2C_GetBestGateway", response)
+	notifyBestGateway(gRouter.bestGateways())
+}
+
+// 通知各应用的登录服务当前最优网关，Labels为各标签的最优地址
+func notifyBestGateway(best map[string]string) {
+	labels := make(map[string]string)
+	for label, addr := range best {
+		if label != "" {
+			labels[label] = addr
+		}
+	}
+	for _, app := range gRouter.GetApps() {
+		if s := gRouter.GetServer(app, "login"); s != nil {
+			response := map[string]interface{}{"Address": best[""], "Labels": labels}
+			s.WriteJSON("S2C_GetBestGateway", response)
+		}
+	}
+}
+
+// 服务下线，不再分配新会话。已建立的连接仍可正常转发
+func C2S_Drain(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	if server := gRouter.GetServerByOut(ctx.Out); server != nil {
+		log.Infof("server %s %s drain %v", server.name, server.addr, args.IsDrain)
+		server.isDrain = args.IsDrain
+		gStore.MarkDirty()
+		notifyServerDrain(server)
+	}
+}
+
+// 网关不再为新会话绑定下线的实例
+func notifyServerDrain(server *Server) {
+	if server.typ == "gateway" || server.addr == "" {
+		return
+	}
+	info := cmd.ServerDrainArgs{ServerInfo: newServerInfo(server), IsDrain: server.isDrain}
+	for _, gw := range gRouter.gateways {
+		gw.WriteJSON("FUNC_ServerDrain", info)
 	}
+	gRouter.instancesChanged(server)
 }
 
 func C2S_Route(ctx *cmd.Context, data interface{}) {
 	args := data.(*cmd.ForwardArgs)
+	gQuota.Forward(ctx, len(args.Data), func() { route(ctx, args) })
+}
+
+// 仅转发至发送方所属应用的服务
+func route(ctx *cmd.Context, args *cmd.ForwardArgs) {
+	app := ""
+	if server := gRouter.GetServerByOut(ctx.Out); server != nil {
+		app = server.app
+	}
 	servers := args.ServerList
 	if len(servers) == 1 && servers[0] == "*" {
 		prefixMap := make(map[string]bool)
 		for _, server := range gRouter.servers {
-			prefixMap[server.name] = true
+			if server.app == app {
+				prefixMap[server.name] = true
+			}
 		}
 		servers = servers[:0]
 		for s := range prefixMap {
@@ -132,15 +254,100 @@ func C2S_Route(ctx *cmd.Context, data interface{}) {
 	}
 
 	for _, name := range servers {
-		if s := gRouter.GetServer(name); s != nil {
-			s.out.WriteJSON(args.Name, args.Data)
+		gateways := gRouter.GetGateways(name)
+		var s *Server
+		if args.HashKey != "" {
+			s = gRouter.GetServerByKey(app, name, args.HashKey)
+		} else {
+			s = gRouter.GetServer(app, name)
+		}
+		if s != nil && s.out != nil {
+			s.WriteJSON(args.Name, args.Data)
+		} else if len(gateways) > 0 {
+			// 转发至同名的全部网关，如管理消息
+			for _, gw := range gateways {
+				gw.WriteJSON(args.Name, args.Data)
+			}
+		} else {
+			cmd.HandleDeadLetter(ctx, &cmd.DeadLetter{
+				ServerName: name,
+				MessageId:  args.Name,
+				Reason:     "server not registered",
+				Data:       args.Data,
+			})
 		}
 	}
 }
 
-func FUNC_Close(ctx *cmd.Context, data interface{}) {
-	// args := data.(*Args)
-	// gRouter.Remove(ctx.Out)
-	// TODO
-	// log.Info("server lose connection")
+// 服务断开连接，注册信息保留至重连或过期
+func onDisconnect(ctx *cmd.Context) {
+	gTopics.Remove(ctx.Out)
+	gQuota.Remove(ctx.Out)
+	gConfigTables.Remove(ctx.Out)
+	gRegistryWatch.Remove(ctx.Out)
+	server := gRouter.GetServerByOut(ctx.Out)
+	if server == nil {
+		return
+	}
+	if server.typ == "gateway" {
+		gLocator.DeleteByGateway(server.addr)
+	}
+	// 注册信息保留，标记为未连接
+	server.out = nil
+	gStore.MarkDirty()
+	gRouter.instancesChanged(server)
+	gRegistryWatch.Notify(cmd.RegistryRemove, server)
+}
+
+type gatewayStats struct {
+	ServerName string
+	ServerAddr string
+	Weight     int
+	IsDrain    bool
+	Latency    *cmd.LatencyStats
+	Sessions   map[string]map[string]int
+	Queues     map[string]*cmd.QueueStats
+}
+
+// 网关负载，供运维工具查询
+func ADMIN_GetGatewayStats(ctx *cmd.Context, data interface{}) {
+	var stats []gatewayStats
+	for _, gw := range gRouter.gateways {
+		stats = append(stats, gatewayStats{
+			ServerName: gw.name,
+			ServerAddr: gw.addr,
+			Weight:     gw.weight,
+			IsDrain:    gw.isDrain,
+			Latency:    gw.latency,
+			Sessions:   gw.sessions,
+			Queues:     gw.queues,
+		})
+	}
+	ctx.Out.WriteJSON("S2C_GetGatewayStats", map[string]interface{}{"Gateways": stats})
+}
+
+func ADMIN_ListServers(ctx *cmd.Context, data interface{}) {
+	ctx.Out.WriteJSON("S2C_ListServers", buildTopology())
+}
+
+// 运维工具下线或恢复服务，按地址或名称匹配
+// 服务重连后以自身上报的状态为准
+func ADMIN_Drain(ctx *cmd.Context, data interface{}) {
+	args := data.(*Args)
+	var n int
+	for _, servers := range []map[string]*Server{gRouter.gateways, gRouter.servers} {
+		for _, server := range servers {
+			if (args.ServerAddr != "" && server.addr == args.ServerAddr) ||
+				(args.ServerAddr == "" && server.name == args.ServerName) {
+				log.Infof("admin drain server %s %s %v", server.name, server.addr, args.IsDrain)
+				server.isDrain = args.IsDrain
+				notifyServerDrain(server)
+				n++
+			}
+		}
+	}
+	if n > 0 {
+		gStore.MarkDirty()
+	}
+	ctx.Out.WriteJSON("S2C_Drain", map[string]interface{}{"Servers": n})
 }
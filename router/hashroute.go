@@ -0,0 +1,51 @@
+package main
+
+// 按键一致性哈希选择服务实例，如房间分片的游戏服务
+// 发送方通过cmd.ForwardByKey指定键，无需了解服务的实例
+// 仅选择已连接的实例，下线中的实例仍参与选择以保持已有房间的路由不变
+// 实例增删时仅少量键迁移
+
+import (
+	"github.com/guogeer/husky/util"
+	"sort"
+	"strings"
+)
+
+type hashRouteRing struct {
+	instances string // 实例列表，变化时重建
+	ring      *util.HashRing
+}
+
+var gHashRings = make(map[string]*hashRouteRing)
+
+func (r *Router) hashInstances(match func(*Server) bool) []string {
+	var keys []string
+	for key, server := range r.servers {
+		if server.out != nil && match(server) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 优先选择默认版本的实例
+func (r *Router) GetServerByKey(app, name, key string) *Server {
+	keys := r.hashInstances(func(s *Server) bool { return s.app == app && s.name == name && s.version == "" })
+	if len(keys) == 0 {
+		keys = r.hashInstances(func(s *Server) bool { return s.app == app && s.name == name })
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	instances := strings.Join(keys, ",")
+	ringKey := app + "/" + name
+	hr, ok := gHashRings[ringKey]
+	if !ok || hr.instances != instances {
+		hr = &hashRouteRing{instances: instances, ring: util.NewHashRing(0)}
+		hr.ring.Add(keys...)
+		gHashRings[ringKey] = hr
+	}
+	return r.servers[hr.ring.GetNode(key)]
+}
@@ -0,0 +1,64 @@
+package main
+
+// 服务实例列表，推送至网关，由网关按会话ID一致性哈希选择实例
+// 实例注册、注销、连接断开或下线状态变化时递增版本号，并向网关推送该服务已连接且未下线的实例
+// 路由按会话查询地址时使用相同的哈希环，哈希环按版本号缓存
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"sort"
+)
+
+type instanceRingCache struct {
+	generation int
+	ring       *cmd.InstanceRing
+}
+
+func (r *Router) instanceList(app, name string) *cmd.InstanceListArgs {
+	args := &cmd.InstanceListArgs{AppId: app, ServerName: name}
+	for _, server := range r.servers {
+		if server.app == app && server.name == name && server.out != nil && !server.isDrain && server.addr != "" {
+			args.Instances = append(args.Instances, cmd.InstanceInfo{Addr: server.addr, Version: server.version, Weight: server.weight})
+		}
+	}
+	sort.Slice(args.Instances, func(i, j int) bool { return args.Instances[i].Addr < args.Instances[j].Addr })
+	return args
+}
+
+func (r *Router) instanceRing(app, name string) *cmd.InstanceRing {
+	key := app + "/" + name
+	c, ok := r.rings[key]
+	if !ok || c.generation != r.generation {
+		if r.rings == nil {
+			r.rings = make(map[string]*instanceRingCache)
+		}
+		c = &instanceRingCache{generation: r.generation, ring: cmd.NewInstanceRing(r.instanceList(app, name).Instances)}
+		r.rings[key] = c
+	}
+	return c.ring
+}
+
+// 服务实例变化，通知全部网关
+func (r *Router) instancesChanged(server *Server) {
+	if server.typ == "gateway" {
+		return
+	}
+	r.generation++
+	args := r.instanceList(server.app, server.name)
+	for _, gw := range r.gateways {
+		gw.WriteJSON("FUNC_SetInstances", args)
+	}
+}
+
+// 网关注册时推送全部服务的实例
+func syncInstances(out cmd.Conn) {
+	seen := make(map[string]bool)
+	for _, server := range gRouter.servers {
+		key := server.app + "/" + server.name
+		if server.typ == "gateway" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out.WriteJSON("FUNC_SetInstances", gRouter.instanceList(server.app, server.name))
+	}
+}
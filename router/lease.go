@@ -0,0 +1,95 @@
+package main
+
+// 服务注册租约
+// 服务注册时声明支持租约，路由回复租约时长，服务定期通过C2S_RenewLease续期，网关上报负载时同时续期
+// 超过租约未续期的服务视为连接假死，删除注册信息并关闭连接，通知注册订阅方、网关及center
+//   <Router><Lease TTL="30s" Interval="5s"/></Router>
+// TTL为0时不启用，未声明支持租约的旧版本服务保留至断开连接
+// 过期次数通过/debug/vars中的router_expired_leases查询
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+type leaseConfig struct {
+	TTL      time.Duration `default:"30s"`
+	Interval time.Duration `default:"5s"` // 检查间隔
+}
+
+var (
+	leaseTTL          time.Duration
+	expiredLeaseCount = cmd.NewCounter("router_expired_leases")
+)
+
+func init() {
+	var cfg leaseConfig
+	if err := config.Unmarshal("Router.Lease", &cfg); err != nil {
+		log.Errorf("load lease config %v", err)
+	}
+	leaseTTL = cfg.TTL
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	util.NewPeriodTimer(checkLeases, "2001-01-01", cfg.Interval)
+
+	cmd.Bind(C2S_RenewLease, (*Args)(nil))
+}
+
+func (server *Server) renewLease() {
+	if leaseTTL > 0 {
+		server.leaseExpire = time.Now().Add(leaseTTL)
+	}
+}
+
+// 续期，注册信息已删除时通知服务重新注册
+func C2S_RenewLease(ctx *cmd.Context, data interface{}) {
+	server := gRouter.GetServerByOut(ctx.Out)
+	if server == nil {
+		ctx.Out.WriteJSON("FUNC_LeaseExpired", struct{}{})
+		return
+	}
+	server.renewLease()
+}
+
+func checkLeases() {
+	now := time.Now()
+	var expired []*Server
+	for _, servers := range []map[string]*Server{gRouter.gateways, gRouter.servers} {
+		for _, server := range servers {
+			if server.out != nil && !server.leaseExpire.IsZero() && now.After(server.leaseExpire) {
+				expired = append(expired, server)
+			}
+		}
+	}
+	for _, server := range expired {
+		expireLease(server)
+	}
+}
+
+func expireLease(server *Server) {
+	log.Warnf("server %s %s lease expired at %v", server.name, server.addr, server.leaseExpire.Format("15:04:05"))
+	expiredLeaseCount.Add(1)
+
+	out := server.out
+	if server.typ == "gateway" {
+		gLocator.DeleteByGateway(server.addr)
+	}
+	gRouter.Remove(out)
+	out.Close()
+
+	info := newServerInfo(server)
+	gRegistryWatch.Notify(cmd.RegistryRemove, server)
+	gTopics.PublishEvent(server.app, "FUNC_ServerExpire", info)
+	for _, gw := range gRouter.gateways {
+		gw.WriteJSON("FUNC_ServerExpire", info)
+	}
+	for _, s := range gRouter.servers {
+		if s.typ == "center" {
+			s.WriteJSON("FUNC_ServerExpire", info)
+		}
+	}
+}
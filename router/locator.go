@@ -0,0 +1,61 @@
+package main
+
+// 会话位置，由网关同步
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"time"
+)
+
+// 网关异常断开时未同步的位置超时清除
+var gLocator = cmd.NewSessionLocator(24 * time.Hour)
+
+type locateArgs struct {
+	Ssid string
+	UId  int
+}
+
+func init() {
+	cmd.Bind(C2S_SetSessionLocation, (*cmd.SessionLocation)(nil))
+	cmd.Bind(C2S_GetSessionLocation, (*locateArgs)(nil))
+	cmd.Bind(C2S_QuerySession, (*locateArgs)(nil))
+	cmd.SetTraceResolver(locateUId)
+}
+
+func locateUId(uid int) string {
+	loc, _ := gLocator.GetByUId(uid)
+	return loc.Ssid
+}
+
+func C2S_SetSessionLocation(ctx *cmd.Context, data interface{}) {
+	loc := data.(*cmd.SessionLocation)
+	gStore.MarkDirty()
+	if loc.IsDelete {
+		gLocator.Delete(loc.Ssid)
+		return
+	}
+	if gw := gRouter.GetServerByOut(ctx.Out); gw != nil {
+		loc.Gateway = gw.addr
+	}
+	gLocator.Set(*loc)
+}
+
+func locate(args *locateArgs) cmd.SessionLocation {
+	loc, ok := gLocator.Get(args.Ssid)
+	if !ok && args.UId != 0 {
+		loc, ok = gLocator.GetByUId(args.UId)
+	}
+	if !ok {
+		loc = cmd.SessionLocation{Ssid: args.Ssid, UId: args.UId}
+	}
+	return loc
+}
+
+func C2S_GetSessionLocation(ctx *cmd.Context, data interface{}) {
+	ctx.Out.WriteJSON("S2C_GetSessionLocation", locate(data.(*locateArgs)))
+}
+
+// 查询会话所在的网关及绑定的服务，Gateway为空表示不在线
+func C2S_QuerySession(ctx *cmd.Context, data interface{}) {
+	ctx.Out.WriteJSON("S2C_QuerySession", locate(data.(*locateArgs)))
+}
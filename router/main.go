@@ -1,22 +1,69 @@
 package main
 
 import (
-	"fmt"
 	"github.com/guogeer/husky/cmd"
 	"github.com/guogeer/husky/config"
 	"github.com/guogeer/husky/log"
-	"github.com/guogeer/husky/util"
 	"net"
 	"runtime"
-	"strconv"
 )
 
+type routerConfig struct {
+	StorePath    string // 注册信息及会话位置保存路径，为空时不保存
+	SpillDir     string // 发送队列溢出至磁盘的目录，为空时不开启
+	SpillMaxSize int64  `default:"256MB"`
+
+	Quotas []quotaRule `config:"Quota"` // 服务发送配额
+
+	HTTPAddr string // 拓扑查询HTTP地址，为空时不开启
+}
+
 func main() {
+	var cfg routerConfig
+	if err := config.Unmarshal("Router", &cfg); err != nil {
+		log.Fatalf("load router config %v", err)
+	}
+	for _, rule := range cfg.Quotas {
+		gQuota.SetRule(rule)
+	}
+
 	addr := config.Config().Server("router").Addr
-	_, portStr, _ := net.SplitHostPort(addr)
-	port, _ := strconv.Atoi(portStr)
-	log.Infof("start router server, listen %d", port)
-	go func() { cmd.ListenAndServe(fmt.Sprintf(":%d", port)) }()
+	_, port, _ := net.SplitHostPort(addr)
+	srv := &cmd.Server{
+		SpillDir:     cfg.SpillDir,
+		SpillMaxSize: cfg.SpillMaxSize,
+	}
+	// 停止时先关闭监听端口，处理完剩余的消息后保存注册信息
+	cmd.RegisterComponent(&cmd.Component{
+		Name: "store",
+		Start: func() error {
+			gStore.Start(gRouter, cfg.StorePath)
+			return nil
+		},
+		Stop: func() error {
+			gStore.MarkDirty()
+			return gStore.Save(gRouter)
+		},
+	})
+	cmd.RegisterComponent(&cmd.Component{
+		Name: "configtables",
+		Start: func() error {
+			gConfigTables.Start()
+			return nil
+		},
+	})
+	cmd.RegisterComponent(&cmd.Component{
+		Name:      "listener",
+		DependsOn: []string{"store", "configtables"},
+		Start: func() error {
+			log.Infof("start router server, listen %s", port)
+			if cfg.HTTPAddr != "" {
+				go serveTopology(cfg.HTTPAddr)
+			}
+			return srv.Listen(&cmd.ListenOptions{Addr: net.JoinHostPort("", port)})
+		},
+		StopAccept: srv.Close,
+	})
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -27,10 +74,5 @@ func main() {
 			log.Errorf("%s", buf)
 		}
 	}()
-
-	for {
-		util.TickTimerRun()
-		// handle message
-		cmd.RunOnce()
-	}
+	cmd.Run()
 }
@@ -0,0 +1,104 @@
+package main
+
+// 消息ID命名空间，前缀仅允许同名服务（含不同版本）注册
+// 按实例记录注册的前缀，同名服务的全部实例均不再使用时删除，滚动升级时新旧实例的前缀可以不同
+// 变更后同步至全部网关
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+)
+
+type namespaceInstance struct {
+	server   string
+	prefixes map[string]bool
+}
+
+type namespaceManage struct {
+	owners    map[string]string             // 前缀 -> 服务名
+	instances map[string]*namespaceInstance // 实例 -> 注册的前缀
+}
+
+var gNamespaces = newNamespaceManage()
+
+func newNamespaceManage() *namespaceManage {
+	return &namespaceManage{
+		owners:    make(map[string]string),
+		instances: make(map[string]*namespaceInstance),
+	}
+}
+
+// 服务实例注册前缀，替换该实例之前注册的前缀，返回冲突的前缀
+func (nm *namespaceManage) Register(server, instance string, prefixes []string) []string {
+	owned := make(map[string]bool)
+	var conflicts []string
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		if owner, ok := nm.owners[prefix]; ok && owner != server {
+			log.Errorf("server %s message prefix %s is registered by %s", server, prefix, owner)
+			conflicts = append(conflicts, prefix)
+			continue
+		}
+		owned[prefix] = true
+	}
+
+	old := nm.instances[instance]
+	nm.instances[instance] = &namespaceInstance{server: server, prefixes: owned}
+	changed := old != nil && nm.release(old)
+	for prefix := range owned {
+		if _, ok := nm.owners[prefix]; !ok {
+			nm.owners[prefix] = server
+			changed = true
+		}
+	}
+	if changed {
+		nm.syncAll()
+	}
+	return conflicts
+}
+
+// 实例注销，删除其他实例不再使用的前缀
+func (nm *namespaceManage) Unregister(instance string) {
+	old, ok := nm.instances[instance]
+	if !ok {
+		return
+	}
+	delete(nm.instances, instance)
+	if nm.release(old) {
+		nm.syncAll()
+	}
+}
+
+// 删除同名服务的实例均未注册的前缀
+func (nm *namespaceManage) release(old *namespaceInstance) bool {
+	changed := false
+	for prefix := range old.prefixes {
+		if nm.owners[prefix] != old.server || nm.isUsed(old.server, prefix) {
+			continue
+		}
+		delete(nm.owners, prefix)
+		changed = true
+	}
+	return changed
+}
+
+func (nm *namespaceManage) isUsed(server, prefix string) bool {
+	for _, inst := range nm.instances {
+		if inst.server == server && inst.prefixes[prefix] {
+			return true
+		}
+	}
+	return false
+}
+
+func (nm *namespaceManage) syncAll() {
+	for _, gw := range gRouter.gateways {
+		nm.Sync(gw)
+	}
+}
+
+func (nm *namespaceManage) Sync(gw *Server) {
+	gw.WriteJSON("FUNC_SetNamespaces", &cmd.NamespaceArgs{Namespaces: nm.owners})
+}
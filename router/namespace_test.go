@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNamespaceInstances(t *testing.T) {
+	nm := newNamespaceManage()
+	nm.Register("hall", "hall#1", []string{"Hall", "Shop"})
+	// 滚动升级，新实例不再使用Shop
+	nm.Register("hall", "hall#2", []string{"Hall", "Mail"})
+	if len(nm.owners) != 3 {
+		t.Fatal("owners", nm.owners)
+	}
+	if conflicts := nm.Register("game", "game#1", []string{"Hall", "Game"}); len(conflicts) != 1 || conflicts[0] != "Hall" {
+		t.Error("conflicts", conflicts)
+	}
+
+	// 旧实例重新注册时按本次注册的前缀替换
+	nm.Register("hall", "hall#1", []string{"Hall"})
+	if _, ok := nm.owners["Shop"]; ok {
+		t.Error("stale prefix", nm.owners)
+	}
+	nm.Unregister("hall#1")
+	if nm.owners["Hall"] != "hall" || nm.owners["Mail"] != "hall" {
+		t.Error("prefix of other instance removed", nm.owners)
+	}
+	nm.Unregister("hall#2")
+	if _, ok := nm.owners["Hall"]; ok || nm.owners["Game"] != "game" {
+		t.Error("unregister", nm.owners)
+	}
+	nm.Unregister("hall#2")
+}
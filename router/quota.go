@@ -0,0 +1,202 @@
+package main
+
+// 服务发送配额，限制服务经路由转发、广播的消息频率及流量
+//   <Router>
+//     <Quota><Server>*</Server><MsgPerSec>5000</MsgPerSec><BytesPerSec>8MB</BytesPerSec><Action>throttle</Action></Quota>
+//   </Router>
+// Server为*时作用于未单独配置的服务
+// 超出配额时的处理方式：throttle延迟转发，drop丢弃，disconnect断开连接
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"time"
+)
+
+const (
+	QuotaThrottle   = "throttle"
+	QuotaDrop       = "drop"
+	QuotaDisconnect = "disconnect"
+)
+
+// 延迟转发的上限，超过时丢弃
+const maxThrottleDelay = 5 * time.Second
+
+type quotaRule struct {
+	Server      string `default:"*"`
+	MsgPerSec   int
+	BytesPerSec int64
+	Action      string `default:"drop"`
+}
+
+// 令牌桶，容量为每秒的速率
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// 预留令牌，返回需等待的时间
+func (l *rateLimiter) reserve(n float64, now time.Time) time.Duration {
+	l.refill(now)
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) cancel(n float64) {
+	l.tokens += n
+}
+
+type serverQuota struct {
+	rule        *quotaRule
+	msgLimiter  *rateLimiter
+	byteLimiter *rateLimiter
+}
+
+type quotaStats struct {
+	Throttled, Dropped, Disconnected int64
+}
+
+type quotaManage struct {
+	rules  map[string]*quotaRule
+	quotas map[cmd.Conn]*serverQuota
+	stats  map[string]*quotaStats
+}
+
+var gQuota = &quotaManage{
+	rules:  make(map[string]*quotaRule),
+	quotas: make(map[cmd.Conn]*serverQuota),
+	stats:  make(map[string]*quotaStats),
+}
+
+func init() {
+	cmd.BindAdmin("ADMIN_SetQuota", ADMIN_SetQuota, (*quotaRule)(nil))
+	cmd.BindAdmin("ADMIN_GetQuotaStats", ADMIN_GetQuotaStats, (*struct{})(nil))
+}
+
+func (qm *quotaManage) SetRule(rule quotaRule) {
+	if rule.MsgPerSec <= 0 && rule.BytesPerSec <= 0 {
+		delete(qm.rules, rule.Server)
+	} else {
+		qm.rules[rule.Server] = &rule
+	}
+	// 重新创建限流
+	qm.quotas = make(map[cmd.Conn]*serverQuota)
+}
+
+func (qm *quotaManage) Remove(out cmd.Conn) {
+	delete(qm.quotas, out)
+}
+
+func (qm *quotaManage) getQuota(out cmd.Conn, name string) *serverQuota {
+	if q, ok := qm.quotas[out]; ok {
+		return q
+	}
+	rule, ok := qm.rules[name]
+	if !ok {
+		rule = qm.rules["*"]
+	}
+	q := &serverQuota{rule: rule}
+	if rule != nil && rule.MsgPerSec > 0 {
+		q.msgLimiter = newRateLimiter(float64(rule.MsgPerSec))
+	}
+	if rule != nil && rule.BytesPerSec > 0 {
+		q.byteLimiter = newRateLimiter(float64(rule.BytesPerSec))
+	}
+	qm.quotas[out] = q
+	return q
+}
+
+func (qm *quotaManage) getStats(name string) *quotaStats {
+	stats, ok := qm.stats[name]
+	if !ok {
+		stats = &quotaStats{}
+		qm.stats[name] = stats
+	}
+	return stats
+}
+
+// 检查配额，通过时转发
+func (qm *quotaManage) Forward(ctx *cmd.Context, size int, forward func()) {
+	name := "*"
+	if server := gRouter.GetServerByOut(ctx.Out); server != nil {
+		name = server.name
+		server.recvCount++
+	}
+	q := qm.getQuota(ctx.Out, name)
+	if q.rule == nil {
+		forward()
+		return
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	if q.msgLimiter != nil {
+		wait = q.msgLimiter.reserve(1, now)
+	}
+	if q.byteLimiter != nil {
+		if d := q.byteLimiter.reserve(float64(size), now); d > wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		forward()
+		return
+	}
+
+	stats := qm.getStats(name)
+	action := q.rule.Action
+	if action == QuotaThrottle && wait <= maxThrottleDelay {
+		stats.Throttled++
+		util.NewTimer(forward, wait)
+		return
+	}
+	// 未转发的消息不占用配额
+	if q.msgLimiter != nil {
+		q.msgLimiter.cancel(1)
+	}
+	if q.byteLimiter != nil {
+		q.byteLimiter.cancel(float64(size))
+	}
+	if action == QuotaDisconnect {
+		stats.Disconnected++
+		log.Warnf("server %s %s exceed quota, disconnect", name, ctx.Out.RemoteAddr())
+		ctx.Out.Close()
+		return
+	}
+	if stats.Dropped++; stats.Dropped&(stats.Dropped-1) == 0 {
+		log.Warnf("server %s exceed quota, dropped %d", name, stats.Dropped)
+	}
+}
+
+func ADMIN_SetQuota(ctx *cmd.Context, data interface{}) {
+	rule := data.(*quotaRule)
+	if rule.Server == "" {
+		rule.Server = "*"
+	}
+	if rule.Action == "" {
+		rule.Action = QuotaDrop
+	}
+	log.Infof("server %s quota %d msg/s %d bytes/s %s", rule.Server, rule.MsgPerSec, rule.BytesPerSec, rule.Action)
+	gQuota.SetRule(*rule)
+}
+
+func ADMIN_GetQuotaStats(ctx *cmd.Context, data interface{}) {
+	ctx.Out.WriteJSON("S2C_GetQuotaStats", map[string]interface{}{"Stats": gQuota.stats})
+}
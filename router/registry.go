@@ -0,0 +1,61 @@
+package main
+
+// 服务注册信息订阅
+// 订阅时推送全部已连接的服务，之后推送服务的注册及断开
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+)
+
+type registryWatch struct {
+	watchers map[cmd.Conn]bool
+}
+
+var gRegistryWatch = &registryWatch{watchers: make(map[cmd.Conn]bool)}
+
+func init() {
+	cmd.Bind(C2S_WatchRegistry, (*Args)(nil))
+}
+
+func newServerInfo(server *Server) cmd.ServerInfo {
+	return cmd.ServerInfo{
+		Name:     server.name,
+		Addr:     server.addr,
+		Type:     server.typ,
+		Version:  server.version,
+		Instance: server.instance,
+		App:      server.app,
+		Data:     server.data,
+	}
+}
+
+func (rw *registryWatch) Watch(out cmd.Conn) {
+	rw.watchers[out] = true
+
+	ev := &cmd.RegistryEvent{Op: cmd.RegistrySnapshot, Servers: []cmd.ServerInfo{}}
+	for _, servers := range []map[string]*Server{gRouter.gateways, gRouter.servers} {
+		for _, server := range servers {
+			if server.out != nil {
+				ev.Servers = append(ev.Servers, newServerInfo(server))
+			}
+		}
+	}
+	out.WriteJSON("FUNC_RegistryEvent", ev)
+}
+
+func (rw *registryWatch) Remove(out cmd.Conn) {
+	delete(rw.watchers, out)
+}
+
+func (rw *registryWatch) Notify(op string, server *Server) {
+	ev := &cmd.RegistryEvent{Op: op, Servers: []cmd.ServerInfo{newServerInfo(server)}}
+	for out := range rw.watchers {
+		out.WriteJSON("FUNC_RegistryEvent", ev)
+	}
+}
+
+func C2S_WatchRegistry(ctx *cmd.Context, data interface{}) {
+	log.Debugf("watch registry %s", ctx.Out.RemoteAddr())
+	gRegistryWatch.Watch(ctx.Out)
+}
@@ -0,0 +1,69 @@
+package main
+
+// 灰度发布的版本比例，由运维工具设置，下发至全部网关，保存在注册信息中
+//   huskyctl rollout hall v2=10
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+)
+
+var gRollouts = make(map[string]*cmd.RolloutRule)
+
+func init() {
+	cmd.BindAdmin("ADMIN_SetRollout", ADMIN_SetRollout, (*cmd.RolloutRule)(nil))
+}
+
+func rolloutKey(app, name string) string {
+	if app != "" {
+		return app + "/" + name
+	}
+	return name
+}
+
+func setRollout(rule *cmd.RolloutRule) {
+	for version, n := range rule.Weights {
+		if n <= 0 {
+			delete(rule.Weights, version)
+		}
+	}
+	if len(rule.Weights) == 0 {
+		delete(gRollouts, rolloutKey(rule.AppId, rule.ServerName))
+	} else {
+		gRollouts[rolloutKey(rule.AppId, rule.ServerName)] = rule
+	}
+}
+
+// 新注册的网关同步全部比例
+func syncRollouts(gw cmd.Conn) {
+	for _, rule := range gRollouts {
+		gw.WriteJSON("FUNC_SetRollout", rule)
+	}
+}
+
+// 比例合计不超过100
+func ADMIN_SetRollout(ctx *cmd.Context, data interface{}) {
+	rule := data.(*cmd.RolloutRule)
+	total := 0
+	for _, n := range rule.Weights {
+		if n > 0 {
+			total += n
+		}
+	}
+	if total > 100 {
+		ctx.Out.WriteJSON("S2C_SetRollout", map[string]interface{}{"Err": "total weight exceeds 100"})
+		return
+	}
+
+	log.Infof("admin set rollout %s %s %v", rule.AppId, rule.ServerName, rule.Weights)
+	setRollout(rule)
+	gStore.MarkDirty()
+	for _, gw := range gRouter.gateways {
+		gw.WriteJSON("FUNC_SetRollout", rule)
+	}
+	var rules []*cmd.RolloutRule
+	for _, rule := range gRollouts {
+		rules = append(rules, rule)
+	}
+	ctx.Out.WriteJSON("S2C_SetRollout", map[string]interface{}{"Rules": rules})
+}
@@ -4,19 +4,45 @@ import (
 	"github.com/guogeer/husky/cmd"
 	// "github.com/guogeer/husky/log"
 	"encoding/json"
+	"github.com/guogeer/husky/randutil"
+	"time"
 )
 
 type Server struct {
 	out             cmd.Conn
-	weight          int
+	weight          int // 网关为当前负载，服务为实例权重
 	name, addr, typ string
+	version         string                     // 服务版本
+	instance        string                     // 实例ID，同名服务可注册多个实例
+	app             string                     // 所属应用，网关由全部应用共用
+	isDrain         bool                       // 下线中
+	isStale         bool                       // 网关长时间未上报负载
+	reportTime      time.Time                  // 网关最近一次上报负载的时间
+	leaseExpire     time.Time                  // 注册租约到期时间，为空时不过期
+	latency         *cmd.LatencyStats          // 网关上报的会话延迟
+	sessions        map[string]map[string]int  // 网关上报的会话分组统计
+	queues          map[string]*cmd.QueueStats // 网关上报的写队列统计
+	labels          map[string]*gatewayLabel   // 网关上报的各标签地址负载
+
+	sendCount, recvCount int64   // 发送至服务、服务转发的消息数量
+	sendRate, recvRate   float64 // 每秒消息数量
 
 	data json.RawMessage
 }
 
+// 恢复的服务尚未重新注册时连接为空
+func (server *Server) WriteJSON(name string, i interface{}) {
+	if server.out != nil {
+		server.sendCount++
+		server.out.WriteJSON(name, i)
+	}
+}
+
 type Router struct {
-	servers  map[string]*Server
-	gateways map[string]*Server
+	servers    map[string]*Server
+	gateways   map[string]*Server
+	generation int                           // 服务实例变化时递增
+	rings      map[string]*instanceRingCache // 按会话选择实例的哈希环
 }
 
 var gRouter = &Router{
@@ -26,32 +52,191 @@ var gRouter = &Router{
 }
 
 func (r *Router) GetBestGateway() string {
+	addr, _ := r.bestGateway("")
+	return addr
+}
+
+// 客户端标签对应的最优网关地址，无网关监听该标签时返回默认地址
+func (r *Router) GetLabelGateway(label string) string {
+	if addr, ok := r.bestGateway(label); ok {
+		return addr
+	}
+	return r.GetBestGateway()
+}
+
+// 依次优先选择负载未过期、未被运维排空的网关
+func (r *Router) bestGateway(label string) (string, bool) {
 	var (
 		addr   string
 		weight int
+		rank   int
 	)
 	for host, gw := range r.gateways {
-		if len(addr) == 0 || gw.weight < weight {
+		if gw.isDrain || gGatewayOverrides.Blacklist[gw.addr] {
+			continue
+		}
+		gwWeight := gw.weight
+		if label != "" {
+			l, ok := gw.labels[label]
+			if !ok {
+				continue
+			}
+			host, gwWeight = l.Addr, l.Weight
+		}
+		gwWeight, drain := gGatewayOverrides.weight(gw, gwWeight)
+		gwRank := 0
+		if drain {
+			gwRank = 2
+		} else if gw.isStale {
+			gwRank = 1
+		}
+		better := gwWeight < weight
+		if rank != gwRank {
+			better = gwRank < rank
+		}
+		if len(addr) == 0 || better {
 			addr = host
-			weight = gw.weight
+			weight = gwWeight
+			rank = gwRank
 			// log.Debug("best", addr, gw.weight, weight)
 		}
 	}
-	return addr
+	return addr, addr != ""
 }
 
-func (r *Router) GetServerAddr(name string) string {
-	var addr string
-	if server, ok := r.servers[name]; ok {
-		addr = server.addr
+// 默认及各标签的最优网关，默认地址的标签为空
+func (r *Router) bestGateways() map[string]string {
+	best := map[string]string{"": r.GetBestGateway()}
+	for _, gw := range r.gateways {
+		for label := range gw.labels {
+			if _, ok := best[label]; !ok {
+				best[label] = r.GetLabelGateway(label)
+			}
+		}
 	}
-	return addr
+	return best
+}
+
+func serverKey(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "@" + version
+}
+
+// 服务实例在注册信息中的键
+func (server *Server) key() string {
+	return instanceKey(server.app, server.name, server.version, server.instance)
+}
+
+func instanceKey(app, name, version, instance string) string {
+	key := serverKey(name, version) + "#" + instance
+	if app != "" {
+		key = app + "/" + key
+	}
+	return key
+}
+
+// 按权重随机选择满足条件的实例，优先未下线的实例
+func (r *Router) chooseServer(match func(*Server) bool) *Server {
+	var candidates, drained []*Server
+	var weights []int
+	for _, server := range r.servers {
+		if !match(server) {
+			continue
+		}
+		if server.isDrain {
+			drained = append(drained, server)
+			continue
+		}
+		weight := server.weight
+		if weight <= 0 {
+			weight = 1
+		}
+		candidates = append(candidates, server)
+		weights = append(weights, weight)
+	}
+	if len(candidates) > 0 {
+		return candidates[randutil.Index(weights)]
+	}
+	if len(drained) > 0 {
+		return drained[0]
+	}
+	return nil
 }
 
-func (r *Router) GetServer(name string) *Server {
-	if server, ok := r.servers[name]; ok {
+// 优先选择指定版本，版本不存在或下线时选择默认版本
+// ssid不为空时与网关相同，按会话一致性哈希选择已连接的实例
+func (r *Router) GetServerAddr(app, name, version, ssid string) string {
+	if ssid != "" {
+		if addr := r.instanceRing(app, name).Choose(version, ssid); addr != "" {
+			return addr
+		}
+	}
+	server := r.chooseServer(func(s *Server) bool { return s.app == app && s.name == name && s.version == version })
+	if server == nil || server.isDrain {
+		server = r.GetServer(app, name)
+	}
+	if server != nil && !server.isDrain {
+		return server.addr
+	}
+	return ""
+}
+
+// 优先选择应用内默认版本的实例
+func (r *Router) GetServer(app, name string) *Server {
+	if server := r.chooseServer(func(s *Server) bool { return s.app == app && s.name == name && s.version == "" }); server != nil && !server.isDrain {
 		return server
 	}
+	// 仅存在带版本的服务
+	return r.chooseServer(func(s *Server) bool { return s.app == app && s.name == name })
+}
+
+// 已注册服务的全部应用
+func (r *Router) GetApps() []string {
+	var apps []string
+	seen := make(map[string]bool)
+	for _, server := range r.servers {
+		if !seen[server.app] {
+			seen[server.app] = true
+			apps = append(apps, server.app)
+		}
+	}
+	return apps
+}
+
+// 服务的全部实例
+func (r *Router) GetInstances(name string) []*Server {
+	var instances []*Server
+	for _, server := range r.servers {
+		if server.name == name {
+			instances = append(instances, server)
+		}
+	}
+	return instances
+}
+
+func (r *Router) GetGateways(name string) []*Server {
+	var gateways []*Server
+	for _, gw := range r.gateways {
+		if gw.name == name {
+			gateways = append(gateways, gw)
+		}
+	}
+	return gateways
+}
+
+func (r *Router) GetServerByOut(out cmd.Conn) *Server {
+	for _, server := range r.gateways {
+		if server.out == out {
+			return server
+		}
+	}
+	for _, server := range r.servers {
+		if server.out == out {
+			return server
+		}
+	}
 	return nil
 }
 
@@ -59,23 +244,29 @@ func (r *Router) Remove(out cmd.Conn) {
 	for addr, server := range r.gateways {
 		if server.out == out {
 			delete(r.gateways, addr)
+			gStore.MarkDirty()
 			break
 		}
 	}
-	for name, server := range r.servers {
+	for key, server := range r.servers {
 		if server.out == out {
-			delete(r.servers, name)
+			delete(r.servers, key)
+			gNamespaces.Unregister(key)
+			gStore.MarkDirty()
+			r.instancesChanged(server)
 			break
 		}
 	}
 }
 
 func (r *Router) AddServer(server *Server) {
-	name := server.name
 	addr := server.addr
 	if server.typ == "gateway" {
+		server.reportTime = time.Now()
 		r.gateways[addr] = server
 	} else {
-		r.servers[name] = server
+		r.servers[server.key()] = server
+		r.instancesChanged(server)
 	}
+	gStore.MarkDirty()
 }
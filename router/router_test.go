@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/guogeer/husky/cmd"
+	"testing"
+)
+
+func TestChooseServerBySession(t *testing.T) {
+	r := &Router{servers: make(map[string]*Server), gateways: make(map[string]*Server)}
+	gw := &recordConn{}
+	r.AddServer(&Server{name: "gateway", addr: "127.0.0.1:8201", typ: "gateway", out: gw})
+	for i := 1; i <= 3; i++ {
+		addr := fmt.Sprintf("127.0.0.1:901%d", i)
+		r.AddServer(&Server{name: "hall", addr: addr, instance: addr, out: &recordConn{}})
+	}
+
+	chosen := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		ssid := fmt.Sprintf("s%d", i)
+		addr := r.GetServerAddr("", "hall", "", ssid)
+		chosen[ssid] = addr
+		counts[addr]++
+	}
+	if len(counts) != 3 {
+		t.Error("spread", counts)
+	}
+	for ssid, addr := range chosen {
+		if r.GetServerAddr("", "hall", "", ssid) != addr {
+			t.Fatal("session moved", ssid)
+		}
+	}
+
+	// 实例下线后仅该实例的会话重新选择
+	if len(gw.names) != 3 || gw.names[2] != "FUNC_SetInstances" {
+		t.Error("push instances", gw.names)
+	}
+	if args := gw.data[2].(*cmd.InstanceListArgs); len(args.Instances) != 3 {
+		t.Error("instances", args.Instances)
+	}
+	// 实例未变化时复用哈希环
+	if r.instanceRing("", "hall") != r.instanceRing("", "hall") {
+		t.Error("ring not cached")
+	}
+
+	drained := r.servers[instanceKey("", "hall", "", "127.0.0.1:9011")]
+	drained.isDrain = true
+	r.instancesChanged(drained)
+	for ssid, addr := range chosen {
+		next := r.GetServerAddr("", "hall", "", ssid)
+		if next == "127.0.0.1:9011" || (addr != "127.0.0.1:9011" && next != addr) {
+			t.Fatal("drain", ssid, addr, next)
+		}
+	}
+	if addr := r.GetServerAddr("", "hall", "", ""); addr == "" || addr == "127.0.0.1:9011" {
+		t.Error("session-less", addr)
+	}
+}
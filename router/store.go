@@ -0,0 +1,180 @@
+package main
+
+// 路由重启后恢复服务注册信息、网关负载、运维设置的网关选择、灰度比例及会话位置
+// 恢复的服务在重新注册前仅用于查询地址，超时未注册则删除，未重新注册的网关上的会话位置同时删除
+// 默认不保存，需配置保存路径
+//   <Router><StorePath>router.store.json</StorePath></Router>
+
+import (
+	"encoding/json"
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	storeSavePeriod = 5 * time.Second
+	restoreTimeout  = 2 * time.Minute // 恢复的服务等待重新注册的时间
+)
+
+type serverRecord struct {
+	Name     string
+	Addr     string
+	Type     string
+	Version  string
+	Instance string `json:",omitempty"`
+	App      string `json:",omitempty"`
+	Data     json.RawMessage
+	Weight   int
+	IsDrain  bool
+}
+
+type registryRecord struct {
+	Servers  []serverRecord
+	Gateways []serverRecord
+
+	GatewayOverrides *gatewayOverrides     `json:",omitempty"` // 运维设置的网关选择
+	Sessions         []cmd.SessionLocation `json:",omitempty"` // 网关同步的会话位置
+	Rollouts         []*cmd.RolloutRule    `json:",omitempty"` // 灰度发布的版本比例
+}
+
+type registryStore struct {
+	path  string
+	dirty bool
+}
+
+var gStore = &registryStore{}
+
+func newServerRecord(server *Server) serverRecord {
+	return serverRecord{
+		Name:     server.name,
+		Addr:     server.addr,
+		Type:     server.typ,
+		Version:  server.version,
+		Instance: server.instance,
+		App:      server.app,
+		Data:     server.data,
+		Weight:   server.weight,
+		IsDrain:  server.isDrain,
+	}
+}
+
+func (record *serverRecord) newServer() *Server {
+	return &Server{
+		name:     record.Name,
+		addr:     record.Addr,
+		typ:      record.Type,
+		version:  record.Version,
+		instance: record.Instance,
+		app:      record.App,
+		data:     record.Data,
+		weight:   record.Weight,
+		isDrain:  record.IsDrain,
+	}
+}
+
+func (store *registryStore) MarkDirty() {
+	store.dirty = true
+}
+
+func (store *registryStore) Save(r *Router) error {
+	if store.path == "" || store.dirty == false {
+		return nil
+	}
+	store.dirty = false
+
+	var record registryRecord
+	for _, server := range r.servers {
+		record.Servers = append(record.Servers, newServerRecord(server))
+	}
+	for _, gw := range r.gateways {
+		record.Gateways = append(record.Gateways, newServerRecord(gw))
+	}
+	record.GatewayOverrides = gGatewayOverrides
+	record.Sessions = gLocator.List()
+	for _, rule := range gRollouts {
+		record.Rollouts = append(record.Rollouts, rule)
+	}
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件，防止写入中断导致文件损坏
+	tempPath := store.path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, store.path)
+}
+
+func (store *registryStore) Load(r *Router) error {
+	buf, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var record registryRecord
+	if err := json.Unmarshal(buf, &record); err != nil {
+		return err
+	}
+	for _, s := range record.Servers {
+		r.AddServer(s.newServer())
+	}
+	for _, s := range record.Gateways {
+		r.AddServer(s.newServer())
+	}
+	gGatewayOverrides.restore(record.GatewayOverrides)
+	for _, loc := range record.Sessions {
+		gLocator.Set(loc)
+	}
+	for _, rule := range record.Rollouts {
+		setRollout(rule)
+	}
+	log.Infof("restore %d servers %d gateways %d sessions", len(record.Servers), len(record.Gateways), len(record.Sessions))
+	return nil
+}
+
+// 删除未重新注册的服务
+func (store *registryStore) expire(r *Router) {
+	for key, server := range r.servers {
+		if server.out == nil {
+			log.Infof("restored server %s expire", key)
+			delete(r.servers, key)
+			gNamespaces.Unregister(key)
+			store.MarkDirty()
+			r.instancesChanged(server)
+			gRegistryWatch.Notify(cmd.RegistryRemove, server)
+		}
+	}
+	for addr, gw := range r.gateways {
+		if gw.out == nil {
+			log.Infof("restored gateway %s expire", addr)
+			gLocator.DeleteByGateway(gw.addr)
+			delete(r.gateways, addr)
+			store.MarkDirty()
+			gRegistryWatch.Notify(cmd.RegistryRemove, gw)
+		}
+	}
+}
+
+func (store *registryStore) Start(r *Router, path string) {
+	store.path = path
+	if path == "" {
+		return
+	}
+	if err := store.Load(r); err != nil {
+		log.Errorf("load registry %v", err)
+	}
+	util.NewTimer(func() { store.expire(r) }, restoreTimeout)
+	util.NewPeriodTimer(func() {
+		if err := store.Save(r); err != nil {
+			log.Errorf("save registry %v", err)
+		}
+	}, "2001-01-01", storeSavePeriod)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRestore(t *testing.T) {
+	defer func(l *cmd.SessionLocator) { gLocator = l }(gLocator)
+	gLocator = cmd.NewSessionLocator(time.Hour)
+
+	r := &Router{servers: make(map[string]*Server), gateways: make(map[string]*Server)}
+	r.AddServer(&Server{name: "hall", addr: "127.0.0.1:9010", instance: "127.0.0.1:9010", out: &recordConn{}})
+	r.AddServer(&Server{name: "gateway", addr: "127.0.0.1:8201", typ: "gateway", out: &recordConn{}})
+	r.AddServer(&Server{name: "gateway", addr: "127.0.0.1:8202", typ: "gateway", out: &recordConn{}})
+	gLocator.Set(cmd.SessionLocation{Ssid: "s1", UId: 10, Gateway: "127.0.0.1:8201", Services: []string{"hall"}})
+	gLocator.Set(cmd.SessionLocation{Ssid: "s2", UId: 11, Gateway: "127.0.0.1:8202"})
+
+	store := &registryStore{path: filepath.Join(t.TempDir(), "router.store.json")}
+	store.MarkDirty()
+	if err := store.Save(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟重启
+	gLocator = cmd.NewSessionLocator(time.Hour)
+	restored := &Router{servers: make(map[string]*Server), gateways: make(map[string]*Server)}
+	if err := store.Load(restored); err != nil {
+		t.Fatal(err)
+	}
+	if addr := restored.GetServerAddr("", "hall", "", ""); addr != "127.0.0.1:9010" {
+		t.Error("server addr", addr)
+	}
+	if loc, ok := gLocator.GetByUId(10); !ok || loc.Ssid != "s1" || len(loc.Services) != 1 {
+		t.Error("session location", loc, ok)
+	}
+
+	// 网关重新注册，未重新注册的网关上的会话删除
+	restored.gateways["127.0.0.1:8201"].out = &recordConn{}
+	store.expire(restored)
+	if _, ok := gLocator.Get("s1"); !ok {
+		t.Error("session on registered gateway removed")
+	}
+	if _, ok := gLocator.Get("s2"); ok {
+		t.Error("session on expired gateway kept")
+	}
+}
+
+func TestStoreDisabled(t *testing.T) {
+	store := &registryStore{}
+	store.MarkDirty()
+	if err := store.Save(gRouter); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// 记录写入消息的连接
+type recordConn struct {
+	names []string
+	data  []interface{}
+}
+
+func (c *recordConn) Write(buf []byte) error {
+	return nil
+}
+
+func (c *recordConn) WriteJSON(name string, i interface{}) error {
+	c.names = append(c.names, name)
+	c.data = append(c.data, i)
+	return nil
+}
+
+func (c *recordConn) RemoteAddr() string {
+	return "127.0.0.1:0"
+}
+
+func (c *recordConn) Close() {}
@@ -0,0 +1,90 @@
+package main
+
+// 主题订阅，按应用隔离，订阅方仅收到同一应用的服务发布的消息
+// 路由发布的服务变更事件送达该服务所属应用及未指定应用的公共服务
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/log"
+)
+
+type topicManage struct {
+	subscribers map[string]map[cmd.Conn]string // 主题 -> 订阅方所属应用
+}
+
+var gTopics = &topicManage{
+	subscribers: make(map[string]map[cmd.Conn]string),
+}
+
+func init() {
+	cmd.Bind(C2S_Subscribe, (*cmd.TopicArgs)(nil))
+	cmd.Bind(C2S_Unsubscribe, (*cmd.TopicArgs)(nil))
+	cmd.Bind(C2S_Publish, (*cmd.TopicArgs)(nil))
+}
+
+func (tm *topicManage) Subscribe(app, topic string, out cmd.Conn) {
+	subs, ok := tm.subscribers[topic]
+	if !ok {
+		subs = make(map[cmd.Conn]string)
+		tm.subscribers[topic] = subs
+	}
+	subs[out] = app
+}
+
+func (tm *topicManage) Unsubscribe(topic string, out cmd.Conn) {
+	if subs, ok := tm.subscribers[topic]; ok {
+		delete(subs, out)
+		if len(subs) == 0 {
+			delete(tm.subscribers, topic)
+		}
+	}
+}
+
+// 连接断开时取消全部订阅
+func (tm *topicManage) Remove(out cmd.Conn) {
+	for topic := range tm.subscribers {
+		tm.Unsubscribe(topic, out)
+	}
+}
+
+// 发送至同一应用的订阅方
+func (tm *topicManage) Publish(app, topic string, i interface{}) {
+	for out, subApp := range tm.subscribers[topic] {
+		if subApp == app {
+			out.WriteJSON(topic, i)
+		}
+	}
+}
+
+// 路由发布的事件，同时送达公共服务
+func (tm *topicManage) PublishEvent(app, topic string, i interface{}) {
+	for out, subApp := range tm.subscribers[topic] {
+		if subApp == app || subApp == "" {
+			out.WriteJSON(topic, i)
+		}
+	}
+}
+
+// 连接所属应用，未注册的连接为空
+func connApp(out cmd.Conn) string {
+	if server := gRouter.GetServerByOut(out); server != nil {
+		return server.app
+	}
+	return ""
+}
+
+func C2S_Subscribe(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.TopicArgs)
+	log.Debugf("subscribe %s %s", args.Topic, ctx.Out.RemoteAddr())
+	gTopics.Subscribe(connApp(ctx.Out), args.Topic, ctx.Out)
+}
+
+func C2S_Unsubscribe(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.TopicArgs)
+	gTopics.Unsubscribe(args.Topic, ctx.Out)
+}
+
+func C2S_Publish(ctx *cmd.Context, data interface{}) {
+	args := data.(*cmd.TopicArgs)
+	gTopics.Publish(connApp(ctx.Out), args.Topic, args.Data)
+}
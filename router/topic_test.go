@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"testing"
+)
+
+func TestTopicApp(t *testing.T) {
+	c1, c2, shared := &recordConn{}, &recordConn{}, &recordConn{}
+	tm := &topicManage{subscribers: make(map[string]map[cmd.Conn]string)}
+	tm.Subscribe("game1", "Notice", c1)
+	tm.Subscribe("game2", "Notice", c2)
+	tm.Subscribe("", "Notice", shared)
+
+	tm.Publish("game1", "Notice", "{}")
+	if len(c1.names) != 1 || len(c2.names) != 0 || len(shared.names) != 0 {
+		t.Fatal("publish", c1.names, c2.names, shared.names)
+	}
+	tm.PublishEvent("game2", "Notice", "{}")
+	if len(c2.names) != 1 || len(shared.names) != 1 || len(c1.names) != 1 {
+		t.Fatal("publish event", c1.names, c2.names, shared.names)
+	}
+
+	tm.Remove(c1)
+	tm.Unsubscribe("Notice", c2)
+	tm.Unsubscribe("Notice", shared)
+	if len(tm.subscribers) != 0 {
+		t.Error(tm.subscribers)
+	}
+}
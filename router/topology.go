@@ -0,0 +1,153 @@
+package main
+
+// 拓扑查询
+//   GET /topology          JSON
+//   GET /topology?format=dot  graphviz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/guogeer/husky/util"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const topologyRatePeriod = 5 * time.Second
+
+type topologyNode struct {
+	Name      string
+	Addr      string
+	Type      string `json:",omitempty"`
+	Version   string `json:",omitempty"`
+	Instance  string `json:",omitempty"`
+	App       string `json:",omitempty"`
+	Weight    int
+	IsDrain   bool
+	IsStale   bool `json:",omitempty"` // 网关负载过期
+	Connected bool
+	SendRate  float64 // 路由发送至服务的消息，每秒
+	RecvRate  float64 // 服务经路由转发的消息，每秒
+}
+
+type topology struct {
+	Router   string
+	Gateways []topologyNode
+	Servers  []topologyNode
+}
+
+func init() {
+	util.NewPeriodTimer(updateTopologyRates, "2001-01-01", topologyRatePeriod)
+}
+
+// 计算各服务的消息速率
+func updateTopologyRates() {
+	secs := topologyRatePeriod.Seconds()
+	for _, servers := range []map[string]*Server{gRouter.gateways, gRouter.servers} {
+		for _, server := range servers {
+			server.sendRate = float64(server.sendCount) / secs
+			server.recvRate = float64(server.recvCount) / secs
+			server.sendCount, server.recvCount = 0, 0
+		}
+	}
+}
+
+func newTopologyNode(server *Server) topologyNode {
+	return topologyNode{
+		Name:      server.name,
+		Addr:      server.addr,
+		Type:      server.typ,
+		Version:   server.version,
+		Instance:  server.instance,
+		App:       server.app,
+		Weight:    server.weight,
+		IsDrain:   server.isDrain,
+		IsStale:   server.isStale,
+		Connected: server.out != nil,
+		SendRate:  server.sendRate,
+		RecvRate:  server.recvRate,
+	}
+}
+
+func buildTopology() *topology {
+	t := &topology{Router: config.Config().Server("router").Addr}
+	for _, gw := range gRouter.gateways {
+		t.Gateways = append(t.Gateways, newTopologyNode(gw))
+	}
+	for _, server := range gRouter.servers {
+		t.Servers = append(t.Servers, newTopologyNode(server))
+	}
+	sort.Slice(t.Gateways, func(i, j int) bool { return t.Gateways[i].Addr < t.Gateways[j].Addr })
+	sort.Slice(t.Servers, func(i, j int) bool { return t.Servers[i].Addr < t.Servers[j].Addr })
+	return t
+}
+
+func (t *topology) DOT() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph husky {\n")
+	fmt.Fprintf(&buf, "\trouter [shape=box,label=\"router\\n%s\"];\n", t.Router)
+	writeNodes := func(nodes []topologyNode, shape string) {
+		for _, n := range nodes {
+			label := n.Name
+			if n.Version != "" {
+				label += "@" + n.Version
+			}
+			style := "solid"
+			if !n.Connected {
+				style = "dashed"
+			} else if n.IsDrain {
+				style = "dotted"
+			}
+			fmt.Fprintf(&buf, "\t%q [shape=%s,style=%s,label=\"%s\\n%s\\nweight %d\"];\n",
+				n.Addr, shape, style, label, n.Addr, n.Weight)
+			fmt.Fprintf(&buf, "\trouter -> %q [label=\"%.1f/s\"];\n", n.Addr, n.SendRate)
+			fmt.Fprintf(&buf, "\t%q -> router [label=\"%.1f/s\"];\n", n.Addr, n.RecvRate)
+		}
+	}
+	writeNodes(t.Gateways, "ellipse")
+	writeNodes(t.Servers, "component")
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// 路由数据仅在主循环中访问
+func snapshotTopology() (*topology, bool) {
+	ch := make(chan *topology, 1)
+	cmd.Enqueue(&cmd.Context{}, func(ctx *cmd.Context, _ interface{}) {
+		ch <- buildTopology()
+	}, nil)
+	select {
+	case t := <-ch:
+		return t, true
+	case <-time.After(3 * time.Second):
+		return nil, false
+	}
+}
+
+func handleTopology(w http.ResponseWriter, r *http.Request) {
+	t, ok := snapshotTopology()
+	if !ok {
+		http.Error(w, "router busy", http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write(t.DOT())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func serveTopology(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/topology", handleTopology)
+	log.Infof("topology listen %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("topology %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package script
+
+// Lua脚本处理消息
+// 脚本位于配置文件所在目录的scripts下，文件名即消息ID，如scripts/C2S_Sign.lua
+// 脚本需定义函数handle(ctx, args)，args为消息数据，ctx提供以下方法：
+//   ctx.Ssid
+//   ctx.WriteJSON(name, data)
+//   ctx.Route(serverName, name, data)
+// 脚本加载时编译一次，每次调用从缓存中取出虚拟机，不同会话的消息并发执行
+// 全局变量仅在单个虚拟机内有效，不能用于保存跨消息的数据
+//   <Lua>
+//     <PoolSize>8</PoolSize>
+//     <Timeout>1s</Timeout>
+//   </Lua>
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/guogeer/husky/cmd"
+	"github.com/guogeer/husky/config"
+	"github.com/guogeer/husky/log"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LuaOptions struct {
+	PoolSize int           `default:"8"`  // 每个脚本缓存的虚拟机数
+	Timeout  time.Duration `default:"1s"` // 单次调用的超时时间
+}
+
+type luaHandler struct {
+	path   string
+	proto  *lua.FunctionProto
+	pool   chan *lua.LState
+	mu     sync.Mutex
+	closed bool // 已被新脚本替换
+}
+
+var (
+	luaOptions   LuaOptions
+	luaHandlers  = make(map[string]*luaHandler)
+	luaHandlerMu sync.Mutex
+
+	errLuaClosed = errors.New("lua script replaced")
+)
+
+func init() {
+	if err := config.Unmarshal("Lua", &luaOptions); err != nil {
+		log.Errorf("load lua options %v", err)
+	}
+	cmd.BindAdmin("ADMIN_ReloadScripts", funcReloadScripts, (*struct{})(nil))
+}
+
+// 脚本目录
+func Dir() string {
+	return filepath.Join(filepath.Dir(config.Config().Path()), "scripts")
+}
+
+// 加载目录下全部脚本，已绑定的消息将被替换
+func LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || filepath.Ext(name) != ".lua" {
+			continue
+		}
+		msgId := strings.TrimSuffix(name, ".lua")
+		if err := BindLua(msgId, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 编译脚本
+func compileLua(path string) (*lua.FunctionProto, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunk, err := parse.Parse(f, path)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, path)
+}
+
+// 绑定消息至脚本
+func BindLua(name, path string) error {
+	proto, err := compileLua(path)
+	if err != nil {
+		return err
+	}
+	size := luaOptions.PoolSize
+	if size < 1 {
+		size = 1
+	}
+	h := &luaHandler{path: path, proto: proto, pool: make(chan *lua.LState, size)}
+	// 预先执行一次，检查脚本的运行错误
+	L, err := h.newState()
+	if err != nil {
+		return err
+	}
+	h.put(L)
+
+	log.Infof("bind lua %s %s", name, path)
+	cmd.RebindWithName(name, h.handle, (*json.RawMessage)(nil), cmd.WithConcurrency(cmd.RunSerialSession))
+
+	luaHandlerMu.Lock()
+	old := luaHandlers[name]
+	luaHandlers[name] = h
+	luaHandlerMu.Unlock()
+	// 释放旧脚本
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+func (h *luaHandler) newState() (*lua.LState, error) {
+	L := lua.NewState()
+	L.Push(L.NewFunctionFromProto(h.proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+func (h *luaHandler) get() (*lua.LState, error) {
+	select {
+	case L := <-h.pool:
+		return L, nil
+	default:
+	}
+
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if closed {
+		return nil, errLuaClosed
+	}
+	return h.newState()
+}
+
+// 放回缓存，缓存已满或脚本已替换时释放
+func (h *luaHandler) put(L *lua.LState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		select {
+		case h.pool <- L:
+			return
+		default:
+		}
+	}
+	L.Close()
+}
+
+func (h *luaHandler) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for {
+		select {
+		case L := <-h.pool:
+			L.Close()
+		default:
+			return
+		}
+	}
+}
+
+// 调用脚本的handle函数，超时后中断执行
+func (h *luaHandler) call(ctx *cmd.Context, args interface{}) error {
+	L, err := h.get()
+	if err != nil {
+		return err
+	}
+
+	fn := L.GetGlobal("handle")
+	if fn == lua.LNil {
+		h.put(L)
+		return errors.New("function handle not found")
+	}
+	if luaOptions.Timeout > 0 {
+		callCtx, cancel := context.WithTimeout(context.Background(), luaOptions.Timeout)
+		defer cancel()
+		L.SetContext(callCtx)
+	}
+	p := lua.P{Fn: fn, NRet: 0, Protect: true}
+	err = L.CallByParam(p, newLuaContext(L, ctx), toLua(L, args))
+	L.RemoveContext()
+	if err != nil {
+		// 出错或超时中断的虚拟机不再复用
+		L.Close()
+		return err
+	}
+	h.put(L)
+	return nil
+}
+
+func (h *luaHandler) handle(ctx *cmd.Context, data interface{}) {
+	raw := data.(*json.RawMessage)
+
+	var args interface{}
+	if err := json.Unmarshal(*raw, &args); err != nil {
+		log.Errorf("lua %s decode %v", h.path, err)
+		return
+	}
+
+	if err := h.call(ctx, args); err != nil && err != errLuaClosed {
+		log.Errorf("lua %s %v", h.path, err)
+	}
+}
+
+func newLuaContext(L *lua.LState, ctx *cmd.Context) *lua.LTable {
+	tb := L.NewTable()
+	tb.RawSetString("Ssid", lua.LString(ctx.Ssid))
+	tb.RawSetString("WriteJSON", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		ctx.WriteJSON(name, fromLua(L.Get(2)))
+		return 0
+	}))
+	tb.RawSetString("Route", L.NewFunction(func(L *lua.LState) int {
+		serverName, name := L.CheckString(1), L.CheckString(2)
+		data := fromLua(L.Get(3))
+		// 网关中的会话按会话路由，其他服务携带会话ID转发
+		if ctx.Ssid == "" {
+			cmd.Route(serverName, name, data)
+		} else if ss := cmd.GetSession(ctx.Ssid); ss != nil {
+			ss.Route(serverName, name, data)
+		} else {
+			cmd.RouteSession(ctx.Ssid, serverName, name, data)
+		}
+		return 0
+	}))
+	return tb
+}
+
+// JSON数据转换为Lua数据
+func toLua(L *lua.LState, i interface{}) lua.LValue {
+	switch v := i.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		tb := L.NewTable()
+		for k, item := range v {
+			tb.RawSetInt(k+1, toLua(L, item))
+		}
+		return tb
+	case map[string]interface{}:
+		tb := L.NewTable()
+		for k, item := range v {
+			tb.RawSetString(k, toLua(L, item))
+		}
+		return tb
+	}
+	return lua.LNil
+}
+
+// 下标从1连续的表转换为数组，其他表转换为对象
+func fromLua(v lua.LValue) interface{} {
+	switch lv := v.(type) {
+	case lua.LBool:
+		return bool(lv)
+	case lua.LNumber:
+		return float64(lv)
+	case lua.LString:
+		return string(lv)
+	case *lua.LTable:
+		if n := lv.MaxN(); n > 0 {
+			a := make([]interface{}, n)
+			for k := range a {
+				a[k] = fromLua(lv.RawGetInt(k + 1))
+			}
+			return a
+		}
+		m := make(map[string]interface{})
+		lv.ForEach(func(key, value lua.LValue) {
+			m[key.String()] = fromLua(value)
+		})
+		return m
+	}
+	return nil
+}
+
+func funcReloadScripts(ctx *cmd.Context, data interface{}) {
+	if err := LoadDir(Dir()); err != nil {
+		log.Errorf("reload scripts %v", err)
+	}
+}
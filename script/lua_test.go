@@ -0,0 +1,172 @@
+package script
+
+import (
+	"github.com/guogeer/husky/cmd"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录写入消息的连接
+type recordConn struct {
+	names []string
+	data  []interface{}
+	mu    sync.Mutex
+}
+
+func (c *recordConn) Write(buf []byte) error {
+	return nil
+}
+
+func (c *recordConn) WriteJSON(name string, i interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names = append(c.names, name)
+	c.data = append(c.data, i)
+	return nil
+}
+
+func (c *recordConn) RemoteAddr() string {
+	return "127.0.0.1:0"
+}
+
+func (c *recordConn) Close() {}
+
+func writeScript(t *testing.T, dir, name, src string) string {
+	path := filepath.Join(dir, name+".lua")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func callLua(t *testing.T, name string, args interface{}) (*recordConn, error) {
+	luaHandlerMu.Lock()
+	h := luaHandlers[name]
+	luaHandlerMu.Unlock()
+	if h == nil {
+		t.Fatal("lua not bound", name)
+	}
+	out := &recordConn{}
+	return out, h.call(&cmd.Context{Out: out}, args)
+}
+
+func TestLuaLoadAndCall(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "C2S_LuaEcho", `
+function handle(ctx, args)
+	ctx.WriteJSON("S2C_LuaEcho", {Name = args.Name, N = args.N + 1})
+end`)
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := callLua(t, "C2S_LuaEcho", map[string]interface{}{"Name": "a", "N": 1.0})
+			if err != nil || len(out.names) != 1 || out.names[0] != "S2C_LuaEcho" {
+				t.Error("call", err, out.names)
+				return
+			}
+			if m, _ := out.data[0].(map[string]interface{}); m["Name"] != "a" || m["N"] != 2.0 {
+				t.Error("reply", out.data[0])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLuaError(t *testing.T) {
+	dir := t.TempDir()
+	if err := BindLua("C2S_LuaSyntax", writeScript(t, dir, "C2S_LuaSyntax", "function handle(")); err == nil {
+		t.Error("load syntax error")
+	}
+	if err := BindLua("C2S_LuaInit", writeScript(t, dir, "C2S_LuaInit", `error("init")`)); err == nil {
+		t.Error("load runtime error")
+	}
+
+	BindLua("C2S_LuaError", writeScript(t, dir, "C2S_LuaError", `
+function handle(ctx, args)
+	if args.Fail then
+		error("fail")
+	end
+	ctx.WriteJSON("S2C_LuaError", {})
+end`))
+	if _, err := callLua(t, "C2S_LuaError", map[string]interface{}{"Fail": true}); err == nil || !strings.Contains(err.Error(), "fail") {
+		t.Error("runtime error", err)
+	}
+	// 出错后仍可继续调用
+	if out, err := callLua(t, "C2S_LuaError", map[string]interface{}{}); err != nil || len(out.names) != 1 {
+		t.Error("call after error", err)
+	}
+
+	BindLua("C2S_LuaNoHandle", writeScript(t, dir, "C2S_LuaNoHandle", "x = 1"))
+	if _, err := callLua(t, "C2S_LuaNoHandle", nil); err == nil {
+		t.Error("handle not found")
+	}
+}
+
+func TestLuaTimeout(t *testing.T) {
+	defer func(d time.Duration) { luaOptions.Timeout = d }(luaOptions.Timeout)
+	luaOptions.Timeout = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	BindLua("C2S_LuaLoop", writeScript(t, dir, "C2S_LuaLoop", `
+function handle(ctx, args)
+	while true do end
+end`))
+	start := time.Now()
+	if _, err := callLua(t, "C2S_LuaLoop", nil); err == nil {
+		t.Error("infinite loop not interrupted")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("timeout", d)
+	}
+}
+
+func TestLuaReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeScript(t, dir, "C2S_LuaReload", `
+function handle(ctx, args)
+	ctx.WriteJSON("S2C_V1", {})
+end`)
+	if err := BindLua("C2S_LuaReload", path); err != nil {
+		t.Fatal(err)
+	}
+	luaHandlerMu.Lock()
+	old := luaHandlers["C2S_LuaReload"]
+	luaHandlerMu.Unlock()
+
+	writeScript(t, dir, "C2S_LuaReload", `
+function handle(ctx, args)
+	ctx.WriteJSON("S2C_V2", {})
+end`)
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := callLua(t, "C2S_LuaReload", nil); err != nil || len(out.names) != 1 || out.names[0] != "S2C_V2" {
+		t.Error("reload", err, out.names)
+	}
+	// 旧脚本释放缓存的虚拟机，不再执行
+	if len(old.pool) != 0 {
+		t.Error("old pool not released")
+	}
+	if err := old.call(&cmd.Context{Out: &recordConn{}}, nil); err != errLuaClosed {
+		t.Error("call replaced script", err)
+	}
+
+	// 新脚本加载失败时保留原脚本
+	writeScript(t, dir, "C2S_LuaReload", "function handle(")
+	if err := BindLua("C2S_LuaReload", path); err == nil {
+		t.Error("reload syntax error")
+	}
+	if out, err := callLua(t, "C2S_LuaReload", nil); err != nil || len(out.names) != 1 || out.names[0] != "S2C_V2" {
+		t.Error("keep old script", err, out.names)
+	}
+}
@@ -0,0 +1,135 @@
+package util
+
+// 二进制差异，按块匹配旧数据（类似rsync），用于下发配置等增量更新
+// 差异由若干指令组成：
+//   'C' 偏移 长度 复制旧数据
+//   'I' 长度 数据 插入新数据
+// 偏移、长度使用uvarint编码
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const diffBlockSize = 64
+
+var ErrInvalidDiff = errors.New("invalid diff")
+
+// 弱校验和，可滚动计算
+func weakSum(data []byte) (uint32, uint32) {
+	var a, b uint32
+	for i, c := range data {
+		a += uint32(c)
+		b += uint32(len(data)-i) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+type diffWriter struct {
+	buf     bytes.Buffer
+	pending []byte // 待插入的数据
+}
+
+func (w *diffWriter) uvarint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (w *diffWriter) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+	w.buf.WriteByte('I')
+	w.uvarint(len(w.pending))
+	w.buf.Write(w.pending)
+	w.pending = w.pending[:0]
+}
+
+func (w *diffWriter) copy(offset, n int) {
+	w.flush()
+	w.buf.WriteByte('C')
+	w.uvarint(offset)
+	w.uvarint(n)
+}
+
+// 计算由base生成target的差异
+func Diff(base, target []byte) []byte {
+	blocks := make(map[uint32][]int)
+	for off := 0; off+diffBlockSize <= len(base); off += diffBlockSize {
+		a, b := weakSum(base[off : off+diffBlockSize])
+		sum := a | b<<16
+		blocks[sum] = append(blocks[sum], off)
+	}
+
+	w := &diffWriter{}
+	i := 0
+	var a, b uint32
+	rolling := false
+	for i+diffBlockSize <= len(target) {
+		if !rolling {
+			a, b = weakSum(target[i : i+diffBlockSize])
+			rolling = true
+		}
+		matched := -1
+		for _, off := range blocks[a|b<<16] {
+			if bytes.Equal(base[off:off+diffBlockSize], target[i:i+diffBlockSize]) {
+				matched = off
+				break
+			}
+		}
+		if matched < 0 {
+			// 窗口后移一个字节
+			out := uint32(target[i])
+			w.pending = append(w.pending, target[i])
+			i++
+			if i+diffBlockSize <= len(target) {
+				in := uint32(target[i+diffBlockSize-1])
+				a = (a - out + in) & 0xffff
+				b = (b - diffBlockSize*out + a) & 0xffff
+			}
+			continue
+		}
+
+		// 合并连续匹配的块
+		n := diffBlockSize
+		for matched+n < len(base) && i+n < len(target) && base[matched+n] == target[i+n] {
+			n++
+		}
+		w.copy(matched, n)
+		i += n
+		rolling = false
+	}
+	w.pending = append(w.pending, target[i:]...)
+	w.flush()
+	return w.buf.Bytes()
+}
+
+// 根据差异由base生成新数据
+func Patch(base, diff []byte) ([]byte, error) {
+	var out bytes.Buffer
+	r := bytes.NewReader(diff)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case 'C':
+			offset, err1 := binary.ReadUvarint(r)
+			n, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || offset > uint64(len(base)) || n > uint64(len(base))-offset {
+				return nil, ErrInvalidDiff
+			}
+			out.Write(base[offset : offset+n])
+		case 'I':
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, ErrInvalidDiff
+			}
+			data := make([]byte, n)
+			r.Read(data)
+			out.Write(data)
+		default:
+			return nil, ErrInvalidDiff
+		}
+	}
+	return out.Bytes(), nil
+}
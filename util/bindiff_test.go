@@ -0,0 +1,75 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDiffPatch(t *testing.T) {
+	base := make([]byte, 8<<10)
+	rand.New(rand.NewSource(1)).Read(base)
+
+	target := append([]byte("head"), base[:1000]...)
+	target = append(target, []byte("changed")...)
+	target = append(target, base[1100:]...)
+	samples := [][]byte{nil, []byte("short"), base, target}
+	for _, data := range samples {
+		diff := Diff(base, data)
+		out, err := Patch(base, diff)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Error("patch", len(data), err)
+		}
+	}
+
+	// 少量修改时差异远小于原数据
+	if diff := Diff(base, target); len(diff) > 256 {
+		t.Error("diff too large", len(diff))
+	}
+	if _, err := Patch(base, []byte{'C', 0xff, 0x01}); err != ErrInvalidDiff {
+		t.Error("invalid diff", err)
+	}
+}
+
+func appendCopy(diff []byte, offset, n uint64) []byte {
+	diff = append(diff, 'C')
+	diff = binary.AppendUvarint(diff, offset)
+	return binary.AppendUvarint(diff, n)
+}
+
+func TestPatchCopyRange(t *testing.T) {
+	base := []byte("0123456789")
+	samples := []struct {
+		offset, n uint64
+		ok        bool
+	}{
+		{0, 10, true},
+		{10, 0, true},
+		{9, 1, true},
+		{9, 2, false},
+		{11, 0, false},
+		{math.MaxUint64, 2, false}, // offset+n溢出
+		{2, math.MaxUint64 - 1, false},
+	}
+	for _, sample := range samples {
+		out, err := Patch(base, appendCopy(nil, sample.offset, sample.n))
+		if (err == nil) != sample.ok {
+			t.Error(sample, err)
+		}
+		if err == nil && !bytes.Equal(out, base[sample.offset:sample.offset+sample.n]) {
+			t.Error(sample, out)
+		}
+	}
+}
+
+func FuzzPatch(f *testing.F) {
+	base := []byte("0123456789")
+	f.Add(Diff(base, []byte("01234abc")))
+	f.Add(appendCopy(nil, math.MaxUint64, 2))
+	f.Add([]byte{'I', 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, diff []byte) {
+		Patch(base, diff)
+	})
+}
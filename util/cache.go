@@ -0,0 +1,172 @@
+package util
+
+// 协程安全的LRU缓存，可选条目过期时间及容量限制
+//   c := util.NewCache(&util.CacheOptions{MaxEntries: 10000, TTL: time.Hour})
+//   c.Set(uid, profile)
+//   v, ok := c.Get(uid)
+// 超出数量或总开销上限时淘汰最久未访问的条目，过期条目在访问时删除
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type CacheOptions struct {
+	MaxEntries int                          // 条目数量上限，0不限制
+	MaxCost    int64                        // 总开销上限，0不限制
+	TTL        time.Duration                // 默认过期时间，0不过期
+	OnEvict    func(key, value interface{}) // 条目淘汰、过期或删除时回调，持有缓存锁时调用
+}
+
+type cacheEntry struct {
+	key, value interface{}
+	cost       int64
+	expireAt   time.Time
+}
+
+type Cache struct {
+	opts  CacheOptions
+	ll    *list.List
+	items map[interface{}]*list.Element
+	cost  int64
+	mu    sync.Mutex
+}
+
+func NewCache(opts *CacheOptions) *Cache {
+	c := &Cache{
+		ll:    list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	return c
+}
+
+func (c *Cache) Set(key, value interface{}) {
+	c.SetEx(key, value, 1, c.opts.TTL)
+}
+
+// 指定开销及过期时间，ttl为0时不过期
+func (c *Cache) SetEx(key, value interface{}, cost int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = Now().Add(ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*cacheEntry)
+		c.cost += cost - e.cost
+		e.value, e.cost, e.expireAt = value, cost, expireAt
+		c.ll.MoveToFront(elem)
+	} else {
+		e := &cacheEntry{key: key, value: value, cost: cost, expireAt: expireAt}
+		c.items[key] = c.ll.PushFront(e)
+		c.cost += cost
+	}
+	for c.ll.Len() > 0 && c.overflow() {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *Cache) overflow() bool {
+	if max := c.opts.MaxEntries; max > 0 && c.ll.Len() > max {
+		return true
+	}
+	if max := c.opts.MaxCost; max > 0 && c.cost > max {
+		return true
+	}
+	return false
+}
+
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	if !e.expireAt.IsZero() && Now().After(e.expireAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return e.value, true
+}
+
+func (c *Cache) Delete(key interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	e := elem.Value.(*cacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, e.key)
+	c.cost -= e.cost
+	if c.opts.OnEvict != nil {
+		c.opts.OnEvict(e.key, e.value)
+	}
+}
+
+// 删除全部过期条目
+func (c *Cache) Expire() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := Now()
+	n := 0
+	for elem := c.ll.Back(); elem != nil; {
+		prev := elem.Prev()
+		if e := elem.Value.(*cacheEntry); !e.expireAt.IsZero() && now.After(e.expireAt) {
+			c.removeElement(elem)
+			n++
+		}
+		elem = prev
+	}
+	return n
+}
+
+// 遍历未过期的条目，f返回false时停止，遍历期间不可调用缓存的其他方法
+func (c *Cache) Range(f func(key, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := Now()
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*cacheEntry)
+		if !e.expireAt.IsZero() && now.After(e.expireAt) {
+			continue
+		}
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache) Cost() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cost
+}
+
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+	}
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var evicted []interface{}
+	c := NewCache(&CacheOptions{
+		MaxEntries: 2,
+		OnEvict:    func(key, value interface{}) { evicted = append(evicted, key) },
+	})
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	// b最久未访问被淘汰
+	if _, ok := c.Get("b"); ok || len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("lru evict", evicted)
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Error("get", v, ok)
+	}
+
+	c = NewCache(&CacheOptions{MaxCost: 10})
+	c.SetEx("a", nil, 6, 0)
+	c.SetEx("b", nil, 6, 0)
+	if c.Len() != 1 || c.Cost() != 6 {
+		t.Error("cost limit", c.Len(), c.Cost())
+	}
+
+	clock := NewVirtualClock(time.Now())
+	SetClock(clock)
+	defer SetClock(nil)
+	c = NewCache(&CacheOptions{TTL: time.Minute})
+	c.Set("a", 1)
+	c.SetEx("b", 2, 1, time.Hour)
+	clock.Advance(2 * time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry not expire")
+	}
+	if n := c.Expire(); n != 0 || c.Len() != 1 {
+		t.Error("expire", n, c.Len())
+	}
+}
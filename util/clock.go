@@ -0,0 +1,83 @@
+package util
+
+// 定时器使用的时钟，测试时可替换为虚拟时钟手动推进时间
+//   clock := util.NewVirtualClock(start)
+//   util.SetClock(clock)
+//   defer util.SetClock(nil)
+//   clock.Advance(time.Minute) // 依次触发到期的定时器
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+type clockHolder struct {
+	Clock
+}
+
+var defaultClock atomic.Value
+
+func init() {
+	defaultClock.Store(clockHolder{realClock{}})
+}
+
+// 替换时钟，nil时恢复系统时钟
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	defaultClock.Store(clockHolder{c})
+}
+
+func Now() time.Time {
+	return defaultClock.Load().(clockHolder).Now()
+}
+
+type VirtualClock struct {
+	t  time.Time
+	mu sync.RWMutex
+}
+
+func NewVirtualClock(t time.Time) *VirtualClock {
+	return &VirtualClock{t: t}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t
+}
+
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+// 推进时间，按到期顺序触发默认定时器，定时器内新建的定时器到期时同样触发
+func (c *VirtualClock) Advance(d time.Duration) {
+	end := c.Now().Add(d)
+	tm := GetTimerManage()
+	for tm.h.Len() > 0 {
+		next := tm.h[0].t
+		if next.After(end) {
+			break
+		}
+		if next.After(c.Now()) {
+			c.Set(next)
+		}
+		tm.Run()
+	}
+	c.Set(end)
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start, _ := ParseTime("2020-01-01 00:00:00")
+	clock := NewVirtualClock(start)
+	SetClock(clock)
+	defer SetClock(nil)
+
+	var fired []time.Duration
+	NewTimer(func() {
+		fired = append(fired, Now().Sub(start))
+		NewTimer(func() { fired = append(fired, Now().Sub(start)) }, 5*time.Second)
+	}, 10*time.Second)
+	timer := NewPeriodTimer(func() { fired = append(fired, Now().Sub(start)) }, "2019-12-31 23:59:57", 7*time.Second)
+	defer StopTimer(timer)
+
+	clock.Advance(9 * time.Second)
+	if len(fired) != 1 || fired[0] != 4*time.Second {
+		t.Fatal(fired)
+	}
+	clock.Advance(6 * time.Second)
+	expect := []time.Duration{4 * time.Second, 10 * time.Second, 11 * time.Second, 15 * time.Second}
+	if len(fired) != len(expect) {
+		t.Fatal(fired)
+	}
+	for i := range expect {
+		if fired[i] != expect[i] {
+			t.Fatal(fired)
+		}
+	}
+	if !Now().Equal(start.Add(15 * time.Second)) {
+		t.Error(Now())
+	}
+}
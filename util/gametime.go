@@ -0,0 +1,93 @@
+package util
+
+// 服务器时区及跨天、跨周计算
+// 每日重置以服务器时区的重置时刻为界，不依赖机器的本地时区，当前时间统一通过Now()获取，测试时可替换时钟
+//   util.SetTimeZone("Asia/Shanghai")
+//   util.IsSameDay(lastLogin, util.Now(), 5*time.Hour) // 每天5点重置
+//   util.NewDailyTimer(resetTasks, 5*time.Hour)
+// 重置时刻按当地时钟计算，夏令时切换当天同样在5点触发
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var serverLocation atomic.Value
+
+func init() {
+	serverLocation.Store(time.Local)
+}
+
+// 服务器时区，如Asia/Shanghai
+func SetTimeZone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	SetLocation(loc)
+	return nil
+}
+
+// nil时恢复本地时区
+func SetLocation(loc *time.Location) {
+	if loc == nil {
+		loc = time.Local
+	}
+	serverLocation.Store(loc)
+}
+
+func Location() *time.Location {
+	return serverLocation.Load().(*time.Location)
+}
+
+// 重置时刻对应的时分秒，超过一天时取余
+func resetClock(reset time.Duration) (int, int, int, int) {
+	reset %= 24 * time.Hour
+	if reset < 0 {
+		reset += 24 * time.Hour
+	}
+	return int(reset / time.Hour), int(reset % time.Hour / time.Minute), int(reset % time.Minute / time.Second), int(reset % time.Second)
+}
+
+// t所属游戏日的开始时间
+func DayStart(t time.Time, reset time.Duration) time.Time {
+	t = t.In(Location())
+	h, m, s, ns := resetClock(reset)
+	year, month, day := t.Date()
+	start := time.Date(year, month, day, h, m, s, ns, t.Location())
+	if start.After(t) {
+		start = time.Date(year, month, day-1, h, m, s, ns, t.Location())
+	}
+	return start
+}
+
+// t之后的下一次重置时间
+func NextDailyReset(t time.Time, reset time.Duration) time.Time {
+	start := DayStart(t, reset)
+	h, m, s, ns := resetClock(reset)
+	year, month, day := start.Date()
+	return time.Date(year, month, day+1, h, m, s, ns, start.Location())
+}
+
+func IsSameDay(a, b time.Time, reset time.Duration) bool {
+	return DayStart(a, reset).Equal(DayStart(b, reset))
+}
+
+// 1970-01-01起的游戏日序号
+func DayIndex(t time.Time, reset time.Duration) int {
+	year, month, day := DayStart(t, reset).Date()
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// 1970-01-05（周一）起的游戏周序号，每周一的重置时刻开始新的一周
+func WeekIndex(t time.Time, reset time.Duration) int {
+	days := DayIndex(t, reset) - 4
+	if days < 0 {
+		return (days - 6) / 7
+	}
+	return days / 7
+}
+
+func IsSameWeek(a, b time.Time, reset time.Duration) bool {
+	return WeekIndex(a, reset) == WeekIndex(b, reset)
+}
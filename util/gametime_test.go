@@ -0,0 +1,76 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGameTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	SetLocation(loc)
+	defer SetLocation(nil)
+
+	reset := 5 * time.Hour
+	at := func(s string) time.Time {
+		tm, _ := ParseTime(s)
+		return tm
+	}
+	samples := []struct {
+		a, b string
+		same bool
+	}{
+		{"2020-03-02 05:00:00", "2020-03-03 04:59:59", true},
+		{"2020-03-02 04:59:59", "2020-03-02 05:00:00", false},
+		{"2020-03-08 04:00:00", "2020-03-07 23:00:00", true},
+	}
+	for _, sample := range samples {
+		if IsSameDay(at(sample.a), at(sample.b), reset) != sample.same {
+			t.Error(sample)
+		}
+	}
+	// 夏令时切换当天仍在当地5点重置
+	next := NextDailyReset(at("2020-03-07 12:00:00"), reset)
+	if s := next.Format("2006-01-02 15:04:05"); s != "2020-03-08 05:00:00" {
+		t.Error(s)
+	}
+	// 其他时区的时间按服务器时区计算
+	if !IsSameDay(at("2020-03-02 23:00:00"), at("2020-03-03 04:00:00").UTC(), reset) {
+		t.Error("same day in utc")
+	}
+
+	if WeekIndex(at("2020-03-02 05:00:00"), reset) != WeekIndex(at("2020-03-09 04:59:59"), reset) {
+		t.Error("same week")
+	}
+	if IsSameWeek(at("2020-03-02 04:59:59"), at("2020-03-02 05:00:00"), reset) {
+		t.Error("new week on monday")
+	}
+	if n := WeekIndex(at("1970-01-04 12:00:00"), 0); n != -1 {
+		t.Error(n)
+	}
+}
+
+func TestDailyTimer(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	SetLocation(loc)
+	defer SetLocation(nil)
+
+	start, _ := ParseTime("2020-03-07 12:00:00")
+	clock := NewVirtualClock(start)
+	SetClock(clock)
+	defer SetClock(nil)
+
+	var fired []string
+	timer := NewDailyTimer(func() { fired = append(fired, Now().In(loc).Format("01-02 15:04")) }, 5*time.Hour)
+	defer StopTimer(timer)
+
+	clock.Advance(48 * time.Hour)
+	if len(fired) != 2 || fired[0] != "03-08 05:00" || fired[1] != "03-09 05:00" {
+		t.Error(fired)
+	}
+}
@@ -0,0 +1,132 @@
+package util
+
+// 一致性哈希，每个节点对应多个虚拟节点，节点增删时仅少量键迁移
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultVirtualNodes = 160
+	MaxNodeWeight       = 16 // 节点权重上限
+)
+
+type HashRing struct {
+	replicas int
+	hashes   []uint32          // 已排序的虚拟节点哈希
+	owners   map[uint32]string // 虚拟节点所属节点
+	nodes    map[string]bool
+	mu       sync.RWMutex
+}
+
+// replicas为每个节点的虚拟节点数，<=0时使用默认值
+func NewHashRing(replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultVirtualNodes
+	}
+	return &HashRing{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		nodes:    make(map[string]bool),
+	}
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+func (r *HashRing) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		r.add(node, r.replicas)
+	}
+	r.sort()
+}
+
+// 按权重增加节点，虚拟节点数为权重的倍数，权重限制在[1,16]
+func (r *HashRing) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if weight > MaxNodeWeight {
+		weight = MaxNodeWeight
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(node, r.replicas*weight)
+	r.sort()
+}
+
+func (r *HashRing) add(node string, n int) {
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+	for i := 0; i < n; i++ {
+		h := hashKey(strconv.Itoa(i) + "#" + node)
+		// 哈希冲突时保留已有的虚拟节点
+		if _, ok := r.owners[h]; ok {
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+}
+
+func (r *HashRing) sort() {
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *HashRing) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+		} else {
+			hashes = append(hashes, h)
+		}
+	}
+	r.hashes = hashes
+}
+
+// 键所属的节点，环为空时返回空字符串
+func (r *HashRing) GetNode(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+func (r *HashRing) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (r *HashRing) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes)
+}
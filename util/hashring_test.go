@@ -0,0 +1,56 @@
+package util
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing(0)
+	if node := r.GetNode("a"); node != "" {
+		t.Error("empty ring", node)
+	}
+
+	r.Add("s1", "s2", "s3")
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		node := r.GetNode(key)
+		counts[node]++
+		owners[key] = node
+	}
+	for _, node := range r.Nodes() {
+		if counts[node] < 2000 {
+			t.Error("unbalanced ring", counts)
+		}
+	}
+
+	// 删除节点后仅该节点的键迁移
+	r.Remove("s2")
+	for key, node := range owners {
+		if node2 := r.GetNode(key); node != "s2" && node2 != node {
+			t.Error("key moved", key, node, node2)
+		}
+	}
+	if r.Len() != 2 {
+		t.Error("ring nodes", r.Nodes())
+	}
+}
+
+func TestHashRingWeighted(t *testing.T) {
+	r := NewHashRing(0)
+	r.AddWeighted("s1", 1)
+	r.AddWeighted("s2", 3)
+	r.AddWeighted("s3", 1000) // 超过上限按上限计算
+	counts := make(map[string]int)
+	for i := 0; i < 20000; i++ {
+		counts[r.GetNode(strconv.Itoa(i))]++
+	}
+	if counts["s2"] < 2*counts["s1"] || counts["s3"] < 3*counts["s2"] || counts["s3"] > 30*counts["s1"] {
+		t.Error("weighted ring", counts)
+	}
+	if len(r.hashes) > 20*defaultVirtualNodes {
+		t.Error("virtual nodes", len(r.hashes))
+	}
+}
@@ -0,0 +1,59 @@
+package util
+
+// 分布式ID，64位：41位毫秒时间戳 + 10位节点ID + 12位序号
+// 时钟回拨时沿用上次的时间戳，保证ID单调递增
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	idWorkerBits   = 10
+	idSequenceBits = 12
+	MaxIDWorker    = 1<<idWorkerBits - 1
+	idSequenceMask = 1<<idSequenceBits - 1
+)
+
+// 2018-01-01 00:00:00 UTC
+var idEpoch = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+type IDGenerator struct {
+	workerID int64
+	last     int64 // 上次生成ID的时间戳
+	sequence int64
+	mu       sync.Mutex
+}
+
+// workerID范围[0,MaxIDWorker]，各进程需唯一
+func NewIDGenerator(workerID int) *IDGenerator {
+	if workerID < 0 || workerID > MaxIDWorker {
+		panic(fmt.Sprintf("id worker %d out of range [0,%d]", workerID, MaxIDWorker))
+	}
+	return &IDGenerator{workerID: int64(workerID)}
+}
+
+func (g *IDGenerator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ts := time.Now().UnixNano()/int64(time.Millisecond) - idEpoch
+	if ts > g.last {
+		g.last, g.sequence = ts, 0
+	} else {
+		// 同一毫秒或时钟回拨
+		g.sequence = (g.sequence + 1) & idSequenceMask
+		if g.sequence == 0 {
+			g.last++ // 序号用尽，借用下一毫秒
+		}
+	}
+	return g.last<<(idWorkerBits+idSequenceBits) | g.workerID<<idSequenceBits | g.sequence
+}
+
+// 解析ID的生成时间及节点
+func ParseID(id int64) (time.Time, int) {
+	ms := id>>(idWorkerBits+idSequenceBits) + idEpoch
+	worker := int(id >> idSequenceBits & MaxIDWorker)
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), worker
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIDGenerator(t *testing.T) {
+	g := NewIDGenerator(7)
+	last := int64(0)
+	for i := 0; i < 10000; i++ {
+		id := g.Next()
+		if id <= last {
+			t.Fatal("id not monotonic", last, id)
+		}
+		last = id
+	}
+
+	// 时钟回拨
+	g.last += 1000
+	if id := g.Next(); id <= last {
+		t.Error("id after clock rollback", last, id)
+	}
+
+	ts, worker := ParseID(NewIDGenerator(7).Next())
+	if worker != 7 || time.Since(ts) > time.Second {
+		t.Error("parse id", ts, worker)
+	}
+}
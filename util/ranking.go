@@ -0,0 +1,316 @@
+package util
+
+// 协程安全的排行榜，跳表实现，与redis的zset类似
+//   r := util.NewRanking(true) // 分数从高到低
+//   r.Add("uid1", 100)
+//   rank := r.Rank("uid1")     // 从0开始，不存在时返回-1
+//   top := r.RangeByRank(0, 9) // 前10名
+// 分数相同时按成员升序排列，NaN及±Inf无法参与排序，新增或更新时忽略
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	rankingMaxLevel = 32
+	rankingP        = 0.25
+)
+
+type RankItem struct {
+	Member string
+	Score  float64
+}
+
+type rankingLevel struct {
+	forward *rankingNode
+	span    int // 至下一节点跨越的节点数
+}
+
+type rankingNode struct {
+	item  RankItem
+	level []rankingLevel
+}
+
+type Ranking struct {
+	desc   bool
+	head   *rankingNode
+	level  int
+	length int
+	scores map[string]float64
+	mu     sync.RWMutex
+}
+
+// desc为true时分数从高到低排列
+func NewRanking(desc bool) *Ranking {
+	return &Ranking{
+		desc:   desc,
+		head:   &rankingNode{level: make([]rankingLevel, rankingMaxLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+func randomRankingLevel() int {
+	level := 1
+	for level < rankingMaxLevel && rand.Float64() < rankingP {
+		level++
+	}
+	return level
+}
+
+// a排在b之前
+func (r *Ranking) less(a, b RankItem) bool {
+	if a.Score != b.Score {
+		if r.desc {
+			return a.Score > b.Score
+		}
+		return a.Score < b.Score
+	}
+	return a.Member < b.Member
+}
+
+func (r *Ranking) insert(item RankItem) {
+	var update [rankingMaxLevel]*rankingNode
+	var rank [rankingMaxLevel]int
+
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		if i < r.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && r.less(x.level[i].forward.item, item) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomRankingLevel()
+	if level > r.level {
+		for i := r.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = r.head
+			update[i].level[i].span = r.length
+		}
+		r.level = level
+	}
+
+	x = &rankingNode{item: item, level: make([]rankingLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < r.level; i++ {
+		update[i].level[i].span++
+	}
+	r.length++
+}
+
+func (r *Ranking) delete(item RankItem) {
+	var update [rankingMaxLevel]*rankingNode
+
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.less(x.level[i].forward.item, item) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.item != item {
+		return
+	}
+
+	for i := 0; i < r.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	for r.level > 1 && r.head.level[r.level-1].forward == nil {
+		r.level--
+	}
+	r.length--
+}
+
+// 排名从1开始
+func (r *Ranking) nodeByRank(rank int) *rankingNode {
+	var traversed int
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func isValidScore(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+func (r *Ranking) set(member string, score float64) {
+	if old, ok := r.scores[member]; ok {
+		if old == score {
+			return
+		}
+		r.delete(RankItem{Member: member, Score: old})
+	}
+	r.scores[member] = score
+	r.insert(RankItem{Member: member, Score: score})
+}
+
+// 新增或更新成员的分数，新增时返回true，分数无效时忽略并返回false
+func (r *Ranking) Add(member string, score float64) bool {
+	if !isValidScore(score) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.scores[member]
+	r.set(member, score)
+	return !ok
+}
+
+// 更新已存在成员的分数，成员不存在或分数无效时返回false
+func (r *Ranking) UpdateScore(member string, score float64) bool {
+	if !isValidScore(score) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.scores[member]; !ok {
+		return false
+	}
+	r.set(member, score)
+	return true
+}
+
+// 增加成员的分数，成员不存在时新增，返回新的分数。结果无效时不修改，返回原分数
+func (r *Ranking) Incr(member string, delta float64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.scores[member]
+	score := old + delta
+	if !isValidScore(score) {
+		return old
+	}
+	r.set(member, score)
+	return score
+}
+
+func (r *Ranking) Remove(member string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	score, ok := r.scores[member]
+	if ok {
+		delete(r.scores, member)
+		r.delete(RankItem{Member: member, Score: score})
+	}
+	return ok
+}
+
+func (r *Ranking) Score(member string) (float64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	score, ok := r.scores[member]
+	return score, ok
+}
+
+func (r *Ranking) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.length
+}
+
+// 成员的排名，从0开始，不存在时返回-1
+func (r *Ranking) Rank(member string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	score, ok := r.scores[member]
+	if !ok {
+		return -1
+	}
+	item := RankItem{Member: member, Score: score}
+
+	var rank int
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.less(item, x.level[i].forward.item) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x.item == item && x != r.head {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// 排名在[start,stop]内的成员，从0开始，负数表示倒数
+func (r *Ranking) RangeByRank(start, stop int) []RankItem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if start < 0 {
+		start += r.length
+	}
+	if stop < 0 {
+		stop += r.length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= r.length {
+		stop = r.length - 1
+	}
+	if start > stop {
+		return nil
+	}
+
+	items := make([]RankItem, 0, stop-start+1)
+	for x := r.nodeByRank(start + 1); x != nil && len(items) < cap(items); x = x.level[0].forward {
+		items = append(items, x.item)
+	}
+	return items
+}
+
+// 分数在[min,max]内的成员，按排名顺序
+func (r *Ranking) RangeByScore(min, max float64) []RankItem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if min > max {
+		return nil
+	}
+	// 排在范围之前
+	before := func(score float64) bool {
+		if r.desc {
+			return score > max
+		}
+		return score < min
+	}
+
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward.item.Score) {
+			x = x.level[i].forward
+		}
+	}
+	var items []RankItem
+	for x = x.level[0].forward; x != nil; x = x.level[0].forward {
+		if score := x.item.Score; score < min || score > max {
+			break
+		}
+		items = append(items, x.item)
+	}
+	return items
+}
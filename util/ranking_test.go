@@ -0,0 +1,111 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestRanking(t *testing.T) {
+	r := NewRanking(true)
+	for i := 0; i < 1000; i++ {
+		r.Add(strconv.Itoa(i), float64(rand.Intn(100)))
+	}
+	for i := 0; i < 300; i++ {
+		member := strconv.Itoa(rand.Intn(1000))
+		switch i % 3 {
+		case 0:
+			r.UpdateScore(member, float64(rand.Intn(100)))
+		case 1:
+			r.Incr(member, 1)
+		case 2:
+			r.Remove(member)
+		}
+	}
+	if r.UpdateScore("none", 1) {
+		t.Error("update absent member")
+	}
+
+	// 与排序结果比较
+	var expect []RankItem
+	for member, score := range r.scores {
+		expect = append(expect, RankItem{Member: member, Score: score})
+	}
+	sort.Slice(expect, func(i, j int) bool { return r.less(expect[i], expect[j]) })
+	if r.Len() != len(expect) {
+		t.Fatal("length", r.Len(), len(expect))
+	}
+	for i, item := range expect {
+		if rank := r.Rank(item.Member); rank != i {
+			t.Fatal("rank", item, rank, i)
+		}
+	}
+	all := r.RangeByRank(0, -1)
+	for i := range all {
+		if all[i] != expect[i] {
+			t.Fatal("range by rank", i, all[i], expect[i])
+		}
+	}
+	if top := r.RangeByRank(0, 9); len(top) != 10 || top[9] != expect[9] {
+		t.Error("top 10", top)
+	}
+	if last := r.RangeByRank(-1, -1); len(last) != 1 || last[0] != expect[len(expect)-1] {
+		t.Error("last", last)
+	}
+
+	var n int
+	for _, item := range r.RangeByScore(20, 30) {
+		if item.Score < 20 || item.Score > 30 {
+			t.Error("range by score", item)
+		}
+		n++
+	}
+	for _, item := range expect {
+		if item.Score >= 20 && item.Score <= 30 {
+			n--
+		}
+	}
+	if n != 0 {
+		t.Error("range by score count", n)
+	}
+	if r.Rank("none") != -1 {
+		t.Error("rank absent member")
+	}
+}
+
+func TestRankingAsc(t *testing.T) {
+	r := NewRanking(false)
+	r.Add("b", 2)
+	r.Add("a", 2)
+	r.Add("c", 1)
+	items := r.RangeByScore(1, 2)
+	if len(items) != 3 || items[0].Member != "c" || items[1].Member != "a" {
+		t.Error("asc order", items)
+	}
+}
+
+func TestRankingInvalidScore(t *testing.T) {
+	r := NewRanking(true)
+	r.Add("a", 1)
+	r.Add("b", math.MaxFloat64)
+	for _, score := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if r.Add("c", score) || r.UpdateScore("a", score) {
+			t.Error("accept", score)
+		}
+		if n := r.Incr("a", score); n != 1 {
+			t.Error("incr", score, n)
+		}
+	}
+	// 溢出为+Inf
+	if n := r.Incr("b", math.MaxFloat64); n != math.MaxFloat64 {
+		t.Error("incr overflow", n)
+	}
+	if r.Len() != 2 || r.Rank("a") != 1 || r.Rank("b") != 0 {
+		t.Error("ranking changed", r.RangeByRank(0, -1))
+	}
+	if _, ok := r.Score("c"); ok {
+		t.Error("invalid member added")
+	}
+}
@@ -0,0 +1,88 @@
+package util
+
+// 失败重试，支持指数退避、随机抖动、最大次数及错误分类
+//   policy := &util.RetryPolicy{MaxAttempts: 5, InitialDelay: 100 * time.Millisecond, MaxDelay: 5 * time.Second}
+//   err := util.Retry(ctx, policy, func(attempt int) error { return call() })
+// 返回util.Permanent(err)的错误不再重试
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts  int           // 最大尝试次数，0不限制
+	InitialDelay time.Duration // 首次重试的间隔
+	MaxDelay     time.Duration // 间隔上限，0不限制
+	Multiplier   float64       // 间隔增长倍数，默认2
+	Jitter       float64       // 随机抖动比例，0~1
+
+	Retryable func(error) bool // 可重试的错误，为空时全部重试
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// 不可重试的错误
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// 第attempt次失败后的等待时间，attempt从1开始
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+			d = float64(p.MaxDelay)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	return time.Duration(d)
+}
+
+// 执行fn直至成功、不可重试、达到最大次数或ctx结束，返回最后一次的错误
+func Retry(ctx context.Context, p *RetryPolicy, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(*permanentError); ok {
+			return e.err
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
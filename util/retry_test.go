@@ -0,0 +1,70 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 4}
+	samples := []time.Duration{100 * time.Millisecond, 400 * time.Millisecond, time.Second, time.Second}
+	for i, d := range samples {
+		if d2 := p.Backoff(i + 1); d2 != d {
+			t.Error(i+1, d, d2)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Backoff(2); d < 200*time.Millisecond || d > 600*time.Millisecond {
+			t.Error("jitter", d)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	errFatal := errors.New("fatal")
+	p := &RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+	n := 0
+	err := Retry(context.Background(), p, func(attempt int) error {
+		n++
+		if attempt < 2 {
+			return errFlaky
+		}
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Error(err, n)
+	}
+
+	n = 0
+	err = Retry(context.Background(), p, func(int) error { n++; return errFlaky })
+	if err != errFlaky || n != 3 {
+		t.Error(err, n)
+	}
+
+	n = 0
+	err = Retry(context.Background(), p, func(int) error { n++; return Permanent(errFatal) })
+	if err != errFatal || n != 1 {
+		t.Error(err, n)
+	}
+
+	p.Retryable = func(err error) bool { return err == errFlaky }
+	n = 0
+	err = Retry(context.Background(), p, func(int) error { n++; return errFatal })
+	if err != errFatal || n != 1 {
+		t.Error(err, n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n = 0
+	err = Retry(ctx, &RetryPolicy{InitialDelay: time.Hour}, func(int) error { n++; return errFlaky })
+	if err != errFlaky || n != 1 {
+		t.Error(err, n)
+	}
+}
@@ -33,6 +33,7 @@ func (h *TimerHeap) Pop() interface{} {
 	timer.pos = -1
 	timer.period = 0 // 清理周期
 	timer.repeat = 0
+	timer.daily = false
 	return timer
 }
 
@@ -43,6 +44,8 @@ type Timer struct {
 	startTime time.Time
 	period    time.Duration
 	repeat    int
+	daily     bool          // 每日重置时刻触发
+	reset     time.Duration // 每日重置时刻
 }
 
 func (timer *Timer) Expire() time.Time {
@@ -70,7 +73,7 @@ func GetTimerManage() *timerManage {
 }
 
 func (tm *timerManage) Run() {
-	now := time.Now()
+	now := Now()
 	for i := 0; i < 64 && tm.h.Len() > 0; i++ {
 		top := tm.h[0]
 		if now.Before(top.t) {
@@ -84,6 +87,9 @@ func (tm *timerManage) Run() {
 				period = SkipPeriodTime(top.startTime, top.period).Sub(now)
 			}
 			tm.ResetTimer(top, period)
+		} else if top.daily {
+			top.t = NextDailyReset(now, top.reset)
+			heap.Fix(&tm.h, top.pos)
 		} else {
 			tm.StopTimer(top)
 		}
@@ -122,14 +128,14 @@ func (tm *timerManage) ResetTimer(timer *Timer, d time.Duration) {
 		return
 	}
 
-	timer.t = time.Now().Add(d)
+	timer.t = Now().Add(d)
 	heap.Fix(&tm.h, timer.pos)
 }
 
 func (tm *timerManage) NewTimer(f func(), d time.Duration) *Timer {
 	timer := &Timer{
 		f: f,
-		t: time.Now().Add(d),
+		t: Now().Add(d),
 	}
 	heap.Push(&tm.h, timer)
 	return timer
@@ -152,6 +158,18 @@ func (tm *timerManage) NewPeriodTimer(f func(), startTimeString string, period t
 	return timer
 }
 
+// 每天服务器时区的reset时刻触发，不受夏令时影响
+func (tm *timerManage) NewDailyTimer(f func(), reset time.Duration) *Timer {
+	timer := &Timer{
+		f:     f,
+		t:     NextDailyReset(Now(), reset),
+		daily: true,
+		reset: reset,
+	}
+	heap.Push(&tm.h, timer)
+	return timer
+}
+
 func StopTimer(t *Timer) {
 	GetTimerManage().StopTimer(t)
 }
@@ -168,6 +186,10 @@ func NewPeriodTimer(f func(), startTimeString string, period time.Duration) *Tim
 	return GetTimerManage().NewPeriodTimer(f, startTimeString, period)
 }
 
+func NewDailyTimer(f func(), reset time.Duration) *Timer {
+	return GetTimerManage().NewDailyTimer(f, reset)
+}
+
 func TickTimerRun() {
 	GetTimerManage().Run()
 }
@@ -12,8 +12,9 @@ var (
 	Separator = ","
 )
 
+// 按服务器时区解析
 func ParseTime(s string) (time.Time, error) {
-	loc, _ := time.LoadLocation("Local")
+	loc := Location()
 	match := "2006-01-02 15:04:05"
 	if form := "2006-01-02"; len(form) == len(s) {
 		match = form
@@ -25,7 +26,7 @@ func ParseTime(s string) (time.Time, error) {
 }
 
 func SkipPeriodTime(start time.Time, d time.Duration) time.Time {
-	return skipPeriodTime3(time.Now(), start, d)
+	return skipPeriodTime3(Now(), start, d)
 }
 
 func skipPeriodTime3(now, start time.Time, d time.Duration) time.Time {
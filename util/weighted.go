@@ -0,0 +1,99 @@
+package util
+
+// 按权重随机选择，树状数组实现，选择及更新权重均为O(log n)
+// 非并发安全
+
+import (
+	"math/rand"
+)
+
+type WeightedChooser struct {
+	items   []interface{}
+	weights []float64
+	tree    []float64 // 树状数组，下标从1开始
+	total   float64
+}
+
+// items与weights一一对应，权重<=0的元素不会被选中
+func NewWeightedChooser(items []interface{}, weights []float64) *WeightedChooser {
+	if len(items) != len(weights) {
+		panic("weighted chooser items and weights mismatch")
+	}
+	wc := &WeightedChooser{
+		items:   append([]interface{}(nil), items...),
+		weights: make([]float64, len(weights)),
+		tree:    make([]float64, len(weights)+1),
+	}
+	for i, w := range weights {
+		wc.UpdateWeight(i, w)
+	}
+	return wc
+}
+
+func (wc *WeightedChooser) add(i int, delta float64) {
+	for k := i + 1; k < len(wc.tree); k += k & -k {
+		wc.tree[k] += delta
+	}
+	wc.total += delta
+}
+
+func (wc *WeightedChooser) UpdateWeight(i int, w float64) {
+	if w < 0 {
+		w = 0
+	}
+	wc.add(i, w-wc.weights[i])
+	wc.weights[i] = w
+}
+
+// 移除后不再被选中，下标保持不变
+func (wc *WeightedChooser) Remove(i int) {
+	wc.UpdateWeight(i, 0)
+}
+
+func (wc *WeightedChooser) Weight(i int) float64 {
+	return wc.weights[i]
+}
+
+func (wc *WeightedChooser) Total() float64 {
+	return wc.total
+}
+
+func (wc *WeightedChooser) Len() int {
+	return len(wc.items)
+}
+
+// 随机下标，总权重为0时返回-1
+func (wc *WeightedChooser) Index() int {
+	if wc.total <= 0 {
+		return -1
+	}
+	r := rand.Float64() * wc.total
+	// 查找前缀和大于r的最小下标
+	pos, step := 0, 1
+	for step*2 < len(wc.tree) {
+		step *= 2
+	}
+	for ; step > 0; step /= 2 {
+		if next := pos + step; next < len(wc.tree) && wc.tree[next] <= r {
+			pos = next
+			r -= wc.tree[next]
+		}
+	}
+	// 浮点误差
+	for pos < len(wc.weights) && wc.weights[pos] <= 0 {
+		pos++
+	}
+	if pos >= len(wc.weights) {
+		for pos = len(wc.weights) - 1; wc.weights[pos] <= 0; pos-- {
+		}
+	}
+	return pos
+}
+
+// 随机元素，总权重为0时返回nil
+func (wc *WeightedChooser) Choose() interface{} {
+	if i := wc.Index(); i >= 0 {
+		return wc.items[i]
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestWeightedChooser(t *testing.T) {
+	wc := NewWeightedChooser([]interface{}{"a", "b", "c", "d"}, []float64{1, 0, 3, 6})
+	counts := make(map[interface{}]int)
+	for i := 0; i < 100000; i++ {
+		counts[wc.Choose()]++
+	}
+	if counts["b"] != 0 || counts["a"] < 8000 || counts["a"] > 12000 ||
+		counts["d"] < 57000 || counts["d"] > 63000 {
+		t.Error("weighted choose", counts)
+	}
+
+	wc.Remove(3)
+	wc.UpdateWeight(1, 1)
+	for i := 0; i < 1000; i++ {
+		if wc.Choose() == "d" {
+			t.Fatal("choose removed item")
+		}
+	}
+	if wc.Total() != 5 {
+		t.Error("total weight", wc.Total())
+	}
+
+	empty := NewWeightedChooser(nil, nil)
+	if empty.Index() != -1 || empty.Choose() != nil {
+		t.Error("empty chooser")
+	}
+}
@@ -0,0 +1,139 @@
+package util
+
+// 固定数量的工作协程，代替无限制地创建协程
+//   pool := util.NewWorkerPool(16, 1024)
+//   pool.Submit(func() { save(data) })
+//   err := pool.SubmitWait(func() { load() }) // 等待执行完毕
+//   pool.Stop()                               // 执行完队列中的任务后返回
+// 队列已满时Submit阻塞，任务异常不影响工作协程
+
+import (
+	"errors"
+	"fmt"
+	"github.com/guogeer/husky/log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrWorkerPoolStopped = errors.New("worker pool stopped")
+
+type WorkerPoolStats struct {
+	Workers   int
+	Capacity  int   // 队列容量
+	Queued    int   // 等待执行的任务
+	Running   int64 // 执行中的任务
+	Submitted int64
+	Completed int64
+	Panics    int64
+}
+
+type WorkerPool struct {
+	workers int
+	tasks   chan func()
+	stopped bool
+	mu      sync.RWMutex
+	wg      sync.WaitGroup
+
+	running, submitted, completed, panics int64
+}
+
+func NewWorkerPool(n, queueSize int) *WorkerPool {
+	if n <= 0 {
+		n = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	pool := &WorkerPool{workers: n, tasks: make(chan func(), queueSize)}
+	pool.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (pool *WorkerPool) work() {
+	defer pool.wg.Done()
+	for task := range pool.tasks {
+		pool.run(task)
+	}
+}
+
+func (pool *WorkerPool) run(task func()) {
+	atomic.AddInt64(&pool.running, 1)
+	defer func() {
+		atomic.AddInt64(&pool.running, -1)
+		atomic.AddInt64(&pool.completed, 1)
+	}()
+	if err := safeCall(task); err != nil {
+		atomic.AddInt64(&pool.panics, 1)
+		log.Error(err)
+	}
+}
+
+// 执行函数，异常时返回错误
+func safeCall(f func()) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("worker pool task panic: %v\n%s", e, buf)
+		}
+	}()
+	f()
+	return nil
+}
+
+// 提交任务，队列已满时阻塞
+func (pool *WorkerPool) Submit(task func()) error {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if pool.stopped {
+		return ErrWorkerPoolStopped
+	}
+	atomic.AddInt64(&pool.submitted, 1)
+	pool.tasks <- task
+	return nil
+}
+
+// 提交任务并等待执行完毕，任务异常时返回错误
+func (pool *WorkerPool) SubmitWait(task func()) error {
+	done := make(chan error, 1)
+	err := pool.Submit(func() {
+		err := safeCall(task)
+		if err != nil {
+			atomic.AddInt64(&pool.panics, 1)
+			log.Error(err)
+		}
+		done <- err
+	})
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// 不再接受新任务，等待已提交的任务执行完毕
+func (pool *WorkerPool) Stop() {
+	pool.mu.Lock()
+	if !pool.stopped {
+		pool.stopped = true
+		close(pool.tasks)
+	}
+	pool.mu.Unlock()
+	pool.wg.Wait()
+}
+
+func (pool *WorkerPool) Stats() *WorkerPoolStats {
+	return &WorkerPoolStats{
+		Workers:   pool.workers,
+		Capacity:  cap(pool.tasks),
+		Queued:    len(pool.tasks),
+		Running:   atomic.LoadInt64(&pool.running),
+		Submitted: atomic.LoadInt64(&pool.submitted),
+		Completed: atomic.LoadInt64(&pool.completed),
+		Panics:    atomic.LoadInt64(&pool.panics),
+	}
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(2, 4)
+	var n int64
+	for i := 0; i < 10; i++ {
+		pool.Submit(func() { atomic.AddInt64(&n, 1) })
+	}
+	pool.Submit(func() { panic("test") })
+	if err := pool.SubmitWait(func() { panic("test") }); err == nil {
+		t.Error("submit wait panic")
+	}
+	if err := pool.SubmitWait(func() { atomic.AddInt64(&n, 1) }); err != nil {
+		t.Error("submit wait", err)
+	}
+	pool.Stop()
+	if n != 11 {
+		t.Error("tasks", n)
+	}
+	if stats := pool.Stats(); stats.Submitted != 13 || stats.Completed != 13 || stats.Panics != 2 || stats.Queued != 0 {
+		t.Error("stats", stats)
+	}
+	if err := pool.Submit(func() {}); err != ErrWorkerPoolStopped {
+		t.Error("submit after stop", err)
+	}
+}